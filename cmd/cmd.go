@@ -22,6 +22,8 @@ var (
 	email     bool
 	ipip      string
 	usercmd   string
+	keydays   int
+	keyips    []string
 	systemdb  string
 	searchdir string
 
@@ -94,9 +94,15 @@ func SetConfig(key string, value interface{}) {
 	db.Init(systemdb)
 	defer db.Close()
 
+	before, _ := db.ConfigAll()
 	err := db.PutConfig(key, value)
 	if err != nil {
 		fmt.Println("Error: ", err.Error())
+		return
+	}
+	after, _ := db.ConfigAll()
+	if err = db.Audit(db.AuditEntry{Actor: db.ActorCLI, Action: db.AuditUpdate, Type: db.AuditConfig}, before, after); err != nil {
+		fmt.Println("Audit error: ", err.Error())
 	}
 	fmt.Printf("Config %s with value %s saved! \n", key, value)
 }
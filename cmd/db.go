@@ -121,7 +121,11 @@ var restoreCmd = &cobra.Command{
 			DisplayExtractObj(contenttype)
 			fmt.Println("========>try to restore to db file ")
 			//fmt.Println(err, contenttype)
-			admin.RestoreContent(bucketname, item.ItemID(), buf)
+			target := fmt.Sprintf("%s:%d", bucketname, item.ItemID())
+			before, _ := db.Content(target)
+			if err := admin.RestoreContent(bucketname, item.ItemID(), buf); err == nil {
+				db.AuditContent(db.ActorCLI, "", db.AuditRestore, target, before)
+			}
 
 		}
 
@@ -165,10 +169,13 @@ var deleteCmd = &cobra.Command{
 			if command == "test" {
 				fmt.Printf("key %s will be delete\n", list[i])
 			} else if command == "yes" {
-				err := db.DeleteContent(bucketname + ":" + fmt.Sprint(list[i]))
+				target := bucketname + ":" + fmt.Sprint(list[i])
+				before, _ := db.Content(target)
+				err := db.DeleteContent(target)
 				if err != nil {
 					fmt.Printf("Delete error\n ")
 				} else {
+					db.AuditContent(db.ActorCLI, "", db.AuditDelete, target, before)
 					fmt.Printf("\tcontent with key %s deleted!\n", list[i])
 				}
 			}
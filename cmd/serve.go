@@ -15,7 +15,10 @@ import (
 	"github.com/agreyfox/eshop/system/api"
 	"github.com/agreyfox/eshop/system/api/analytics"
 	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/i18n"
 	"github.com/agreyfox/eshop/system/logs"
+	"github.com/agreyfox/eshop/system/promotion"
+	"github.com/agreyfox/eshop/system/publish"
 	"github.com/agreyfox/eshop/system/tls"
 	"github.com/go-zoo/bone"
 	"github.com/rs/cors"
@@ -47,7 +50,7 @@ var serveCmd = &cobra.Command{
 
 		mainMux := bone.New()
 		for _, s := range services {
-			if s == "paypal" || s == "payssion" || s == "skrill" {
+			if s == "paypal" || s == "payssion" || s == "skrill" || s == "wallet" || s == "giftcard" {
 				payment.InitialPayment(db.Store(), mainMux)
 				break
 			}
@@ -60,7 +63,7 @@ var serveCmd = &cobra.Command{
 				admin.Run(mainMux)
 			} else if service == "db" {
 				boltdbweb.Run(db.Store(), mainMux) //run bolt db instance
-			} else if service == "static" || service == "paypal" || service == "payssion" || service == "skrill" {
+			} else if service == "static" || service == "paypal" || service == "payssion" || service == "skrill" || service == "wallet" || service == "giftcard" {
 				payment.Run(service)
 			} else if service == "monitor" {
 				go prometheus.Run(":9001", mainMux)
@@ -87,6 +90,15 @@ var serveCmd = &cobra.Command{
 		// init search index 初始化搜索引擎的内容
 		go db.InitSearchIndex()
 
+		// switch the scheduled discounts, hot items and carousels
+		promotion.Start()
+
+		// publish and unpublish the content with a publish window
+		publish.Start()
+
+		// content locales and their default
+		i18n.LoadSetting()
+
 		// 设置log level
 		wholelevel := db.ConfigCache("log_level").(string)
 		if len(wholelevel) > 0 {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/agreyfox/eshop/system/admin/user"
 	"github.com/agreyfox/eshop/system/api/analytics"
@@ -143,13 +144,22 @@ var createUserCmd = &cobra.Command{
 	Use:     "user",
 	Aliases: []string{"u"},
 	Short:   "Create system admin usr from cli.",
-	Long:    `Assign new addmin to system.`,
-	Example: `$ eshop user  add jihua.gao@gmail.com axxdsdawe`,
+	Long: `Assign new addmin to system, or manage the API keys of integrations.
+A key grant is resource[,resource]:action[,action], the token of a new key is
+shown only once.`,
+	Example: `$ eshop user  add jihua.gao@gmail.com axxdsdawe
+$ eshop user key add fulfillment Order:read,modify Product:read --days 90 --ip 10.0.0.0/8
+$ eshop user key ls
+$ eshop user key rm 3f2a9c1b7d4e`,
 	Run: func(cmd *cobra.Command, args []string) {
 		db.Init(systemdb)
 		defer db.Close()
 		//db.PutConfig("Key", config.GenerateKey())
 
+		if len(args) > 0 && strings.ToLower(args[0]) == "key" {
+			apiKeyCmd(args[1:])
+			return
+		}
 		if len(args) < 3 {
 			fmt.Println("use user add/rm email@address.com xxx")
 			return
@@ -158,6 +168,10 @@ var createUserCmd = &cobra.Command{
 		fmt.Println(args)
 		switch strings.ToLower(cmddd) {
 		case "add":
+			if err := db.CheckPassword(args[2]); err != nil {
+				fmt.Println("User created error !", err)
+				return
+			}
 			ur, err := user.New(args[1], args[2])
 			if err != nil {
 				fmt.Println("User operation error")
@@ -181,11 +195,85 @@ var createUserCmd = &cobra.Command{
 	},
 }
 
+// apiKeyCmd adds, lists or removes API keys by args of the user key command
+func apiKeyCmd(args []string) {
+	if len(args) == 0 {
+		fmt.Println("use user key add name grant... / ls / rm id")
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "add":
+		if len(args) < 3 {
+			fmt.Println("use user key add name resource:action[,action]...")
+			return
+		}
+		k := &db.APIKey{Name: args[1], IPs: keyips, Creator: "cli"}
+		for _, s := range args[2:] {
+			g, err := user.ParseGrant(s)
+			if err != nil {
+				fmt.Println(s, err)
+				return
+			}
+			k.Grants = append(k.Grants, g)
+		}
+		if keydays > 0 {
+			k.Expires = time.Now().Add(time.Duration(keydays) * 24 * time.Hour).Unix()
+		}
+		token, err := db.CreateAPIKey(k)
+		if err != nil {
+			fmt.Println("API key created error !", err)
+			return
+		}
+		after, _ := json.Marshal(k)
+		if err := db.Audit(db.AuditEntry{Actor: db.ActorCLI, Action: db.AuditCreate, Type: db.AuditAPIKey, Target: k.ID}, nil, after); err != nil {
+			fmt.Println("Audit error: ", err.Error())
+		}
+		fmt.Printf("API key %s created, send it as the header\nAuthorization: ApiKey %s\n", k.ID, token)
+	case "ls":
+		list, err := db.APIKeys()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, k := range list {
+			grants := []string{}
+			for _, g := range k.Grants {
+				grants = append(grants, g.String())
+			}
+			expires, used := "never", "never"
+			if k.Expires > 0 {
+				expires = time.Unix(k.Expires, 0).Format(time.RFC3339)
+			}
+			if k.LastUsed > 0 {
+				used = time.Unix(k.LastUsed, 0).Format(time.RFC3339) + " from " + k.LastIP
+			}
+			fmt.Printf("%s\t%s\t%s\tips %v\texpires %s\tlast used %s\n", k.ID, k.Name, strings.Join(grants, " "), k.IPs, expires, used)
+		}
+	case "rm":
+		if len(args) < 2 {
+			fmt.Println("use user key rm id")
+			return
+		}
+		if err := db.RevokeAPIKey(args[1]); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := db.Audit(db.AuditEntry{Actor: db.ActorCLI, Action: db.AuditDelete, Type: db.AuditAPIKey, Target: args[1]}, nil, nil); err != nil {
+			fmt.Println("Audit error: ", err.Error())
+		}
+		fmt.Println("API key removed !")
+	default:
+		fmt.Println("no cmd present")
+	}
+}
+
 func init() {
 	versionCmd.Flags().BoolVar(&cli, "cli", false, "specify that information should be returned about the CLI, not project")
 	emailCmd.Flags().BoolVar(&email, "email", false, "start to test email connection")
 	ipCmd.Flags().StringVar(&ipip, "ip", "127.0.0.1", "start to test ip lookup service connection")
 	createUserCmd.Flags().StringVar(&usercmd, "add", "admin@127.0.0.1", "create/remove system admin user")
+	createUserCmd.Flags().IntVar(&keydays, "days", 0, "days a new API key works, 0 for no expiry")
+	createUserCmd.Flags().StringSliceVar(&keyips, "ip", nil, "addresses or CIDR ranges a new API key is allowed from")
 	RegisterCmdlineCommand(versionCmd)
 	RegisterCmdlineCommand(emailCmd)
 	RegisterCmdlineCommand(ipCmd)
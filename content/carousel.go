@@ -17,6 +17,11 @@ type Carousel struct {
 	Desc   string `json:"description,omitempty"`
 	Link   string `json:"link,omitempty"`
 	Number int    `json:"number,omitempty"`
+	// Starttime and Endtime show the carousel only in the window, the
+	// scheduler sets Offline outside of it
+	Starttime string `json:"starttime,omitempty"`
+	Endtime   string `json:"endtime,omitempty"`
+	Offline   bool   `json:"offline,omitempty"`
 }
 
 // MarshalEditor writes a buffer of html to edit a Carousel within the CMS
@@ -79,6 +84,11 @@ func (c *Carousel) String() string {
 	return fmt.Sprintf("Carousel: %s", c.UUID)
 }
 
+// Localized returns the fields translated per locale
+func (c *Carousel) Localized() []string {
+	return []string{"alt", "description"}
+}
+
 func (o *Carousel) ContentStruct() map[string]interface{} {
 	dd := map[string]item.FieldDescription{
 		"name": {
@@ -119,6 +129,24 @@ func (o *Carousel) ContentStruct() map[string]interface{} {
 			DataType:   "field",
 			DataSource: []string{},
 			Order:      20},
+		"starttime": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "开始显示的时间，格式 2006-01-02 15:04，空表示立即显示",
+			Order:      60},
+		"endtime": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "停止显示的时间，格式 2006-01-02 15:04，空表示一直显示",
+			Order:      61},
+		"i18n": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "各语言的翻译，json格式，如 [{\"locale\":\"fr\",\"fields\":{\"alt\":\"...\"}}]，可翻译 alt、description，未翻译的内容显示默认语言",
+			Order:      300},
 	}
 	//retStr, _ := json.Marshal(dd)
 	return map[string]interface{}{
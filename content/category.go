@@ -74,6 +74,11 @@ func (c *Category) String() string {
 	return fmt.Sprintf("Category: %s", c.UUID)
 }
 
+// Localized returns the fields translated per locale
+func (c *Category) Localized() []string {
+	return []string{"description", "hint"}
+}
+
 func (o *Category) ContentStruct() map[string]interface{} {
 	dd := map[string]item.FieldDescription{
 		"name": {
@@ -131,6 +136,12 @@ func (o *Category) ContentStruct() map[string]interface{} {
 			DataType:   "field",
 			DataSource: []string{},
 			Order:      70},
+		"i18n": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "各语言的翻译，json格式，如 [{\"locale\":\"fr\",\"fields\":{\"description\":\"...\"}}]，可翻译 description、hint，未翻译的内容显示默认语言",
+			Order:      300},
 	}
 	return map[string]interface{}{
 		"data": dd,
@@ -138,3 +149,11 @@ func (o *Category) ContentStruct() map[string]interface{} {
 	}
 
 }
+
+// References implements item.Referencer, categories go with their game
+func (c *Category) References() []item.Reference {
+	return []item.Reference{
+		{Field: "game", Type: "Game", OnDelete: item.OnDeleteCascade},
+		{Field: "belongto", Type: "Category", OnDelete: item.OnDeleteRestrict},
+	}
+}
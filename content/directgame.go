@@ -57,6 +57,11 @@ func (d *DirectGame) String() string {
 	return fmt.Sprintf("DirectGame: %s", d.UUID)
 }
 
+// Localized returns the fields translated per locale
+func (d *DirectGame) Localized() []string {
+	return []string{"display_name"}
+}
+
 func (o *DirectGame) ContentStruct() map[string]interface{} {
 	dd := map[string]item.FieldDescription{
 		"name": {
@@ -94,6 +99,13 @@ func (o *DirectGame) ContentStruct() map[string]interface{} {
 			DataSource: []string{""},
 			Order:      90,
 		},
+		"i18n": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "各语言的翻译，json格式，如 [{\"locale\":\"fr\",\"fields\":{\"display_name\":\"...\"}}]，可翻译 display_name，未翻译的内容显示默认语言",
+			Order:      300,
+		},
 	}
 	//retStr, _ := json.Marshal(dd)
 	return map[string]interface{}{
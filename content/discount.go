@@ -11,12 +11,13 @@ type Discount struct {
 	item.Item
 
 	Name      string `json:"name"`
-	List      string `json:"list"`
+	List      string `json:"list"` // json levels [{"qty":"20","discount":"5"}], percent off from qty on
 	Currency  string `json:"currency"`
 	SellText  string `json:"selltext"` //encourge selling
 	Starttime string `json:"starttime"`
 	Endtime   string `json:"endtime"`
-	Online    bool   `json:"online"`
+	Online    bool   `json:"online"`             // set by the scheduler when Starttime or Endtime is given
+	Timezone  string `json:"timezone,omitempty"` // e.g. Asia/Shanghai, the promotion timezone when empty
 	Desc      string `json:"desc,omitempty"`
 }
 
@@ -45,14 +46,21 @@ func (d *Discount) MarshalEditor() ([]byte, error) {
 			View: editor.Input("Starttime", d, map[string]string{
 				"label":       "Starttime",
 				"type":        "text",
-				"placeholder": "Enter the Starttime here",
+				"placeholder": "2006-01-02 15:04",
 			}),
 		},
 		editor.Field{
 			View: editor.Input("Endtime", d, map[string]string{
 				"label":       "Endtime",
 				"type":        "text",
-				"placeholder": "Enter the Endtime here",
+				"placeholder": "2006-01-02 15:04",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("Timezone", d, map[string]string{
+				"label":       "Timezone",
+				"type":        "text",
+				"placeholder": "Asia/Shanghai",
 			}),
 		},
 		editor.Field{
@@ -87,6 +95,11 @@ func (d *Discount) String() string {
 	return fmt.Sprintf("Discount: %s", d.UUID)
 }
 
+// Localized returns the fields translated per locale
+func (d *Discount) Localized() []string {
+	return []string{"selltext", "desc"}
+}
+
 /*
 func (o *Discount) ContentStruct() map[string]item.FieldDescription {
 	dd := map[string]item.FieldDescription{
@@ -10,22 +10,26 @@ import (
 type Game struct {
 	item.Item
 
-	Name         string `json:"name"`
-	Sname        string `json:"sname,omitempty"`
-	SlugName     string `json:"slugname"`
-	Logo         string `json:"logo,omitempty"`
-	Image        string `json:"barImage,omitempty"`
-	Online       bool   `json:"online"`
-	Hotitem      bool   `json:"hotitem"`
-	CoinCode     string `json:"coinName"`
-	ItemCode     string `json:"itemName"`
-	ProductSell  string `json:"productSell"` //  设定该游戏销售那些内容，coin,item，both
-	Desc         string `json:"description,omitempty"`
-	CategoryHint string `json:"categoryHint,omitempty"`
-	ServerHint   string `json:"serverHint,omitempty"`
-	Coupon       string `json:"coupon,omitempty"`   //使用那个coupon
-	Hot          bool   `json:"hot,omitempty"`      //是否在hotgame中显示
-	BuyNotes     string `json:"buyNotes,omitempty"` //购买说明
+	Name         string      `json:"name"`
+	Sname        string      `json:"sname,omitempty"`
+	SlugName     string      `json:"slugname"`
+	Logo         string      `json:"logo,omitempty"`
+	Image        string      `json:"barImage,omitempty"`
+	Online       bool        `json:"online"`
+	Hotitem      bool        `json:"hotitem"`
+	CoinCode     string      `json:"coinName"`
+	ItemCode     string      `json:"itemName"`
+	ProductSell  string      `json:"productSell"` //  设定该游戏销售那些内容，coin,item，both
+	Desc         string      `json:"description,omitempty"`
+	CategoryHint string      `json:"categoryHint,omitempty"`
+	ServerHint   string      `json:"serverHint,omitempty"`
+	Coupon       string      `json:"coupon,omitempty"`    //使用那个coupon
+	Hot          bool        `json:"hot,omitempty"`       //是否在hotgame中显示
+	BuyNotes     string      `json:"buyNotes,omitempty"`  //购买说明
+	PointRate    float64     `json:"pointRate,omitempty"` //每单位金额获得的积分
+	HotStart     string      `json:"hotStart,omitempty"`  //定时进入hot game
+	HotEnd       string      `json:"hotEnd,omitempty"`    //定时退出hot game
+	Inputs       InputSchema `json:"inputs,omitempty"`    //购买时顾客填写的内容
 }
 
 // MarshalEditor writes a buffer of html to edit a Game within the CMS
@@ -136,6 +140,18 @@ func (o *Game) ContentStruct() map[string]interface{} {
 			Required:   true,
 			Help:       "选择本游戏是否出现在hot game列表中",
 			Order:      30},
+		"hotStart": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "定时出现在hot game列表的开始时间，格式 2006-01-02 15:04，空表示手工设定",
+			Order:      31},
+		"hotEnd": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "定时退出hot game列表的时间，格式 2006-01-02 15:04",
+			Order:      32},
 		"logo": {
 			Type:       "file",
 			DataType:   "field",
@@ -168,16 +184,34 @@ func (o *Game) ContentStruct() map[string]interface{} {
 			Help:       "选择本游戏是否出现在hotitem列表中",
 			Order:      80,
 		},
+		"pointRate": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "订单完成后每单位金额获得的积分，0表示使用系统默认",
+			Order:      82},
 		"buyNotes": {
 			Type:       "textarea",
 			DataType:   "field",
 			DataSource: []string{},
 			Order:      85},
+		"inputs": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "购买时顾客须填写的内容，json格式，如 [{\"name\":\"character\",\"label\":\"Character Name\",\"required\":true,\"pattern\":\"^[A-Za-z]{2,12}$\"},{\"name\":\"platform\",\"label\":\"Platform\",\"type\":\"select\",\"options\":[\"PC\",\"PS4\"]}]，type可为text、number、email、select",
+			Order:      87},
 		"description": {
 			Type:       "textarea",
 			DataType:   "field",
 			DataSource: []string{},
 			Order:      90},
+		"i18n": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "各语言的翻译，json格式，如 [{\"locale\":\"fr\",\"fields\":{\"description\":\"...\"}}]，可翻译 description、buyNotes、categoryHint、serverHint，未翻译的内容显示默认语言",
+			Order:      300},
 	}
 	//retStr, _ := json.Marshal(dd)
 	return map[string]interface{}{
@@ -192,6 +226,16 @@ func (g *Game) String() string {
 	return fmt.Sprintf("Game: %s", g.Name)
 }
 
+// Localized returns the fields translated per locale
+func (g *Game) Localized() []string {
+	return []string{"description", "buyNotes", "categoryHint", "serverHint"}
+}
+
+// ReviewField implements item.Reviewable, reviews rate the game they reference
+func (g *Game) ReviewField() string {
+	return "game"
+}
+
 func (g *Game) IndexContent() bool {
 	return true
 }
@@ -0,0 +1,133 @@
+package content
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/agreyfox/eshop/system/item"
+)
+
+const (
+	GiftCardActive   = "active"
+	GiftCardUsed     = "used"
+	GiftCardDisabled = "disabled"
+)
+
+type GiftCard struct {
+	item.Item
+
+	Code           string  `json:"code"`
+	Denomination   float64 `json:"denomination"`
+	Balance        float64 `json:"balance"`
+	Currency       string  `json:"currency"`
+	Expiry         string  `json:"expiry"` // 2006-01-02, empty means never expire
+	BuyerEmail     string  `json:"buyer_email"`
+	RecipientEmail string  `json:"recipient_email"`
+	Message        string  `json:"message,omitempty"`
+	OrderID        string  `json:"order_id,omitempty"`
+	Status         string  `json:"status"`
+	Delivered      bool    `json:"delivered"`
+	Desc           string  `json:"description,omitempty"`
+}
+
+// MarshalEditor writes a buffer of html to edit a GiftCard within the CMS
+// and implements editor.Editable
+func (g *GiftCard) MarshalEditor() ([]byte, error) {
+
+	return nil, fmt.Errorf("Failed to render GiftCard editor view")
+
+}
+
+func init() {
+	item.Types["GiftCard"] = func() interface{} { return new(GiftCard) }
+}
+
+// String defines how a GiftCard is printed. Update it using more descriptive
+// fields from the GiftCard struct type
+func (g *GiftCard) String() string {
+	return fmt.Sprintf("GiftCard: %s", g.UUID)
+}
+
+func (g *GiftCard) ContentStruct() map[string]interface{} {
+	dd := map[string]item.FieldDescription{
+		"code": {
+			Type:       "input",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{},
+			Help:       "Generated when the card is issued",
+			Order:      10},
+		"denomination": {
+			Type:       "input",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{},
+			Order:      20},
+		"balance": {
+			Type:       "input",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{},
+			Order:      30},
+		"currency": {
+			Type:       "select",
+			DataType:   "content",
+			Required:   true,
+			DataSource: []string{"/admin/v1/contents?type=Currency&count=-1"},
+			Order:      40},
+		"expiry": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "Format 2006-01-02, empty is never expire",
+			Order:      50},
+		"buyer_email": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      60},
+		"recipient_email": {
+			Type:       "input",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{},
+			Order:      70},
+		"message": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      80},
+		"order_id": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      90},
+		"status": {
+			Type:       "select",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{GiftCardActive, GiftCardUsed, GiftCardDisabled},
+			Order:      100},
+		"delivered": {
+			Type:       "bool",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      110},
+		"description": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      120},
+	}
+	//retStr, _ := json.Marshal(dd)
+	return map[string]interface{}{
+		"data": dd,
+		"no":   270,
+	}
+}
+
+// Hide keeps gift card codes out of the public content api, cards are only
+// looked up by code through the gift card payment service
+func (g *GiftCard) Hide(res http.ResponseWriter, req *http.Request) error {
+	return nil
+}
@@ -0,0 +1,166 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// input field types
+const (
+	InputText   = "text"
+	InputNumber = "number"
+	InputEmail  = "email"
+	InputSelect = "select"
+)
+
+// InputField is one value the buyer enters for a purchase, e.g. the
+// character name the coins are delivered to
+type InputField struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type,omitempty"` // text when empty
+	Required bool     `json:"required,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"` // regexp the value must match
+	Options  []string `json:"options,omitempty"` // values of a select
+	Hint     string   `json:"hint,omitempty"`
+}
+
+// InputSchema is the buyer input of a product or game, stored as a json
+// array and posted by the admin editor as json text like PriceTiers
+type InputSchema []InputField
+
+// UnmarshalText parse and validate the schema from the admin form
+func (p *InputSchema) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if len(s) == 0 || s == "null" {
+		*p = nil
+		return nil
+	}
+	fields := []InputField{}
+	if err := json.Unmarshal([]byte(s), &fields); err != nil {
+		return fmt.Errorf("input schema is not a json list: %s", err)
+	}
+	if err := InputSchema(fields).Validate(); err != nil {
+		return err
+	}
+	*p = fields
+	return nil
+}
+
+// UnmarshalJSON reads the stored schema
+func (p *InputSchema) UnmarshalJSON(b []byte) error {
+	fields := []InputField{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	*p = fields
+	return nil
+}
+
+// Validate checks the field names are set and unique, the types known, the
+// patterns compile and every select has options
+func (p InputSchema) Validate() error {
+	names := map[string]bool{}
+	for i, f := range p {
+		if len(strings.TrimSpace(f.Name)) == 0 {
+			return fmt.Errorf("input %d has no name", i+1)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("input %s is defined twice", f.Name)
+		}
+		names[f.Name] = true
+		switch f.Type {
+		case "", InputText, InputNumber, InputEmail:
+		case InputSelect:
+			if len(f.Options) == 0 {
+				return fmt.Errorf("input %s: select has no options", f.Name)
+			}
+		default:
+			return fmt.Errorf("input %s: unknown type %s", f.Name, f.Type)
+		}
+		if len(f.Pattern) > 0 {
+			if _, err := regexp.Compile(f.Pattern); err != nil {
+				return fmt.Errorf("input %s: pattern error %s", f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// label returns the label of the field, its name when it has none
+func (f InputField) label() string {
+	if len(f.Label) > 0 {
+		return f.Label
+	}
+	return f.Name
+}
+
+// check validates one value of the field, value is trimmed
+func (f InputField) check(value string) error {
+	if len(value) == 0 {
+		if f.Required {
+			return fmt.Errorf("%s is required", f.label())
+		}
+		return nil
+	}
+	switch f.Type {
+	case InputNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s must be a number", f.label())
+		}
+	case InputEmail:
+		if _, err := mail.ParseAddress(value); err != nil {
+			return fmt.Errorf("%s must be an email", f.label())
+		}
+	case InputSelect:
+		found := false
+		for _, o := range f.Options {
+			if o == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %s", f.label(), strings.Join(f.Options, ", "))
+		}
+	}
+	if len(f.Pattern) > 0 {
+		re, err := regexp.Compile(f.Pattern)
+		if err != nil || !re.MatchString(value) {
+			return fmt.Errorf("%s is not valid", f.label())
+		}
+	}
+	return nil
+}
+
+// Check validates the buyer values against the schema and returns them
+// trimmed, values of fields not in the schema are dropped
+func (p InputSchema) Check(values map[string]string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, f := range p {
+		v := strings.TrimSpace(values[f.Name])
+		if err := f.check(v); err != nil {
+			return nil, err
+		}
+		if len(v) > 0 {
+			result[f.Name] = v
+		}
+	}
+	return result, nil
+}
+
+// Format shows the values as "label: value" lines in schema order, for the
+// fulfillment workers
+func (p InputSchema) Format(values map[string]string) string {
+	lines := []string{}
+	for _, f := range p {
+		if v, ok := values[f.Name]; ok {
+			lines = append(lines, f.label()+": "+v)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
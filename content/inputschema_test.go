@@ -0,0 +1,67 @@
+package content
+
+import (
+	"testing"
+)
+
+func TestInputSchemaValidate(t *testing.T) {
+	cases := []struct {
+		json string
+		ok   bool
+	}{
+		{``, true},
+		{`[{"name":"character","label":"Character Name","required":true,"pattern":"^[A-Za-z]{2,12}$"},{"name":"platform","type":"select","options":["PC","PS4"]}]`, true},
+		{`[{"name":"character"},{"name":"character"}]`, false},
+		{`[{"label":"Character Name"}]`, false},
+		{`[{"name":"platform","type":"select"}]`, false},
+		{`[{"name":"age","type":"date"}]`, false},
+		{`[{"name":"character","pattern":"[a-z"}]`, false},
+		{`{"name":"character"}`, false},
+	}
+	for _, c := range cases {
+		schema := InputSchema{}
+		err := schema.UnmarshalText([]byte(c.json))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.json, c.ok, err)
+		}
+	}
+}
+
+func TestInputSchemaCheck(t *testing.T) {
+	schema := InputSchema{}
+	err := schema.UnmarshalText([]byte(`[
+		{"name":"character","label":"Character Name","required":true,"pattern":"^[A-Za-z]{2,12}$"},
+		{"name":"platform","label":"Platform","type":"select","options":["PC","PS4"]},
+		{"name":"level","type":"number"},
+		{"name":"contact","type":"email"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		values map[string]string
+		ok     bool
+	}{
+		{map[string]string{"character": " Thrall ", "platform": "PC", "level": "60", "contact": "a@b.com"}, true},
+		{map[string]string{"character": "Thrall"}, true},
+		{map[string]string{"platform": "PC"}, false},
+		{map[string]string{"character": "Thrall 2"}, false},
+		{map[string]string{"character": "Thrall", "platform": "Xbox"}, false},
+		{map[string]string{"character": "Thrall", "level": "sixty"}, false},
+		{map[string]string{"character": "Thrall", "contact": "nobody"}, false},
+	}
+	for _, c := range cases {
+		_, err := schema.Check(c.values)
+		if (err == nil) != c.ok {
+			t.Errorf("%v: expected ok %v, got %v", c.values, c.ok, err)
+		}
+	}
+
+	values, _ := schema.Check(map[string]string{"character": " Thrall ", "platform": "PS4", "unknown": "x"})
+	if len(values) != 2 || values["character"] != "Thrall" {
+		t.Errorf("expected trimmed known values, got %v", values)
+	}
+	if text := schema.Format(values); text != "Character Name: Thrall\nPlatform: PS4" {
+		t.Errorf("unexpected format %q", text)
+	}
+}
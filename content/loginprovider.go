@@ -0,0 +1,126 @@
+package content
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/item"
+)
+
+var providerName = regexp.MustCompile("^[a-z0-9_-]+$")
+
+// LoginProvider is an OpenID Connect provider customers can log in with, like
+// Google. Its name is used in /api/v1/oidc/{name}/login and the redirect url
+// registered at the provider is /api/v1/oidc/{name}/callback.
+type LoginProvider struct {
+	item.Item
+
+	Name         string `json:"name"`
+	Title        string `json:"title"` //登录按钮上显示的名字
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Scopes       string `json:"scopes"`       //空格分隔, 默认 openid email profile
+	RedirectURL  string `json:"redirect_url"` //空表示按请求的地址生成
+	Enabled      bool   `json:"enabled"`
+}
+
+// MarshalEditor writes a buffer of html to edit a LoginProvider within the CMS
+// and implements editor.Editable
+func (l *LoginProvider) MarshalEditor() ([]byte, error) {
+
+	return nil, fmt.Errorf("Failed to render LoginProvider editor view")
+
+}
+
+func init() {
+	item.Types["LoginProvider"] = func() interface{} { return new(LoginProvider) }
+}
+
+// String defines how a LoginProvider is printed. Update it using more descriptive
+// fields from the LoginProvider struct type
+func (l *LoginProvider) String() string {
+	return fmt.Sprintf("LoginProvider: %s", l.Name)
+}
+
+// Validate checks the name and the issuer url
+func (l *LoginProvider) Validate() error {
+	if !providerName.MatchString(l.Name) {
+		return fmt.Errorf("Name %q should be lower case letters, digits, - or _", l.Name)
+	}
+	u, err := url.Parse(l.Issuer)
+	if err != nil || (u.Scheme != "https" && !strings.HasPrefix(u.Host, "localhost")) {
+		return fmt.Errorf("Issuer %q should be an https url", l.Issuer)
+	}
+	if len(l.ClientID) == 0 {
+		return fmt.Errorf("Client id is required")
+	}
+	return nil
+}
+
+func (l *LoginProvider) ContentStruct() map[string]interface{} {
+	dd := map[string]item.FieldDescription{
+		"name": {
+			Type:       "input",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{},
+			Help:       "Lower case, used in the login url, e.g. google",
+			Order:      10},
+		"title": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "Shown on the login button",
+			Order:      20},
+		"issuer": {
+			Type:       "input",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{},
+			Help:       "e.g. https://accounts.google.com",
+			Order:      30},
+		"client_id": {
+			Type:       "input",
+			DataType:   "field",
+			Required:   true,
+			DataSource: []string{},
+			Order:      40},
+		"client_secret": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      50},
+		"scopes": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "Space separated, empty is openid email profile",
+			Order:      60},
+		"redirect_url": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "https://{domain}/api/v1/oidc/{name}/callback, empty is made from the request",
+			Order:      70},
+		"enabled": {
+			Type:       "bool",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      80},
+	}
+	//retStr, _ := json.Marshal(dd)
+	return map[string]interface{}{
+		"data": dd,
+		"no":   290,
+	}
+}
+
+// Hide keeps the providers and their client secrets out of the public content
+// api, the enabled ones are listed by /api/v1/oidc/providers
+func (l *LoginProvider) Hide(res http.ResponseWriter, req *http.Request) error {
+	return nil
+}
@@ -101,6 +101,11 @@ func (n *News) String() string {
 	return fmt.Sprintf("News: %s", n.UUID)
 }
 
+// Localized returns the fields translated per locale
+func (n *News) Localized() []string {
+	return []string{"title", "text", "desc"}
+}
+
 func (o *News) ContentStruct() map[string]interface{} {
 	dd := map[string]item.FieldDescription{
 		"title": {
@@ -142,6 +147,12 @@ func (o *News) ContentStruct() map[string]interface{} {
 			DataType:   "field",
 			DataSource: []string{"Show in homepage"},
 			Order:      20},
+		"i18n": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "各语言的翻译，json格式，如 [{\"locale\":\"fr\",\"fields\":{\"title\":\"...\"}}]，可翻译 title、text、desc，未翻译的内容显示默认语言",
+			Order:      300},
 	}
 	//retStr, _ := json.Marshal(dd)
 	return map[string]interface{}{
@@ -0,0 +1,115 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PriceTier is the price of a quantity range, Max 0 means no upper limit.
+// A tier sets either the unit Price or a Percent off the base price.
+type PriceTier struct {
+	Min     int     `json:"min"`
+	Max     int     `json:"max,omitempty"`
+	Price   float64 `json:"price,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+}
+
+// String shows the quantity range of the tier
+func (t PriceTier) String() string {
+	if t.Max == 0 {
+		return fmt.Sprintf("%d+", t.Min)
+	}
+	return fmt.Sprintf("%d-%d", t.Min, t.Max)
+}
+
+// PriceTiers is the volume pricing of a product or server, stored as a json
+// array. The admin editor posts it as json text which is checked by
+// UnmarshalText, so invalid tiers are never saved.
+type PriceTiers []PriceTier
+
+// UnmarshalText parse and validate the tiers from the admin form
+func (p *PriceTiers) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if len(s) == 0 || s == "null" {
+		*p = nil
+		return nil
+	}
+	tiers := []PriceTier{}
+	if err := json.Unmarshal([]byte(s), &tiers); err != nil {
+		return fmt.Errorf("price tiers are not a json list: %s", err)
+	}
+	if err := PriceTiers(tiers).Validate(); err != nil {
+		return err
+	}
+	*p = tiers
+	return nil
+}
+
+// UnmarshalJSON reads the stored tiers, it is needed as encoding/json would
+// use UnmarshalText for the json array otherwise
+func (p *PriceTiers) UnmarshalJSON(b []byte) error {
+	tiers := []PriceTier{}
+	if err := json.Unmarshal(b, &tiers); err != nil {
+		return err
+	}
+	*p = tiers
+	return nil
+}
+
+// Validate checks the tiers cover the quantities from the first Min on
+// without gaps or overlaps, only the last tier may be open ended
+func (p PriceTiers) Validate() error {
+	tiers := append(PriceTiers{}, p...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Min < tiers[j].Min })
+
+	for i, t := range tiers {
+		if t.Min < 1 {
+			return fmt.Errorf("tier %d: min must be at least 1", i+1)
+		}
+		if t.Max != 0 && t.Max < t.Min {
+			return fmt.Errorf("tier %s: max is less than min", t)
+		}
+		if (t.Price > 0) == (t.Percent > 0) || t.Price < 0 || t.Percent < 0 {
+			return fmt.Errorf("tier %s: set either price or percent", t)
+		}
+		if t.Percent >= 100 {
+			return fmt.Errorf("tier %s: percent must be below 100", t)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := tiers[i-1]
+		if prev.Max == 0 || t.Min <= prev.Max {
+			return fmt.Errorf("tier %s overlaps tier %s", t, prev)
+		}
+		if t.Min > prev.Max+1 {
+			return fmt.Errorf("gap between tier %s and tier %s", prev, t)
+		}
+	}
+	return nil
+}
+
+// Tier returns the tier of quantity, false when no tier covers it
+func (p PriceTiers) Tier(quantity int) (PriceTier, bool) {
+	for _, t := range p {
+		if quantity >= t.Min && (t.Max == 0 || quantity <= t.Max) {
+			return t, true
+		}
+	}
+	return PriceTier{}, false
+}
+
+// UnitPrice returns the unit price of quantity, base is the price without
+// tiers and is kept for quantities no tier covers
+func (p PriceTiers) UnitPrice(base float64, quantity int) float64 {
+	t, ok := p.Tier(quantity)
+	if !ok {
+		return base
+	}
+	if t.Price > 0 {
+		return t.Price
+	}
+	return base * (100 - t.Percent) / 100
+}
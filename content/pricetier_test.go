@@ -0,0 +1,46 @@
+package content
+
+import (
+	"testing"
+)
+
+func TestPriceTiersValidate(t *testing.T) {
+	cases := []struct {
+		json string
+		ok   bool
+	}{
+		{``, true},
+		{`[{"min":1,"max":99,"price":1.2},{"min":100,"max":499,"price":1.1},{"min":500,"percent":15}]`, true},
+		{`[{"min":100,"percent":5},{"min":1,"max":99,"price":1.2}]`, true},  // order does not matter
+		{`[{"min":1,"max":99,"price":1.2},{"min":99,"price":1.1}]`, false},  // overlap
+		{`[{"min":1,"max":99,"price":1.2},{"min":101,"price":1.1}]`, false}, // gap
+		{`[{"min":1,"price":1.2},{"min":100,"price":1.1}]`, false},          // open tier not last
+		{`[{"min":0,"max":9,"price":1}]`, false},
+		{`[{"min":10,"max":9,"price":1}]`, false},
+		{`[{"min":1,"price":1,"percent":5}]`, false},
+		{`[{"min":1}]`, false},
+		{`[{"min":1,"percent":100}]`, false},
+		{`{"min":1}`, false},
+	}
+	for _, c := range cases {
+		tiers := PriceTiers{}
+		err := tiers.UnmarshalText([]byte(c.json))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.json, c.ok, err)
+		}
+	}
+}
+
+func TestPriceTiersUnitPrice(t *testing.T) {
+	tiers := PriceTiers{}
+	err := tiers.UnmarshalText([]byte(`[{"min":10,"max":99,"price":1.5},{"min":100,"percent":20}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[int]float64{1: 2, 10: 1.5, 99: 1.5, 100: 1.6, 5000: 1.6}
+	for qty, want := range cases {
+		if got := tiers.UnitPrice(2, qty); got != want {
+			t.Errorf("quantity %d: expected %v, got %v", qty, want, got)
+		}
+	}
+}
@@ -27,7 +27,14 @@ type Product struct {
 	PurchaseLabel   string  `json:"customerLabel"`             //用户输入提示内容
 	PurchaseCaution string  `json:"customerCaution,omitempty"` //用户输入要求购买内容
 	//	Notes           string  `json:notes,omitempty`             //mobile 上的产品第二行
-	Discount string `json:"discount,omitempty"` //使用discount模板
+	Discount  string          `json:"discount,omitempty"`  //使用discount模板
+	PointRate float64         `json:"pointRate,omitempty"` //每单位金额获得的积分，覆盖游戏的设定
+	HotStart  string          `json:"hotStart,omitempty"`  //定时进入hotitem
+	HotEnd    string          `json:"hotEnd,omitempty"`    //定时退出hotitem
+	Tiers     PriceTiers      `json:"tiers,omitempty"`     //批量价格
+	Options   ProductOptions  `json:"options,omitempty"`   //可选项，如区服、平台
+	Variants  ProductVariants `json:"variants,omitempty"`  //规格，每种选项组合的sku、价格和库存
+	Inputs    InputSchema     `json:"inputs,omitempty"`    //购买时顾客填写的内容，覆盖游戏的设定
 
 }
 
@@ -111,6 +118,16 @@ func (p *Product) String() string {
 	return fmt.Sprintf("Product: %s", p.Name)
 }
 
+// Localized returns the fields translated per locale
+func (p *Product) Localized() []string {
+	return []string{"description", "hintText", "customerLabel", "customerCaution"}
+}
+
+// ReviewField implements item.Reviewable, reviews rate the product they reference
+func (p *Product) ReviewField() string {
+	return "product"
+}
+
 func (p *Product) ContentStruct() map[string]interface{} {
 	dd := map[string]item.FieldDescription{
 
@@ -136,6 +153,20 @@ func (p *Product) ContentStruct() map[string]interface{} {
 			Order:      20,
 			Others:     "false",
 		},
+		"hotStart": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "定时出现在hotitem栏目的开始时间，格式 2006-01-02 15:04，空表示手工设定",
+			Order:      21,
+		},
+		"hotEnd": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "定时退出hotitem栏目的时间，格式 2006-01-02 15:04",
+			Order:      22,
+		},
 		"stock": {
 			Type:       "input",
 			DataType:   "field",
@@ -183,6 +214,34 @@ func (p *Product) ContentStruct() map[string]interface{} {
 			Required:   true,
 			Order:      80,
 		},
+		"tiers": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "按购买数量分段定价，json格式，如 [{\"min\":1,\"max\":99,\"price\":1.2},{\"min\":100,\"percent\":5}]，price为单价，percent为单价折扣百分比，数量段不能重叠或留空",
+			Order:      53,
+		},
+		"options": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "顾客可选的选项，json格式，如 [{\"name\":\"platform\",\"values\":[\"PC\",\"PS4\"]}]",
+			Order:      54,
+		},
+		"variants": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "产品规格，json格式，如 [{\"sku\":\"MK-PC\",\"options\":{\"platform\":\"PC\"},\"price\":5.99,\"stock\":100,\"online\":true}]，每个规格须选定每个选项的一个值，库存不足时不能下单",
+			Order:      55,
+		},
+		"pointRate": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "订单完成后每单位金额获得的积分，0表示使用游戏的设定",
+			Order:      82,
+		},
 		"order": {
 			Type:       "input",
 			DataType:   "field",
@@ -249,6 +308,20 @@ func (p *Product) ContentStruct() map[string]interface{} {
 			Help:       "当用购买本产品时，提示用户购买注意事项，在输入框下方",
 			Order:      140,
 		},
+		"inputs": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "购买时顾客须填写的内容，json格式，如 [{\"name\":\"character\",\"label\":\"Character Name\",\"required\":true,\"pattern\":\"^[A-Za-z]{2,12}$\"},{\"name\":\"platform\",\"label\":\"Platform\",\"type\":\"select\",\"options\":[\"PC\",\"PS4\"]}]，type可为text、number、email、select，设置后覆盖游戏的设定",
+			Order:      145,
+		},
+		"i18n": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "各语言的翻译，json格式，如 [{\"locale\":\"fr\",\"fields\":{\"description\":\"...\"}}]，可翻译 description、hintText、customerLabel、customerCaution，未翻译的内容显示默认语言",
+			Order:      300,
+		},
 	}
 	//retStr, _ := json.Marshal(dd)
 	return map[string]interface{}{
@@ -260,3 +333,19 @@ func (p *Product) ContentStruct() map[string]interface{} {
 func (g *Product) IndexContent() bool {
 	return true
 }
+
+// Validate implements item.Validatable, the variants must use the options
+func (p *Product) Validate() error {
+	if err := p.Options.Validate(); err != nil {
+		return err
+	}
+	return p.Variants.Validate(p.Options)
+}
+
+// References implements item.Referencer, a product can not be left without
+// its game
+func (p *Product) References() []item.Reference {
+	return []item.Reference{
+		{Field: "game", Type: "Game", OnDelete: item.OnDeleteRestrict},
+	}
+}
@@ -0,0 +1,185 @@
+package content
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/agreyfox/eshop/management/editor"
+	"github.com/agreyfox/eshop/system/item"
+)
+
+// Review is the rating and review a customer leaves on a product. Customers
+// submit them to Review__pending through /api/v1/review and an admin approves
+// or rejects them.
+type Review struct {
+	item.Item
+
+	Product  string `json:"product"`
+	Game     string `json:"game,omitempty"`
+	Email    string `json:"email"`
+	Name     string `json:"name,omitempty"` //显示的顾客名字
+	Rating   int    `json:"rating"`         //1-5
+	Title    string `json:"title,omitempty"`
+	Text     string `json:"text,omitempty"`
+	OrderID  string `json:"order_id,omitempty"` //购买了产品的订单
+	Verified bool   `json:"verified"`           //是否为已验证的购买
+	IP       string `json:"ip,omitempty"`
+}
+
+// MarshalEditor writes a buffer of html to edit a Review within the CMS
+// and implements editor.Editable
+func (r *Review) MarshalEditor() ([]byte, error) {
+	view, err := editor.Form(r,
+		editor.Field{
+			View: editor.Input("Product", r, map[string]string{
+				"label":       "Product",
+				"type":        "text",
+				"placeholder": "Enter the Product here",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("Rating", r, map[string]string{
+				"label":       "Rating",
+				"type":        "text",
+				"placeholder": "Enter the Rating here",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("Title", r, map[string]string{
+				"label":       "Title",
+				"type":        "text",
+				"placeholder": "Enter the Title here",
+			}),
+		},
+		editor.Field{
+			View: editor.Textarea("Text", r, map[string]string{
+				"label":       "Text",
+				"placeholder": "Enter the Text here",
+			}),
+		},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to render Review editor view: %s", err.Error())
+	}
+
+	return view, nil
+}
+
+func init() {
+	item.Types["Review"] = func() interface{} { return new(Review) }
+}
+
+// String defines how a Review is printed
+func (r *Review) String() string {
+	return fmt.Sprintf("Review: %s %d", r.Product, r.Rating)
+}
+
+// Approve implements editor.Mergeable, so reviews are moderated with the
+// pending approve and reject of the admin
+func (r *Review) Approve(w http.ResponseWriter, req *http.Request) error {
+	return nil
+}
+
+// Omit implements item.Omittable, the customer email, order and address are
+// not shown by the content API
+func (r *Review) Omit(w http.ResponseWriter, req *http.Request) ([]string, error) {
+	return []string{"email", "order_id", "ip"}, nil
+}
+
+// Validate implements item.Validatable
+func (r *Review) Validate() error {
+	if r.Rating < 1 || r.Rating > 5 {
+		return fmt.Errorf("rating must be from 1 to 5, got %d", r.Rating)
+	}
+	return nil
+}
+
+// References implements item.Referencer, the reviews go with their product
+// or game
+func (r *Review) References() []item.Reference {
+	return []item.Reference{
+		{Field: "product", Type: "Product", OnDelete: item.OnDeleteCascade},
+		{Field: "game", Type: "Game", OnDelete: item.OnDeleteCascade},
+	}
+}
+
+func (r *Review) ContentStruct() map[string]interface{} {
+	dd := map[string]item.FieldDescription{
+		"product": {
+			Type:       "select",
+			DataType:   "content",
+			DataSource: []string{"/admin/v1/contents?type=Product&count=-1"},
+			Required:   true,
+			Order:      1,
+		},
+		"game": {
+			Type:       "select",
+			DataType:   "content",
+			DataSource: []string{"/admin/v1/contents?type=Game&count=-1"},
+			Help:       "产品所属的游戏，用于统计游戏的评分",
+			Order:      2,
+		},
+		"rating": {
+			Type:       "select",
+			DataType:   "field",
+			DataSource: []string{"1", "2", "3", "4", "5"},
+			Required:   true,
+			Help:       "评分，1到5星",
+			Order:      10,
+		},
+		"title": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Order:      20,
+		},
+		"text": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "评论内容",
+			Order:      30,
+		},
+		"name": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "显示的顾客名字",
+			Order:      40,
+		},
+		"email": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "顾客的邮件，不对外显示",
+			Order:      50,
+		},
+		"verified": {
+			Type:       "bool",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "顾客有包含本产品的已完成订单",
+			Order:      60,
+			Others:     "false",
+		},
+		"order_id": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "验证购买的订单号",
+			Order:      70,
+		},
+		"ip": {
+			Type:       "input",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "提交评论的地址",
+			Order:      80,
+		},
+	}
+	return map[string]interface{}{
+		"data": dd,
+		"no":   280,
+	}
+}
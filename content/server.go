@@ -10,20 +10,21 @@ import (
 type Server struct {
 	item.Item
 
-	Name        string  `json:"name"`
-	ShortName   string  `json:"sName,omitempty"`    //长名
-	LongName    string  `json:"longName,omitempty"` //长名
-	Game        string  `json:"game"`
-	Online      bool    `json:"online"`
-	Category    string  `json:"category,omitempty"`
-	Tags        string  `json:"tags,omitempty"`
-	Coins       string  `json:"coins,omitempty"` //服务器上所有在卖的coin
-	Items       string  `json:"items,omitempty"` //服务器上的所有在卖的item
-	UnitPrice   float32 `json:"price"`           // 金币单价
-	UnitName    string  `json:"unitName"`        // 单位的名字
-	Hint        string  `json:"hint,omitempty"`  //替代server名字
-	Order       int     `json:"order,omitempty"`
-	Description string  `json:"description,omitempty`
+	Name        string     `json:"name"`
+	ShortName   string     `json:"sName,omitempty"`    //长名
+	LongName    string     `json:"longName,omitempty"` //长名
+	Game        string     `json:"game"`
+	Online      bool       `json:"online"`
+	Category    string     `json:"category,omitempty"`
+	Tags        string     `json:"tags,omitempty"`
+	Coins       string     `json:"coins,omitempty"` //服务器上所有在卖的coin
+	Items       string     `json:"items,omitempty"` //服务器上的所有在卖的item
+	UnitPrice   float32    `json:"price"`           // 金币单价
+	UnitName    string     `json:"unitName"`        // 单位的名字
+	Tiers       PriceTiers `json:"tiers,omitempty"` // 按数量的金币单价
+	Hint        string     `json:"hint,omitempty"`  //替代server名字
+	Order       int        `json:"order,omitempty"`
+	Description string     `json:"description,omitempty`
 }
 
 // MarshalEditor writes a buffer of html to edit a Server within the CMS
@@ -94,6 +95,12 @@ func (s *Server) String() string {
 	return fmt.Sprintf("Server: %s", s.Name)
 }
 
+// Localized returns the fields translated per locale, the description is
+// stored under its Go name
+func (s *Server) Localized() []string {
+	return []string{"Description", "hint"}
+}
+
 func (o *Server) ContentStruct() map[string]interface{} {
 	dd := map[string]item.FieldDescription{
 		"name": {
@@ -147,6 +154,13 @@ func (o *Server) ContentStruct() map[string]interface{} {
 			Required:   true,
 			Order:      52,
 		},
+		"tiers": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "按购买数量分段的金币单价，json格式，如 [{\"min\":1,\"max\":999,\"price\":0.012},{\"min\":1000,\"percent\":8}]，数量段不能重叠或留空",
+			Order:      53,
+		},
 		"unitName": {
 			Type:       "input",
 			DataType:   "field",
@@ -199,6 +213,13 @@ func (o *Server) ContentStruct() map[string]interface{} {
 			DataSource: []string{""},
 			Order:      90,
 		},
+		"i18n": {
+			Type:       "textarea",
+			DataType:   "field",
+			DataSource: []string{},
+			Help:       "各语言的翻译，json格式，如 [{\"locale\":\"fr\",\"fields\":{\"Description\":\"...\"}}]，可翻译 Description、hint，未翻译的内容显示默认语言",
+			Order:      300,
+		},
 	}
 	//retStr, _ := json.Marshal(dd)
 	return map[string]interface{}{
@@ -210,3 +231,14 @@ func (o *Server) ContentStruct() map[string]interface{} {
 func (g *Server) IndexContent() bool {
 	return true
 }
+
+// References implements item.Referencer, servers go with their game and the
+// coins and items sold on a deleted product are dropped from the lists
+func (s *Server) References() []item.Reference {
+	return []item.Reference{
+		{Field: "game", Type: "Game", OnDelete: item.OnDeleteCascade},
+		{Field: "category", Type: "Category", OnDelete: item.OnDeleteRestrict},
+		{Field: "coins", Type: "Product", Multi: true, OnDelete: item.OnDeleteCascade},
+		{Field: "items", Type: "Product", Multi: true, OnDelete: item.OnDeleteCascade},
+	}
+}
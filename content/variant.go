@@ -0,0 +1,197 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ProductOption is a choice the customer makes on a product, e.g. platform
+// with the values PC, PS4 and Xbox
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductOptions are the options of a product, stored as a json array and
+// posted by the admin editor as json text like PriceTiers
+type ProductOptions []ProductOption
+
+// UnmarshalText parse and validate the options from the admin form
+func (p *ProductOptions) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if len(s) == 0 || s == "null" {
+		*p = nil
+		return nil
+	}
+	options := []ProductOption{}
+	if err := json.Unmarshal([]byte(s), &options); err != nil {
+		return fmt.Errorf("product options are not a json list: %s", err)
+	}
+	if err := ProductOptions(options).Validate(); err != nil {
+		return err
+	}
+	*p = options
+	return nil
+}
+
+// UnmarshalJSON reads the stored options
+func (p *ProductOptions) UnmarshalJSON(b []byte) error {
+	options := []ProductOption{}
+	if err := json.Unmarshal(b, &options); err != nil {
+		return err
+	}
+	*p = options
+	return nil
+}
+
+// Validate checks every option has a unique name and distinct values
+func (p ProductOptions) Validate() error {
+	names := map[string]bool{}
+	for i, o := range p {
+		if len(strings.TrimSpace(o.Name)) == 0 {
+			return fmt.Errorf("option %d has no name", i+1)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("option %s is defined twice", o.Name)
+		}
+		names[o.Name] = true
+		if len(o.Values) == 0 {
+			return fmt.Errorf("option %s has no value", o.Name)
+		}
+		values := map[string]bool{}
+		for _, v := range o.Values {
+			if len(strings.TrimSpace(v)) == 0 || values[v] {
+				return fmt.Errorf("option %s has an empty or repeated value", o.Name)
+			}
+			values[v] = true
+		}
+	}
+	return nil
+}
+
+// Has tells if value is one of the values of option name
+func (p ProductOptions) Has(name, value string) bool {
+	for _, o := range p {
+		if o.Name != name {
+			continue
+		}
+		for _, v := range o.Values {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ProductVariant is one sellable combination of the product options with
+// its own SKU, price, stock and online flag
+type ProductVariant struct {
+	SKU     string            `json:"sku"`
+	Options map[string]string `json:"options"` // option name to value
+	Price   float32           `json:"price"`
+	Stock   uint64            `json:"stock"`
+	Online  bool              `json:"online"`
+}
+
+// String shows the option values of the variant, ordered by option name
+func (v ProductVariant) String() string {
+	keys := make([]string, 0, len(v.Options))
+	for k := range v.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, k+":"+v.Options[k])
+	}
+	return strings.Join(values, ",")
+}
+
+// ProductVariants are the variants of a product, stored as a json array
+type ProductVariants []ProductVariant
+
+// UnmarshalText parse and validate the variants from the admin form, the
+// options they use are checked by Product.Validate
+func (p *ProductVariants) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if len(s) == 0 || s == "null" {
+		*p = nil
+		return nil
+	}
+	variants := []ProductVariant{}
+	if err := json.Unmarshal([]byte(s), &variants); err != nil {
+		return fmt.Errorf("product variants are not a json list: %s", err)
+	}
+	if err := ProductVariants(variants).validate(nil, false); err != nil {
+		return err
+	}
+	*p = variants
+	return nil
+}
+
+// UnmarshalJSON reads the stored variants
+func (p *ProductVariants) UnmarshalJSON(b []byte) error {
+	variants := []ProductVariant{}
+	if err := json.Unmarshal(b, &variants); err != nil {
+		return err
+	}
+	*p = variants
+	return nil
+}
+
+// Validate checks the SKUs are set and unique, the prices positive, every
+// variant picks one value of each option and no two variants share the same
+// option values
+func (p ProductVariants) Validate(options ProductOptions) error {
+	return p.validate(options, true)
+}
+
+// validate checks the variants, against the options when withOptions is set
+func (p ProductVariants) validate(options ProductOptions, withOptions bool) error {
+	skus := map[string]bool{}
+	combos := map[string]string{}
+	for i, v := range p {
+		if len(strings.TrimSpace(v.SKU)) == 0 {
+			return fmt.Errorf("variant %d has no sku", i+1)
+		}
+		if skus[v.SKU] {
+			return fmt.Errorf("variant sku %s is used twice", v.SKU)
+		}
+		skus[v.SKU] = true
+		if v.Price <= 0 {
+			return fmt.Errorf("variant %s: price must be above 0", v.SKU)
+		}
+		if len(v.Options) > 0 {
+			if sku, ok := combos[v.String()]; ok {
+				return fmt.Errorf("variant %s has the same options as %s", v.SKU, sku)
+			}
+			combos[v.String()] = v.SKU
+		}
+
+		if !withOptions {
+			continue
+		}
+		if len(v.Options) != len(options) {
+			return fmt.Errorf("variant %s must set each of the %d options", v.SKU, len(options))
+		}
+		for name, value := range v.Options {
+			if !options.Has(name, value) {
+				return fmt.Errorf("variant %s: %s is not a value of option %s", v.SKU, value, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Find returns the variant with sku
+func (p ProductVariants) Find(sku string) (ProductVariant, bool) {
+	for _, v := range p {
+		if v.SKU == sku {
+			return v, true
+		}
+	}
+	return ProductVariant{}, false
+}
@@ -0,0 +1,77 @@
+package content
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestProductOptionsValidate(t *testing.T) {
+	cases := []struct {
+		json string
+		ok   bool
+	}{
+		{``, true},
+		{`[{"name":"platform","values":["PC","PS4"]},{"name":"region","values":["EU","US"]}]`, true},
+		{`[{"name":"platform","values":["PC"]},{"name":"platform","values":["PS4"]}]`, false},
+		{`[{"name":"platform","values":["PC","PC"]}]`, false},
+		{`[{"name":"platform","values":[]}]`, false},
+		{`[{"name":"","values":["PC"]}]`, false},
+		{`{"name":"platform"}`, false},
+	}
+	for _, c := range cases {
+		options := ProductOptions{}
+		err := options.UnmarshalText([]byte(c.json))
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.json, c.ok, err)
+		}
+	}
+}
+
+func TestProductVariantsValidate(t *testing.T) {
+	options := ProductOptions{}
+	if err := options.UnmarshalText([]byte(`[{"name":"platform","values":["PC","PS4"]},{"name":"region","values":["EU","US"]}]`)); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		json string
+		ok   bool
+	}{
+		{`[{"sku":"A","options":{"platform":"PC","region":"EU"},"price":5},{"sku":"B","options":{"platform":"PS4","region":"EU"},"price":6}]`, true},
+		{`[{"sku":"A","options":{"platform":"PC","region":"EU"},"price":5},{"sku":"A","options":{"platform":"PS4","region":"EU"},"price":6}]`, false}, // same sku
+		{`[{"sku":"A","options":{"platform":"PC","region":"EU"},"price":5},{"sku":"B","options":{"region":"EU","platform":"PC"},"price":6}]`, false},  // same options
+		{`[{"sku":"A","options":{"platform":"PC"},"price":5}]`, false},                                                                                // missing region
+		{`[{"sku":"A","options":{"platform":"Xbox","region":"EU"},"price":5}]`, false},                                                                // unknown value
+		{`[{"sku":"","options":{"platform":"PC","region":"EU"},"price":5}]`, false},
+		{`[{"sku":"A","options":{"platform":"PC","region":"EU"},"price":0}]`, false},
+	}
+	for _, c := range cases {
+		variants := ProductVariants{}
+		err := json.Unmarshal([]byte(c.json), &variants)
+		if err == nil {
+			err = variants.Validate(options)
+		}
+		if (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.json, c.ok, err)
+		}
+	}
+}
+
+func TestProductValidate(t *testing.T) {
+	p := &Product{}
+	err := json.Unmarshal([]byte(`{"name":"Master Key","options":[{"name":"platform","values":["PC","PS4"]}],
+		"variants":[{"sku":"MK-PC","options":{"platform":"PC"},"price":5.99,"stock":3,"online":true}]}`), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Validate(); err != nil {
+		t.Errorf("expected valid product, got %s", err)
+	}
+	if v, ok := p.Variants.Find("MK-PC"); !ok || v.String() != "platform:PC" {
+		t.Errorf("expected variant MK-PC, got %v", v)
+	}
+
+	p.Options = nil
+	if err = p.Validate(); err == nil {
+		t.Error("expected error for variant options without product options")
+	}
+}
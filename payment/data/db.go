@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -77,6 +78,25 @@ func GetRequestByID(orderid string) (*UserSubmitOrderRequest, error) {
 	return &rr, err
 }
 
+// RequestOfOrder returns the submitted request an order was created from.
+// PayPal orders made before the invoice id was the request id carry the next
+// short id, the request before it is taken when it has the same buyer.
+func RequestOfOrder(order *Order) (*UserSubmitOrderRequest, error) {
+	req, err := GetRequestByID(order.OrderID)
+	if err == nil {
+		return req, nil
+	}
+	id, perr := strconv.ParseInt(order.OrderID, 10, 64)
+	if perr != nil || id <= 0 {
+		return nil, err
+	}
+	prev, perr := GetRequestByID(fmt.Sprint(id - 1))
+	if perr != nil || !strings.EqualFold(prev.Email, order.User) {
+		return nil, err
+	}
+	return prev, nil
+}
+
 ///=========================================================================
 /*
 数据结构如下
@@ -438,3 +458,52 @@ func removeQuote(s string) string {
 	}
 	return s
 }
+
+// SaveGiftCardRedemption append a redemption to the ledger of the gift card
+func SaveGiftCardRedemption(r *GiftCardRedemption) error {
+	r.Timestamp = time.Now().Unix()
+
+	return PaymentLogHandler.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(DBGiftCardLog))
+		if root == nil {
+			return bolt.ErrBucketNotFound
+		}
+		bkt, err := root.CreateBucketIfNotExists([]byte(r.Code))
+		if err != nil {
+			return err
+		}
+		id, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(fmt.Sprintf("%010d", id)), buf)
+	})
+}
+
+// GetGiftCardRedemptions return the ledger of the gift card, oldest first
+func GetGiftCardRedemptions(code string) ([]GiftCardRedemption, error) {
+	ret := []GiftCardRedemption{}
+	err := PaymentLogHandler.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(DBGiftCardLog))
+		if root == nil {
+			return bolt.ErrBucketNotFound
+		}
+		bkt := root.Bucket([]byte(code))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			r := GiftCardRedemption{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			ret = append(ret, r)
+			return nil
+		})
+	})
+	return ret, err
+}
@@ -0,0 +1,63 @@
+package data
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// OrderStatusHandler is called when an order moves into a new status, before
+// is nil when the order is created with that status
+type OrderStatusHandler func(before, after *Order)
+
+var (
+	statusHandlers   = map[string][]OrderStatusHandler{}
+	statusHandlersMu sync.RWMutex
+)
+
+func init() {
+	db.AddContentWatcher(OrderName, orderWatcher)
+}
+
+// OnOrderStatus registers fn to run each time an order enters status
+func OnOrderStatus(status string, fn OrderStatusHandler) {
+	statusHandlersMu.Lock()
+	defer statusHandlersMu.Unlock()
+
+	statusHandlers[status] = append(statusHandlers[status], fn)
+}
+
+// orderWatcher compares the saved order with the previous one and fires the
+// status handlers when the status changed
+func orderWatcher(target string, before, after []byte) {
+	if after == nil {
+		return
+	}
+	newOrder := &Order{}
+	if err := json.Unmarshal(after, newOrder); err != nil {
+		logger.Errorf("Order watcher decode %s error: %s", target, err)
+		return
+	}
+
+	var oldOrder *Order
+	if before != nil {
+		oldOrder = &Order{}
+		if err := json.Unmarshal(before, oldOrder); err != nil {
+			logger.Errorf("Order watcher decode %s error: %s", target, err)
+			return
+		}
+		if oldOrder.Status == newOrder.Status {
+			return
+		}
+	}
+
+	statusHandlersMu.RLock()
+	list := statusHandlers[newOrder.Status]
+	statusHandlersMu.RUnlock()
+
+	logger.Debugf("Order %s status changed to %s", newOrder.OrderID, newOrder.Status)
+	for _, fn := range list {
+		fn(oldOrder, newOrder)
+	}
+}
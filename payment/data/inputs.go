@@ -0,0 +1,65 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/agreyfox/eshop/content"
+)
+
+// itemSchema returns the input schema of an order item, the one of the
+// product or else the one of its game
+func itemSchema(it Item) content.InputSchema {
+	if c := findContent("Product", it.Product, it.Game); c != nil {
+		product := content.Product{}
+		if json.Unmarshal(c, &product) == nil && len(product.Inputs) > 0 {
+			return product.Inputs
+		}
+	}
+	if c := findContent("Game", it.Game, ""); c != nil {
+		game := content.Game{}
+		if json.Unmarshal(c, &game) == nil {
+			return game.Inputs
+		}
+	}
+	return nil
+}
+
+// ApplyInputs checks the buyer inputs of the order items against the input
+// schema of their product or game and keeps the checked values on the items.
+// The values are also written to the request info the fulfillment workers
+// and the payment pages show, before what the buyer typed there.
+func ApplyInputs(req *UserSubmitOrderRequest) error {
+	lines := []string{}
+	for i, it := range req.ItemList {
+		if it.Category == GiftCardCategory {
+			continue
+		}
+		schema := itemSchema(it)
+		if len(schema) == 0 {
+			continue
+		}
+		values, err := schema.Check(it.Inputs)
+		if err != nil {
+			return fmt.Errorf("%s: %s", it.Product, err)
+		}
+		req.ItemList[i].Inputs = values
+		if text := schema.Format(values); len(text) > 0 {
+			if len(req.ItemList) > 1 {
+				text = it.Product + "\n" + text
+			}
+			lines = append(lines, text)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	info := strings.Join(lines, "\n")
+	if len(strings.TrimSpace(req.RequestInfo)) > 0 {
+		info += "\n" + req.RequestInfo
+	}
+	req.RequestInfo = info
+	return nil
+}
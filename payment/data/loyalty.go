@@ -0,0 +1,263 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/boltdb/bolt"
+	"github.com/robfig/cron"
+)
+
+var (
+	// DefaultPointRate is the points earned per currency unit when neither the
+	// product nor the game set a rate, PaymentSetting loyalty_point_rate
+	DefaultPointRate = 1.0
+	// PointValue is the discount of one point at checkout, PaymentSetting
+	// loyalty_point_value
+	PointValue = 0.01
+	// PointsHoldHours is how long the points of an unpaid checkout stay taken
+	// before they go back to the customer, PaymentSetting loyalty_hold_hours
+	PointsHoldHours = 24
+)
+
+func init() {
+	OnOrderStatus(OrderCompleted, earnPoints)
+	OnOrderStatus(OrderRefunded, reversePoints)
+	OnOrderStatus(OrderDisputed, reversePoints)
+}
+
+// LoadLoyaltySetting read the loyalty setting from PaymentSetting
+func LoadLoyaltySetting() {
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "loyalty_point_rate", "valueString"); err == nil {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			DefaultPointRate = f
+		}
+	}
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "loyalty_point_value", "valueString"); err == nil {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			PointValue = f
+		}
+	}
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "loyalty_point_days", "valueString"); err == nil {
+		if d, err := strconv.Atoi(v); err == nil {
+			db.PointsValidDays = d
+		}
+	}
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "loyalty_hold_hours", "valueString"); err == nil {
+		if h, err := strconv.Atoi(v); err == nil && h > 0 {
+			PointsHoldHours = h
+		}
+	}
+	logger.Infof("Loyalty points rate %v, value %v, valid %d days, held %d hours", DefaultPointRate, PointValue, db.PointsValidDays, PointsHoldHours)
+}
+
+// pointRate find the rate of an order item, product first then game
+func pointRate(it Item) float64 {
+	if c := findContent("Product", it.Product, it.Game); c != nil {
+		product := struct {
+			PointRate float64 `json:"pointRate"`
+		}{}
+		if json.Unmarshal(c, &product) == nil && product.PointRate > 0 {
+			return product.PointRate
+		}
+	}
+	qo := db.QueryOptions{Count: -1, Offset: 0, Order: "desc"}
+	if len(it.Game) > 0 {
+		_, games := db.QueryByFieldValue("Game", "name", it.Game, qo)
+		for _, g := range games {
+			game := struct {
+				PointRate float64 `json:"pointRate"`
+			}{}
+			if json.Unmarshal(g, &game) == nil && game.PointRate > 0 {
+				return game.PointRate
+			}
+		}
+	}
+	return DefaultPointRate
+}
+
+// OrderPoints calculate the points an order earns, the item prices are in the
+// order currency and the point rates per unit of the base currency
+func OrderPoints(order *Order) int {
+	items := []Item{}
+	if err := json.Unmarshal([]byte(order.OrderDetail), &items); err != nil {
+		logger.Warnf("Order %s detail is not a item list, no points", order.OrderID)
+		return 0
+	}
+	rate, err := currencyRate(order.Currency)
+	if err != nil {
+		logger.Warnf("Order %s %s, no points", order.OrderID, err)
+		return 0
+	}
+	total := 0.0
+	for _, it := range items {
+		if it.Category == GiftCardCategory {
+			continue
+		}
+		total += it.UnitPrice / rate * float64(it.Quantity) * pointRate(it)
+	}
+	return int(math.Floor(total))
+}
+
+// earnPoints give points to the customer when the order is completed
+func earnPoints(before, after *Order) {
+	if len(after.User) == 0 || after.IsRefund || after.IsChargeBack {
+		return
+	}
+	points := OrderPoints(after)
+	if points <= 0 {
+		return
+	}
+	_, err := db.EarnPoints(after.User, points, after.OrderID)
+	if err != nil {
+		logger.Errorf("Earn points of order %s error: %s", after.OrderID, err)
+	}
+}
+
+// reversePoints take back the points of a refunded or charged back order and
+// give back the points it redeemed, which are kept under its request id
+func reversePoints(before, after *Order) {
+	if len(after.User) == 0 {
+		return
+	}
+	_, err := db.ReverseOrderPoints(after.User, after.OrderID)
+	if err != nil {
+		logger.Errorf("Reverse points of order %s error: %s", after.OrderID, err)
+	}
+	if req, err := RequestOfOrder(after); err == nil && req.OrderID != after.OrderID {
+		if _, err := db.ReverseOrderPoints(req.Email, req.OrderID); err != nil {
+			logger.Errorf("Release points of order %s error: %s", req.OrderID, err)
+		}
+	}
+}
+
+// ApplyPoints redeem the points in the request as a discount of the order
+// total. Points are taken when the order is submitted, ReleasePoints gives
+// them back when the order could not be created and ReleaseUnpaidPoints when
+// it is not paid within PointsHoldHours.
+func ApplyPoints(r *http.Request, req *UserSubmitOrderRequest) error {
+	if req.Points <= 0 {
+		return nil
+	}
+	buf, err := db.CurrentUser(r)
+	if err != nil {
+		return fmt.Errorf("login to use points")
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+	if !strings.EqualFold(usr.Email, req.Email) {
+		return fmt.Errorf("points belong to other user")
+	}
+
+	value := math.Round(float64(req.Points)*PointValue*100) / 100
+	if value >= req.Amount {
+		return fmt.Errorf("points value is over order amount")
+	}
+	_, err = db.RedeemPoints(usr.Email, req.Points, req.OrderID)
+	if err != nil {
+		return err
+	}
+	req.PointsValue = value
+	req.Amount = math.Round((req.Amount-value)*100) / 100
+	logger.Infof("Order %s use %d points as %.2f discount", req.OrderID, req.Points, value)
+	return nil
+}
+
+// ReleasePoints give back the points redeemed by an order which failed
+func ReleasePoints(req *UserSubmitOrderRequest) {
+	if req.Points <= 0 || req.PointsValue <= 0 {
+		return
+	}
+	_, err := db.ReverseOrderPoints(req.Email, req.OrderID)
+	if err != nil {
+		logger.Errorf("Release points of order %s error: %s", req.OrderID, err)
+	}
+}
+
+// orderStatus returns the status of the order created for request id, empty
+// when the payment never created one
+func orderStatus(id string) string {
+	qo := db.QueryOptions{Count: 1, Offset: 0, Order: "desc"}
+	_, found := db.QueryByFieldValue(OrderName, "order_id", id, qo)
+	if len(found) == 0 {
+		return ""
+	}
+	order := Order{}
+	json.Unmarshal(found[0], &order)
+	return order.Status
+}
+
+// settled tells if the request of a checkout has its final status
+func settled(req *UserSubmitOrderRequest) bool {
+	return len(req.Status) > 0 && req.Status != OrderUnPaid && req.Status != OrderPending
+}
+
+// releasable tells if the points held by req go back, the request is older
+// than cutoff and its order was never created or stayed unpaid. A pending
+// payment may still complete and keeps them.
+func releasable(req *UserSubmitOrderRequest, status string, cutoff int64) bool {
+	if req.Points <= 0 || req.PointsValue <= 0 || req.OrderDate >= cutoff || settled(req) {
+		return false
+	}
+	return status == "" || status == OrderUnPaid
+}
+
+// ReleaseUnpaidPoints gives back the points of the checkouts not paid within
+// PointsHoldHours of now, abandoned or cancelled at the payment vendor, and
+// marks their request cancelled. The request of a paid order takes its
+// status so it is not looked at again. It returns the number of requests
+// released.
+func ReleaseUnpaidPoints(now time.Time) int {
+	held := []*UserSubmitOrderRequest{}
+	err := PaymentDBHandler.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(DBRequest)).ForEach(func(k, v []byte) error {
+			req := &UserSubmitOrderRequest{}
+			if json.Unmarshal(v, req) == nil && req.Points > 0 && !settled(req) {
+				held = append(held, req)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error("Read held points error:", err)
+		return 0
+	}
+
+	cutoff := now.Add(-time.Duration(PointsHoldHours) * time.Hour).Unix()
+	n := 0
+	for _, req := range held {
+		status := orderStatus(req.OrderID)
+		switch {
+		case releasable(req, status, cutoff):
+			logger.Infof("Order %s is not paid after %d hours, release %d points", req.OrderID, PointsHoldHours, req.Points)
+			ReleasePoints(req)
+			req.Status = OrderCancel
+			n++
+		case len(status) > 0 && status != OrderUnPaid && status != OrderPending:
+			req.Status = status
+		default:
+			continue
+		}
+		if err := SaveOrderRequest(req); err != nil {
+			logger.Errorf("Save request %s status error: %s", req.OrderID, err)
+		}
+	}
+	return n
+}
+
+// StartPointsRelease run ReleaseUnpaidPoints every hour
+func StartPointsRelease() {
+	logger.Info("Start unpaid points release job")
+	job := cron.New()
+	job.AddFunc("@every 1h", func() {
+		ReleaseUnpaidPoints(time.Now())
+	})
+	job.Start()
+}
@@ -0,0 +1,29 @@
+package data
+
+import "testing"
+
+func TestReleasable(t *testing.T) {
+	cutoff := int64(1000)
+	cases := []struct {
+		req    UserSubmitOrderRequest
+		status string
+		want   bool
+	}{
+		{UserSubmitOrderRequest{Points: 100, PointsValue: 1, OrderDate: 900}, "", true},
+		{UserSubmitOrderRequest{Points: 100, PointsValue: 1, OrderDate: 900}, OrderUnPaid, true},
+		{UserSubmitOrderRequest{Points: 100, PointsValue: 1, OrderDate: 900, Status: OrderUnPaid}, "", true},
+		// still inside the hold time
+		{UserSubmitOrderRequest{Points: 100, PointsValue: 1, OrderDate: 1000}, "", false},
+		// the payment may still complete
+		{UserSubmitOrderRequest{Points: 100, PointsValue: 1, OrderDate: 900}, OrderPending, false},
+		{UserSubmitOrderRequest{Points: 100, PointsValue: 1, OrderDate: 900}, OrderPaid, false},
+		// released before
+		{UserSubmitOrderRequest{Points: 100, PointsValue: 1, OrderDate: 900, Status: OrderCancel}, "", false},
+		{UserSubmitOrderRequest{OrderDate: 900}, "", false},
+	}
+	for i, c := range cases {
+		if got := releasable(&c.req, c.status, cutoff); got != c.want {
+			t.Errorf("case %d: %v, want %v", i, got, c.want)
+		}
+	}
+}
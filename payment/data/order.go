@@ -0,0 +1,30 @@
+package data
+
+import (
+	"net/http"
+	"time"
+)
+
+// PrepareOrder runs the steps every payment service takes on a submitted
+// order after its own validation: the buyer inputs, the verified email, a new
+// order id, the server prices, the referral and the redeemed points. It
+// returns the retCode the service answers an error with. Points are taken
+// here, the service calls ReleasePoints when the order fails after it.
+func PrepareOrder(r *http.Request, req *UserSubmitOrderRequest) (int, error) {
+	if err := ApplyInputs(req); err != nil {
+		return -1, err
+	}
+	if err := CheckVerified(r, req); err != nil {
+		return -4, err
+	}
+	req.OrderID = GetShortOrderID()
+	req.OrderDate = time.Now().Unix()
+	if err := PriceItems(req); err != nil {
+		return -3, err
+	}
+	ApplyReferral(r, req)
+	if err := ApplyPoints(r, req); err != nil {
+		return -3, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,169 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/boltdb/bolt"
+)
+
+func init() {
+	db.AddPersonalData("payment_requests", db.PersonalData{
+		Export: exportRequests,
+		Erase:  eraseRequests,
+	})
+	db.AddPersonalData("payment_logs", db.PersonalData{
+		Export: exportPaymentLogs,
+		Erase:  erasePaymentLogs,
+	})
+}
+
+// requestOf tells if the order request belongs to the keys
+func requestOf(r *UserSubmitOrderRequest, k db.PersonalKeys) bool {
+	if strings.EqualFold(r.Email, k.Email) {
+		return true
+	}
+	for _, id := range k.Orders {
+		if r.OrderID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// logOf tells if the payment log belongs to the keys
+func logOf(l *PaymentLog, k db.PersonalKeys) bool {
+	if strings.EqualFold(l.BuyerEmail, k.Email) {
+		return true
+	}
+	for _, id := range k.Orders {
+		if l.OrderID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// exportRequests returns the order requests of the customer
+func exportRequests(k db.PersonalKeys) (interface{}, error) {
+	list := []UserSubmitOrderRequest{}
+	if PaymentDBHandler == nil {
+		return list, nil
+	}
+	err := PaymentDBHandler.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DBRequest))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			r := UserSubmitOrderRequest{}
+			if json.Unmarshal(v, &r) == nil && requestOf(&r, k) {
+				list = append(list, r)
+			}
+			return nil
+		})
+	})
+	return list, err
+}
+
+// eraseRequests keeps the amounts of the order requests of the customer and
+// clears the buyer details
+func eraseRequests(k db.PersonalKeys) (int, error) {
+	if PaymentDBHandler == nil {
+		return 0, nil
+	}
+	n := 0
+	err := PaymentDBHandler.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DBRequest))
+		if b == nil {
+			return nil
+		}
+		changed := map[string]*UserSubmitOrderRequest{}
+		b.ForEach(func(key, v []byte) error {
+			r := &UserSubmitOrderRequest{}
+			if json.Unmarshal(v, r) == nil && requestOf(r, k) {
+				changed[string(key)] = r
+			}
+			return nil
+		})
+		for key, r := range changed {
+			r.Email = k.Anon
+			r.FirstName, r.LastName, r.IPAddr, r.ContactInfo = "", "", "", ""
+			r.Phone, r.Address, r.City, r.RequestInfo = "", "", "", ""
+			for i := range r.ItemList {
+				r.ItemList[i].Recipient, r.ItemList[i].Message = "", ""
+			}
+			j, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(key), j); err != nil {
+				return err
+			}
+		}
+		n = len(changed)
+		return nil
+	})
+	return n, err
+}
+
+// exportPaymentLogs returns the payment logs of the orders of the customer
+func exportPaymentLogs(k db.PersonalKeys) (interface{}, error) {
+	list := []PaymentLog{}
+	if PaymentLogHandler == nil {
+		return list, nil
+	}
+	err := PaymentLogHandler.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DBLogName))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			l := PaymentLog{}
+			if v != nil && json.Unmarshal(v, &l) == nil && logOf(&l, k) {
+				list = append(list, l)
+			}
+			return nil
+		})
+	})
+	return list, err
+}
+
+// erasePaymentLogs keeps the payment id, state and amount of the logs of the
+// customer and drops the buyer details and the provider messages holding them
+func erasePaymentLogs(k db.PersonalKeys) (int, error) {
+	if PaymentLogHandler == nil {
+		return 0, nil
+	}
+	n := 0
+	err := PaymentLogHandler.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DBLogName))
+		if b == nil {
+			return nil
+		}
+		changed := map[string]*PaymentLog{}
+		b.ForEach(func(key, v []byte) error {
+			l := &PaymentLog{}
+			if v != nil && json.Unmarshal(v, l) == nil && logOf(l, k) {
+				changed[string(key)] = l
+			}
+			return nil
+		})
+		for key, l := range changed {
+			l.BuyerEmail = k.Anon
+			l.IP, l.Address, l.Comments = "", "", ""
+			l.RequestData, l.ReturnData = nil, nil
+			j, err := json.Marshal(l)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(key), j); err != nil {
+				return err
+			}
+		}
+		n = len(changed)
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,233 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/content"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/promotion"
+)
+
+// findContent returns the content of type ns named name, when several share
+// the name the one of game is taken
+func findContent(ns, name, game string) []byte {
+	if len(name) == 0 {
+		return nil
+	}
+	qo := db.QueryOptions{Count: -1, Offset: 0, Order: "desc"}
+	_, found := db.QueryByFieldValue(ns, "name", name, qo)
+	if len(found) == 1 {
+		return found[0]
+	}
+	for _, c := range found {
+		g := struct {
+			Game string `json:"game"`
+		}{}
+		if json.Unmarshal(c, &g) == nil && len(game) > 0 && strings.Contains(g.Game, game) {
+			return c
+		}
+	}
+	return nil
+}
+
+// itemTiers returns the price tiers of an order item and the base price they
+// apply to. Product tiers come first, then the coin tiers of the server.
+func itemTiers(it Item) (content.PriceTiers, float64) {
+	if c := findContent("Product", it.Product, it.Game); c != nil {
+		product := content.Product{}
+		if json.Unmarshal(c, &product) == nil && len(product.Tiers) > 0 {
+			return product.Tiers, float64(product.Price)
+		}
+	}
+	if c := findContent("Server", it.Server, it.Game); c != nil {
+		server := content.Server{}
+		if json.Unmarshal(c, &server) == nil && len(server.Tiers) > 0 {
+			return server.Tiers, float64(server.UnitPrice)
+		}
+	}
+	return nil, 0
+}
+
+// pricingNow is the clock of the discount windows, replaced in tests
+var pricingNow = time.Now
+
+// discountPercent returns the percent off d gives quantity at now. A discount
+// with a start or end time counts only inside its window in its timezone, one
+// without them while it is online. The level of the list with the largest
+// qty up to quantity applies.
+func discountPercent(d content.Discount, quantity int, now time.Time) float64 {
+	w, err := promotion.NewWindow(d.Starttime, d.Endtime, d.Timezone)
+	if err != nil {
+		logger.Warnf("Discount %s window error: %s", d.Name, err)
+		return 0
+	}
+	if (w.Scheduled() && !w.Active(now)) || (!w.Scheduled() && !d.Online) {
+		return 0
+	}
+	levels := []map[string]interface{}{}
+	if err := json.Unmarshal([]byte(d.List), &levels); err != nil {
+		logger.Warnf("Discount %s list error: %s", d.Name, err)
+		return 0
+	}
+	qty, percent := 0.0, 0.0
+	for _, l := range levels {
+		q, err1 := strconv.ParseFloat(fmt.Sprint(l["qty"]), 64)
+		p, err2 := strconv.ParseFloat(fmt.Sprint(l["discount"]), 64)
+		if err1 != nil || err2 != nil || q > float64(quantity) || q < qty || p <= 0 || p >= 100 {
+			continue
+		}
+		qty, percent = q, p
+	}
+	return percent
+}
+
+// itemDiscount returns the percent off the discount of the product of an
+// order item gives at now, and the product price
+func itemDiscount(it Item, now time.Time) (float64, float64) {
+	product := content.Product{}
+	c := findContent("Product", it.Product, it.Game)
+	if c == nil || json.Unmarshal(c, &product) != nil || len(product.Discount) == 0 {
+		return 0, 0
+	}
+	id, _ := db.ReferenceKey(product.Discount)
+	d := content.Discount{}
+	if buf, err := db.Content("Discount:" + strconv.Itoa(id)); err != nil || len(buf) == 0 || json.Unmarshal(buf, &d) != nil {
+		return 0, 0
+	}
+	return discountPercent(d, it.Quantity, now), float64(product.Price)
+}
+
+// currencyRate returns the rate of the order currency to the price currency
+func currencyRate(name string) (float64, error) {
+	c := findContent("Currency", name, "")
+	if c == nil {
+		return 0, fmt.Errorf("currency %s is not supported", name)
+	}
+	currency := content.Currency{}
+	if err := json.Unmarshal(c, &currency); err != nil || currency.Rate <= 0 {
+		return 0, fmt.Errorf("currency %s has no rate", name)
+	}
+	return currency.Rate, nil
+}
+
+// itemVariant returns the variant of an order item with its product tiers,
+// the variant must be online with enough stock for the quantity
+func itemVariant(it Item) (content.ProductVariant, content.PriceTiers, error) {
+	product := content.Product{}
+	c := findContent("Product", it.Product, it.Game)
+	if c == nil || json.Unmarshal(c, &product) != nil {
+		return content.ProductVariant{}, nil, fmt.Errorf("product %s is not found", it.Product)
+	}
+	v, ok := product.Variants.Find(it.Variant)
+	if !ok || !v.Online {
+		return v, nil, fmt.Errorf("%s %s is not available", it.Product, it.Variant)
+	}
+	if v.Stock < uint64(it.Quantity) {
+		return v, nil, fmt.Errorf("%s %s is out of stock", it.Product, it.Variant)
+	}
+	return v, product.Tiers, nil
+}
+
+// GiftCardAmounts is the face values a gift card is sold at, PaymentSetting
+// giftcard_amounts as a comma list
+var GiftCardAmounts = []float64{10, 25, 50, 100}
+
+// giftCardAmount tells if a gift card can be sold at price
+func giftCardAmount(price float64) bool {
+	for _, a := range GiftCardAmounts {
+		if math.Abs(a-price) < 0.005 {
+			return true
+		}
+	}
+	return false
+}
+
+// ItemsTotal returns the sum of the order items at their unit price
+func ItemsTotal(req *UserSubmitOrderRequest) float64 {
+	total := 0.0
+	for _, it := range req.ItemList {
+		total += math.Round(it.UnitPrice*float64(it.Quantity)*100) / 100
+	}
+	return math.Round(total*100) / 100
+}
+
+// OrderTotal returns what the buyer pays for a priced order, the items and
+// the payment fee less the redeemed points
+func OrderTotal(req *UserSubmitOrderRequest) float64 {
+	return math.Round((ItemsTotal(req)+req.PaymentFee-req.PointsValue)*100) / 100
+}
+
+// PriceItems set the unit price of the order items with a variant, volume
+// price tiers or a discount and moves the sub total and amount by the
+// difference. A variant price is the base of the product tiers, the discount
+// is taken off the tier price. A gift card has to be one of the
+// GiftCardAmounts. Other items keep the price sent by the storefront. The
+// amount has to be the sum of the items and the payment fee.
+func PriceItems(req *UserSubmitOrderRequest) error {
+	rate := 0.0
+	delta := 0.0
+	for i, it := range req.ItemList {
+		if it.Quantity <= 0 || it.UnitPrice < 0 {
+			return fmt.Errorf("item %s quantity or price error", it.Product)
+		}
+		if it.Category == GiftCardCategory {
+			if !giftCardAmount(it.UnitPrice) {
+				return fmt.Errorf("gift card of %.2f is not sold", it.UnitPrice)
+			}
+			continue
+		}
+		percent, price := itemDiscount(it, pricingNow())
+		var tiers content.PriceTiers
+		var base float64
+		if len(it.Variant) > 0 {
+			v, t, err := itemVariant(it)
+			if err != nil {
+				return err
+			}
+			req.ItemList[i].Options = v.Options
+			tiers, base = t, float64(v.Price)
+		} else {
+			tiers, base = itemTiers(it)
+			if len(tiers) == 0 {
+				if percent == 0 {
+					continue
+				}
+				base = price
+			}
+		}
+		if rate == 0 {
+			r, err := currencyRate(req.Currency)
+			if err != nil {
+				return err
+			}
+			rate = r
+		}
+
+		unit := tiers.UnitPrice(base, it.Quantity) * (100 - percent) / 100 * rate
+		before := math.Round(it.UnitPrice*float64(it.Quantity)*100) / 100
+		after := math.Round(unit*float64(it.Quantity)*100) / 100
+		if before == after {
+			continue
+		}
+		logger.Infof("Order %s item %s %s x%d priced %v, was %v", req.OrderID, it.Product, it.Variant, it.Quantity, unit, it.UnitPrice)
+		req.ItemList[i].UnitPrice = unit
+		delta += after - before
+	}
+	if delta != 0 {
+		req.SubTotal = math.Round((req.SubTotal+delta)*100) / 100
+		req.Amount = math.Round((req.Amount+delta)*100) / 100
+	}
+
+	total := ItemsTotal(req)
+	if req.Amount <= 0 || req.PaymentFee < 0 || math.Abs(total+req.PaymentFee-req.Amount) >= 0.005 {
+		logger.Warnf("Order %s amount %v does not match items %v and fee %v", req.OrderID, req.Amount, total, req.PaymentFee)
+		return fmt.Errorf("order amount error")
+	}
+	req.SubTotal = total
+	return nil
+}
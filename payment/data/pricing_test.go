@@ -0,0 +1,52 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/content"
+)
+
+func TestDiscountWindow(t *testing.T) {
+	// 20:00 to 22:00 in Shanghai is 12:00 to 14:00 UTC
+	d := content.Discount{
+		Name:      "Summer",
+		List:      `[{"level":1,"qty":"1","discount":"5"},{"level":2,"qty":"20","discount":"10"}]`,
+		Starttime: "2021-06-18 20:00",
+		Endtime:   "2021-06-18 22:00",
+		Timezone:  "Asia/Shanghai",
+	}
+	start := time.Date(2021, 6, 18, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		now      time.Time
+		quantity int
+		want     float64
+	}{
+		{start.Add(-time.Minute), 1, 0},
+		{start, 1, 5},
+		{start.Add(time.Hour), 19, 5},
+		{start.Add(time.Hour), 20, 10},
+		{start.Add(2*time.Hour - time.Second), 50, 10},
+		{start.Add(2 * time.Hour), 50, 0},
+	}
+	for _, c := range cases {
+		if got := discountPercent(d, c.quantity, c.now); got != c.want {
+			t.Errorf("at %s x%d: %v, want %v", c.now, c.quantity, got, c.want)
+		}
+	}
+
+	// the online flag set by the scheduler does not matter inside a window
+	d.Online = true
+	if got := discountPercent(d, 1, start.Add(-time.Minute)); got != 0 {
+		t.Errorf("online before the window: %v", got)
+	}
+	// without a window the flag switches the discount by hand
+	d.Starttime, d.Endtime = "", ""
+	if got := discountPercent(d, 1, start); got != 5 {
+		t.Errorf("online without window: %v", got)
+	}
+	d.Online = false
+	if got := discountPercent(d, 1, start); got != 0 {
+		t.Errorf("offline without window: %v", got)
+	}
+}
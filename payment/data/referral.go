@@ -0,0 +1,89 @@
+package data
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+var (
+	// CommissionRate is the affiliate share of a referred order total,
+	// PaymentSetting affiliate_commission_rate
+	CommissionRate = 0.05
+	// MinPayout is the smallest pending commission sent in a payout,
+	// PaymentSetting affiliate_min_payout
+	MinPayout = 10.0
+)
+
+func init() {
+	OnOrderStatus(OrderCompleted, addCommission)
+	OnOrderStatus(OrderRefunded, reverseCommission)
+	OnOrderStatus(OrderDisputed, reverseCommission)
+}
+
+// LoadReferralSetting read the affiliate setting from PaymentSetting
+func LoadReferralSetting() {
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "affiliate_commission_rate", "valueString"); err == nil {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f < 1 {
+			CommissionRate = f
+		}
+	}
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "affiliate_min_payout", "valueString"); err == nil {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			MinPayout = f
+		}
+	}
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "affiliate_cookie_days", "valueString"); err == nil {
+		if d, err := strconv.Atoi(v); err == nil && d > 0 {
+			db.ReferralCookieDays = d
+		}
+	}
+	logger.Infof("Affiliate commission rate %v, min payout %v, cookie %d days", CommissionRate, MinPayout, db.ReferralCookieDays)
+}
+
+// ApplyReferral store the referral code of the affiliate link the customer
+// came from in the request, a code sent by the client is kept
+func ApplyReferral(r *http.Request, req *UserSubmitOrderRequest) {
+	if len(req.Referral) == 0 {
+		if c, err := r.Cookie(db.ReferralCookie); err == nil {
+			req.Referral = c.Value
+		}
+	}
+	req.Referral = strings.ToUpper(strings.TrimSpace(req.Referral))
+	if len(req.Referral) == 0 {
+		return
+	}
+	aff, err := db.AffiliateByCode(req.Referral)
+	if err != nil || strings.EqualFold(aff.Email, req.Email) {
+		req.Referral = ""
+	}
+}
+
+// addCommission give the affiliate its share when a referred order is completed
+func addCommission(before, after *Order) {
+	if after.IsRefund || after.IsChargeBack {
+		return
+	}
+	req, err := RequestOfOrder(after)
+	if err != nil || len(req.Referral) == 0 {
+		return
+	}
+	total, err := strconv.ParseFloat(after.Total, 64)
+	if err != nil || total <= 0 {
+		return
+	}
+	_, err = db.AddCommission(req.Referral, after.OrderID, after.User, total, total*CommissionRate, after.Currency)
+	if err != nil {
+		logger.Errorf("Add commission of order %s error: %s", after.OrderID, err)
+	}
+}
+
+// reverseCommission cancel the commission of a refunded or charged back order
+func reverseCommission(before, after *Order) {
+	_, err := db.ReverseCommission(after.OrderID)
+	if err != nil {
+		logger.Errorf("Reverse commission of order %s error: %s", after.OrderID, err)
+	}
+}
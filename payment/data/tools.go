@@ -12,6 +12,9 @@ import (
 	"reflect"
 	"strings"
 	"time"
+
+	"github.com/agreyfox/eshop/content"
+	"github.com/agreyfox/eshop/system/db"
 )
 
 // generator short orderid  based on customer request
@@ -169,18 +172,10 @@ func ClientPublicIP(r *http.Request) string {
 	return ""
 }
 
-// Use sofescate way to get IP address.
+// GetIP returns the client address of r, forwarded addresses are taken from
+// trusted proxies only
 func GetIP(r *http.Request) string {
-	/* forwarded := r.Header.Get("X-FORWARDED-FOR")
-	if forwarded != "" {
-		return forwarded
-	}
-	return r.RemoteAddr */
-	ip := ClientPublicIP(r)
-	if ip == "" {
-		ip = ClientIP(r)
-	}
-	return ip
+	return db.ClientIP(r)
 }
 
 func GetJSONFromBody(req *http.Request) map[string]interface{} {
@@ -269,6 +264,9 @@ func GetPurchaseContent(id string) string {
 		liststr[i] = append(liststr[i], fmt.Sprintf("Game:%s", game))
 		liststr[i] = append(liststr[i], fmt.Sprintf("Category:%s", category))
 		liststr[i] = append(liststr[i], fmt.Sprintf("Server:%s", server))
+		if len(item.Variant) > 0 {
+			liststr[i] = append(liststr[i], fmt.Sprintf("Variant:%s %s", item.Variant, content.ProductVariant{Options: item.Options}))
+		}
 		liststr[i] = append(liststr[i], fmt.Sprintf("Price:%f,Quantity:%d,subtotal:%0.3f", price, quantity, price*float64(quantity)))
 		liststr[i] = append(liststr[i], "<br>\n")
 	}
@@ -39,6 +39,7 @@ var (
 	DBPayPayIPN         = "ipns"         // 2020-12/17 record ipn data
 	DBPaymentLog        = ".logs.db"     //save request to log
 	DBLogName           = "logs"         //log table name
+	DBGiftCardLog       = "giftcards"    // gift card redemption ledger in log db
 
 	UserRequest = "request"
 
@@ -46,6 +47,8 @@ var (
 	DbFile   = "records.db"
 
 	OrderName = "Order" //in main system.db
+
+	GiftCardCategory = "GiftCard" // item category of a gift card purchase
 )
 
 var (
@@ -60,12 +63,17 @@ var (
 
 type (
 	Item struct {
-		Game      string  `json:"game"`
-		Server    string  `json:"server,omitempty"`
-		Category  string  `json:"category"`
-		Product   string  `json:"product"`
-		UnitPrice float64 `json:"unit_price"`
-		Quantity  int     `json:"quantity"`
+		Game      string            `json:"game"`
+		Server    string            `json:"server,omitempty"`
+		Category  string            `json:"category"`
+		Product   string            `json:"product"`
+		Variant   string            `json:"variant,omitempty"` // sku of the product variant
+		Options   map[string]string `json:"options,omitempty"` // option values of the variant, set by the server
+		Inputs    map[string]string `json:"inputs,omitempty"`  // buyer input by input schema field name
+		UnitPrice float64           `json:"unit_price"`
+		Quantity  int               `json:"quantity"`
+		Recipient string            `json:"recipient,omitempty"` // gift card recipient email
+		Message   string            `json:"message,omitempty"`   // gift card message
 	}
 	// User submit same struct to system for order creation
 	UserSubmitOrderRequest struct {
@@ -91,6 +99,11 @@ type (
 		RequestInfo string  `json:"request_info,omitempty"`
 		CouponCode  string  `json:"coupon_code,omitempty"`
 		CouponValue float64 `json:"coupon_value,omitempty"`
+		GiftCard    string  `json:"gift_card,omitempty"` // redeemed gift card code
+		GiftValue   float64 `json:"gift_value,omitempty"`
+		Points      int     `json:"points,omitempty"` // loyalty points redeemed
+		PointsValue float64 `json:"points_value,omitempty"`
+		Referral    string  `json:"referral,omitempty"` // affiliate referral code
 		PaymentFee  float64 `json:"payment_fee,omitempty"`
 		LogoURL     string  `json:"logo_url,omitempty"`
 		Address     string  `json:"address,omitempty"`
@@ -176,6 +189,18 @@ type (
 		Coupon         string `json:"coupon.omitempty"`
 	}
 
+	// GiftCardRedemption is one use of a gift card against an order
+	GiftCardRedemption struct {
+		Code      string  `json:"code"`
+		OrderID   string  `json:"order_id"`
+		Amount    float64 `json:"amount"`
+		Balance   float64 `json:"balance"` // card balance after redemption
+		Currency  string  `json:"currency"`
+		Email     string  `json:"email,omitempty"`
+		IP        string  `json:"ip,omitempty"`
+		Timestamp int64   `json:"timestamp"`
+	}
+
 	UserEmailInfo struct {
 		Name      string `json:"name"`
 		Subject   string `json:"subject"`
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/agreyfox/eshop/content"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+var stockMu sync.Mutex
+
+func init() {
+	OnOrderStatus(OrderCompleted, takeStock)
+}
+
+// takeStock lowers the stock of the product variants of a completed order
+func takeStock(before, after *Order) {
+	req, err := GetRequestByID(after.OrderID)
+	if err != nil {
+		return
+	}
+
+	stockMu.Lock()
+	defer stockMu.Unlock()
+	for _, it := range req.ItemList {
+		if len(it.Variant) == 0 || it.Quantity <= 0 {
+			continue
+		}
+		if err := takeVariantStock(it); err != nil {
+			logger.Errorf("Take stock of order %s error: %s", after.OrderID, err)
+		}
+	}
+}
+
+// takeVariantStock lowers the stock of the variant of one order item
+func takeVariantStock(it Item) error {
+	product := content.Product{}
+	c := findContent("Product", it.Product, it.Game)
+	if c == nil || json.Unmarshal(c, &product) != nil {
+		return fmt.Errorf("product %s is not found", it.Product)
+	}
+	found := false
+	for i, v := range product.Variants {
+		if v.SKU != it.Variant {
+			continue
+		}
+		found = true
+		if v.Stock < uint64(it.Quantity) {
+			product.Variants[i].Stock = 0
+		} else {
+			product.Variants[i].Stock -= uint64(it.Quantity)
+		}
+		logger.Infof("Variant %s of %s stock %d left", v.SKU, it.Product, product.Variants[i].Stock)
+	}
+	if !found {
+		return fmt.Errorf("variant %s of %s is not found", it.Variant, it.Product)
+	}
+
+	j, err := json.Marshal(product.Variants)
+	if err != nil {
+		return err
+	}
+	target := fmt.Sprintf("Product:%d", product.ID)
+	_, err = db.UpdateContent(target, url.Values{"variants": {string(j)}})
+	return err
+}
@@ -0,0 +1,23 @@
+package data
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// CheckVerified refuses the order when the verify_checkout setting is on and
+// the login customer, or the account of the order email, is not verified.
+// The services answer it with retCode -4.
+func CheckVerified(r *http.Request, req *UserSubmitOrderRequest) error {
+	if buf, err := db.CurrentUser(r); err == nil {
+		usr := user.User{}
+		json.Unmarshal(buf, &usr)
+		if err := db.CheckVerified(usr.Email, db.VerifyForCheckout); err != nil {
+			return err
+		}
+	}
+	return db.CheckVerified(req.Email, db.VerifyForCheckout)
+}
@@ -0,0 +1,39 @@
+// Package giftcard issues gift cards for paid orders, sends them to the
+// recipient by email and implements the gift card payment method.
+package giftcard
+
+import (
+	"net/http"
+
+	"github.com/agreyfox/eshop/payment/data"
+	"github.com/agreyfox/eshop/system/logs"
+
+	"github.com/go-zoo/bone"
+	"go.uber.org/zap"
+)
+
+var (
+	logger *zap.SugaredLogger = logs.Log.Sugar()
+)
+
+func init() {
+	// cards are issued whichever payment service took the money
+	data.OnOrderStatus(data.OrderPaid, issueForOrder)
+}
+
+// Start registers the gift card payment routes on mainMux
+func Start(mainMux *bone.Mux) {
+
+	logger.Info("starting gift card payment service...")
+	initGiftCard()
+
+	giftMux := bone.New()
+	giftMux.Get("/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Welcome gift card payment service"))
+	}))
+
+	giftMux.PostFunc("/dopay", userSubmit)
+	giftMux.GetFunc("/balance", balance)
+
+	mainMux.SubRoute("/payment/giftcard", giftMux)
+}
@@ -0,0 +1,89 @@
+package giftcard
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/content"
+	"github.com/agreyfox/eshop/payment/data"
+)
+
+func TestGenerateCode(t *testing.T) {
+	re := regexp.MustCompile(`^[A-Z2-9]{4}-[A-Z2-9]{4}-[A-Z2-9]{4}-[A-Z2-9]{4}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code := generateCode()
+		if !re.MatchString(code) {
+			t.Fatalf("bad gift card code %s", code)
+		}
+		if seen[code] {
+			t.Fatalf("duplicated gift card code %s", code)
+		}
+		seen[code] = true
+		if normalizeCode(code) != code {
+			t.Errorf("normalize changed code %s", code)
+		}
+	}
+	if c := normalizeCode(" abcd-efgh2345jkmn "); c != "ABCD-EFGH-2345-JKMN" {
+		t.Errorf("normalize code got %s", c)
+	}
+}
+
+func TestIsExpired(t *testing.T) {
+	card := &content.GiftCard{}
+	if isExpired(card) {
+		t.Error("card without expiry should not expire")
+	}
+	card.Expiry = time.Now().Format("2006-01-02")
+	if isExpired(card) {
+		t.Error("card should be usable on the expiry day")
+	}
+	card.Expiry = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	if !isExpired(card) {
+		t.Error("card should be expired after the expiry day")
+	}
+}
+
+func TestCovered(t *testing.T) {
+	req := &data.UserSubmitOrderRequest{Currency: "USD", ItemList: []data.Item{
+		{Category: data.GiftCardCategory, UnitPrice: 50, Quantity: 2},
+		{Product: "coin", UnitPrice: 5, Quantity: 1},
+	}}
+	cases := []struct {
+		order data.Order
+		ok    bool
+	}{
+		{data.Order{Currency: "USD", Total: "105.00", Paid: "105.00"}, true},
+		{data.Order{Currency: "usd", Total: "100.00"}, true},
+		{data.Order{Currency: "USD", Total: "105.00", Paid: "10.00"}, false},
+		{data.Order{Currency: "EUR", Total: "105.00"}, false},
+		{data.Order{Currency: "USD"}, false},
+	}
+	for _, c := range cases {
+		if err := covered(&c.order, req); (err == nil) != c.ok {
+			t.Errorf("order %+v: %v", c.order, err)
+		}
+	}
+	req.PointsValue = 90
+	if err := covered(&data.Order{Currency: "USD", Paid: "10.00"}, req); err != nil {
+		t.Errorf("points not counted: %v", err)
+	}
+}
+
+func TestPriceGiftCards(t *testing.T) {
+	req := &data.UserSubmitOrderRequest{Currency: "USD", Amount: 76, SubTotal: 1, PaymentFee: 1, ItemList: []data.Item{
+		{Category: data.GiftCardCategory, UnitPrice: 25, Quantity: 3},
+	}}
+	if err := data.PriceItems(req); err != nil || req.SubTotal != 75 {
+		t.Fatalf("priced %+v: %v", req, err)
+	}
+	req.Amount = 10
+	if err := data.PriceItems(req); err == nil {
+		t.Error("expected an amount below the items refused")
+	}
+	req.Amount, req.ItemList[0].UnitPrice = 4, 1
+	if err := data.PriceItems(req); err == nil {
+		t.Error("expected a gift card of another amount refused")
+	}
+}
@@ -0,0 +1,208 @@
+package giftcard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/content"
+	"github.com/agreyfox/eshop/payment/data"
+	"github.com/agreyfox/eshop/system/admin"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+func initGiftCard() {
+	days, err := db.GetParameterFromConfig("PaymentSetting", "name", "giftcard_valid_days", "valueString")
+	if err == nil {
+		if d, err := strconv.Atoi(days); err == nil {
+			ValidDays = d
+		}
+	}
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "giftcard_amounts", "valueString"); err == nil {
+		amounts := []float64{}
+		for _, a := range strings.Split(v, ",") {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(a), 64); err == nil && f > 0 {
+				amounts = append(amounts, f)
+			}
+		}
+		if len(amounts) > 0 {
+			data.GiftCardAmounts = amounts
+		}
+	}
+	logger.Infof("Gift card is valid for %d days, sold at %v", ValidDays, data.GiftCardAmounts)
+}
+
+// valid user request for gift card payment
+func validateRequest(req *data.UserSubmitOrderRequest) error {
+	if req.Amount < 0.01 {
+		return fmt.Errorf("amount data error")
+	}
+	if len(req.GiftCard) == 0 {
+		return fmt.Errorf("no gift card code")
+	}
+	if len(req.Email) == 0 {
+		return fmt.Errorf("no user info")
+	}
+	if len(req.Currency) == 0 {
+		return fmt.Errorf("no user currency info")
+	}
+	if len(req.ItemList) == 0 {
+		return fmt.Errorf("no item in order")
+	}
+	for _, it := range req.ItemList {
+		if it.Category == data.GiftCardCategory {
+			return fmt.Errorf("gift card can not pay for gift card")
+		}
+	}
+	return nil
+}
+
+// accept user standard request, the total is taken from the gift card
+func userSubmit(w http.ResponseWriter, r *http.Request) {
+	logger.Info("User submit a gift card payment")
+
+	payload := new(data.UserSubmitOrderRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		logger.Errorf("user submit error", err)
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -1,
+			"msg":     "input data parse error",
+		})
+		return
+	}
+	payload.IPAddr = data.GetIP(r)
+	if err := validateRequest(payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -1,
+			"msg":     err.Error(),
+		})
+		return
+	}
+	if retCode, err := data.PrepareOrder(r, payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": retCode,
+			"msg":     err.Error(),
+		})
+		return
+	}
+
+	card, err := Redeem(payload.GiftCard, payload.Amount, payload.Currency, payload.OrderID, payload.Email, payload.IPAddr)
+	if err != nil {
+		logger.Warnf("Gift card payment of %s from %s error: %s", payload.Email, payload.IPAddr, err)
+		data.ReleasePoints(payload)
+		retData := map[string]interface{}{
+			"retCode": -3,
+			"msg":     err.Error(),
+		}
+		if err == ErrCardInsufficient {
+			retData["data"] = map[string]interface{}{
+				"balance":  card.Balance,
+				"currency": card.Currency,
+			}
+		}
+		data.RenderJSON(w, r, retData)
+		return
+	}
+	payload.GiftCard = card.Code
+	payload.GiftValue = payload.Amount
+	payload.Status = data.OrderPaid
+	data.SaveOrderRequest(payload)
+
+	order, ok := CreateNewOrderByRequest(payload)
+	if !ok {
+		restore(card.Code, payload.Amount, payload.OrderID)
+		data.ReleasePoints(payload)
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -1,
+			"msg":     "create order failed",
+		})
+		return
+	}
+
+	go data.SendOrderConfirmEmail(PaymentVendor, order.OrderID, data.GetPurchaseContent(order.OrderID), order.Paytime, order.Comments, order.Total, order.Currency, order.User, order.PayerIP)
+
+	retData := map[string]interface{}{
+		"transaction":  payload,
+		"balance":      card.Balance,
+		"redirect_url": data.OnlineURL + fmt.Sprintf("?status=1&orderno=%s", order.OrderID),
+	}
+	data.RenderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "ok",
+		"data":    retData,
+	})
+}
+
+// balance return the left balance of a card, ?code=
+func balance(w http.ResponseWriter, r *http.Request) {
+	code := normalizeCode(r.URL.Query().Get("code"))
+	_, card, err := findCard(code)
+	if err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -1,
+			"msg":     err.Error(),
+		})
+		return
+	}
+	status := card.Status
+	if status == content.GiftCardActive && isExpired(card) {
+		status = "expired"
+	}
+	data.RenderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "ok",
+		"data": map[string]interface{}{
+			"code":     card.Code,
+			"balance":  card.Balance,
+			"currency": card.Currency,
+			"expiry":   card.Expiry,
+			"status":   status,
+		},
+	})
+}
+
+// CreateNewOrderByRequest create a paid order from the request
+func CreateNewOrderByRequest(reqdata *data.UserSubmitOrderRequest) (data.Order, bool) {
+	oid := reqdata.OrderID
+	if eid := admin.FindContentID("Order", oid, "order_id"); len(eid) > 0 {
+		logger.Warnf("Order id %s duplication,check id %s in db, create order exit", oid, eid)
+		return data.Order{}, false
+	}
+	capdetail, _ := json.Marshal(reqdata.ItemList)
+	buff, _ := json.Marshal(reqdata)
+	now := fmt.Sprint(time.Now().Format(time.RFC1123))
+
+	order := data.Order{
+		Status:        data.OrderPaid,
+		OrderID:       oid,
+		PaymentID:     reqdata.GiftCard,
+		PaymentVendor: PaymentVendor,
+		PaymentMethod: PaymentVendor,
+		NotifyInfo:    string(buff[:]),
+		Description:   string(capdetail),
+		OrderDetail:   string(capdetail),
+		Currency:      reqdata.Currency,
+		Total:         fmt.Sprintf("%.2f", reqdata.Amount),
+		Paid:          fmt.Sprintf("%.2f", reqdata.GiftValue),
+		Net:           fmt.Sprintf("%.2f", reqdata.GiftValue),
+		UpdateTime:    now,
+		Paytime:       now,
+		User:          reqdata.Email,
+		Payer:         reqdata.Email,
+		PayerLink:     reqdata.ContactInfo,
+		Comments:      reqdata.RequestInfo,
+		PayerIP:       reqdata.IPAddr,
+		RequestTime:   fmt.Sprint(time.Unix(reqdata.OrderDate, 0).Format(time.RFC1123)),
+	}
+
+	mm, _ := json.Marshal(order)
+	retCode, ok := admin.CreateContent("Order", mm)
+	if !ok {
+		logger.Error("error in creat order with code :", retCode)
+		return order, false
+	}
+	return order, true
+}
@@ -0,0 +1,332 @@
+package giftcard
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agreyfox/eshop/content"
+	"github.com/agreyfox/eshop/payment/data"
+	"github.com/agreyfox/eshop/system/admin"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/email"
+)
+
+var (
+	issueMu  sync.Mutex // one issuing job at a time, so paid orders never get cards twice
+	redeemMu sync.Mutex // protects card balance between read and write
+)
+
+// generateCode return a code like XXXX-XXXX-XXXX-XXXX
+func generateCode() string {
+	var b strings.Builder
+	max := big.NewInt(int64(len(codeChars)))
+	for i := 0; i < codeLength; i++ {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			logger.Error("generate gift card code error:", err)
+			n = big.NewInt(time.Now().UnixNano() % int64(len(codeChars)))
+		}
+		b.WriteByte(codeChars[n.Int64()])
+	}
+	return b.String()
+}
+
+// normalizeCode accept codes typed in lower case or without dash
+func normalizeCode(code string) string {
+	code = strings.ToUpper(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	var b strings.Builder
+	for i, c := range code {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// findCard return the content id and the card with the code
+func findCard(code string) (string, *content.GiftCard, error) {
+	qo := db.QueryOptions{
+		Count:  1,
+		Offset: 0,
+		Order:  "desc",
+	}
+	_, cards := db.QueryByFieldValue("GiftCard", "code", code, qo)
+	if len(cards) == 0 {
+		return "", nil, ErrCardNotFound
+	}
+	card := &content.GiftCard{}
+	err := json.Unmarshal(cards[0], card)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprint(card.ID), card, nil
+}
+
+// isExpired check the expiry date, a card can be used the whole expiry day
+func isExpired(card *content.GiftCard) bool {
+	if len(card.Expiry) == 0 {
+		return false
+	}
+	t, err := time.ParseInLocation("2006-01-02", card.Expiry, time.Local)
+	if err != nil {
+		logger.Warnf("gift card %s has wrong expiry %s", card.Code, card.Expiry)
+		return true
+	}
+	return time.Now().After(t.AddDate(0, 0, 1))
+}
+
+// IssueCard create a new active gift card
+func IssueCard(amount float64, currency, buyer, recipient, message, orderID string) (*content.GiftCard, error) {
+	if amount <= 0 || len(currency) == 0 || len(recipient) == 0 {
+		return nil, fmt.Errorf("gift card data error")
+	}
+
+	code := generateCode()
+	for {
+		if _, _, err := findCard(code); err == ErrCardNotFound {
+			break
+		}
+		code = generateCode()
+	}
+
+	card := &content.GiftCard{
+		Code:           code,
+		Denomination:   amount,
+		Balance:        amount,
+		Currency:       currency,
+		BuyerEmail:     strings.ToLower(buyer),
+		RecipientEmail: strings.ToLower(recipient),
+		Message:        message,
+		OrderID:        orderID,
+		Status:         content.GiftCardActive,
+	}
+	if ValidDays > 0 {
+		card.Expiry = time.Now().AddDate(0, 0, ValidDays).Format("2006-01-02")
+	}
+
+	mm, _ := json.Marshal(card)
+	id, ok := admin.CreateContent("GiftCard", mm)
+	if !ok {
+		return nil, fmt.Errorf("save gift card error with code %d", id)
+	}
+	card.ID = id
+	logger.Infof("Gift card %d issued for order %s, %.2f %s to %s", id, orderID, amount, currency, recipient)
+
+	return card, nil
+}
+
+// issueForOrder create and deliver the cards bought in a paid order
+func issueForOrder(before, after *data.Order) {
+	if data.PaymentDBHandler == nil {
+		return
+	}
+	req, err := data.GetRequestByID(after.OrderID)
+	if err != nil {
+		logger.Debugf("No request for order %s, skip gift card", after.OrderID)
+		return
+	}
+
+	issueMu.Lock()
+	defer issueMu.Unlock()
+
+	qo := db.QueryOptions{Count: -1, Offset: 0, Order: "desc"}
+	if _, issued := db.QueryByFieldValue("GiftCard", "order_id", after.OrderID, qo); len(issued) > 0 {
+		logger.Debugf("Gift cards of order %s already issued", after.OrderID)
+		return
+	}
+	if err := covered(after, req); err != nil {
+		logger.Errorf("Gift cards of order %s not issued: %s", after.OrderID, err)
+		return
+	}
+
+	for _, it := range req.ItemList {
+		if it.Category != data.GiftCardCategory {
+			continue
+		}
+		recipient := it.Recipient
+		if len(recipient) == 0 {
+			recipient = req.Email
+		}
+		for i := 0; i < it.Quantity; i++ {
+			card, err := IssueCard(it.UnitPrice, req.Currency, req.Email, recipient, it.Message, after.OrderID)
+			if err != nil {
+				logger.Errorf("Issue gift card for order %s error: %s", after.OrderID, err)
+				continue
+			}
+			go deliver(card)
+		}
+	}
+}
+
+// covered returns an error when the gift cards of the request are worth more
+// than the order paid, with the points taken off it, or are in another currency
+func covered(order *data.Order, req *data.UserSubmitOrderRequest) error {
+	cards := 0.0
+	for _, it := range req.ItemList {
+		if it.Category == data.GiftCardCategory {
+			cards += it.UnitPrice * float64(it.Quantity)
+		}
+	}
+	if cards == 0 {
+		return nil
+	}
+	if len(order.Currency) > 0 && !strings.EqualFold(order.Currency, req.Currency) {
+		return ErrCardCurrency
+	}
+	paid := order.Paid
+	if len(paid) == 0 {
+		paid = order.Total
+	}
+	amount, err := strconv.ParseFloat(paid, 64)
+	if err != nil {
+		return fmt.Errorf("paid amount %q error", paid)
+	}
+	if math.Round((amount+req.PointsValue)*100) < math.Round(cards*100) {
+		return fmt.Errorf("paid %.2f is less than the cards %.2f", amount+req.PointsValue, cards)
+	}
+	return nil
+}
+
+// deliver send the card to the recipient with the gift card email template
+func deliver(card *content.GiftCard) {
+	emailConfbuf, err := db.Content(EmailTemplate)
+	if err != nil || len(emailConfbuf) == 0 {
+		logger.Warnf("Skip gift card email send job, no template %s", EmailTemplate)
+		return
+	}
+	emailstruct := data.UserEmailInfo{}
+	err = json.Unmarshal(emailConfbuf, &emailstruct)
+	if err != nil {
+		logger.Warnf("Skip gift card email send job,Error:%s", err)
+		return
+	}
+	if !emailstruct.Enable {
+		logger.Warnf("Gift card email template is disabled")
+		return
+	}
+
+	expiry := card.Expiry
+	if len(expiry) == 0 {
+		expiry = "-"
+	}
+	body := fmt.Sprintf(emailstruct.EmailBody, card.Code, fmt.Sprintf("%.2f", card.Denomination), card.Currency, expiry, card.BuyerEmail, card.Message)
+	tomail := []string{card.RecipientEmail}
+	if len(emailstruct.CC) > 0 {
+		tomail = append(tomail, strings.Split(emailstruct.CC, ",")...)
+	}
+	emailtarget := email.Email{
+		To:       tomail,
+		Subject:  fmt.Sprintf(emailstruct.Subject, card.BuyerEmail),
+		TextBody: body,
+		HtmlBody: body,
+	}
+	res, err := email.Send(&emailtarget)
+	if err != nil {
+		logger.Warnf("Gift card email with an Error Occurred: %s\n", err)
+		return
+	} else if res.Data.Succeeded != 1 {
+		logger.Warnf("Gift card email Sent with error: %v\n", res)
+		return
+	}
+	logger.Infof("Gift card %d sent to %s", card.ID, card.RecipientEmail)
+
+	_, err = admin.UpdateContent("GiftCard", fmt.Sprint(card.ID), "delivered", []byte("true"))
+	if err != nil {
+		logger.Error("Update gift card delivered error:", err)
+	}
+}
+
+// Redeem take amount from the card balance for the order, the left balance
+// stays on the card for later orders
+func Redeem(code string, amount float64, currency, orderID, buyer, ip string) (*content.GiftCard, error) {
+	redeemMu.Lock()
+	defer redeemMu.Unlock()
+
+	id, card, err := findCard(normalizeCode(code))
+	if err != nil {
+		return nil, err
+	}
+	if card.Status != content.GiftCardActive {
+		return card, ErrCardNotActive
+	}
+	if isExpired(card) {
+		return card, ErrCardExpired
+	}
+	if !strings.EqualFold(card.Currency, currency) {
+		return card, ErrCardCurrency
+	}
+	amount = math.Round(amount*100) / 100
+	if card.Balance < amount {
+		return card, ErrCardInsufficient
+	}
+
+	err = setBalance(id, card, math.Round((card.Balance-amount)*100)/100)
+	if err != nil {
+		return card, err
+	}
+
+	err = data.SaveGiftCardRedemption(&data.GiftCardRedemption{
+		Code:     card.Code,
+		OrderID:  orderID,
+		Amount:   amount,
+		Balance:  card.Balance,
+		Currency: card.Currency,
+		Email:    buyer,
+		IP:       ip,
+	})
+	if err != nil {
+		logger.Error("Save gift card redemption error:", err)
+	}
+	return card, nil
+}
+
+// restore give amount back to the card when the order could not be created
+func restore(code string, amount float64, orderID string) {
+	redeemMu.Lock()
+	defer redeemMu.Unlock()
+
+	id, card, err := findCard(code)
+	if err != nil {
+		logger.Errorf("Restore gift card %s of order %s error: %s", code, orderID, err)
+		return
+	}
+	err = setBalance(id, card, math.Round((card.Balance+amount)*100)/100)
+	if err != nil {
+		logger.Errorf("Restore gift card %s of order %s error: %s", code, orderID, err)
+		return
+	}
+	data.SaveGiftCardRedemption(&data.GiftCardRedemption{
+		Code:     card.Code,
+		OrderID:  orderID,
+		Amount:   -amount,
+		Balance:  card.Balance,
+		Currency: card.Currency,
+	})
+}
+
+// setBalance save the new balance, an empty card is marked as used
+func setBalance(id string, card *content.GiftCard, balance float64) error {
+	card.Balance = balance
+	if balance <= 0 {
+		card.Status = content.GiftCardUsed
+	} else {
+		card.Status = content.GiftCardActive
+	}
+	values := url.Values{}
+	values.Set("balance", fmt.Sprintf("%.2f", card.Balance))
+	values.Set("status", card.Status)
+	_, err := admin.UpdateContents("GiftCard", id, []string{"balance", "status"}, &values)
+	return err
+}
@@ -0,0 +1,26 @@
+package giftcard
+
+import "errors"
+
+const (
+	// PaymentVendor is the vendor name stored on orders paid by gift card
+	PaymentVendor = "giftcard"
+
+	// EmailTemplate is the Email content used to deliver a card, the body is
+	// formatted with code, amount, currency, expiry, buyer and message
+	EmailTemplate = "Email:4"
+
+	codeChars  = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	codeLength = 16
+)
+
+var (
+	// ValidDays is how long a new card can be used, 0 means never expire
+	ValidDays = 365
+
+	ErrCardNotFound     = errors.New("gift card not found")
+	ErrCardNotActive    = errors.New("gift card is not active")
+	ErrCardExpired      = errors.New("gift card expired")
+	ErrCardCurrency     = errors.New("gift card currency not match")
+	ErrCardInsufficient = errors.New("gift card balance is not enough")
+)
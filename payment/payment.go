@@ -16,10 +16,12 @@ import (
 	"os"
 
 	"github.com/agreyfox/eshop/payment/data"
+	"github.com/agreyfox/eshop/payment/giftcard"
 	"github.com/agreyfox/eshop/payment/paypal"
 	"github.com/agreyfox/eshop/payment/payssion"
 	"github.com/agreyfox/eshop/payment/skrill"
 	"github.com/agreyfox/eshop/payment/static"
+	"github.com/agreyfox/eshop/payment/wallet"
 	"github.com/agreyfox/eshop/system/db"
 	"github.com/agreyfox/eshop/system/logs"
 	"github.com/go-zoo/bone"
@@ -123,6 +125,11 @@ func InitialPayment(db *bolt.DB, mux *bone.Mux) {
 			logger.Debug("Error in check Record db")
 			return err
 		}
+		_, err = tx.CreateBucketIfNotExists([]byte(data.DBGiftCardLog))
+		if err != nil {
+			logger.Debug("Error in check gift card log db")
+			return err
+		}
 
 		return nil
 	})
@@ -131,6 +138,7 @@ func InitialPayment(db *bolt.DB, mux *bone.Mux) {
 		logger.Fatal("initialize payment request&record db with buckets.Please check!", err)
 	}
 
+	data.StartPointsRelease()
 	initial = true
 
 }
@@ -142,6 +150,8 @@ func Run(serviceName string) {
 		data.OnlineURL = cc
 	}
 	logger.Infof("Payment service result page url is %s", data.OnlineURL)
+	data.LoadLoyaltySetting()
+	data.LoadReferralSetting()
 	//	initpaypal() // repalce to not use default initial
 	switch serviceName {
 	case "paypal":
@@ -152,6 +162,10 @@ func Run(serviceName string) {
 		skrill.Start(mainMux)
 	case "static":
 		static.Start(mainMux)
+	case "wallet":
+		wallet.Start(mainMux)
+	case "giftcard":
+		giftcard.Start(mainMux)
 	default:
 		logger.Fatal("Wrong payment service name!")
 	}
@@ -0,0 +1,129 @@
+package paypal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/agreyfox/eshop/payment/data"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/robfig/cron"
+)
+
+var (
+	// payoutSubject is the subject of the mail PayPal sends to the affiliate
+	payoutSubject = "Your affiliate commission"
+	payoutMu      sync.Mutex
+)
+
+// initPayout start the affiliate payout job when PaymentSetting
+// paypal_PayoutSchedule is set, e.g. "@monthly"
+func initPayout() {
+	schedule, err := db.GetParameterFromConfig("PaymentSetting", "name", "paypal_PayoutSchedule", "valueString")
+	if err != nil || len(schedule) == 0 {
+		return
+	}
+	job := cron.New()
+	err = job.AddFunc(schedule, func() {
+		if _, err := PayoutAffiliates(); err != nil {
+			logger.Error("Affiliate payout error:", err)
+		}
+	})
+	if err != nil {
+		logger.Error("Affiliate payout schedule error:", err)
+		return
+	}
+	logger.Infof("Start affiliate payout job %s", schedule)
+	job.Start()
+}
+
+// PayoutAffiliates send the pending commissions of all affiliates in one
+// PayPal payout batch and mark them paid
+func PayoutAffiliates() (*PayoutResponse, error) {
+	payoutMu.Lock()
+	defer payoutMu.Unlock()
+
+	if payClient == nil {
+		return nil, fmt.Errorf("paypal client is not ready")
+	}
+	balances, err := db.AffiliateBalances(data.MinPayout)
+	if err != nil {
+		return nil, err
+	}
+	if len(balances) == 0 {
+		logger.Info("No affiliate commission to pay")
+		return nil, nil
+	}
+
+	batchID := fmt.Sprintf("AFF-%d", time.Now().Unix())
+	res, err := sendAffiliatePayout(payClient, balances, batchID)
+	if err != nil {
+		return res, err
+	}
+	if res.BatchHeader != nil && len(res.BatchHeader.PayoutBatchID) > 0 {
+		batchID = res.BatchHeader.PayoutBatchID
+	}
+	for _, b := range balances {
+		if err := db.MarkCommissionsPaid(b, batchID); err != nil {
+			logger.Errorf("Mark commissions of %s paid in batch %s error: %s", b.Email, batchID, err)
+		}
+	}
+	logger.Infof("Affiliate payout batch %s sent to %d receivers", batchID, len(balances))
+	return res, nil
+}
+
+// sendAffiliatePayout create the payout batch of balances
+func sendAffiliatePayout(c *Client, balances []db.AffiliateBalance, batchID string) (*PayoutResponse, error) {
+	payout := Payout{
+		SenderBatchHeader: &SenderBatchHeader{
+			EmailSubject:  payoutSubject,
+			SenderBatchID: batchID,
+		},
+		Items: []PayoutItem{},
+	}
+	for _, b := range balances {
+		payout.Items = append(payout.Items, PayoutItem{
+			RecipientType: "EMAIL",
+			Receiver:      b.Receiver,
+			Amount: &AmountPayout{
+				Value:    fmt.Sprintf("%.2f", b.Amount),
+				Currency: b.Currency,
+			},
+			Note:         fmt.Sprintf("Commission of %d orders", len(b.IDs)),
+			SenderItemID: fmt.Sprintf("%s-%s", b.Email, b.Currency),
+		})
+	}
+	return c.CreateSinglePayout(payout)
+}
+
+// AffiliatePayout is the admin call to run the affiliate payout now, for the
+// admins allowed to send payouts
+func AffiliatePayout(w http.ResponseWriter, r *http.Request) {
+	usr := &user.User{}
+	buf, err := db.CurrentUser(r)
+	if err != nil || json.Unmarshal(buf, usr) != nil || !usr.Can(user.ResourcePayouts, user.ActionCreate) {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -99,
+			"msg":     "Permission Denied",
+		})
+		return
+	}
+	logger.Infof("Affiliate payout started by %s from %s", usr.Email, data.GetIP(r))
+
+	res, err := PayoutAffiliates()
+	if err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -1,
+			"msg":     err.Error(),
+		})
+		return
+	}
+	data.RenderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "ok",
+		"data":    res,
+	})
+}
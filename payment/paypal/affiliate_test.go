@@ -0,0 +1,78 @@
+package paypal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// payoutTestServer stubs the PayPal token and payouts endpoints
+type payoutTestServer struct {
+	t      *testing.T
+	auth   string
+	payout Payout
+}
+
+func (ts *payoutTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/v1/oauth2/token":
+		w.Write([]byte(`{"access_token":"stub-token","token_type":"Bearer","expires_in":32400}`))
+	case "/v1/payments/payouts":
+		ts.auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&ts.payout); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"batch_header":{"payout_batch_id":"5UXD2E8A7EBQJ","batch_status":"PENDING",
+			"sender_batch_header":{"sender_batch_id":"` + ts.payout.SenderBatchHeader.SenderBatchID + `"}}}`))
+	default:
+		ts.t.Errorf("unexpected call %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSendAffiliatePayout(t *testing.T) {
+	stub := &payoutTestServer{t: t}
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	c, _ := NewClient("foo", "bar", ts.URL)
+	if _, err := c.GetAccessToken(); err != nil {
+		t.Fatal(err)
+	}
+
+	balances := []db.AffiliateBalance{
+		{Email: "a@example.com", Receiver: "a-paypal@example.com", Amount: 12.5, Currency: "USD", IDs: []int{1, 2}},
+		{Email: "b@example.com", Receiver: "b@example.com", Amount: 30, Currency: "EUR", IDs: []int{4}},
+	}
+	res, err := sendAffiliatePayout(c, balances, "AFF-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stub.auth != "Bearer stub-token" {
+		t.Errorf("payout sent without token, got %q", stub.auth)
+	}
+	if stub.payout.SenderBatchHeader.SenderBatchID != "AFF-1" {
+		t.Errorf("unexpected batch id %s", stub.payout.SenderBatchHeader.SenderBatchID)
+	}
+	if len(stub.payout.Items) != 2 {
+		t.Fatalf("expected 2 payout items, got %d", len(stub.payout.Items))
+	}
+	item := stub.payout.Items[0]
+	if item.RecipientType != "EMAIL" || item.Receiver != "a-paypal@example.com" ||
+		item.Amount.Value != "12.50" || item.Amount.Currency != "USD" {
+		t.Errorf("unexpected payout item %+v %+v", item, item.Amount)
+	}
+	if stub.payout.Items[1].Amount.Value != "30.00" || stub.payout.Items[1].Amount.Currency != "EUR" {
+		t.Errorf("unexpected payout item %+v", stub.payout.Items[1].Amount)
+	}
+	if res.BatchHeader == nil || res.BatchHeader.PayoutBatchID != "5UXD2E8A7EBQJ" {
+		t.Errorf("unexpected payout response %+v", res)
+	}
+}
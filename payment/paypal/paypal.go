@@ -50,6 +50,7 @@ func Start(mainMux *bone.Mux) {
 
 	logger.Info("starting PayPal beckend service...")
 	initpaypal() // repalce to not use default initial
+	initPayout()
 
 	boltMux := bone.New() //.Prefix("admin")
 	boltMux.Get("/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -66,6 +67,7 @@ func Start(mainMux *bone.Mux) {
 	boltMux.HandleFunc("/cancel", Failed)
 	boltMux.HandleFunc("/test", Test)
 	boltMux.Get("/info/:id", http.HandlerFunc(TransactionInfo))
+	boltMux.PostFunc("/payout", AffiliatePayout)
 
 	pwd, erro := os.Getwd()
 	if erro != nil {
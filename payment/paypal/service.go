@@ -138,8 +138,13 @@ func userSubmit(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	payload.OrderID = data.GetShortOrderID()
-	payload.OrderDate = time.Now().Unix()
+	if retCode, err := data.PrepareOrder(r, payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": retCode,
+			"msg":     err.Error(),
+		})
+		return
+	}
 	respond, err := createOrder(payload) //create  call
 
 	rettxt, _ := json.MarshalIndent(respond, "", "  ")
@@ -149,6 +154,7 @@ func userSubmit(w http.ResponseWriter, r *http.Request) {
 	logger.Infof("Create paypal request in db with err:", errcreateorder)
 	if err != nil {
 		logger.Error("create paypal order error:", err)
+		data.ReleasePoints(payload)
 		data.RenderJSON(w, r, map[string]interface{}{
 			"retCode": -2,
 			"msg":     err,
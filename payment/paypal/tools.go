@@ -45,7 +45,7 @@ func validateRequest(req *data.UserSubmitOrderRequest) error {
 func createOrder(r *data.UserSubmitOrderRequest) (*Order, error) {
 	logger.Debug("User create the paypal payment")
 
-	invoiceID := data.GetShortOrderID()
+	invoiceID := r.OrderID // the id of the saved request, the hooks find it by the order id
 	order := OrderRequest{
 		Payer:    r.Payment,
 		Email:    r.Email,
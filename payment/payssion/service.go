@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/agreyfox/eshop/payment/data"
 	"github.com/agreyfox/eshop/prometheus"
@@ -101,11 +100,17 @@ func userSubmit(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	payload.OrderID = data.GetShortOrderID()
-	payload.OrderDate = time.Now().Unix()
+	if retCode, err := data.PrepareOrder(r, payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": retCode,
+			"msg":     err.Error(),
+		})
+		return
+	}
 	respond, err := createOrder(payload) //create payssion call
 	//payload.Respond = fmt.Sprint(respond)
 	if err != nil {
+		data.ReleasePoints(payload)
 		data.RenderJSON(w, r, map[string]interface{}{
 			"retCode": -1,
 			"msg":     err.Error(),
@@ -3,7 +3,6 @@ package skrill
 import (
 	"encoding/json"
 	"fmt"
-	"time"
 
 	"net/http"
 	"strconv"
@@ -53,8 +52,13 @@ func userSubmit(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	payload.OrderID = data.GetShortOrderID()
-	payload.OrderDate = time.Now().Unix()
+	if retCode, err := data.PrepareOrder(r, payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": retCode,
+			"msg":     err.Error(),
+		})
+		return
+	}
 	respond, err := createOrder(payload) //create payssion call
 
 	payload.Respond = respond
@@ -74,6 +78,7 @@ func userSubmit(w http.ResponseWriter, r *http.Request) {
 		})
 
 	} else {
+		data.ReleasePoints(payload)
 		data.RenderJSON(w, r, map[string]interface{}{
 			"retCode": -10,
 			"msg":     "Something error",
@@ -3,7 +3,6 @@ package static
 import (
 	"encoding/json"
 	"html"
-	"time"
 
 	"net/http"
 
@@ -60,8 +59,13 @@ func userSubmit(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	payload.OrderID = data.GetShortOrderID()
-	payload.OrderDate = time.Now().Unix()
+	if retCode, err := data.PrepareOrder(r, payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": retCode,
+			"msg":     err.Error(),
+		})
+		return
+	}
 	respond, err := createOrder(payload) //create payssion call
 	logger.Debugf("Create static payment order, error:&s", err)
 	payload.Respond = respond
@@ -0,0 +1,101 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/agreyfox/eshop/payment/data"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+func initWallet() {
+	currency, err := db.GetParameterFromConfig("PaymentSetting", "name", "wallet_currency", "valueString")
+	if err == nil && len(currency) > 0 {
+		db.WalletDefaultCurrency = currency
+	}
+	logger.Infof("Wallet payment use currency %s", db.WalletDefaultCurrency)
+}
+
+// currentEmail returns the email of the logged in customer
+func currentEmail(r *http.Request) (string, error) {
+	buf, err := db.CurrentUser(r)
+	if err != nil {
+		return "", err
+	}
+	usr := user.User{}
+	err = json.Unmarshal(buf, &usr)
+	if err != nil {
+		return "", err
+	}
+	return usr.Email, nil
+}
+
+// accept user standard request, the order is paid by the customer wallet
+func userSubmit(w http.ResponseWriter, r *http.Request) {
+	logger.Info("User submit a wallet payment")
+
+	email, err := currentEmail(r)
+	if err != nil {
+		logger.Warnf("wallet payment without login from %s", data.GetIP(r))
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -2,
+			"msg":     "You should login first",
+		})
+		return
+	}
+
+	payload := new(data.UserSubmitOrderRequest)
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		logger.Errorf("user submit error", err)
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -1,
+			"msg":     "input data parse error",
+		})
+		return
+	}
+	payload.IPAddr = data.GetIP(r)
+	payload.Email = email // wallet always belongs to the login user
+	if err := validateRequest(payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": -1,
+			"msg":     err.Error(),
+		})
+		return
+	}
+	if retCode, err := data.PrepareOrder(r, payload); err != nil {
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": retCode,
+			"msg":     err.Error(),
+		})
+		return
+	}
+
+	order, err := createOrder(payload)
+	if err != nil {
+		logger.Errorf("Create wallet payment order %s error: %s", payload.OrderID, err)
+		data.ReleasePoints(payload)
+		retCode := -1
+		if err == db.ErrInsufficientBalance {
+			retCode = -3
+		}
+		data.RenderJSON(w, r, map[string]interface{}{
+			"retCode": retCode,
+			"msg":     err.Error(),
+		})
+		return
+	}
+
+	go data.SendOrderConfirmEmail(PaymentVendor, order.OrderID, data.GetPurchaseContent(order.OrderID), order.Paytime, order.Comments, order.Total, order.Currency, order.User, order.PayerIP)
+
+	retData := map[string]interface{}{
+		"transaction":  payload,
+		"redirect_url": data.OnlineURL + fmt.Sprintf("?status=1&orderno=%s", order.OrderID),
+	}
+	data.RenderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "ok",
+		"data":    retData,
+	})
+}
@@ -0,0 +1,128 @@
+package wallet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/agreyfox/eshop/payment/data"
+	"github.com/agreyfox/eshop/system/admin"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// valid user request for wallet payment
+func validateRequest(req *data.UserSubmitOrderRequest) error {
+	if req.Amount < 0.01 {
+		return fmt.Errorf("amount data error")
+	}
+	if len(req.Email) == 0 {
+		return fmt.Errorf("no user info")
+	}
+	if len(req.Currency) == 0 {
+		return fmt.Errorf("no user currency info")
+	}
+	if req.Currency != db.WalletDefaultCurrency {
+		return fmt.Errorf("wallet only accept %s", db.WalletDefaultCurrency)
+	}
+	if len(req.ItemList) == 0 {
+		return fmt.Errorf("no item in order")
+	}
+	return nil
+}
+
+// to save a record
+func saveRequest(r *data.UserSubmitOrderRequest, state string) bool {
+	da, _ := json.Marshal(r)
+
+	record := data.PaymentLog{
+		RequestData: string(da),
+	}
+	record.OrderID = r.OrderID
+	record.PaymentMethod = PaymentVendor
+	record.PaymentID = r.OrderID
+	record.Total = fmt.Sprintf("%.2f", r.Amount)
+	record.Currency = r.Currency
+	record.PaymentState = state
+	record.BuyerEmail = r.Email
+	record.RequestTime = time.Now().Unix()
+	record.Comments = r.RequestInfo
+	record.IP = r.IPAddr
+
+	return data.SavePaymentLog(&record)
+}
+
+// createOrder debits the wallet by the order total of the priced items and
+// creates the paid order, the debit is credited back when the order can not be
+// saved
+func createOrder(r *data.UserSubmitOrderRequest) (*data.Order, error) {
+	logger.Infof("User %s create the wallet payment from %s", r.Email, r.IPAddr)
+
+	total := data.OrderTotal(r)
+	if total < 0.01 || math.Abs(total-r.Amount) >= 0.005 {
+		saveRequest(r, data.OrderUnPaid)
+		return nil, fmt.Errorf("amount %.2f does not match order total %.2f", r.Amount, total)
+	}
+	entry, err := db.DebitWallet(r.Email, total, r.OrderID, PaymentVendor, "order payment")
+	if err != nil {
+		saveRequest(r, data.OrderUnPaid)
+		return nil, err
+	}
+
+	r.Status = data.OrderPaid
+	data.SaveOrderRequest(r)
+	saveRequest(r, data.OrderPaid)
+
+	order, ok := CreateNewOrderByRequest(r, entry)
+	if !ok {
+		_, cerr := db.CreditWallet(r.Email, entry.Amount, r.OrderID, PaymentVendor, "rollback failed order")
+		if cerr != nil {
+			logger.Errorf("Rollback wallet debit of order %s error: %s", r.OrderID, cerr)
+		}
+		return nil, fmt.Errorf("create order failed")
+	}
+	return &order, nil
+}
+
+// CreateNewOrderByRequest create a paid order from the request and the wallet debit
+func CreateNewOrderByRequest(reqdata *data.UserSubmitOrderRequest, entry *db.WalletEntry) (data.Order, bool) {
+	oid := reqdata.OrderID
+	if eid := admin.FindContentID("Order", oid, "order_id"); len(eid) > 0 {
+		logger.Warnf("Order id %s duplication,check id %s in db, create order exit", oid, eid)
+		return data.Order{}, false
+	}
+	capdetail, _ := json.Marshal(reqdata.ItemList)
+	buff, _ := json.Marshal(reqdata)
+	now := fmt.Sprint(time.Now().Format(time.RFC1123))
+
+	order := data.Order{
+		Status:        data.OrderPaid,
+		OrderID:       oid,
+		PaymentID:     fmt.Sprintf("%s-%d", PaymentVendor, entry.ID),
+		PaymentVendor: PaymentVendor,
+		PaymentMethod: PaymentVendor,
+		NotifyInfo:    string(buff[:]),
+		Description:   string(capdetail),
+		OrderDetail:   string(capdetail),
+		Currency:      reqdata.Currency,
+		Total:         fmt.Sprintf("%.2f", reqdata.Amount),
+		Paid:          fmt.Sprintf("%.2f", entry.Amount),
+		Net:           fmt.Sprintf("%.2f", entry.Amount),
+		UpdateTime:    now,
+		Paytime:       now,
+		User:          reqdata.Email,
+		Payer:         reqdata.Email,
+		PayerLink:     reqdata.ContactInfo,
+		Comments:      reqdata.RequestInfo,
+		PayerIP:       reqdata.IPAddr,
+		RequestTime:   fmt.Sprint(time.Unix(reqdata.OrderDate, 0).Format(time.RFC1123)),
+	}
+
+	mm, _ := json.Marshal(order)
+	retCode, ok := admin.CreateContent("Order", mm)
+	if !ok {
+		logger.Error("error in creat order with code :", retCode)
+		return order, false
+	}
+	return order, true
+}
@@ -0,0 +1,6 @@
+package wallet
+
+const (
+	// PaymentVendor is the vendor name stored on orders paid from a wallet
+	PaymentVendor = "wallet"
+)
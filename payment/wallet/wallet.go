@@ -0,0 +1,32 @@
+// Package wallet implements the store-credit payment method, the order total
+// is debited from the customer wallet kept in the system db.
+package wallet
+
+import (
+	"net/http"
+
+	"github.com/agreyfox/eshop/system/logs"
+
+	"github.com/go-zoo/bone"
+	"go.uber.org/zap"
+)
+
+var (
+	logger *zap.SugaredLogger = logs.Log.Sugar()
+)
+
+// Start registers the wallet payment routes on mainMux
+func Start(mainMux *bone.Mux) {
+
+	logger.Info("starting wallet payment service...")
+	initWallet()
+
+	walletMux := bone.New()
+	walletMux.Get("/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Welcome wallet payment service"))
+	}))
+
+	walletMux.PostFunc("/dopay", userSubmit)
+
+	mainMux.SubRoute("/payment/wallet", walletMux)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -58,13 +59,23 @@ func login(res http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
-	email := requestJson["email"].(string)
-	password := requestJson["password"].(string)
+	email, _ := requestJson["email"].(string)
+	password, _ := requestJson["password"].(string)
+	email = strings.ToLower(email)
+	if wait, err := db.CheckLogin(email, ipaddr); err != nil {
+		logger.Warnf("Admin login of %s from %s refused, wait %s", email, ipaddr, wait)
+		renderJSON(res, req, ReturnData{
+			RetCode: -8,
+			Msg:     fmt.Sprintf("%s (%s)", err.Error(), wait.Round(time.Second)),
+		})
+		return
+	}
 
 	// check email & password
-	j, err := db.User(strings.ToLower(email))
+	j, err := db.User(email)
 	if err != nil || j == nil {
 		logger.Error(err)
+		db.LoginFailed(email, ipaddr)
 		renderJSON(res, req, ReturnData{
 			RetCode: -1,
 			Msg:     "No User information",
@@ -84,12 +95,14 @@ func login(res http.ResponseWriter, req *http.Request) {
 	}
 
 	if !user.IsUser(usr, password) {
+		db.LoginFailed(email, ipaddr)
 		renderJSON(res, req, ReturnData{
 			RetCode: -99,
 			Msg:     "user name or password incorrect",
 		})
 		return
 	}
+	db.UpgradePassword(usr, password)
 	if !usr.IsAdmin || !usr.Perm.Admin { //  modified at 2021/4/13 } !usr.Perm.Admin {
 		logger.Warnf("Normal user try to access admin panel")
 		renderJSON(res, req, ReturnData{
@@ -98,6 +111,11 @@ func login(res http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
+	recovery, ok := passTwoFactor(res, req, usr, requestJson)
+	if !ok {
+		return
+	}
+	db.LoginSucceeded(usr.Email)
 	// create new token
 	week := time.Now().Add(time.Hour * 24 * 7)
 
@@ -105,11 +123,9 @@ func login(res http.ResponseWriter, req *http.Request) {
 
 	countryInfor, _ := ipSearchHandler.QueryIPByDB(ipaddr)
 	claims := map[string]interface{}{
-		"exp":     week,
-		"user":    usr.Email,
 		"country": countryInfor,
 	}
-	token, err := jwt.New(claims)
+	token, err := db.OpenSession(usr.Email, req.UserAgent(), ipaddr, week, claims)
 	if err != nil {
 		logger.Error(err)
 		renderJSON(res, req, ReturnData{
@@ -140,6 +156,13 @@ func login(res http.ResponseWriter, req *http.Request) {
 		"contents":       string(contentStructData[:]),
 		"socialType":     "",
 		"socialLink":     "",
+		"role":           usr.StaffRole(),
+	}
+	if device := rememberDevice(res, usr.Email, requestJson); len(device) > 0 {
+		retdata["device"] = device
+	}
+	if recovery != nil {
+		retdata["recovery"] = recovery
 	}
 	if len(usr.Meta) > 0 {
 		retdata["socialType"] = usr.Meta
@@ -151,6 +174,11 @@ func login(res http.ResponseWriter, req *http.Request) {
 }
 
 func logout(res http.ResponseWriter, req *http.Request) {
+	if token := user.Token(req); user.ValidToken(token) {
+		if err := db.EndSession(token); err != nil {
+			logger.Warn("End session at logout error:", err)
+		}
+	}
 	http.SetCookie(res, &http.Cookie{
 		Name:    user.Lqcmstoken,
 		Expires: time.Unix(0, 0),
@@ -221,6 +249,13 @@ func recoverRequest(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("Failed account recovery. No email address submitted.")
 		return
 	}
+	if err := db.ThrottleReset(emailaddr, GetIP(r)); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -8,
+			Msg:     err.Error(),
+		})
+		return
+	}
 
 	_, err = db.User(emailaddr)
 	if err == db.ErrNoUserExists {
@@ -331,9 +366,19 @@ func recoverPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ipaddr := GetIP(r)
+	if wait, err := db.CheckLogin(email, ipaddr); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -8,
+			Msg:     fmt.Sprintf("%s (%s)", err.Error(), wait.Round(time.Second)),
+		})
+		return
+	}
+
 	var actual string
 	if actual, err = db.RecoveryKey(email); err != nil || actual == "" {
 		logger.Error("Error getting recovery key from database:", err)
+		db.LoginFailed(email, ipaddr)
 
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Error, please go back and try again."))
@@ -342,6 +387,7 @@ func recoverPassword(w http.ResponseWriter, r *http.Request) {
 
 	if key != actual {
 		logger.Debug("Bad recovery key submitted:", key)
+		db.LoginFailed(email, ipaddr)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Error, please go back and try again."))
 		return
@@ -349,6 +395,13 @@ func recoverPassword(w http.ResponseWriter, r *http.Request) {
 
 	// set user with new password
 	password := fmt.Sprintf("%s", reqJSON["password"])
+	if err := db.CheckPassword(password); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
 	usr := &user.User{}
 	u, err := db.User(email)
 	if err != nil {
@@ -443,61 +496,57 @@ func getConfig(res http.ResponseWriter, req *http.Request) {
 //save the config to system, could be key-value and multiple is support
 func saveConfig(res http.ResponseWriter, req *http.Request) {
 	logger.Debugf("Admin try to save the system configuration,from", GetIP(req))
-	if db.IsValidAdminUser(req) {
-		//fmt.Println("is admin")
-		ret := getJsonFromBody(req)
-		if ret == nil {
-			renderJSON(res, req, ReturnData{
-				RetCode: -1,
-				Msg:     "No Input Data",
-			})
-			return
-		}
-		//PrettyPrint(ret)
-		var err error
-		var someerror bool
-		for k, v := range ret {
-			err = db.PutConfig(k, v)
-			if err != nil {
-				logger.Errorf("Save key %s error", k)
-				someerror = true
-			}
+	//fmt.Println("is admin")
+	ret := getJsonFromBody(req)
+	if ret == nil {
+		renderJSON(res, req, ReturnData{
+			RetCode: -1,
+			Msg:     "No Input Data",
+		})
+		return
+	}
+	//PrettyPrint(ret)
+	var err error
+	var someerror bool
+	before, _ := db.ConfigAll()
+	for k, v := range ret {
+		err = db.PutConfig(k, v)
+		if err != nil {
+			logger.Errorf("Save key %s error", k)
+			someerror = true
 		}
+	}
+	after, _ := db.ConfigAll()
+	auditChange(req, db.AuditUpdate, db.AuditConfig, "", before, after)
 
-		/* 		{
-		   			"name": ret["name"].(string),
-
-		   		"bind_addr" : ret["bind_addr"].(string),
-		   		"http_port": ret["http_port"].(string)
-		   		c.HTTPSPort : ret["https_port"].(string)
+	/* 		{
+	   			"name": ret["name"].(string),
 
-		   		c.AdminEmail : ret["admin_email"].(string)
-		   		c.DisableCORS : ret["cors_disabled"].(bool)
-		   		c.DisableHTTPCache : ret["cache_disabled"].(bool)
-		   		c.DisableGZIP : ret["zip_disabled"].(bool)
-		   		c.LogLevel : ret["log_level"].(string)
-		   		c.LogFile : ret["log_file"].(string)
+	   		"bind_addr" : ret["bind_addr"].(string),
+	   		"http_port": ret["http_port"].(string)
+	   		c.HTTPSPort : ret["https_port"].(string)
 
-		   		} */
+	   		c.AdminEmail : ret["admin_email"].(string)
+	   		c.DisableCORS : ret["cors_disabled"].(bool)
+	   		c.DisableHTTPCache : ret["cache_disabled"].(bool)
+	   		c.DisableGZIP : ret["zip_disabled"].(bool)
+	   		c.LogLevel : ret["log_level"].(string)
+	   		c.LogFile : ret["log_file"].(string)
 
-		if someerror {
-			renderJSON(res, req, ReturnData{
-				RetCode: 0,
-				Msg:     "Some config save error ",
-			})
-			return
-		}
+	   		} */
 
+	if someerror {
 		renderJSON(res, req, ReturnData{
 			RetCode: 0,
-			Msg:     "Done",
-		})
-	} else {
-		renderJSON(res, req, ReturnData{
-			RetCode: -99,
-			Msg:     "Permission Denied",
+			Msg:     "Some config save error ",
 		})
+		return
 	}
+
+	renderJSON(res, req, ReturnData{
+		RetCode: 0,
+		Msg:     "Done",
+	})
 }
 
 func newAdmin(w http.ResponseWriter, r *http.Request) {
@@ -510,20 +559,56 @@ func newAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	operator, err := currentUser(r)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Permission Denied",
+		})
+		return
+	}
+	// the role is given explicitly, and not above the operator
+	role, _ := reqJSON["role"].(string)
+	if !user.ValidRole(role) {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Unknown role " + role,
+		})
+		return
+	}
+	if !operator.CanGrant(role) {
+		logger.Warnf("Admin %s is not allowed to grant role %s", operator.Email, role)
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Permission Denied",
+		})
+		return
+	}
+	if err := db.CheckPassword(password); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+
 	usr, err := user.New(email, password)
 	if err != nil {
 		logger.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	usr.Perm.Admin = true
+	usr.Perm = user.AdminPermmission
 	usr.IsAdmin = true
+	usr.Role = role
 	_, err = db.SetUser(usr)
 	if err != nil {
 		logger.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	after, _ := db.User(email)
+	auditChange(r, db.AuditCreate, db.AuditUser, email, nil, after)
 	renderJSON(w, r, ReturnData{
 		RetCode: 0,
 		Msg:     "Done",
@@ -536,8 +621,8 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	ipaddr := GetIP(r)
 	logger.Debugf("Admin  try to update admin user :%s", ipaddr)
 	reqJSON := getJsonFromBody(r)
-	if !db.IsValidAdminUser(r) {
-		logger.Error(err)
+	operator, err := currentUser(r)
+	if err != nil {
 		renderJSON(w, r, ReturnData{
 			RetCode: -99,
 			Msg:     "Permission Denied",
@@ -548,6 +633,26 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	// check email & password
 	em := fmt.Sprintf("%s", reqJSON["email"])
 
+	// an admin changes its own password, other users and roles are changed
+	// by the admins who manage users
+	manager := operator.Can(user.ResourceUsers, user.ActionModify)
+	role, _ := reqJSON["role"].(string)
+	if !manager && (!strings.EqualFold(em, operator.Email) || len(role) > 0) {
+		logger.Warnf("Admin %s is not allowed to update user %s", operator.Email, em)
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Permission Denied",
+		})
+		return
+	}
+	if len(role) > 0 && !user.ValidRole(role) {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Unknown role " + role,
+		})
+		return
+	}
+
 	logger.Debug("try to update user % password ", em)
 	j, err := db.User(strings.ToLower(em))
 	if err != nil {
@@ -579,6 +684,17 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a role is set only by an admin allowed both the role the user has and
+	// the new one
+	if len(role) > 0 && (!operator.CanGrant(role) || (usr.StaffRole() != "" && !operator.CanGrant(usr.StaffRole()))) {
+		logger.Warnf("Admin %s is not allowed to set role %s of %s", operator.Email, role, usr.Email)
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Permission Denied",
+		})
+		return
+	}
+
 	// check if password matches
 	passwordptr, newptr := reqJSON["password"], reqJSON["new_password"]
 	password := ""
@@ -586,7 +702,13 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		password = fmt.Sprint(passwordptr)
 	}
 
-	if len(password) == 0 {
+	if len(password) == 0 && !manager {
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Old password is wrong",
+		})
+		return
+	} else if len(password) == 0 {
 		logger.Errorf("Unexpected user/password combination for %s", usr.Email)
 		logger.Errorf("The admin will change user password without old password", usr.Email)
 		/* renderJSON(w, r, ReturnData{
@@ -606,6 +728,24 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 	if newptr != nil {
 		newPassword = fmt.Sprint(newptr) //reqJSON["new_password"].(string)
 	}
+	if len(newPassword) == 0 && len(role) > 0 {
+		// only the role changes
+		updatedUser := *usr
+		updatedUser.Role = role
+		if err = db.UpdateUser(usr, &updatedUser); err != nil {
+			logger.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		after, _ := db.User(usr.Email)
+		auditChange(r, db.AuditUpdate, db.AuditUser, usr.Email, j, after)
+		logger.Infof("Admin %s set role of %s to %s from %s", operator.Email, usr.Email, role, ipaddr)
+		renderJSON(w, r, ReturnData{
+			RetCode: 0,
+			Msg:     "done",
+		})
+		return
+	}
 	if len(newPassword) == 0 {
 		logger.Errorf("New password setting error for user %s", usr.Email)
 		renderJSON(w, r, ReturnData{
@@ -614,6 +754,13 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if err := db.CheckPassword(newPassword); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
 	var updatedUser *user.User
 
 	updatedUser, err = user.New(em, newPassword)
@@ -622,9 +769,13 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	updatedUser.Perm.Admin = usr.Perm.Admin
+	updatedUser.Perm = usr.Perm
 	updatedUser.IsAdmin = usr.IsAdmin
-	
+	updatedUser.Role = usr.Role
+	if len(role) > 0 {
+		updatedUser.Role = role
+	}
+
 	// set the ID to the same ID as current user
 	updatedUser.ID = usr.ID
 
@@ -635,6 +786,8 @@ func updateAdmin(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	after, _ := db.User(updatedUser.Email)
+	auditChange(r, db.AuditUpdate, db.AuditUser, usr.Email, j, after)
 	renderJSON(w, r, ReturnData{
 		RetCode: 0,
 		Msg:     "done",
@@ -730,6 +883,7 @@ func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// delete existing user
+	before, _ := db.User(email)
 	err = db.DeleteUser(email)
 	if err != nil {
 		logger.Error(err)
@@ -737,6 +891,7 @@ func deleteAdmin(w http.ResponseWriter, r *http.Request) {
 
 		return
 	}
+	auditChange(r, db.AuditDelete, db.AuditUser, email, before, nil)
 
 	renderJSON(w, r, ReturnData{
 		RetCode: 0,
@@ -1220,6 +1375,7 @@ func loginRestHandler(res http.ResponseWriter, req *http.Request) {
 			http.Redirect(res, req, req.URL.String(), http.StatusFound)
 			return
 		}
+		db.UpgradePassword(usr, req.FormValue("password"))
 		// create new token
 		week := time.Now().Add(time.Hour * 24 * 7)
 		claims := map[string]interface{}{
@@ -2341,13 +2497,23 @@ func updateContent(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		id, err := db.UpdateContent(t+":"+cid, upp)
+		before, _ := db.Content(t + ":" + cid)
+		id, err := db.UpdateContentBy(t+":"+cid, upp, currentAdminEmail(r))
 		if err != nil {
 			logger.Error(err.Error())
+			if errors.Is(err, db.ErrReferenceNotFound) || errors.Is(err, db.ErrInvalidContent) {
+				renderJSON(w, r, ReturnData{
+					RetCode: -1,
+					Msg:     err.Error(),
+				})
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
+		auditContent(r, db.AuditUpdate, fmt.Sprintf("%s:%d", t, id), before)
+
 		// set the target in the context so user can get saved value from db in hook
 		ctx := context.WithValue(r.Context(), "target", fmt.Sprintf("%s:%d", t, id))
 
@@ -2466,14 +2632,23 @@ func createContent(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		id, err := db.SetContent(t+":-1", upp)
+		id, err := db.SetContentBy(t+":-1", upp, currentAdminEmail(r))
 		if err != nil {
 			logger.Error(err.Error())
+			if errors.Is(err, db.ErrReferenceNotFound) || errors.Is(err, db.ErrInvalidContent) {
+				renderJSON(w, r, ReturnData{
+					RetCode: -1,
+					Msg:     err.Error(),
+				})
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 
 			return
 		}
 
+		auditContent(r, db.AuditCreate, fmt.Sprintf("%s:%d", t, id), nil)
+
 		// set the target in the context so user can get saved value from db in hook
 		ctx := context.WithValue(r.Context(), "target", fmt.Sprintf("%s:%d", t, id))
 
@@ -3420,6 +3595,8 @@ func approveContent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditContent(r, db.AuditApprove, fmt.Sprintf("%s:%d", t, id), nil)
+
 	// set the target in the context so user can get saved value from db in hook
 	ctx := context.WithValue(r.Context(), "target", fmt.Sprintf("%s:%d", t, id))
 	r = r.WithContext(ctx)
@@ -3488,7 +3665,7 @@ func rejectContent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.Content(t + PENDINGSuffix + ":" + id) //target is pending bucket
+	before, err := db.Content(t + PENDINGSuffix + ":" + id) //target is pending bucket
 	if err != nil {
 		logger.Error("no reject content ", t+":"+id, err)
 		renderJSON(w, r, ReturnData{
@@ -3536,6 +3713,7 @@ func rejectContent(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	auditContent(r, db.AuditReject, t+PENDINGSuffix+":"+id, before)
 
 	err = hook.AfterDelete(w, r)
 	if err != nil {
@@ -3648,12 +3826,21 @@ func deleteContent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.Debug("Now to delete the content ", t, ": ", id)
+	before, _ := db.Content(t + ":" + id)
 	err = db.DeleteContent(t + ":" + id)
 	if err != nil {
 		logger.Error(err)
+		if errors.Is(err, db.ErrReferenced) {
+			renderJSON(w, r, ReturnData{
+				RetCode: -1,
+				Msg:     err.Error(),
+			})
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	auditContent(r, db.AuditDelete, t+":"+id, before)
 
 	err = hook.AfterDelete(w, r)
 	if err != nil {
@@ -4152,7 +4339,7 @@ func editRestHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		id, err := db.SetContent(t+":"+cid, req.PostForm)
+		id, err := db.SetContentBy(t+":"+cid, req.PostForm, currentAdminEmail(req))
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
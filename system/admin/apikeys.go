@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// apiKeyRequest is the body of a new API key. Grants may also be written as
+// scopes like "Order:read,modify".
+type apiKeyRequest struct {
+	Name   string       `json:"name"`
+	Grants []user.Grant `json:"grants"`
+	Scopes []string     `json:"scopes"`
+	IPs    []string     `json:"ips"`
+	Days   int          `json:"days"` // days the key works, 0 for no expiry
+}
+
+// getAPIKeys lists the API keys with their grants and last use
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query API keys from %s", GetIP(r))
+	list, err := db.APIKeys()
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    list,
+	})
+}
+
+// createAPIKey issues a key for an integration, request like
+// {"name":"fulfillment","scopes":["Order:read,modify"],"ips":["10.0.0.0/8"],"days":90}.
+// The token is shown only here. A key can not issue keys.
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	operator, err := currentUser(r)
+	if err != nil || operator.StaffRole() == user.RoleAPIKey {
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Permission Denied",
+		})
+		return
+	}
+
+	req := apiKeyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Days < 0 {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong request",
+		})
+		return
+	}
+	for _, s := range req.Scopes {
+		g, err := user.ParseGrant(s)
+		if err != nil {
+			renderJSON(w, r, ReturnData{
+				RetCode: -1,
+				Msg:     err.Error(),
+			})
+			return
+		}
+		req.Grants = append(req.Grants, g)
+	}
+
+	k := &db.APIKey{Name: req.Name, Grants: req.Grants, IPs: req.IPs, Creator: operator.Email}
+	if req.Days > 0 {
+		k.Expires = time.Now().Add(time.Duration(req.Days) * 24 * time.Hour).Unix()
+	}
+	token, err := db.CreateAPIKey(k)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s create API key %s (%s) with %v from %s", operator.Email, k.ID, k.Name, k.Grants, ipaddr)
+	after, _ := json.Marshal(k)
+	auditChange(r, db.AuditCreate, db.AuditAPIKey, k.ID, nil, after)
+
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data": map[string]interface{}{
+			"key":   k,
+			"token": token,
+		},
+	})
+}
+
+// revokeAPIKey deletes key ?id=
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	id := r.URL.Query().Get("id")
+	if err := db.RevokeAPIKey(id); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s revoke API key %s from %s", currentAdminEmail(r), id, ipaddr)
+	auditChange(r, db.AuditDelete, db.AuditAPIKey, id, nil, nil)
+
+	renderJSON(w, r, ReturnData{
+		RetCode: 0,
+		Msg:     "Done",
+	})
+}
@@ -0,0 +1,137 @@
+package admin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// auditContent records the change of content target by the admin of r,
+// before is the item before the change
+func auditContent(r *http.Request, action, target string, before []byte) {
+	db.AuditContent(currentAdminEmail(r), GetIP(r), action, target, before)
+}
+
+// auditChange records the change of a config, user or key by the admin of r
+func auditChange(r *http.Request, action, typ, target string, before, after []byte) {
+	operator := currentAdminEmail(r)
+	err := db.Audit(db.AuditEntry{Actor: operator, IP: GetIP(r), Action: action, Type: typ, Target: target}, before, after)
+	if err != nil {
+		logger.Errorf("Audit %s of %s %s by %s error: %s", action, typ, target, operator, err)
+	}
+}
+
+// auditTime reads a time filter given as unix seconds or a 2006-01-02 date
+func auditTime(v string) (int64, error) {
+	if len(v) == 0 {
+		return 0, nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// auditFilter reads the filter of the audit log from the query,
+// ?actor=&ip=&action=&type=&id=&since=&until=&offset=&count=
+func auditFilter(r *http.Request) (db.AuditFilter, error) {
+	q := r.URL.Query()
+	f := db.AuditFilter{
+		Actor:  q.Get("actor"),
+		IP:     q.Get("ip"),
+		Action: q.Get("action"),
+		Type:   q.Get("type"),
+		Target: q.Get("id"),
+	}
+	var err error
+	if f.Since, err = auditTime(q.Get("since")); err != nil {
+		return f, err
+	}
+	if f.Until, err = auditTime(q.Get("until")); err != nil {
+		return f, err
+	}
+	for name, n := range map[string]*int{"offset": &f.Offset, "count": &f.Limit} {
+		if v := q.Get(name); len(v) > 0 {
+			if *n, err = strconv.Atoi(v); err != nil || *n < 0 {
+				return f, fmt.Errorf("Wrong %s", name)
+			}
+		}
+	}
+	return f, nil
+}
+
+// getAuditLog lists the audit log the latest first, filtered by the query of
+// auditFilter, 50 entries when count is not given
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query audit log from %s", GetIP(r))
+	f, err := auditFilter(r)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	if f.Limit == 0 {
+		f.Limit = 50
+	}
+	list, total, err := db.AuditLog(f)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    list,
+		"total":   total,
+	})
+}
+
+// exportAuditLog writes the audit log filtered like getAuditLog as csv, all
+// entries when count is not given
+func exportAuditLog(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	f, err := auditFilter(r)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	list, _, err := db.AuditLog(f)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s export %d audit entries from %s", currentAdminEmail(r), len(list), ipaddr)
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-%d.csv\"", time.Now().Unix()))
+	c := csv.NewWriter(w)
+	c.Write([]string{"id", "time", "actor", "ip", "action", "type", "id", "changes"})
+	for _, e := range list {
+		changes, _ := json.Marshal(e.Changes)
+		c.Write([]string{
+			strconv.Itoa(e.ID), time.Unix(e.Time, 0).UTC().Format(time.RFC3339), e.Actor, e.IP,
+			e.Action, e.Type, e.Target, string(changes),
+		})
+	}
+	c.Flush()
+}
@@ -35,6 +35,15 @@ type Config struct {
 	BackupBasicAuthPassword string   `json:"backup_basic_auth_password"`
 	LogLevel                string   `json:"log_level"`
 	LogFile                 string   `json:"log_file"`
+	AdminTwoFactor          bool     `json:"admin_2fa"`
+	TwoFactorRememberDays   int64    `json:"remember_2fa_days"`
+	VerifyCheckout          bool     `json:"verify_checkout"`
+	VerifyClaim             bool     `json:"verify_claim"`
+	VerifyLinkHours         int64    `json:"verify_link_hours"`
+	PasswordMinLength       int64    `json:"password_min_length"`
+	PasswordBreachedList    string   `json:"password_breached_list"`
+	ResetLinkURL            string   `json:"reset_link_url"`
+	TrustedProxies          string   `json:"trusted_proxies"`
 }
 
 const (
@@ -150,6 +159,66 @@ func (c *Config) MarshalEditor() ([]byte, error) {
 				"invalidate": "Invalidate Cache",
 			}),
 		},
+		editor.Field{
+			View: editor.Checkbox("AdminTwoFactor", c, map[string]string{
+				"label": "Require two-factor login for admin users",
+			}, map[string]string{
+				"true": "Require Two-Factor",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("TwoFactorRememberDays", c, map[string]string{
+				"label": "Days a device may skip the two-factor code (0 = never remember)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Checkbox("VerifyCheckout", c, map[string]string{
+				"label": "Customers with an unverified email can not check out",
+			}, map[string]string{
+				"true": "Require Verified Email",
+			}),
+		},
+		editor.Field{
+			View: editor.Checkbox("VerifyClaim", c, map[string]string{
+				"label": "Customers with an unverified email can not use orders placed under it, like for reviews",
+			}, map[string]string{
+				"true": "Require Verified Email",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("VerifyLinkHours", c, map[string]string{
+				"label": "Hours an email verification link works (0 = 48)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("PasswordMinLength", c, map[string]string{
+				"label": "Minimum length of a new password (0 = 8)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("PasswordBreachedList", c, map[string]string{
+				"label":       "File of breached passwords, one password or SHA-1 hash per line, refused for new passwords",
+				"placeholder": "e.g. /etc/eshop/breached.txt",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("ResetLinkURL", c, map[string]string{
+				"label":       "Page the password reset link opens, with ?token= added",
+				"placeholder": "e.g. https://www.example.com/reset (default: the reset API of the domain)",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("TrustedProxies", c, map[string]string{
+				"label":       "Proxies whose X-Forwarded-For header gives the client address, besides the loopback",
+				"placeholder": "e.g. 10.0.0.0/8, 172.16.0.5",
+				"type":        "text",
+			}),
+		},
 		editor.Field{
 			View: []byte(dbBackupInfo),
 		},
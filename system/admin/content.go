@@ -54,6 +54,7 @@ func CreateContent(ctype string, content []byte) (int, bool) {
 
 		return -1, false
 	}
+	db.AuditContent(db.ActorSystem, "", db.AuditCreate, fmt.Sprintf("%s:%d", ctype, id), nil)
 
 	return id, true
 }
@@ -139,12 +140,14 @@ func UpdateContents(t string, cid string, key []string, updateValue *url.Values)
 		return 0, fmt.Errorf("data format issues")
 	}
 
+	before, _ := db.Content(t + ":" + cid)
 	id, err := db.UpdateContent(t+":"+cid, upp)
 	if err != nil {
 		logger.Error(err.Error())
 
 		return -1, err
 	}
+	db.AuditContent(db.ActorSystem, "", db.AuditUpdate, t+":"+cid, before)
 	return id, nil
 }
 
@@ -180,12 +183,14 @@ func UpdateContent(t string, cid, key string, content []byte) (int, error) {
 		return 0, fmt.Errorf("Data format issues:%s", err.Error())
 	}
 
+	before, _ := db.Content(t + ":" + cid)
 	id, err := db.UpdateContent(t+":"+cid, upp)
 	if err != nil {
 		logger.Error(err.Error())
 
 		return -1, err
 	}
+	db.AuditContent(db.ActorSystem, "", db.AuditUpdate, t+":"+cid, before)
 	return id, nil
 
 }
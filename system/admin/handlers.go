@@ -591,6 +591,7 @@ func loginHandler(res http.ResponseWriter, req *http.Request) {
 			http.Redirect(res, req, req.URL.String(), http.StatusFound)
 			return
 		}
+		db.UpgradePassword(usr, req.FormValue("password"))
 		// create new token
 		week := time.Now().Add(time.Hour * 24 * 7)
 		claims := map[string]interface{}{
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// getLoginLocks lists the accounts and addresses which wait after failed
+// logins
+func getLoginLocks(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query login locks from %s", GetIP(r))
+	list, err := db.LoginLocks()
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    list,
+	})
+}
+
+// unlockLogin clears the failed logins of an account or an address, request
+// like {"email":"abc@mail.com"} or {"ip":"1.2.3.4"}
+func unlockLogin(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	reqJSON := getJsonFromBody(r)
+	email, _ := reqJSON["email"].(string)
+	addr, _ := reqJSON["ip"].(string)
+
+	key := ""
+	switch {
+	case len(email) > 0:
+		key = "email:" + strings.ToLower(strings.TrimSpace(email))
+	case len(addr) > 0:
+		key = "ip:" + strings.TrimSpace(addr)
+	default:
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Need email or ip",
+		})
+		return
+	}
+
+	if err := db.UnlockLogin(key, currentAdminEmail(r), ipaddr); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, ReturnData{
+		RetCode: 0,
+		Msg:     "Done",
+	})
+}
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// Resources of the route table besides the user.Resource areas
+const (
+	// public routes need no login
+	public = ""
+	// staff routes need an admin login of any role
+	staff = "staff"
+	// typeResource is the content type of ?type= of the request
+	typeResource = "?type"
+)
+
+// currentUser returns the login user of the request, or the user of its API
+// key with the grants of the key, replaced in tests
+var currentUser = func(r *http.Request) (*user.User, error) {
+	if len(user.APIKeyToken(r)) > 0 {
+		return db.APIKeyUser(r)
+	}
+	j, err := db.CurrentUser(r)
+	if err != nil {
+		return nil, err
+	}
+	usr := &user.User{}
+	if err = json.Unmarshal(j, usr); err != nil {
+		return nil, err
+	}
+	return usr, nil
+}
+
+// route is one admin api route with the permission it needs
+type route struct {
+	method   string // empty for any method
+	path     string
+	resource string
+	action   string
+	handler  http.HandlerFunc
+}
+
+// resourceOf returns the resource the request acts on
+func resourceOf(resource string, r *http.Request) string {
+	if resource != typeResource {
+		return resource
+	}
+	// pending and sorted content go with their type
+	return strings.Split(r.URL.Query().Get("type"), "__")[0]
+}
+
+// permit is HTTP middleware which lets only the admin users whose role
+// allows action on resource through. API keys do not pass the staff routes,
+// which act on the login user itself.
+func permit(resource, action string, next http.HandlerFunc) http.HandlerFunc {
+	if resource == public {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		usr, err := currentUser(r)
+		if err != nil {
+			deny(w, http.StatusUnauthorized, ReturnData{
+				RetCode: 2,
+				Msg:     "You should login first",
+			})
+			return
+		}
+		role := usr.StaffRole()
+		if len(role) == 0 || (resource == staff && role == user.RoleAPIKey) ||
+			(resource != staff && !usr.Can(resourceOf(resource, r), action)) {
+			logger.Warnf("Admin %s (%s) denied %s %s from %s", usr.Email, role, r.Method, r.URL.Path, GetIP(r))
+			deny(w, http.StatusForbidden, ReturnData{
+				RetCode: -99,
+				Msg:     "Permission Denied",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// deny writes the json error of a refused request with status
+func deny(w http.ResponseWriter, status int, data ReturnData) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,259 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/go-zoo/bone"
+)
+
+// testMux serves the v1 route table with every handler replaced by one that
+// answers 200, and the login user taken from the X-Test-User header
+func testMux(users map[string]*user.User) (*bone.Mux, func()) {
+	old := currentUser
+	currentUser = func(r *http.Request) (*user.User, error) {
+		if u, ok := users[r.Header.Get("X-Test-User")]; ok {
+			return u, nil
+		}
+		return nil, errors.New("no login")
+	}
+
+	mux := bone.New()
+	for _, rt := range v1Routes() {
+		rt.handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}
+		handle(mux, rt)
+	}
+	return mux, func() { currentUser = old }
+}
+
+func staffUser(email, role string) *user.User {
+	return &user.User{Email: email, IsAdmin: true, Perm: user.AdminPermmission, Role: role}
+}
+
+func testUsers() map[string]*user.User {
+	legacy := &user.User{Email: "legacy@eshop.com", IsAdmin: true, Perm: user.CustomerPermission}
+	legacy.Perm.Admin = true
+	return map[string]*user.User{
+		"customer":    {Email: "buyer@example.com", Perm: user.CustomerPermission},
+		"legacy":      legacy,
+		"owner":       staffUser("owner@eshop.com", user.RoleOwner),
+		"editor":      staffUser("editor@eshop.com", user.RoleEditor),
+		"support":     staffUser("support@eshop.com", user.RoleSupport),
+		"fulfillment": staffUser("worker@eshop.com", user.RoleFulfillment),
+		"finance":     staffUser("finance@eshop.com", user.RoleFinance),
+	}
+}
+
+func serve(mux http.Handler, method, path, who string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if len(who) > 0 {
+		req.Header.Set("X-Test-User", who)
+	}
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	return res.Code
+}
+
+// TestPublicRoutes keeps the routes open to everyone to a known list, so a
+// new route can not be added without a permission by mistake
+func TestPublicRoutes(t *testing.T) {
+	want := []string{
+		"GET /file",
+		"POST /login",
+		"POST /logout",
+		"POST /recover/key",
+		"POST /user/login",
+		"POST /user/logout",
+		"POST /user/recover",
+	}
+	got := []string{}
+	for _, rt := range v1Routes() {
+		if rt.resource == public {
+			got = append(got, rt.method+" "+rt.path)
+		}
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("public routes %v, want %v", got, want)
+	}
+}
+
+// TestRoutesNeedStaff checks every protected route refuses requests without a
+// login and customer logins, and lets an owner through
+func TestRoutesNeedStaff(t *testing.T) {
+	mux, restore := testMux(testUsers())
+	defer restore()
+
+	for _, rt := range v1Routes() {
+		if rt.resource == public {
+			continue
+		}
+		method := rt.method
+		if len(method) == 0 {
+			method = http.MethodGet
+		}
+		path := rt.path + "?type=Product"
+		if code := serve(mux, method, path, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s %s without login: %d, want 401", method, rt.path, code)
+		}
+		if code := serve(mux, method, path, "customer"); code != http.StatusForbidden {
+			t.Errorf("%s %s by customer: %d, want 403", method, rt.path, code)
+		}
+		for _, who := range []string{"owner", "legacy"} {
+			if code := serve(mux, method, path, who); code != http.StatusOK {
+				t.Errorf("%s %s by %s: %d, want 200", method, rt.path, who, code)
+			}
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	mux, restore := testMux(testUsers())
+	defer restore()
+
+	cases := []struct {
+		method string
+		path   string
+		allow  []string // roles besides owner which pass
+	}{
+		{http.MethodGet, "/contents?type=Product", []string{"editor", "support", "fulfillment"}},
+		{http.MethodPost, "/content/update?type=Product", []string{"editor"}},
+		{http.MethodDelete, "/content?type=Product", []string{"editor"}},
+		{http.MethodPost, "/content/approve?type=Review__pending", []string{"editor", "support"}},
+		{http.MethodPost, "/content/reject?type=News__pending", []string{"editor"}},
+		{http.MethodGet, "/contents?type=Order", []string{"support", "fulfillment", "finance"}},
+		{http.MethodPost, "/content/update?type=Order", []string{"support", "fulfillment", "finance"}},
+		{http.MethodDelete, "/content?type=Order", nil},
+		{http.MethodGet, "/contents/export?type=Order", []string{"finance"}},
+		{http.MethodGet, "/contents?type=PaymentSetting", []string{"finance"}},
+		{http.MethodPost, "/content?type=Coupon", []string{"finance"}},
+		{http.MethodGet, "/contents", nil},
+		{http.MethodPost, "/file", []string{"editor"}},
+		{http.MethodGet, "/files", []string{"editor", "support", "fulfillment"}},
+		{http.MethodPost, "/wallet/credit", []string{"support", "finance"}},
+		{http.MethodGet, "/points", []string{"support", "finance"}},
+		{http.MethodGet, "/user/search", []string{"support"}},
+		{http.MethodPost, "/user/unlock", nil},
+		{http.MethodGet, "/user/export", nil},
+		{http.MethodPost, "/user/erase", nil},
+		{http.MethodPost, "/user/register", nil},
+		{http.MethodDelete, "/user/remove", nil},
+		{http.MethodPost, "/config", nil},
+		{http.MethodGet, "/config", nil},
+		{http.MethodGet, "/backup", nil},
+		{http.MethodPost, "/addons", nil},
+		{http.MethodPost, "/revisions/restore?type=Product", []string{"editor"}},
+		{http.MethodPost, "/previews", []string{"editor"}},
+		{http.MethodGet, "/scheduled", []string{"editor"}},
+		{http.MethodPost, "/user/update", []string{"editor", "support", "fulfillment", "finance"}},
+		{http.MethodDelete, "/sessions", []string{"editor", "support", "fulfillment", "finance"}},
+		{http.MethodGet, "/apikeys", nil},
+		{http.MethodPost, "/apikeys", nil},
+		{http.MethodGet, "/audit", nil},
+		{http.MethodGet, "/audit/export", nil},
+	}
+	for _, c := range cases {
+		allowed := map[string]bool{"owner": true}
+		for _, r := range c.allow {
+			allowed[r] = true
+		}
+		for _, who := range []string{"owner", "editor", "support", "fulfillment", "finance"} {
+			want := http.StatusForbidden
+			if allowed[who] {
+				want = http.StatusOK
+			}
+			if code := serve(mux, c.method, c.path, who); code != want {
+				t.Errorf("%s %s by %s: %d, want %d", c.method, c.path, who, code, want)
+			}
+		}
+	}
+}
+
+func TestAPIKeyGrants(t *testing.T) {
+	g, err := user.ParseGrant("Order, Carts:read,modify")
+	if err != nil || g.String() != "Order,Carts:read,modify" {
+		t.Fatalf("parse grant: %+v %v", g, err)
+	}
+	for _, s := range []string{"Order", ":read", "Order:drop", "Order:read:modify"} {
+		if _, err := user.ParseGrant(s); err != user.ErrGrant {
+			t.Errorf("parse %q: %v", s, err)
+		}
+	}
+
+	key := &user.User{Email: "apikey:1", IsAdmin: true, Perm: user.AdminPermmission, Grants: []user.Grant{g}}
+	mux, restore := testMux(map[string]*user.User{"key": key})
+	defer restore()
+
+	cases := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/contents?type=Order", http.StatusOK},
+		{http.MethodPost, "/content/update?type=Carts", http.StatusOK},
+		{http.MethodDelete, "/content?type=Order", http.StatusForbidden},
+		{http.MethodGet, "/contents?type=Product", http.StatusForbidden},
+		{http.MethodGet, "/apikeys", http.StatusForbidden},
+		{http.MethodPost, "/user/update", http.StatusForbidden},
+		{http.MethodGet, "/sessions", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if code := serve(mux, c.method, c.path, "key"); code != c.want {
+			t.Errorf("%s %s by key: %d, want %d", c.method, c.path, code, c.want)
+		}
+	}
+}
+
+func TestPermissionFlags(t *testing.T) {
+	editor := staffUser("editor@eshop.com", user.RoleEditor)
+	editor.Perm.Delete = false
+	if !editor.Can("Product", user.ActionModify) || editor.Can("Product", user.ActionDelete) {
+		t.Error("expected the delete permission flag to limit the editor role")
+	}
+
+	unknown := staffUser("who@eshop.com", "janitor")
+	if unknown.StaffRole() != "" || unknown.Can("Product", user.ActionRead) {
+		t.Error("expected an unknown role to have no permission")
+	}
+}
+
+func TestCanGrant(t *testing.T) {
+	owner := staffUser("owner@eshop.com", user.RoleOwner)
+	for role := range user.Roles {
+		if !owner.CanGrant(role) {
+			t.Errorf("expected the owner to grant %s", role)
+		}
+	}
+	if owner.CanGrant("") || owner.CanGrant(user.RoleAPIKey) {
+		t.Error("expected no role to be granted without a name")
+	}
+	if !staffUser("legacy@eshop.com", "").CanGrant(user.RoleOwner) {
+		t.Error("expected an admin saved before roles to grant owner")
+	}
+
+	support := staffUser("support@eshop.com", user.RoleSupport)
+	if support.CanGrant(user.RoleOwner) || support.CanGrant(user.RoleEditor) || !support.CanGrant(user.RoleSupport) {
+		t.Error("expected the support role to grant no more than its own")
+	}
+	finance := staffUser("finance@eshop.com", user.RoleFinance)
+	if finance.CanGrant(user.RoleOwner) || finance.CanGrant(user.RoleFulfillment) {
+		t.Error("expected the finance role to grant no role above it")
+	}
+}
+
+// TestPayoutGrant keeps sending money to the owner and finance roles
+func TestPayoutGrant(t *testing.T) {
+	for who, u := range testUsers() {
+		want := who == "owner" || who == "legacy" || who == "finance"
+		if got := u.Can(user.ResourcePayouts, user.ActionCreate); got != want {
+			t.Errorf("%s payout: %v, want %v", who, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// exportPersonalData sends everything kept of the customer ?email= as json,
+// or as a zip archive with ?format=zip
+func exportPersonalData(w http.ResponseWriter, r *http.Request) {
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	data, err := db.ExportPersonalData(email)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Personal data of %s exported by %s from %s", email, currentAdminEmail(r), GetIP(r))
+
+	name := "personal-data-" + db.AnonID(email)
+	if r.URL.Query().Get("format") == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+		if err := db.WritePersonalZip(w, data); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		logger.Error(err)
+	}
+}
+
+// erasePersonalData erases the customer, request like {"email":"abc@mail.com"}.
+// The orders are kept anonymized, see db.ErasePersonalData.
+func erasePersonalData(w http.ResponseWriter, r *http.Request) {
+	reqJSON := getJsonFromBody(r)
+	email, _ := reqJSON["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) == 0 {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Need email",
+		})
+		return
+	}
+
+	e, err := db.ErasePersonalData(email, currentAdminEmail(r), GetIP(r))
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	// the erased email is not written to the audit log, only its anonymous id
+	auditChange(r, db.AuditDelete, db.AuditUser, e.ID, nil, nil)
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    e,
+	})
+}
+
+// getErasures lists the records of the erased accounts
+func getErasures(w http.ResponseWriter, r *http.Request) {
+	list, err := db.Erasures()
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    list,
+	})
+}
@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// getPoints returns the loyalty points and history of a customer, ?email=
+func getPoints(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query points from %s", GetIP(r))
+	email := strings.ToLower(r.URL.Query().Get("email"))
+	if !isValidateEmail(email) {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong email",
+		})
+		return
+	}
+	account, err := db.PointsOf(email)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	history, err := db.PointsLedger(email)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data": map[string]interface{}{
+			"points":  account,
+			"history": history,
+		},
+	})
+}
+
+// adjustPoints add or take points of a customer, body is
+// {"email":"","points":-100,"comment":""}
+func adjustPoints(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	logger.Debugf("Admin adjust points from %s", ipaddr)
+	reqJSON := getJsonFromBody(r)
+	if reqJSON == nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "No Input Data",
+		})
+		return
+	}
+	email := strings.ToLower(fmt.Sprint(reqJSON["email"]))
+	if _, err := db.User(email); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "User not exists",
+		})
+		return
+	}
+	points, err := strconv.ParseFloat(fmt.Sprint(reqJSON["points"]), 64)
+	if err != nil || int(points) == 0 {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong points",
+		})
+		return
+	}
+	comment := ""
+	if c, ok := reqJSON["comment"]; ok {
+		comment = fmt.Sprint(c)
+	}
+
+	operator := currentAdminEmail(r)
+	entry, err := db.AdjustPoints(email, int(points), operator, comment)
+	if err != nil {
+		logger.Errorf("Admin %s adjust points of %s error: %s", operator, email, err)
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s adjust %d points of %s from %s", operator, entry.Points, email, ipaddr)
+
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    entry,
+	})
+}
@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/item"
+)
+
+// defaultPreviewHours is how long a preview link is valid without ?hours=
+const defaultPreviewHours = 72
+
+// pendingTarget returns the pending content target of ?type=&id=, the type
+// may be given with or without __pending
+func pendingTarget(r *http.Request) (string, bool) {
+	q := r.URL.Query()
+	t, id := strings.TrimSuffix(q.Get("type"), PENDINGSuffix), q.Get("id")
+	if _, ok := item.Types[t]; !ok || !db.IsValidID(id) {
+		return "", false
+	}
+	return t + PENDINGSuffix + ":" + id, true
+}
+
+// getPreviews lists the preview links of a pending item, ?type=&id=, or of
+// every item without them
+func getPreviews(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query previews from %s", GetIP(r))
+	target := ""
+	if len(r.URL.Query().Get("type")) > 0 {
+		var ok bool
+		if target, ok = pendingTarget(r); !ok {
+			renderJSON(w, r, ReturnData{
+				RetCode: -1,
+				Msg:     "Wrong content type or id",
+			})
+			return
+		}
+	}
+	list, err := db.Previews(target)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    list,
+	})
+}
+
+// createPreview makes a preview link of a pending item,
+// ?type=&id=&hours=. The token is shown only here, it is passed as
+// ?preview= to the content and catalog API.
+func createPreview(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	logger.Debugf("Admin create preview from %s", ipaddr)
+	target, ok := pendingTarget(r)
+	hours := defaultPreviewHours
+	if h := r.URL.Query().Get("hours"); len(h) > 0 {
+		var err error
+		if hours, err = strconv.Atoi(h); err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong content or hours",
+		})
+		return
+	}
+
+	operator := currentAdminEmail(r)
+	p, token, err := db.CreatePreview(target, time.Duration(hours)*time.Hour, operator)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s create preview %s of %s from %s", operator, p.ID, target, ipaddr)
+
+	t := strings.TrimSuffix(strings.Split(target, ":")[0], PENDINGSuffix)
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data": map[string]interface{}{
+			"preview": p,
+			"token":   token,
+			"path":    "/api/v1/content?type=" + t + "&preview=" + token,
+		},
+	})
+}
+
+// revokePreview ends a preview link, ?id= is the preview id
+func revokePreview(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	id := r.URL.Query().Get("id")
+	if err := db.RevokePreview(id); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s revoke preview %s from %s", currentAdminEmail(r), id, ipaddr)
+
+	renderJSON(w, r, ReturnData{
+		RetCode: 0,
+		Msg:     "Done",
+	})
+}
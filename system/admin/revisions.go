@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/item"
+)
+
+// revisionTarget returns the content target of ?type=&id=, false when the
+// type is unknown or the id not valid
+func revisionTarget(r *http.Request) (string, bool) {
+	q := r.URL.Query()
+	t, id := q.Get("type"), q.Get("id")
+	if _, ok := item.Types[t]; !ok || !db.IsValidID(id) {
+		return "", false
+	}
+	return t + ":" + id, true
+}
+
+// getRevisions lists the revisions of a content item newest first,
+// ?type=&id=
+func getRevisions(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query revisions from %s", GetIP(r))
+	target, ok := revisionTarget(r)
+	if !ok {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong content type or id",
+		})
+		return
+	}
+	list, err := db.Revisions(target)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    list,
+	})
+}
+
+// getRevisionDiff returns the field changes between two revisions of a
+// content item, ?type=&id=&from=&to=, without to compares with the current
+// content
+func getRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query revision diff from %s", GetIP(r))
+	target, ok := revisionTarget(r)
+	q := r.URL.Query()
+	from, err := strconv.Atoi(q.Get("from"))
+	to := 0
+	if err == nil && len(q.Get("to")) > 0 {
+		to, err = strconv.Atoi(q.Get("to"))
+	}
+	if !ok || err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong content or revision",
+		})
+		return
+	}
+	changes, err := db.DiffRevisions(target, from, to)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    changes,
+	})
+}
+
+// restoreRevision saves a revision back to its content item,
+// ?type=&id=&rev=
+func restoreRevision(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	logger.Debugf("Admin restore revision from %s", ipaddr)
+	target, ok := revisionTarget(r)
+	rev, err := strconv.Atoi(r.URL.Query().Get("rev"))
+	if !ok || err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong content or revision",
+		})
+		return
+	}
+
+	operator := currentAdminEmail(r)
+	before, _ := db.Content(target)
+	err = db.RestoreRevision(target, rev, operator)
+	if err != nil {
+		logger.Errorf("Admin %s restore revision %d of %s error: %s", operator, rev, target, err)
+		if errors.Is(err, db.ErrRevisionNotFound) || errors.Is(err, db.ErrReferenceNotFound) {
+			renderJSON(w, r, ReturnData{
+				RetCode: -1,
+				Msg:     err.Error(),
+			})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("Admin %s restore revision %d of %s from %s", operator, rev, target, ipaddr)
+	auditContent(r, db.AuditRestore, target, before)
+
+	renderJSON(w, r, ReturnData{
+		RetCode: 0,
+		Msg:     "Done",
+	})
+}
@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/agreyfox/eshop/system/publish"
+)
+
+// getScheduled lists the content items waiting to be published or
+// unpublished, by time of their next change
+func getScheduled(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query scheduled content from %s", GetIP(r))
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    publish.Scheduled(time.Now()),
+	})
+}
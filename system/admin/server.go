@@ -101,61 +101,9 @@ func Run(mainMux *bone.Mux) {
 	pageDir := filepath.Join(pwd, "pages")
 
 	v1Mux := bone.New()
-	//v1Mux.HandleFunc("/login", loginRestHandler)
-	v1Mux.Post("/login", api.CORS(http.HandlerFunc(login)))
-	v1Mux.Post("/user/login", api.CORS(http.HandlerFunc(login)))
-	v1Mux.Post("/user/register", http.HandlerFunc(newAdmin))
-	v1Mux.Post("/user/update", http.HandlerFunc(updateAdmin))
-	v1Mux.Delete("/user/remove", user.Auth(http.HandlerFunc(deleteAdmin)))
-	v1Mux.Post("/user/search", user.Auth(searchUser))
-	v1Mux.Get("/user/search", user.Auth(searchUser))
-	//v1Mux.HandleFunc("/logout", http.HandlerFunc(logout))
-	v1Mux.Post("/user/logout", http.HandlerFunc(logout))
-	v1Mux.Post("/logout", api.CORS(http.HandlerFunc(logout)))
-
-	v1Mux.Post("/user/recover", http.HandlerFunc(recoverRequest))
-	v1Mux.Post("/recover/key", http.HandlerFunc(recoverPassword))
-	v1Mux.Get("/backup", http.HandlerFunc(backup))
-
-	//v1Mux.HandleFunc("/recover", forgotPasswordRestHandler)
-	//v1Mux.HandleFunc("/recover/key", recoveryKeyRestHandler)
-
-	v1Mux.HandleFunc("/addons", user.Auth(addonsRestHandler))
-	v1Mux.HandleFunc("/addon", user.Auth(addonRestHandler))
-
-	v1Mux.Get("/config", http.HandlerFunc(getConfig))
-	v1Mux.Post("/config", user.Auth(http.HandlerFunc(saveConfig)))
-
-	//v1Mux.HandleFunc("/configure", user.Auth(configRestHandler))
-	//v1Mux.HandleFunc("/configure/users", user.Auth(configUsersRestHandler))
-	//v1Mux.HandleFunc("/configure/users/edit", user.Auth(configUsersEditRestHandler))
-	//v1Mux.HandleFunc("/configure/users/delete", user.Auth(configUsersDeleteRestHandler))
-	v1Mux.Get("/files", http.HandlerFunc(getMediaContents))
-	//v1Mux.HandleFunc("/uploads", user.Auth(uploadContentsRestHandler))
-	//v1Mux.HandleFunc("/uploads/search", user.Auth(uploadSearchRestHandler))
-	v1Mux.Get("/file", http.HandlerFunc(getMedia))
-	v1Mux.Delete("/file", user.Auth(http.HandlerFunc(deleteMediaContent)))
-	v1Mux.Post("/file", http.HandlerFunc(uploadMediaContent))
-	v1Mux.Get("/files/search", http.HandlerFunc(searchMediaContent))
-
-	//v1Mux.HandleFunc("/contents", user.Auth(contentsRestHandler))
-	v1Mux.Get("/contents", http.HandlerFunc(getContents))
-	v1Mux.Get("/contents/search", http.HandlerFunc(searchContent))
-	v1Mux.Get("/contents/export", http.HandlerFunc(export))
-	v1Mux.Get("/contents/ss", http.HandlerFunc(searchContentEnhanced))
-
-	//v1Mux.HandleFunc("/contents/search", user.Auth(searchRestHandler))
-
-	//v1Mux.HandleFunc("/contents/export", user.Auth(exportRestHandler))
-	v1Mux.Post("/content", user.Auth(createContent))
-	v1Mux.Post("/content/update", http.HandlerFunc(updateContent))
-	//v1Mux.HandleFunc("/edit", user.Auth(editRestHandler))
-	//v1Mux.HandleFunc("/edit/delete", user.Auth(deleteRestHandler))
-	v1Mux.Get("/content", http.HandlerFunc(getContent))
-	//v1Mux.HandleFunc("/content/delete", http.HandlerFunc(deleteContent))
-	v1Mux.Post("/content/approve", http.HandlerFunc(approveContent))
-	v1Mux.Post("/content/reject", http.HandlerFunc(rejectContent))
-	v1Mux.Delete("/content", user.Auth(http.HandlerFunc(deleteContent)))
+	for _, rt := range v1Routes() {
+		handle(v1Mux, rt)
+	}
 
 	//v1Mux.HandleFunc("/edit/approve", user.Auth(approveContentRestHandler))
 	//v1Mux.HandleFunc("/edit/upload", user.Auth(editUploadRestHandler))
@@ -180,6 +128,84 @@ func Run(mainMux *bone.Mux) {
 
 }
 
+// v1Routes is the table of the /admin/v1 routes with the role permission each
+// one needs, content routes are checked on the content type of ?type=
+func v1Routes() []route {
+	return []route{
+		{http.MethodPost, "/login", public, "", api.CORS(login)},
+		{http.MethodPost, "/user/login", public, "", api.CORS(login)},
+		{http.MethodPost, "/logout", public, "", api.CORS(logout)},
+		{http.MethodPost, "/user/logout", public, "", logout},
+		{http.MethodPost, "/user/recover", public, "", recoverRequest},
+		{http.MethodPost, "/recover/key", public, "", recoverPassword},
+
+		{http.MethodPost, "/user/register", user.ResourceUsers, user.ActionCreate, newAdmin},
+		{http.MethodPost, "/user/update", staff, "", updateAdmin},
+		{http.MethodDelete, "/user/remove", user.ResourceUsers, user.ActionDelete, deleteAdmin},
+		{http.MethodPost, "/user/search", user.ResourceUsers, user.ActionRead, searchUser},
+		{http.MethodGet, "/user/search", user.ResourceUsers, user.ActionRead, searchUser},
+		{http.MethodGet, "/user/locks", user.ResourceUsers, user.ActionRead, getLoginLocks},
+		{http.MethodPost, "/user/unlock", user.ResourceUsers, user.ActionModify, unlockLogin},
+		{http.MethodGet, "/user/export", user.ResourceUsers, user.ActionExport, exportPersonalData},
+		{http.MethodPost, "/user/erase", user.ResourceUsers, user.ActionDelete, erasePersonalData},
+		{http.MethodGet, "/user/erasures", user.ResourceUsers, user.ActionRead, getErasures},
+		{http.MethodGet, "/sessions", staff, "", getSessions},
+		{http.MethodDelete, "/sessions", staff, "", revokeSessions},
+		{http.MethodPost, "/2fa/:action", staff, "", twoFactor},
+		{http.MethodGet, "/apikeys", user.ResourceAPIKeys, user.ActionRead, getAPIKeys},
+		{http.MethodPost, "/apikeys", user.ResourceAPIKeys, user.ActionCreate, createAPIKey},
+		{http.MethodDelete, "/apikeys", user.ResourceAPIKeys, user.ActionDelete, revokeAPIKey},
+		{http.MethodGet, "/audit", user.ResourceAudit, user.ActionRead, getAuditLog},
+		{http.MethodGet, "/audit/export", user.ResourceAudit, user.ActionExport, exportAuditLog},
+
+		{http.MethodGet, "/backup", user.ResourceBackup, user.ActionExport, backup},
+		{"", "/addons", user.ResourceAddons, user.ActionModify, addonsRestHandler},
+		{"", "/addon", user.ResourceAddons, user.ActionModify, addonRestHandler},
+		{http.MethodGet, "/config", user.ResourceConfig, user.ActionRead, getConfig},
+		{http.MethodPost, "/config", user.ResourceConfig, user.ActionModify, saveConfig},
+
+		{http.MethodGet, "/files", user.ResourceFiles, user.ActionRead, getMediaContents},
+		{http.MethodGet, "/file", public, "", getMedia},
+		{http.MethodDelete, "/file", user.ResourceFiles, user.ActionDelete, deleteMediaContent},
+		{http.MethodPost, "/file", user.ResourceFiles, user.ActionCreate, uploadMediaContent},
+		{http.MethodGet, "/files/search", user.ResourceFiles, user.ActionRead, searchMediaContent},
+
+		{http.MethodGet, "/contents", typeResource, user.ActionRead, getContents},
+		{http.MethodGet, "/contents/search", typeResource, user.ActionRead, searchContent},
+		{http.MethodGet, "/contents/export", typeResource, user.ActionExport, export},
+		{http.MethodGet, "/contents/ss", typeResource, user.ActionRead, searchContentEnhanced},
+		{http.MethodPost, "/content", typeResource, user.ActionCreate, createContent},
+		{http.MethodPost, "/content/update", typeResource, user.ActionModify, updateContent},
+		{http.MethodGet, "/content", typeResource, user.ActionRead, getContent},
+		{http.MethodPost, "/content/approve", typeResource, user.ActionApprove, approveContent},
+		{http.MethodPost, "/content/reject", typeResource, user.ActionApprove, rejectContent},
+		{http.MethodDelete, "/content", typeResource, user.ActionDelete, deleteContent},
+
+		{http.MethodGet, "/wallet", user.ResourceWallet, user.ActionRead, getWallet},
+		{http.MethodPost, "/wallet/credit", user.ResourceWallet, user.ActionModify, grantCredit},
+		{http.MethodGet, "/points", user.ResourcePoints, user.ActionRead, getPoints},
+		{http.MethodPost, "/points/adjust", user.ResourcePoints, user.ActionModify, adjustPoints},
+		{http.MethodGet, "/translations/missing", user.ResourceTranslations, user.ActionRead, getMissingTranslations},
+		{http.MethodGet, "/revisions", typeResource, user.ActionRead, getRevisions},
+		{http.MethodGet, "/revisions/diff", typeResource, user.ActionRead, getRevisionDiff},
+		{http.MethodPost, "/revisions/restore", typeResource, user.ActionModify, restoreRevision},
+		{http.MethodGet, "/scheduled", user.ResourceScheduled, user.ActionRead, getScheduled},
+		{http.MethodGet, "/previews", user.ResourcePreviews, user.ActionRead, getPreviews},
+		{http.MethodPost, "/previews", user.ResourcePreviews, user.ActionCreate, createPreview},
+		{http.MethodDelete, "/previews", user.ResourcePreviews, user.ActionDelete, revokePreview},
+	}
+}
+
+// handle registers rt on mux behind its permission
+func handle(mux *bone.Mux, rt route) {
+	h := permit(rt.resource, rt.action, rt.handler)
+	if len(rt.method) == 0 {
+		mux.HandleFunc(rt.path, h)
+		return
+	}
+	mux.Register(rt.method, rt.path, h)
+}
+
 // Docs adds the documentation file server to the server, accessible at
 // http://localhost:1234 by default
 func Docs(port int) {
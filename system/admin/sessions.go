@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// targetUser returns the user the request is about, ?email= or the admin
+// itself, false when the admin may not do action on them
+func targetUser(r *http.Request, action string) (string, bool) {
+	operator, err := currentUser(r)
+	if err != nil {
+		return "", false
+	}
+	email := strings.ToLower(r.URL.Query().Get("email"))
+	if len(email) == 0 || email == operator.Email {
+		return operator.Email, true
+	}
+	return email, operator.Can(user.ResourceUsers, action)
+}
+
+// getSessions lists the open sessions of a user, ?email=, the admin's own
+// without email
+func getSessions(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query sessions from %s", GetIP(r))
+	email, ok := targetUser(r, user.ActionRead)
+	if !ok {
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Permission Denied",
+		})
+		return
+	}
+	list, err := db.Sessions(email, user.Token(r))
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    list,
+	})
+}
+
+// revokeSessions ends session ?id= of a user, ?email=, or every session of the
+// user without id
+func revokeSessions(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	email, ok := targetUser(r, user.ActionModify)
+	if !ok {
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     "Permission Denied",
+		})
+		return
+	}
+
+	operator := currentAdminEmail(r)
+	id := r.URL.Query().Get("id")
+	n := 1
+	var err error
+	if len(id) > 0 {
+		err = db.RevokeSession(email, id)
+	} else {
+		n, err = db.RevokeSessions(email, "")
+	}
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s ended %d sessions of %s from %s", operator, n, email, ipaddr)
+
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    n,
+	})
+}
@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/i18n"
+)
+
+// getMissingTranslations reports the localized content fields which have no
+// translation, for ?lang= or else every served locale
+func getMissingTranslations(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query missing translations from %s", GetIP(r))
+	locale := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("lang")))
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data": map[string]interface{}{
+			"default": i18n.DefaultLocale,
+			"locales": i18n.Locales,
+			"missing": i18n.MissingOf(locale),
+		},
+	})
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/go-zoo/bone"
+)
+
+// passTwoFactor checks the second factor of a login whose password passed and
+// answers the request when it does not pass. An admin who has to enroll gets
+// the secret to scan. It returns the recovery codes of a confirmed
+// enrollment.
+func passTwoFactor(w http.ResponseWriter, r *http.Request, usr *user.User, body map[string]interface{}) ([]string, bool) {
+	code, _ := body["code"].(string)
+	recovery, err := db.PassTwoFactor(usr, code, user.DeviceToken(r, body))
+	switch err {
+	case nil:
+		return recovery, true
+	case db.ErrTwoFactorRequired:
+		renderJSON(w, r, ReturnData{
+			RetCode: 3,
+			Msg:     err.Error(),
+		})
+	case db.ErrTwoFactorEnroll:
+		secret, uri, err := db.BeginTwoFactor(usr.Email)
+		if err != nil {
+			logger.Error(err)
+			renderJSON(w, r, ReturnData{
+				RetCode: -6,
+				Msg:     "Internal Error",
+			})
+			return nil, false
+		}
+		renderJSON(w, r, map[string]interface{}{
+			"retCode": 4,
+			"msg":     db.ErrTwoFactorEnroll.Error(),
+			"data":    map[string]string{"secret": secret, "uri": uri},
+		})
+	default:
+		db.LoginFailed(usr.Email, GetIP(r))
+		logger.Warnf("Admin %s two-factor failed from %s: %s", usr.Email, GetIP(r), err)
+		renderJSON(w, r, ReturnData{
+			RetCode: -99,
+			Msg:     err.Error(),
+		})
+	}
+	return nil, false
+}
+
+// rememberDevice sets the cookie of a remembered device after a login with
+// a code and "remember": true, and returns its token
+func rememberDevice(w http.ResponseWriter, email string, body map[string]interface{}) string {
+	code, _ := body["code"].(string)
+	remember, _ := body["remember"].(bool)
+	if !remember || len(code) == 0 {
+		return ""
+	}
+	token, expires, err := db.RememberDevice(email)
+	if err != nil || len(token) == 0 {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     user.TwoFactorDevice,
+		Value:    token,
+		Expires:  expires,
+		Path:     "/",
+		HttpOnly: true,
+	})
+	return token
+}
+
+// twoFactor manages the second factor of the login admin, by the action of
+// the path:
+//
+//	enroll    returns a new TOTP secret and its provisioning URI
+//	confirm   enables it with {"code"}, returns the recovery codes
+//	recovery  returns new recovery codes for {"code"}
+//	disable   removes it with {"code"}
+//	reset     removes it from the user ?email= without a code
+func twoFactor(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	operator := currentAdminEmail(r)
+	action := bone.GetValue(r, "action")
+	body := getJsonFromBody(r)
+	code, _ := body["code"].(string)
+
+	var data interface{}
+	var err error
+	switch action {
+	case "enroll":
+		secret, uri, e := db.BeginTwoFactor(operator)
+		data, err = map[string]string{"secret": secret, "uri": uri}, e
+	case "confirm":
+		data, err = db.ConfirmTwoFactor(operator, code)
+	case "recovery":
+		data, err = db.RenewRecoveryCodes(operator, code)
+	case "disable":
+		if enforced, _ := db.ConfigCache("admin_2fa").(bool); enforced {
+			renderJSON(w, r, ReturnData{
+				RetCode: -99,
+				Msg:     "Two-factor is required for admin users",
+			})
+			return
+		}
+		err = db.DisableTwoFactor(operator, code)
+	case "reset":
+		email, ok := targetUser(r, user.ActionModify)
+		if !ok || email == operator {
+			renderJSON(w, r, ReturnData{
+				RetCode: -99,
+				Msg:     "Permission Denied",
+			})
+			return
+		}
+		err = db.ResetTwoFactor(email)
+		operator += " for " + email
+	default:
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Unknown action " + action,
+		})
+		return
+	}
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s two-factor %s from %s", operator, action, ipaddr)
+
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    data,
+	})
+}
@@ -5,7 +5,10 @@ package user
 import (
 	"bytes"
 	crand "crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	mrand "math/rand"
 	"net/http"
 
@@ -15,26 +18,61 @@ import (
 	"github.com/agreyfox/eshop/system/logs"
 	"github.com/nilslice/jwt"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // User defines a admin user in the system
 type User struct {
-	ID      int    `json:"id"`
-	Email   string `json:"email"`
-	Hash    string `json:"hash"`
-	Salt    string
-	Locale  string `json:"locale"`
-	Perm    Permissions
-	IsAdmin bool   `json:"isAdmin"`
-	Phone   string `json:phone,omitempty`
-	Social  string `json:"social,omitempty"`
-	Meta    string `json:metadata,omitempty`
+	ID        int    `json:"id"`
+	Email     string `json:"email"`
+	Hash      string `json:"hash"`
+	Salt      string
+	Locale    string `json:"locale"`
+	Perm      Permissions
+	IsAdmin   bool       `json:"isAdmin"`
+	Role      string     `json:"role,omitempty"` // role of an admin user, see Roles
+	TwoFactor *TwoFactor `json:"two_factor,omitempty"`
+	Verified  bool       `json:"verified"` // the customer opened the email verification link
+	Grants    []Grant    `json:"-"`        // grants of an API key, which replace the role
+	Phone     string     `json:phone,omitempty`
+	Social    string     `json:"social,omitempty"`
+	Meta      string     `json:metadata,omitempty`
+
+	// HashVersion tells how Hash was made, HashBcrypt or HashArgon2id
+	HashVersion int `json:"hash_version,omitempty"`
 }
 
 const (
 	// use for cookie name
 	Lqcmstoken string = "lqcms_token"
+	// cookie of a device remembered to skip the two-factor code
+	TwoFactorDevice string = "lqcms_2fa_device"
+)
+
+// Versions of the password hash of a user
+const (
+	// HashBcrypt is bcrypt over the salt and password, of the users made
+	// before HashArgon2id
+	HashBcrypt = 0
+	// HashArgon2id is Argon2id, the older hashes are replaced on login
+	HashArgon2id = 1
+)
+
+// Argon2id parameters of the new hashes, a hash keeps the ones it was made with
+var (
+	Argon2Time    uint32 = 3
+	Argon2Memory  uint32 = 64 * 1024 // KiB
+	Argon2Threads uint8  = 2
+)
+
+const argon2KeyLen = 32
+
+var (
+	// ErrPasswordHash is returned for a stored hash which can not be read
+	ErrPasswordHash = errors.New("Invalid password hash")
+	// ErrPasswordMismatch is returned for a password which does not match
+	ErrPasswordMismatch = errors.New("Password does not match")
 )
 
 var (
@@ -61,6 +99,8 @@ func New(email, password string) (*User, error) {
 		Salt:    base64.StdEncoding.EncodeToString(salt),
 		Perm:    CustomerPermission,
 		IsAdmin: false, //add 2021/4/13
+
+		HashVersion: HashArgon2id,
 	}
 	return user, nil
 }
@@ -84,6 +124,8 @@ func NewCustomer(email, password string) (*User, error) {
 		Perm:   CustomerPermission,
 		Social: "",
 		Meta:   "",
+
+		HashVersion: HashArgon2id,
 	}
 
 	return user, nil
@@ -109,6 +151,8 @@ func NewCustomerWithSocial(email, password, social, value string) (*User, error)
 		IsAdmin: false,
 		Meta:    social,
 		Social:  value, // 保存用户的social内容
+
+		HashVersion: HashArgon2id,
 	}
 
 	return user, nil
@@ -141,48 +185,50 @@ func CustomerAuth(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
-// IsValid checks if the user request is authenticated
-func IsValid(req *http.Request) bool {
-	var token string
-	tt := req.Header.Get(Lqcmstoken)
-	if len(tt) > 0 {
+// SessionCheck tells if the session of a token which passed its signature
+// check is still open. The db package sets it to its session registry.
+var SessionCheck = func(token string) bool { return true }
+
+// ValidToken checks the signature and session of a token
+func ValidToken(token string) bool {
+	return len(token) > 0 && jwt.Passes(token) && SessionCheck(token)
+}
+
+// Token returns the token of the request, from the token header, the cookie
+// or an Authorization Bearer header in that order
+func Token(req *http.Request) string {
+	if tt := req.Header.Get(Lqcmstoken); len(tt) > 0 {
 		logger.Debug("get token from header:", tt)
-		token = tt
-		return jwt.Passes(token)
+		return tt
 	}
 
 	cookie, err := req.Cookie(Lqcmstoken)
-	if err != nil {
-		logger.Error("Cookie Token is not found!")
-		logger.Debug(req)
-	} else {
-		logger.Debug("get token from cookei:", tt)
-		token = cookie.Value
-	}
-	if len(token) == 0 {
-		reqToken := req.Header.Get("Authorization")
-		if len(reqToken) == 0 {
-			logger.Error("Authorization Token is not found!")
-			logger.Debug(req)
-			return false
-		} else {
-			splitToken := strings.Split(reqToken, "Bearer ")
-			if len(splitToken) >= 1 {
-				token = splitToken[1]
-			} else {
-				logger.Error("Authorization Token is not present!")
-				logger.Debug(req)
-				return false
-			}
-		}
+	if err == nil && len(cookie.Value) > 0 {
+		return cookie.Value
 	}
-	// check if token exists in cookie
+	logger.Debug("Cookie Token is not found!")
 
-	// validate it and allow or redirect request
-	if len(token) > 0 {
-		return jwt.Passes(token)
+	reqToken := req.Header.Get("Authorization")
+	if !strings.HasPrefix(reqToken, "Bearer ") {
+		logger.Debug("Authorization Token is not present!")
+		return ""
+	}
+	return strings.TrimPrefix(reqToken, "Bearer ")
+}
+
+// APIKeyToken returns the key of an Authorization ApiKey header of the
+// request, empty when there is none
+func APIKeyToken(req *http.Request) string {
+	h := req.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "ApiKey ") {
+		return ""
 	}
-	return false
+	return strings.TrimSpace(strings.TrimPrefix(h, "ApiKey "))
+}
+
+// IsValid checks if the user request is authenticated
+func IsValid(req *http.Request) bool {
+	return ValidToken(Token(req))
 }
 
 // IsValid checks if the user request is authenticated
@@ -194,7 +240,7 @@ func IsValidAdmin(req *http.Request) bool {
 	logger.Debug("user cookie:", cookie)
 	// validate it and allow or redirect request
 	token := cookie.Value
-	if jwt.Passes(token) {
+	if ValidToken(token) {
 		clienInfo := jwt.GetClaims(token)
 
 		username := clienInfo["user"].(string)
@@ -208,12 +254,7 @@ func IsValidAdmin(req *http.Request) bool {
 
 // IsUser checks for consistency in email/pass combination
 func IsUser(usr *User, password string) bool {
-	salt, err := base64.StdEncoding.DecodeString(usr.Salt)
-	if err != nil {
-		return false
-	}
-
-	err = checkPassword([]byte(usr.Hash), []byte(password), salt)
+	err := checkPassword(usr, []byte(password))
 	if err != nil {
 		logger.Error("Error checking password:", err)
 		return false
@@ -224,12 +265,7 @@ func IsUser(usr *User, password string) bool {
 
 // IsUser checks for consistency in email/pass combination
 func IsAdminUser(usr *User, password string) bool {
-	salt, err := base64.StdEncoding.DecodeString(usr.Salt)
-	if err != nil {
-		return false
-	}
-
-	err = checkPassword([]byte(usr.Hash), []byte(password), salt)
+	err := checkPassword(usr, []byte(password))
 	if err != nil {
 		logger.Error("Error checking password:", err)
 		return false
@@ -268,29 +304,88 @@ func saltPassword(password, salt []byte) ([]byte, error) {
 	return salted.Bytes(), nil
 }
 
-// hashPassword encrypts the salted password using bcrypt
+// hashPassword hashes the password with Argon2id, the hash keeps the salt
+// and the parameters it was made with
 func hashPassword(password, salt []byte) ([]byte, error) {
+	if len(salt) == 0 {
+		return nil, ErrPasswordHash
+	}
+	key := argon2.IDKey(password, salt, Argon2Time, Argon2Memory, Argon2Threads, argon2KeyLen)
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		Argon2Memory, Argon2Time, Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+// checkPassword compares the hash of usr with the password. A nil return
+// means the password is correct, but an error could mean either the password
+// is not correct, or the hash or salt is broken - indicated in logs
+func checkPassword(usr *User, password []byte) error {
+	if usr.HashVersion == HashArgon2id {
+		return checkArgon2id(usr.Hash, password)
+	}
+
+	// the salted bcrypt hashes of the users before HashArgon2id
+	salt, err := base64.StdEncoding.DecodeString(usr.Salt)
+	if err != nil {
+		return err
+	}
 	salted, err := saltPassword(password, salt)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	hash, err := bcrypt.GenerateFromPassword(salted, 10)
+	return bcrypt.CompareHashAndPassword([]byte(usr.Hash), salted)
+}
+
+// checkArgon2id compares an Argon2id hash made by hashPassword with the password
+func checkArgon2id(hash string, password []byte) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return ErrPasswordHash
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return ErrPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return ErrPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
 	if err != nil {
-		return nil, err
+		return ErrPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return ErrPasswordHash
 	}
 
-	return hash, nil
+	other := argon2.IDKey(password, salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
 }
 
-// checkPassword compares the hash with the salted password. A nil return means
-// the password is correct, but an error could mean either the password is not
-// correct, or the salt process failed - indicated in logs
-func checkPassword(hash, password, salt []byte) error {
-	salted, err := saltPassword(password, salt)
+// SetPassword gives usr a new salt and the Argon2id hash of password
+func SetPassword(usr *User, password string) error {
+	salt, err := randSalt()
+	if err != nil {
+		return err
+	}
+	hash, err := hashPassword([]byte(password), salt)
 	if err != nil {
 		return err
 	}
+	usr.Hash = string(hash)
+	usr.Salt = base64.StdEncoding.EncodeToString(salt)
+	usr.HashVersion = HashArgon2id
+	return nil
+}
 
-	return bcrypt.CompareHashAndPassword(hash, salted)
+// NeedsRehash tells if the password hash of the user is of an older version,
+// to be replaced the next time the password is checked
+func (u *User) NeedsRehash() bool {
+	return u.HashVersion < HashArgon2id && len(u.Hash) > 0
 }
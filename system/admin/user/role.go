@@ -0,0 +1,236 @@
+package user
+
+import (
+	"errors"
+	"strings"
+)
+
+// Roles of the admin users. A role grants actions on resources, a resource is
+// a content type name or one of the admin areas below.
+const (
+	RoleOwner       = "owner"
+	RoleEditor      = "editor"
+	RoleSupport     = "support"
+	RoleFulfillment = "fulfillment"
+	RoleFinance     = "finance"
+	// RoleAPIKey is the role of a request made with an API key, which can
+	// not be given to a user
+	RoleAPIKey = "apikey"
+)
+
+// Actions on a resource
+const (
+	ActionRead    = "read"
+	ActionCreate  = "create"
+	ActionModify  = "modify"
+	ActionDelete  = "delete"
+	ActionApprove = "approve" // approve or reject pending content
+	ActionExport  = "export"  // export and backup
+)
+
+// Admin areas which are not content types
+const (
+	ResourceUsers        = "users"
+	ResourceConfig       = "config"
+	ResourceAddons       = "addons"
+	ResourceBackup       = "backup"
+	ResourceFiles        = "files"
+	ResourceWallet       = "wallet"
+	ResourcePoints       = "points"
+	ResourcePayouts      = "payouts" // money sent out, the affiliate payout
+	ResourceTranslations = "translations"
+	ResourceScheduled    = "scheduled"
+	ResourcePreviews     = "previews"
+	ResourceAPIKeys      = "apikeys"
+	ResourceAudit        = "audit"
+)
+
+// Actions are the actions a grant can list besides "*"
+var Actions = []string{ActionRead, ActionCreate, ActionModify, ActionDelete, ActionApprove, ActionExport}
+
+// ErrGrant is returned for a grant without resources or with an unknown action
+var ErrGrant = errors.New("Invalid grant, use resource[,resource]:action[,action]")
+
+// Grant allows the actions on the resources, "*" matches any
+type Grant struct {
+	Resources []string `json:"resources"`
+	Actions   []string `json:"actions"`
+}
+
+var (
+	catalog = []string{"Carousel", "Category", "Country", "Currency", "DirectGame", "Discount",
+		"Email", "Game", "News", "Product", "Review", "Server", "Site"}
+	orders = []string{"Order", "Carts", "Orderstatus"}
+	read   = []string{ActionRead}
+
+	// Roles are the grants of each role
+	Roles = map[string][]Grant{
+		RoleOwner: {
+			{Resources: []string{"*"}, Actions: []string{"*"}},
+		},
+		RoleEditor: {
+			{Resources: catalog, Actions: []string{ActionRead, ActionCreate, ActionModify, ActionDelete, ActionApprove}},
+			{Resources: []string{ResourceFiles}, Actions: []string{ActionRead, ActionCreate, ActionDelete}},
+			{Resources: []string{ResourcePreviews}, Actions: []string{ActionRead, ActionCreate, ActionDelete}},
+			{Resources: []string{ResourceTranslations, ResourceScheduled}, Actions: read},
+		},
+		RoleSupport: {
+			{Resources: orders, Actions: []string{ActionRead, ActionModify}},
+			{Resources: []string{"Review"}, Actions: []string{ActionRead, ActionModify, ActionApprove}},
+			{Resources: []string{ResourceWallet, ResourcePoints}, Actions: []string{ActionRead, ActionModify}},
+			{Resources: append([]string{ResourceUsers, ResourceFiles, "Coupon", "GiftCard"}, catalog...), Actions: read},
+		},
+		RoleFulfillment: {
+			{Resources: []string{"Order"}, Actions: []string{ActionRead, ActionModify}},
+			{Resources: []string{"Orderstatus", "Game", "Product", "Category", "Server", ResourceFiles}, Actions: read},
+		},
+		RoleFinance: {
+			{Resources: []string{"Order", "Coupon", "GiftCard"}, Actions: []string{ActionRead, ActionModify, ActionExport}},
+			{Resources: []string{"Coupon", "GiftCard"}, Actions: []string{ActionCreate}},
+			{Resources: []string{ResourceWallet, ResourcePoints}, Actions: []string{ActionRead, ActionModify}},
+			{Resources: []string{ResourcePayouts}, Actions: []string{ActionCreate}},
+			{Resources: []string{"Carts", "PaymentSetting", "Paymentbutton", "Currency"}, Actions: []string{ActionRead, ActionExport}},
+		},
+	}
+)
+
+// ValidRole tells if role is one of Roles
+func ValidRole(role string) bool {
+	_, ok := Roles[role]
+	return ok
+}
+
+// CanGrant tells if the user may give role to another user, which needs the
+// user to be allowed all the role grants
+func (u *User) CanGrant(role string) bool {
+	grants, ok := Roles[role]
+	if !ok {
+		return false
+	}
+	for _, g := range grants {
+		for _, r := range g.Resources {
+			for _, a := range g.Actions {
+				if !u.Can(r, a) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// ParseGrant parses a grant written as resource[,resource]:action[,action],
+// like Order:read,modify
+func ParseGrant(s string) (Grant, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return Grant{}, ErrGrant
+	}
+	g := Grant{Resources: splitList(parts[0]), Actions: splitList(parts[1])}
+	return g, g.Validate()
+}
+
+// Validate checks the grant has resources and known actions
+func (g Grant) Validate() error {
+	if len(g.Resources) == 0 || len(g.Actions) == 0 {
+		return ErrGrant
+	}
+	for _, a := range g.Actions {
+		if a != "*" && !matchAny(Actions, a) {
+			return ErrGrant
+		}
+	}
+	return nil
+}
+
+// String writes the grant the way ParseGrant reads it
+func (g Grant) String() string {
+	return strings.Join(g.Resources, ",") + ":" + strings.Join(g.Actions, ",")
+}
+
+// splitList returns the non empty comma separated values of s
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// StaffRole returns the role of an admin user, empty for a customer. Admins
+// saved before roles existed are owners.
+func (u *User) StaffRole() string {
+	if u.Grants != nil {
+		return RoleAPIKey
+	}
+	if !u.IsAdmin || !u.Perm.Admin {
+		return ""
+	}
+	if len(u.Role) == 0 {
+		return RoleOwner
+	}
+	if !ValidRole(u.Role) {
+		return ""
+	}
+	return u.Role
+}
+
+// Can tells if the user may do action on resource. The role must grant it and
+// for a user with a role the create, modify, delete and export actions also
+// need the matching Permissions. Admins saved before roles existed kept the
+// customer Permissions, so they are not checked for them. An API key may do
+// what its grants allow.
+func (u *User) Can(resource, action string) bool {
+	if u.Grants != nil {
+		return grantsCan(u.Grants, resource, action)
+	}
+	if len(u.Role) == 0 {
+		return RoleCan(u.StaffRole(), resource, action)
+	}
+	switch action {
+	case ActionCreate:
+		if !u.Perm.Create {
+			return false
+		}
+	case ActionModify, ActionApprove:
+		if !u.Perm.Modify {
+			return false
+		}
+	case ActionDelete:
+		if !u.Perm.Delete {
+			return false
+		}
+	case ActionExport:
+		if !u.Perm.Download {
+			return false
+		}
+	}
+	return RoleCan(u.StaffRole(), resource, action)
+}
+
+// RoleCan tells if role grants action on resource
+func RoleCan(role, resource, action string) bool {
+	return grantsCan(Roles[role], resource, action)
+}
+
+// grantsCan tells if one of grants allows action on resource
+func grantsCan(grants []Grant, resource, action string) bool {
+	for _, g := range grants {
+		if matchAny(g.Resources, resource) && matchAny(g.Actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny tells if v is in list or the list has "*"
+func matchAny(list []string, v string) bool {
+	for _, s := range list {
+		if s == "*" || s == v {
+			return true
+		}
+	}
+	return false
+}
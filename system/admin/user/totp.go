@@ -0,0 +1,115 @@
+package user
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP settings of RFC 6238, the ones authenticator apps use by default
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 // seconds
+	TOTPSkew   = 1  // steps accepted before and after the current one
+)
+
+// TwoFactor is the second login factor of a user
+type TwoFactor struct {
+	Secret   string   `json:"secret,omitempty"` // encrypted TOTP secret
+	Enabled  bool     `json:"enabled"`          // false while enrolling
+	LastStep int64    `json:"last_step,omitempty"`
+	Recovery []string `json:"recovery,omitempty"` // hashes of unused recovery codes
+	Devices  []Device `json:"devices,omitempty"`
+}
+
+// Device is a browser remembered to pass the second factor
+type Device struct {
+	Hash    string `json:"hash"` // hash of the device token
+	Expires int64  `json:"expires"`
+}
+
+// DeviceToken returns the remembered device token of a login request, given
+// as device in the body or by the cookie
+func DeviceToken(req *http.Request, body map[string]interface{}) string {
+	if device, ok := body["device"].(string); ok && len(device) > 0 {
+		return device
+	}
+	if cookie, err := req.Cookie(TwoFactorDevice); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 TOTP secret of 160 bits
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// TOTPStep returns the time step of t
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// TOTPCode returns the code of secret at time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, code%1000000), nil
+}
+
+// CheckTOTP returns the time step code matches for secret around t, or 0
+// when it does not match
+func CheckTOTP(secret, code string, t time.Time) int64 {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0
+	}
+	now := TOTPStep(t)
+	for step := now - TOTPSkew; step <= now+TOTPSkew; step++ {
+		c, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0
+		}
+		if hmac.Equal([]byte(c), []byte(code)) {
+			return step
+		}
+	}
+	return 0
+}
+
+// ProvisioningURI returns the otpauth URI of secret, shown as a QR code for
+// authenticator apps to scan
+func ProvisioningURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(TOTPPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
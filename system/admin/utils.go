@@ -143,12 +143,10 @@ func returnStructData(w http.ResponseWriter, r *http.Request, data []map[string]
 	return 0, nil
 }
 
+// GetIP returns the client address of r, forwarded addresses are taken from
+// trusted proxies only
 func GetIP(r *http.Request) string {
-	forwarded := r.Header.Get("X-FORWARDED-FOR")
-	if forwarded != "" {
-		return forwarded
-	}
-	return r.RemoteAddr
+	return db.ClientIP(r)
 }
 
 // print pretty map[string]internface output
@@ -195,11 +193,21 @@ func formatData(data map[string]interface{}) url.Values {
 	for key, value := range data {
 
 		onedata, o := value.([]string)
-		if !o {
-			retdata[key] = []string{fmt.Sprint(value)}
-		} else {
+		if o {
 			retdata[key] = onedata
+			continue
 		}
+		// a list of objects, e.g. price tiers, is kept as json text for the
+		// field to decode
+		if list, ok := value.([]interface{}); ok && len(list) > 0 {
+			if _, ok := list[0].(map[string]interface{}); ok {
+				if j, err := json.Marshal(list); err == nil {
+					retdata[key] = []string{string(j)}
+					continue
+				}
+			}
+		}
+		retdata[key] = []string{fmt.Sprint(value)}
 	}
 
 	return retdata
@@ -285,6 +293,9 @@ func GetUsers(stxt string) ([]user.User, error) {
 		}
 		aUser.Perm = user.Permissions{}
 		aUser.Salt = ""
+		if aUser.TwoFactor != nil {
+			aUser.TwoFactor = &user.TwoFactor{Enabled: aUser.TwoFactor.Enabled}
+		}
 		retValue = append(retValue, aUser)
 	}
 	return retValue, nil
@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// currentAdminEmail returns the email of the admin sending the request
+func currentAdminEmail(r *http.Request) string {
+	buf, err := db.CurrentUser(r)
+	if err != nil {
+		return ""
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+	return usr.Email
+}
+
+// getWallet returns the wallet and ledger of a customer, email is given by ?email=
+func getWallet(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Admin query wallet from %s", GetIP(r))
+	email := strings.ToLower(r.URL.Query().Get("email"))
+	if !isValidateEmail(email) {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong email",
+		})
+		return
+	}
+	wallet, err := db.WalletOf(email)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	ledger, err := db.WalletLedger(email)
+	if err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data": map[string]interface{}{
+			"wallet": wallet,
+			"ledger": ledger,
+		},
+	})
+}
+
+// grantCredit adds store credit to a customer wallet, body is
+// {"email":"","amount":10.5,"comment":""}
+func grantCredit(w http.ResponseWriter, r *http.Request) {
+	ipaddr := GetIP(r)
+	logger.Debugf("Admin grant wallet credit from %s", ipaddr)
+	reqJSON := getJsonFromBody(r)
+	if reqJSON == nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "No Input Data",
+		})
+		return
+	}
+	email := strings.ToLower(fmt.Sprint(reqJSON["email"]))
+	if _, err := db.User(email); err != nil {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "User not exists",
+		})
+		return
+	}
+	amount, err := strconv.ParseFloat(fmt.Sprint(reqJSON["amount"]), 64)
+	if err != nil || amount <= 0 {
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     "Wrong amount",
+		})
+		return
+	}
+	comment := ""
+	if c, ok := reqJSON["comment"]; ok {
+		comment = fmt.Sprint(c)
+	}
+
+	operator := currentAdminEmail(r)
+	entry, err := db.CreditWallet(email, amount, "", operator, comment)
+	if err != nil {
+		logger.Errorf("Admin %s grant credit to %s error: %s", operator, email, err)
+		renderJSON(w, r, ReturnData{
+			RetCode: -1,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	logger.Infof("Admin %s grant %.2f credit to %s from %s", operator, entry.Amount, email, ipaddr)
+
+	renderJSON(w, r, map[string]interface{}{
+		"retCode": 0,
+		"msg":     "Done",
+		"data":    entry,
+	})
+}
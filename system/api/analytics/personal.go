@@ -0,0 +1,78 @@
+package analytics
+
+import (
+	"encoding/json"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/boltdb/bolt"
+)
+
+func init() {
+	db.AddPersonalData("analytics", db.PersonalData{
+		Export: exportRequests,
+		Erase:  eraseRequests,
+	})
+}
+
+// requestsOf runs fn on the recorded requests from the addresses of k
+func requestsOf(tx *bolt.Tx, k db.PersonalKeys, fn func(key []byte, r apiRequest) error) error {
+	b := tx.Bucket([]byte("__requests"))
+	if b == nil || len(k.IPs) == 0 {
+		return nil
+	}
+	ips := map[string]bool{}
+	for _, ip := range k.IPs {
+		ips[ip] = true
+	}
+	return b.ForEach(func(key, v []byte) error {
+		r := apiRequest{}
+		if json.Unmarshal(v, &r) != nil || !ips[r.RemoteAddr] {
+			return nil
+		}
+		return fn(key, r)
+	})
+}
+
+// exportRequests returns the api requests recorded from the addresses of the
+// customer
+func exportRequests(k db.PersonalKeys) (interface{}, error) {
+	list := []apiRequest{}
+	if store == nil {
+		return list, nil
+	}
+	err := store.View(func(tx *bolt.Tx) error {
+		return requestsOf(tx, k, func(_ []byte, r apiRequest) error {
+			list = append(list, r)
+			return nil
+		})
+	})
+	return list, err
+}
+
+// eraseRequests removes the api requests recorded from the addresses of the
+// customer, the daily metrics keep only counts
+func eraseRequests(k db.PersonalKeys) (int, error) {
+	if store == nil {
+		return 0, nil
+	}
+	n := 0
+	err := store.Update(func(tx *bolt.Tx) error {
+		keys := [][]byte{}
+		err := requestsOf(tx, k, func(key []byte, _ apiRequest) error {
+			keys = append(keys, append([]byte{}, key...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket([]byte("__requests"))
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/catalog"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/i18n"
+	"github.com/go-zoo/bone"
+)
+
+// Catalog returns the catalog tree of a game by slug in one call. The tree
+// carries an ETag which changes with any of its members, so the storefront
+// can revalidate it with If-None-Match.
+func Catalog(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "游戏目录").Add(1)
+
+	slug := strings.TrimSpace(bone.GetValue(req, "slug"))
+	if len(req.URL.Query().Get("preview")) > 0 {
+		catalogPreview(res, req, slug)
+		return
+	}
+	t, err := catalog.Get(slug, i18n.Pick(req))
+	if err == catalog.ErrGameNotFound {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "Game not found",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("Build catalog of %s error: %s", slug, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("ETag", t.ETag)
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Add("Vary", "Accept-Language")
+	if match := req.Header.Get("If-None-Match"); match != "" && strings.Contains(match, t.ETag) {
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    json.RawMessage(t.Body),
+	})
+}
+
+// catalogPreview returns the tree of a game with the pending item of the
+// ?preview= token in it. Previews are never cached.
+func catalogPreview(res http.ResponseWriter, req *http.Request, slug string) {
+	ns, id, err := previewOf(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     err.Error(),
+		})
+		return
+	}
+	data, err := db.Content(ns + "__pending:" + id)
+	if err != nil || len(data) == 0 {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "Preview content not found",
+		})
+		return
+	}
+	pid, _ := strconv.Atoi(id)
+	t, err := catalog.Preview(slug, i18n.Pick(req), &catalog.Draft{Type: ns, ID: pid, Data: data})
+	if err == catalog.ErrGameNotFound {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "Game not found",
+		})
+		return
+	}
+	if err != nil {
+		logger.Errorf("Build catalog preview of %s error: %s", slug, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Cache-Control", "no-store")
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    json.RawMessage(t.Body),
+	})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/oidc"
+	"github.com/go-zoo/bone"
+)
+
+// LoginProviders lists the social login providers for the login buttons
+func LoginProviders(res http.ResponseWriter, req *http.Request) {
+	list := []map[string]string{}
+	for _, p := range db.LoginProviders() {
+		list = append(list, map[string]string{
+			"name":  p.Name,
+			"title": p.Title,
+			"login": "/api/v1/oidc/" + p.Name + "/login",
+		})
+	}
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    list,
+	})
+}
+
+// OIDCLogin sends the customer to the login page of provider :provider.
+// After the login the customer is sent to ?redirect=, a path or a url of this
+// site, with the token as #token=, or given the login answer without it.
+func OIDCLogin(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "第三方登录").Add(1)
+
+	p, err := db.LoginProvider(bone.GetValue(req, "provider"))
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	redirect := req.URL.Query().Get("redirect")
+	if !safeRedirect(req, redirect) {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "Redirect should be a page of this site"})
+		return
+	}
+
+	r, err := oidc.NewRequest()
+	if err != nil {
+		logger.Error(err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "internal error"})
+		return
+	}
+	p.RedirectURL = callbackURL(req, p)
+	to, err := p.AuthURL(r)
+	if err != nil {
+		logger.Errorf("Social login %s error: %s", p.Name, err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "Login provider not available"})
+		return
+	}
+	err = db.SaveLoginRequest(&db.LoginRequest{Request: *r, Provider: p.Name, Redirect: redirect})
+	if err != nil {
+		logger.Error(err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "internal error"})
+		return
+	}
+	http.Redirect(res, req, to, http.StatusFound)
+}
+
+// OIDCCallback finishes the login the provider :provider sent the customer
+// back from, and opens the session as Login does
+func OIDCCallback(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	name := bone.GetValue(req, "provider")
+	q := req.URL.Query()
+
+	r, err := db.TakeLoginRequest(q.Get("state"))
+	if err != nil || r.Provider != name {
+		logger.Warnf("Social login %s callback without request from %s", name, ipAddr)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     db.ErrLoginRequest.Error()})
+		return
+	}
+	fail := func(msg string) {
+		if len(r.Redirect) > 0 {
+			http.Redirect(res, req, r.Redirect+"#error="+url.QueryEscape(msg), http.StatusFound)
+			return
+		}
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     msg})
+	}
+	if e := q.Get("error"); len(e) > 0 {
+		fail(e)
+		return
+	}
+	if wait, err := db.CheckLogin("", ipAddr); err != nil {
+		logger.Warnf("Social login from %s refused, wait %s", ipAddr, wait)
+		fail(err.Error())
+		return
+	}
+
+	p, err := db.LoginProvider(name)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	p.RedirectURL = callbackURL(req, p)
+	claims, err := p.Exchange(q.Get("code"), &r.Request)
+	if err != nil {
+		logger.Warnf("Social login %s from %s failed: %s", name, ipAddr, err)
+		db.LoginFailed("", ipAddr)
+		fail("Login failed")
+		return
+	}
+	usr, err := db.SocialUser(name, claims)
+	if err != nil {
+		logger.Warnf("Social login %s of %s from %s refused: %s", name, claims.Email, ipAddr, err)
+		fail(err.Error())
+		return
+	}
+
+	token, countryInfor, err := openLogin(res, req, usr)
+	if err != nil {
+		logger.Debug(err)
+		fail("internal error")
+		return
+	}
+	logger.Debugf("User %s logged in by %s !", usr.Email, name)
+	if len(r.Redirect) > 0 {
+		http.Redirect(res, req, r.Redirect+"#token="+token, http.StatusFound)
+		return
+	}
+	RenderJSON(res, req, loginResult(usr, token, countryInfor))
+}
+
+// callbackURL returns the redirect url of provider p, the callback of this
+// site when it is not set
+func callbackURL(req *http.Request, p *oidc.Provider) string {
+	if len(p.RedirectURL) > 0 {
+		return p.RedirectURL
+	}
+	return siteURL(req) + "/api/v1/oidc/" + p.Name + "/callback"
+}
+
+// siteURL returns the scheme and host the request was sent to
+func siteURL(req *http.Request) string {
+	scheme := "https"
+	if req.TLS == nil && req.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + req.Host
+}
+
+// safeRedirect tells if redirect is empty, a path or a url of this site
+func safeRedirect(req *http.Request, redirect string) bool {
+	if len(redirect) == 0 {
+		return true
+	}
+	u, err := url.Parse(redirect)
+	if err != nil || len(u.Fragment) > 0 || strings.Contains(redirect, "\\") {
+		return false
+	}
+	if len(u.Scheme) == 0 && len(u.Host) == 0 {
+		return strings.HasPrefix(redirect, "/") && !strings.HasPrefix(redirect, "//")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	domain, _ := db.ConfigCache("domain").(string)
+	host := u.Hostname()
+	return u.Host == req.Host || (len(domain) > 0 && (host == domain || strings.HasSuffix(host, "."+domain)))
+}
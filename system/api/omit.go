@@ -1,9 +1,14 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/i18n"
 	"github.com/agreyfox/eshop/system/item"
 
 	"github.com/tidwall/gjson"
@@ -11,6 +16,19 @@ import (
 )
 
 func omit(res http.ResponseWriter, req *http.Request, it interface{}, data []byte) ([]byte, error) {
+	data, err := dropUnpublished(data)
+	if err != nil {
+		return nil, err
+	}
+	data, err = localize(res, req, it, data)
+	if err != nil {
+		return nil, err
+	}
+	data, err = rate(it, data)
+	if err != nil {
+		return nil, err
+	}
+
 	// is it Omittable
 	om, ok := it.(item.Omittable)
 	if !ok {
@@ -20,6 +38,64 @@ func omit(res http.ResponseWriter, req *http.Request, it interface{}, data []byt
 	return omitFields(res, req, om, data, "data")
 }
 
+// dropUnpublished removes the content the publish scheduler has marked
+// unpublished from the top-level "data" array
+func dropUnpublished(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(`"unpublished":true`)) {
+		return data, nil
+	}
+	resp := map[string][]map[string]interface{}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	list := []map[string]interface{}{}
+	for _, m := range resp["data"] {
+		if hidden, _ := m["unpublished"].(bool); !hidden {
+			list = append(list, m)
+		}
+	}
+	return fmtMAP(list...)
+}
+
+// localize sets the localized fields of the content in the top-level "data"
+// array to the request locale
+func localize(res http.ResponseWriter, req *http.Request, it interface{}, data []byte) ([]byte, error) {
+	l, ok := it.(item.Localizable)
+	if !ok {
+		return data, nil
+	}
+	res.Header().Add("Vary", "Accept-Language")
+
+	resp := map[string][]map[string]interface{}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	locale := i18n.Pick(req)
+	for _, m := range resp["data"] {
+		i18n.Apply(m, l.Localized(), locale)
+	}
+	return fmtMAP(resp["data"]...)
+}
+
+// rate adds the rating of the public reviews to the Reviewable content in the
+// top-level "data" array
+func rate(it interface{}, data []byte) ([]byte, error) {
+	if _, ok := it.(item.Reviewable); !ok {
+		return data, nil
+	}
+	ns := reflect.Indirect(reflect.ValueOf(it)).Type().Name()
+
+	resp := map[string][]map[string]interface{}{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	for _, m := range resp["data"] {
+		id, _ := m["id"].(float64)
+		m["rating"] = db.RatingOf(ns, int(id))
+	}
+	return fmtMAP(resp["data"]...)
+}
+
 // omit some field in map way
 func omitUserFields(res http.ResponseWriter, req *http.Request, it interface{}, data []map[string]interface{}) ([]map[string]interface{}, error) {
 	// is it Omittable
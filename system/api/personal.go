@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// ExportData sends everything kept of the login customer as json, or as a zip
+// archive with ?format=zip
+func ExportData(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "导出个人数据").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	data, err := db.ExportPersonalData(usr.Email)
+	if err != nil {
+		logger.Error(err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "internal error"})
+		return
+	}
+	logger.Infof("Personal data of %s exported from %s", usr.Email, ipAddr)
+
+	if req.URL.Query().Get("format") == "zip" {
+		res.Header().Set("Content-Type", "application/zip")
+		res.Header().Set("Content-Disposition", `attachment; filename="personal-data.zip"`)
+		if err := db.WritePersonalZip(res, data); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Content-Disposition", `attachment; filename="personal-data.json"`)
+	enc := json.NewEncoder(res)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		logger.Error(err)
+	}
+}
+
+// DeleteAccount erases the login customer after checking the password, request
+// like {"password":"..."}. The orders are kept anonymized.
+func DeleteAccount(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "删除账号").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	if wait, err := db.CheckLogin(usr.Email, ipAddr); err != nil {
+		logger.Warnf("Account deletion of %s from %s refused, wait %s", usr.Email, ipAddr, wait)
+		RenderJSON(res, req, RetUser{
+			RetCode: -8,
+			Msg:     err.Error()})
+		return
+	}
+	password, _ := GetJsonFromBody(req)["password"].(string)
+	if !user.IsUser(&usr, password) {
+		db.LoginFailed(usr.Email, ipAddr)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "Wrong password"})
+		return
+	}
+
+	e, err := db.ErasePersonalData(usr.Email, usr.Email, ipAddr)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:    user.Lqcmstoken,
+		Expires: time.Unix(0, 0),
+		Value:   "",
+		Path:    "/",
+	})
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    e.ID,
+	})
+}
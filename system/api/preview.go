@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// previewOf returns the content type and pending id the ?preview= token of
+// the request shows, both empty when the request has no token
+func previewOf(req *http.Request) (string, string, error) {
+	token := strings.TrimSpace(req.URL.Query().Get("preview"))
+	if len(token) == 0 {
+		return "", "", nil
+	}
+	target, err := db.PreviewTarget(token)
+	if err != nil {
+		return "", "", err
+	}
+	t := strings.Split(target, ":")
+	return strings.TrimSuffix(t[0], "__pending"), t[1], nil
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/promotion"
+)
+
+// Promotions returns the flash sales, hot items and carousels running now
+// with the seconds left, and the scheduled ones with the seconds to start
+func Promotions(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "促销活动").Add(1)
+
+	now := time.Now()
+	active, upcoming := promotion.Current(now)
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data: map[string]interface{}{
+			"now":      now.Unix(),
+			"timezone": promotion.Location.String(),
+			"active":   active,
+			"upcoming": upcoming,
+		},
+	})
+}
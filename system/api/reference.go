@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/item"
+)
+
+// References returns the content pointing to one item by type, e.g. all the
+// servers, categories and products of a game. The item is given by type and
+// id or name.
+func References(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "关联内容").Add(1)
+
+	q := req.URL.Query()
+	t := q.Get("type")
+	it, ok := item.Types[t]
+	if !ok {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "Unknown content type",
+		})
+		return
+	}
+	if hide(res, req, it()) {
+		return
+	}
+
+	id := q.Get("id")
+	if name := q.Get("name"); len(id) == 0 && len(name) > 0 {
+		opts := db.QueryOptions{Count: 1, Offset: 0, Order: "desc"}
+		_, found := db.QueryByFieldValue(t, "name", name, opts)
+		if len(found) > 0 {
+			c := struct {
+				ID int `json:"id"`
+			}{}
+			if json.Unmarshal(found[0], &c) == nil {
+				id = strconv.Itoa(c.ID)
+			}
+		}
+	}
+	if len(id) == 0 {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "Content not found",
+		})
+		return
+	}
+
+	refs, err := db.Referrers(t + ":" + id)
+	if err != nil {
+		if errors.Is(err, db.ErrReferenceNotFound) {
+			RenderJSON(res, req, RetUser{
+				RetCode: -2,
+				Msg:     "Content not found",
+			})
+			return
+		}
+		logger.Errorf("Read references of %s:%s error: %s", t, id, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	result := map[string][]json.RawMessage{}
+	for typ, list := range refs {
+		if _, hidden := item.Types[typ]().(item.Hideable); hidden {
+			continue
+		}
+		result[typ] = list
+	}
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    result,
+	})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/go-zoo/bone"
+)
+
+// ReferralLink is the affiliate link, it counts the click, keeps the code in
+// the attribution cookie and sends the customer on to the "to" page
+func ReferralLink(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "推广链接").Add(1)
+
+	to := req.URL.Query().Get("to")
+	if !strings.HasPrefix(to, "/") || strings.HasPrefix(to, "//") {
+		to = "/"
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(bone.GetValue(req, "code")))
+	if err := db.RecordReferralClick(code); err != nil {
+		logger.Warnf("Referral link %s from %s: %s", code, ipAddr, err)
+		http.Redirect(res, req, to, http.StatusFound)
+		return
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     db.ReferralCookie,
+		Value:    code,
+		Expires:  time.Now().AddDate(0, 0, db.ReferralCookieDays),
+		Path:     "/",
+		HttpOnly: true,
+	})
+	http.Redirect(res, req, to, http.StatusFound)
+}
+
+// Referral returns the referral code, clicks, conversions and commissions of
+// the login customer. A POST with {"paypal": "..."} sets the payout account.
+func Referral(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "推广佣金").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	var aff *db.Affiliate
+	if req.Method == http.MethodPost {
+		reqJSON := GetJsonFromBody(req)
+		paypal, _ := reqJSON["paypal"].(string)
+		aff, err = db.SetAffiliatePayPal(usr.Email, paypal)
+	} else {
+		aff, err = db.ReferralCodeOf(usr.Email)
+	}
+	if err != nil {
+		logger.Error("Get affiliate error:", err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	commissions, err := db.CommissionsOf(usr.Email)
+	if err != nil {
+		logger.Error("Get commissions error:", err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data: map[string]interface{}{
+			"affiliate":   aff,
+			"link":        "/api/v1/ref/" + aff.Code,
+			"commissions": commissions,
+		},
+	})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/email"
+)
+
+// resetEmailTemplate is the Email content of the password reset email, its
+// body is formatted with the email and the link. Email:3 is the former
+// forgot password email, formatted with a new password which is no longer
+// mailed.
+const resetEmailTemplate = "Email:5"
+
+// resetEmailBody is the password reset email when the resetEmailTemplate is
+// not set, with the email, the link and the minutes it works
+const resetEmailBody = `
+There has been a password reset request for the account with email %s.
+
+To choose a new password, please open the link:
+
+%s
+
+The link works once, for %.0f minutes. If you did not make the request,
+ignore this message and your password will remain as-is.
+`
+
+// ResetPassword checks the ?token= of a reset link and returns its email on
+// GET, and sets the new password of {"token":"","password":""} on POST
+func ResetPassword(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "重置密码").Add(1)
+
+	if req.Method == http.MethodGet {
+		addr, err := db.ResetEmail(req.URL.Query().Get("token"))
+		if err != nil {
+			RenderJSON(res, req, RetUser{
+				RetCode: -1,
+				Msg:     err.Error()})
+			return
+		}
+		RenderJSON(res, req, RetUser{
+			RetCode: 0,
+			Msg:     "Done",
+			Data:    addr,
+		})
+		return
+	}
+
+	reqJSON := GetJsonFromBody(req)
+	token, _ := reqJSON["token"].(string)
+	password, _ := reqJSON["password"].(string)
+	addr, err := db.ResetPassword(token, password)
+	if err != nil {
+		logger.Warnf("Password reset from %s failed: %s", ipAddr, err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	db.LoginSucceeded(addr)
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done, please login again",
+		Data:    addr,
+	})
+}
+
+// sendResetEmail mails the password reset link of addr to the page of the
+// reset_link_url setting, or of the domain setting when it is not set, by the
+// resetEmailTemplate when it is enabled
+func sendResetEmail(addr string) {
+	page, _ := db.ConfigCache("reset_link_url").(string)
+	if len(page) == 0 {
+		site, err := mailSite()
+		if err != nil {
+			logger.Errorf("Reset email to %s not sent: %s", addr, err)
+			return
+		}
+		page = site + "/api/v1/user/reset"
+	}
+	token, expires, err := db.ResetToken(addr)
+	if err != nil {
+		logger.Errorf("Reset link of %s error: %s", addr, err)
+		return
+	}
+	sep := "?"
+	if strings.Contains(page, "?") {
+		sep = "&"
+	}
+	link := page + sep + "token=" + url.QueryEscape(token)
+
+	subject := "Reset your password"
+	body := fmt.Sprintf(resetEmailBody, addr, link, time.Until(expires).Minutes())
+	tomail := []string{addr}
+	if buf, err := db.Content(resetEmailTemplate); err == nil {
+		tmpl := userEmailInfo{}
+		if err := json.Unmarshal(buf, &tmpl); err == nil && tmpl.Enable && len(tmpl.EmailBody) > 0 {
+			subject = tmpl.Subject
+			body = fmt.Sprintf(tmpl.EmailBody, addr, link)
+			if len(tmpl.CC) > 0 {
+				tomail = append(tomail, strings.Split(tmpl.CC, ",")...)
+			}
+		}
+	}
+
+	ret, err := email.Send(&email.Email{
+		To:       tomail,
+		Subject:  subject,
+		TextBody: body,
+		HtmlBody: strings.Replace(body, "\n", "<br>", -1),
+	})
+	if err != nil {
+		logger.Warnf("Send reset email to %s error: %s", addr, err)
+	} else if ret.Data.Succeeded == 1 {
+		logger.Infof("Reset email sent to %s", addr)
+	} else {
+		logger.Warnf("Reset email to %s sent with error: %v", addr, ret)
+	}
+}
@@ -215,7 +215,20 @@ func content(res http.ResponseWriter, req *http.Request) {
 	t := q.Get("type")
 	slug := q.Get("slug")
 
-	if slug != "" {
+	// a preview link shows the pending version of its item
+	pns, pid, err := previewOf(req)
+	if err != nil || (len(pns) > 0 && pns != t) {
+		res.WriteHeader(http.StatusForbidden)
+		return
+	}
+	target := t + ":" + id
+	if len(pid) > 0 {
+		id = pid
+		target = t + "__pending:" + id
+		res.Header().Set("Cache-Control", "no-store")
+	}
+
+	if slug != "" && len(pid) == 0 {
 		contentHandlerBySlug(res, req)
 		return
 	}
@@ -231,11 +244,15 @@ func content(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	post, err := db.Content(t + ":" + id)
+	post, err := db.Content(target)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if len(post) == 0 && len(pid) > 0 {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
 
 	p := pt()
 	err = json.Unmarshal(post, p)
@@ -292,7 +309,7 @@ func content(res http.ResponseWriter, req *http.Request) {
 	if ok {
 		// hook before response
 		fields, hasSubContent := hook.EnableSubContent()
-		if hasSubContent {
+		if hasSubContent && len(pid) == 0 {
 
 			logger.Debug("Now process sub-content ")
 
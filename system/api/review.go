@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// SubmitReview takes the rating and review of a product from the login
+// customer, {"product": id, "rating": 1-5, "title": "...", "text": "..."}.
+// The review waits in Review__pending until an admin approves it.
+func SubmitReview(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "产品评论").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	r := db.ReviewRequest{}
+	if err = json.NewDecoder(req.Body).Decode(&r); err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     db.ErrInvalidReview.Error()})
+		return
+	}
+	defer req.Body.Close()
+
+	id, err := db.SubmitReview(usr.Email, ipAddr, r)
+	if err != nil {
+		logger.Warnf("Review of product %d by %s from %s: %s", r.Product, usr.Email, ipAddr, err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	logger.Infof("Review %d of product %d by %s waits for approval", id, r.Product, usr.Email)
+
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data: map[string]interface{}{
+			"id":     id,
+			"status": "pending",
+		},
+	})
+}
@@ -4,6 +4,7 @@
 package api
 
 import (
+	"github.com/agreyfox/eshop/system/admin/user"
 	"github.com/agreyfox/eshop/system/logs"
 	"github.com/go-zoo/bone"
 	"go.uber.org/zap"
@@ -23,15 +24,18 @@ func Run(mainMux *bone.Mux) {
 
 	//apiv1Mux.HandleFunc("/content", Record(CORS(Gzip(contentHandler))))
 	apiv1Mux.Get("/content", Record(CORS(Gzip(content))))
-	apiv1Mux.Post("/content/create", Record(CORS(CustomerAuth(createContent)))) //2021/1/23
+	apiv1Mux.Post("/content/create", Record(CORS(KeyAuth(user.ActionCreate, createContent)))) //2021/1/23
 
 	//apiv1Mux.HandleFunc("/content/create", Record(CORS(createContentHandler)))
 
-	apiv1Mux.HandleFunc("/content/update", Record(CORS(CustomerAuth(updateContentHandler)))) //2021/1/23
+	apiv1Mux.HandleFunc("/content/update", Record(CORS(KeyAuth(user.ActionModify, updateContentHandler)))) //2021/1/23
 
-	apiv1Mux.HandleFunc("/content/delete", Record(CORS(CustomerAuth(deleteContentHandler)))) //2021/1/23
-	apiv1Mux.HandleFunc("/content/search", Record(CORS(Gzip(advSearchContent))))             //2021/1/23
+	apiv1Mux.HandleFunc("/content/delete", Record(CORS(KeyAuth(user.ActionDelete, deleteContentHandler)))) //2021/1/23
+	apiv1Mux.HandleFunc("/content/search", Record(CORS(Gzip(advSearchContent))))                           //2021/1/23
 	apiv1Mux.Get("/search", Record(CORS(Gzip(searchContent))))
+	apiv1Mux.Get("/promotion", Record(CORS(Gzip(Promotions))))
+	apiv1Mux.Get("/content/references", Record(CORS(Gzip(References))))
+	apiv1Mux.Get("/catalog/:slug", Record(CORS(Gzip(Catalog))))
 
 	//apiv1Mux.HandleFunc("/search", Record(CustomerAuth(CORS(Gzip(searchContentHandler)))))
 
@@ -46,9 +50,27 @@ func Run(mainMux *bone.Mux) {
 	apiv1Mux.Post("/logout", CORS(CustomerAuth(Logout)))
 	apiv1Mux.Post("/forgot", CORS(NewForgot)) //modifoy, origin is Forgot
 	apiv1Mux.Post("/login", Record(CORS(Login)))
+	apiv1Mux.Get("/oidc/providers", Record(CORS(LoginProviders)))
+	apiv1Mux.Get("/oidc/:provider/login", Record(OIDCLogin))
+	apiv1Mux.Get("/oidc/:provider/callback", Record(OIDCCallback))
 	//apiv1Mux.Post("/user/login", Record(CORS(Login)))
 	apiv1Mux.Post("/recovery", CORS(Recovery))
 	apiv1Mux.Post("/config", Record(CORS(Config)))
+	apiv1Mux.Get("/wallet", Record(CORS(CustomerAuth(Wallet))))
+	apiv1Mux.Get("/user/points", Record(CORS(CustomerAuth(Points))))
+	apiv1Mux.Get("/user/referral", Record(CORS(CustomerAuth(Referral))))
+	apiv1Mux.Post("/user/referral", Record(CORS(CustomerAuth(Referral))))
+	apiv1Mux.Get("/user/sessions", Record(CORS(CustomerAuth(Sessions))))
+	apiv1Mux.Delete("/user/sessions", Record(CORS(CustomerAuth(Sessions))))
+	apiv1Mux.Post("/user/2fa/:action", Record(CORS(CustomerAuth(TwoFactor))))
+	apiv1Mux.Get("/user/reset", Record(CORS(ResetPassword)))
+	apiv1Mux.Post("/user/reset", Record(CORS(ResetPassword)))
+	apiv1Mux.Get("/user/verify", Record(CORS(VerifyEmail)))
+	apiv1Mux.Post("/user/verify", Record(CORS(CustomerAuth(ResendVerify))))
+	apiv1Mux.Get("/user/export", Record(CORS(CustomerAuth(ExportData))))
+	apiv1Mux.Post("/user/delete", Record(CORS(CustomerAuth(DeleteAccount))))
+	apiv1Mux.Get("/ref/:code", Record(ReferralLink))
+	apiv1Mux.Post("/review", Record(CORS(CustomerAuth(SubmitReview))))
 
 	//	apiv1Mux.HandleFunc("/user/login", CORS(LoginHandler))
 
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+)
+
+// Sessions lists the logins of the login customer, a DELETE with ?id= ends
+// one of them and without id logs the customer out everywhere
+func Sessions(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "登录设备").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	if req.Method == http.MethodDelete {
+		id := req.URL.Query().Get("id")
+		n := 1
+		if len(id) > 0 {
+			err = db.RevokeSession(usr.Email, id)
+		} else {
+			n, err = db.RevokeSessions(usr.Email, "")
+		}
+		if err != nil {
+			RenderJSON(res, req, RetUser{
+				RetCode: -1,
+				Msg:     err.Error()})
+			return
+		}
+		logger.Infof("User %s ended %d sessions from %s", usr.Email, n, ipAddr)
+		if !user.IsValid(req) {
+			http.SetCookie(res, &http.Cookie{
+				Name:    user.Lqcmstoken,
+				Expires: time.Unix(0, 0),
+				Value:   "",
+				Path:    "/",
+			})
+		}
+		RenderJSON(res, req, RetUser{
+			RetCode: 0,
+			Msg:     "Done",
+			Data:    n,
+		})
+		return
+	}
+
+	list, err := db.Sessions(usr.Email, user.Token(req))
+	if err != nil {
+		logger.Error("Get sessions error:", err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    list,
+	})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/go-zoo/bone"
+)
+
+// passTwoFactor checks the second factor of a login whose password passed and
+// answers the request when it does not pass. It returns the recovery codes of
+// a confirmed enrollment.
+func passTwoFactor(res http.ResponseWriter, req *http.Request, usr *user.User, body map[string]interface{}) ([]string, bool) {
+	code, _ := body["code"].(string)
+	recovery, err := db.PassTwoFactor(usr, code, user.DeviceToken(req, body))
+	switch err {
+	case nil:
+		return recovery, true
+	case db.ErrTwoFactorRequired:
+		RenderJSON(res, req, RetUser{
+			RetCode: 3,
+			Msg:     err.Error()})
+	default:
+		db.LoginFailed(usr.Email, GetIP(req))
+		logger.Warnf("User %s two-factor failed from %s: %s", usr.Email, GetIP(req), err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+	}
+	return nil, false
+}
+
+// rememberDevice sets the cookie of a remembered device after a login with
+// a code and "remember": true, and returns its token
+func rememberDevice(res http.ResponseWriter, email string, body map[string]interface{}) string {
+	code, _ := body["code"].(string)
+	remember, _ := body["remember"].(bool)
+	if !remember || len(code) == 0 {
+		return ""
+	}
+	token, expires, err := db.RememberDevice(email)
+	if err != nil || len(token) == 0 {
+		return ""
+	}
+	http.SetCookie(res, &http.Cookie{
+		Name:     user.TwoFactorDevice,
+		Value:    token,
+		Expires:  expires,
+		Path:     "/",
+		HttpOnly: true,
+	})
+	return token
+}
+
+// TwoFactor manages the second factor of the login customer, by the action of
+// the path:
+//
+//	enroll    returns a new TOTP secret and its provisioning URI
+//	confirm   enables it with {"code"}, returns the recovery codes
+//	recovery  returns new recovery codes for {"code"}
+//	disable   removes it with {"code"}
+func TwoFactor(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "两步验证").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	action := bone.GetValue(req, "action")
+	body := GetJsonFromBody(req)
+	code, _ := body["code"].(string)
+
+	var data interface{}
+	switch action {
+	case "enroll":
+		secret, uri, e := db.BeginTwoFactor(usr.Email)
+		data, err = map[string]string{"secret": secret, "uri": uri}, e
+	case "confirm":
+		data, err = db.ConfirmTwoFactor(usr.Email, code)
+	case "recovery":
+		data, err = db.RenewRecoveryCodes(usr.Email, code)
+	case "disable":
+		err = db.DisableTwoFactor(usr.Email, code)
+	default:
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "Unknown action " + action})
+		return
+	}
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	logger.Infof("User %s two-factor %s from %s", usr.Email, action, ipAddr)
+
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    data,
+	})
+}
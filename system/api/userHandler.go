@@ -131,6 +131,12 @@ func RegisterUser(res http.ResponseWriter, req *http.Request) {
 			Msg:     "Wrong Register User Data"})
 		return
 	}
+	if err := db.CheckPassword(password); err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -23,
+			Msg:     err.Error()})
+		return
+	}
 
 	usr, err := user.NewCustomerWithSocial(inputemail, password, meta, social)
 	if err != nil {
@@ -159,6 +165,7 @@ func RegisterUser(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 	go prometheus.RegisterCounter.Inc()
+	go sendVerifyEmail(inputemail)
 	//http.Redirect(res, req, req.URL.String(), http.StatusFound)
 	//res.WriteHeader(http.StatusAccepted)
 	//emailaddr := inputemail
@@ -277,6 +284,12 @@ func UpdateUser(res http.ResponseWriter, req *http.Request) {
 
 	var updatedUser *user.User
 	if len(password) > 0 {
+		if err := db.CheckPassword(password); err != nil {
+			RenderJSON(res, req, RetUser{
+				RetCode: -23,
+				Msg:     err.Error()})
+			return
+		}
 		updatedUser, err = user.New(email, password)
 		if err != nil {
 			logger.Error("password error")
@@ -311,11 +324,9 @@ func UpdateUser(res http.ResponseWriter, req *http.Request) {
 	countryInfor, _ := ipSearchHandler.QueryIPByDB(ipAddr)
 	week := time.Now().Add(time.Hour * 24 * 7)
 	claims := map[string]interface{}{
-		"exp":     week,
-		"user":    updatedUser.Email,
 		"country": countryInfor,
 	}
-	token, err := jwt.New(claims)
+	token, err := db.OpenSession(updatedUser.Email, req.UserAgent(), ipAddr, week, claims)
 
 	if err != nil {
 		logger.Debug(err)
@@ -390,11 +401,20 @@ func Login(res http.ResponseWriter, req *http.Request) {
 	}
 	password := fmt.Sprintf("%s", requestJson["password"])
 	logger.Debug("The Request email is :", email)
+	email = strings.ToLower(email)
+	if wait, err := db.CheckLogin(email, ipAddr); err != nil {
+		logger.Warnf("User login of %s from %s refused, wait %s", email, ipAddr, wait)
+		RenderJSON(res, req, RetUser{
+			RetCode: -8,
+			Msg:     fmt.Sprintf("%s (%s)", err.Error(), wait.Round(time.Second))})
+		return
+	}
 
-	j, err := db.User(strings.ToLower(email))
+	j, err := db.User(email)
 
 	if err != nil {
 		logger.Error(err)
+		db.LoginFailed(email, ipAddr)
 		RenderJSON(res, req, RetUser{
 			RetCode: -1,
 			Msg:     err.Error(),
@@ -425,6 +445,7 @@ func Login(res http.ResponseWriter, req *http.Request) {
 
 	if !user.IsUser(usr, password) { //check if user password is ok
 		logger.Warn("wrong user login attempt")
+		db.LoginFailed(email, ipAddr)
 		RenderJSON(res, req, RetUser{
 			RetCode: -1,
 			Msg:     "wrong email or password!",
@@ -432,6 +453,37 @@ func Login(res http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
+	db.UpgradePassword(usr, password)
+	recovery, ok := passTwoFactor(res, req, usr, requestJson)
+	if !ok {
+		return
+	}
+	token, countryInfor, err := openLogin(res, req, usr)
+	if err != nil {
+		logger.Debug(err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     "internal error",
+			Data:    "",
+		})
+		return
+	}
+
+	logger.Debugf("User %s logged in !", usr)
+	ret := loginResult(usr, token, countryInfor)
+	ret.Recovery = recovery
+	ret.Device = rememberDevice(res, usr.Email, requestJson)
+	RenderJSON(res, req, ret)
+
+	return
+
+}
+
+// openLogin opens a session of usr as the login of a customer, sets the token
+// cookie and returns the token with the country of the request
+func openLogin(res http.ResponseWriter, req *http.Request, usr *user.User) (string, string, error) {
+	ipAddr := GetIP(req)
+	db.LoginSucceeded(usr.Email)
 	// create new token
 	week := time.Now().Add(time.Hour * 2) // session time is 2 hours
 
@@ -440,22 +492,13 @@ func Login(res http.ResponseWriter, req *http.Request) {
 	countryInfor, _ := ipSearchHandler.QueryIPByDB(ipAddr)
 
 	claims := map[string]interface{}{
-		"exp":     week,
-		"user":    usr.Email,
 		"country": countryInfor,
 	}
-	token, err := jwt.New(claims)
+	token, err := db.OpenSession(usr.Email, req.UserAgent(), ipAddr, week, claims)
 	//DecodeJwt(token)
 	//logger.Debug(jwt.GetClaims(token))
-
 	if err != nil {
-		logger.Debug(err)
-		RenderJSON(res, req, RetUser{
-			RetCode: -1,
-			Msg:     "internal error",
-			Data:    "",
-		})
-		return
+		return "", "", err
 	}
 
 	// add it to cookie +1 week expiration
@@ -465,12 +508,15 @@ func Login(res http.ResponseWriter, req *http.Request) {
 		Expires: week,
 		Path:    "/",
 	})
+	return token, countryInfor, nil
+}
 
-	logger.Debugf("User %s logged in !", usr)
+// loginResult is the answer to a customer login with token
+func loginResult(usr *user.User, token, countryInfor string) RetUser {
 	currency := getContentList("Currency")
 	country := getContentList("Country")
 	button := getContentList("PaymentButton")
-	RenderJSON(res, req, RetUser{
+	return RetUser{
 		RetCode:        0,
 		Msg:            "Done",
 		Data:           token,
@@ -480,10 +526,8 @@ func Login(res http.ResponseWriter, req *http.Request) {
 		SocialType:     usr.Meta,
 		SocialLink:     usr.Social,
 		Buttons:        button,
-	})
-
-	return
-
+		Verified:       &usr.Verified,
+	}
 }
 
 // customer login function , check login credential and return data
@@ -522,16 +566,11 @@ func Renew(res http.ResponseWriter, req *http.Request) {
 		if err == nil {
 			// validate it and allow or redirect request
 			token := cookie.Value
-			claim := jwt.GetClaims(token)
 
 			// create new token
 			week := time.Now().Add(time.Hour * 2) // session time is 2 hours
 
-			claims := map[string]interface{}{
-				"exp":  week,
-				"user": claim["user"],
-			}
-			newtoken, err := jwt.New(claims)
+			newtoken, err := db.RenewSession(token, week)
 			//DecodeJwt(token)
 			//logger.Debug(jwt.GetClaims(token))
 
@@ -577,6 +616,11 @@ func Renew(res http.ResponseWriter, req *http.Request) {
 }
 
 func Logout(res http.ResponseWriter, req *http.Request) {
+	if token := user.Token(req); user.ValidToken(token) {
+		if err := db.EndSession(token); err != nil {
+			logger.Warn("End session at logout error:", err)
+		}
+	}
 	http.SetCookie(res, &http.Cookie{
 		Name:    user.Lqcmstoken,
 		Expires: time.Unix(0, 0),
@@ -592,12 +636,50 @@ func Logout(res http.ResponseWriter, req *http.Request) {
 	//	http.Redirect(res, req, req.URL.Scheme+req.URL.Host+"/admin/login", http.StatusFound)
 }
 
-// modified at 2021/4/7 to send password directly
-/*
-    1 generate password 8 letter
-	2 update password with it
-	3 send password to customer with forgot mail temlate
-*/
+// Points returns the loyalty points balance and history of the login user
+func Points(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "积分").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	account, err := db.PointsOf(usr.Email)
+	if err != nil {
+		logger.Error("Get points error:", err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	history, err := db.PointsLedger(usr.Email)
+	if err != nil {
+		logger.Error("Get points history error:", err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data: map[string]interface{}{
+			"points":  account,
+			"history": history,
+		},
+	})
+}
+
+// NewForgot sends a password reset link to the email of the request, the
+// password is set on the page of the link, see ResetPassword
 func NewForgot(res http.ResponseWriter, req *http.Request) {
 	ip := GetIP(req)
 	logger.Debugf("User try to recover password, from:", ip)
@@ -611,6 +693,10 @@ func NewForgot(res http.ResponseWriter, req *http.Request) {
 		logger.Error("Failed account recovery. No email address submitted.")
 		return
 	}
+	if err := db.ThrottleReset(useremail, ip); err != nil {
+		RenderJSON(res, req, RetUser{RetCode: -8, Msg: err.Error()})
+		return
+	}
 	usr := &user.User{}
 	u, err := db.User(useremail)
 	if err == db.ErrNoUserExists {
@@ -628,8 +714,6 @@ func NewForgot(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	newPassword := GeneratePassword()
-
 	err = json.Unmarshal(u, usr)
 	if err != nil {
 		logger.Error("Error decoding user from database:", err)
@@ -637,75 +721,7 @@ func NewForgot(res http.ResponseWriter, req *http.Request) {
 		RenderJSON(res, req, RetUser{RetCode: -1, Msg: "Error, please go back and try again.", Data: ""})
 		return
 	}
-
-	update, err := user.NewCustomer(useremail, newPassword)
-	if err != nil {
-		logger.Error(err)
-
-		RenderJSON(res, req, RetUser{RetCode: -1, Msg: err.Error(), Data: ""})
-		return
-	}
-
-	update.ID = usr.ID
-
-	err = db.UpdateUser(usr, update)
-	if err != nil {
-		logger.Error("Error setting  user new password:", err)
-		RenderJSON(res, req, RetUser{RetCode: -1, Msg: "Error, please go back and try again.", Data: ""})
-		return
-	}
-
-	emailConfbuf, err := db.Content("Email:3") // 3 means forgot email
-	if err != nil {
-		logger.Warnf("Skip forget email send job,Error:%s", err)
-		RenderJSON(res, req, RetUser{RetCode: -2, Msg: "error when send mail", Data: ""})
-		return
-	} else {
-		emailstruct := userEmailInfo{}
-		err := json.Unmarshal(emailConfbuf, &emailstruct)
-		logger.Debugf("email template is %v", emailstruct)
-		//	emailstruct.Subject
-		if err != nil {
-			logger.Warnf("Skip forgot email send job,Error:%s", err)
-
-		} else {
-
-			//go func() {
-			logger.Debugf("send forget email to User %s!", useremail)
-			bodyTemplate := emailstruct.EmailBody
-			if len(bodyTemplate) == 0 {
-				logger.Warnf("Skip forget email With no template setting")
-				//return
-			}
-			logger.Debugf("email body template len is %d", len(bodyTemplate))
-			body := fmt.Sprintf(bodyTemplate, useremail, newPassword)
-			tomail := []string{string(useremail)}
-			ccmail := strings.Split(emailstruct.CC, ",")
-
-			tomail = append(tomail, ccmail...)
-
-			subj := emailstruct.Subject
-			logger.Infof("also try to send admin notification email to %v\n", tomail)
-			emailtarget := email.Email{
-				//From: admin.MailUser,
-				To:       tomail,
-				Subject:  subj,
-				TextBody: body,
-				HtmlBody: body,
-			}
-			res, err := email.Send(&emailtarget)
-			if err != nil {
-				logger.Warnf("Send Alert email with n Error Occurred: %s\n", err)
-			} else if res.Data.Succeeded == 1 {
-				logger.Infof("Email allter sent Successfully: %v\n", res)
-			} else {
-				logger.Warnf("Email allter Sent with error: %v\n", res)
-			}
-
-			//}()
-		}
-
-	}
+	go sendResetEmail(usr.Email)
 
 	// redirect to /admin/recover/key and send email with key and URL
 	//http.Redirect(res, req, req.URL.Scheme+req.URL.Host+"/admin/recover/key", http.StatusFound)
@@ -837,15 +853,24 @@ func Recovery(res http.ResponseWriter, req *http.Request) {
 		logger.Debug("Failed account recovery. No email address submitted.")
 		return
 	}
+	ipAddr := GetIP(req)
+	if wait, err := db.CheckLogin(email, ipAddr); err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -8,
+			Msg:     fmt.Sprintf("%s (%s)", err.Error(), wait.Round(time.Second))})
+		return
+	}
 	var actual string
 	if actual, err = db.RecoveryKey(email); err != nil || actual == "" {
 		logger.Debug("Error getting recovery key from database:", err)
+		db.LoginFailed(email, ipAddr)
 		RenderJSON(res, req, RetUser{RetCode: -1, Msg: "Error, please go back and try again."})
 		return
 	}
 
 	if key != actual {
 		logger.Debug("Bad recovery key submitted:", key)
+		db.LoginFailed(email, ipAddr)
 
 		RenderJSON(res, req, RetUser{RetCode: -1, Msg: "Error, please go back and try again.", Data: ""})
 		return
@@ -853,6 +878,10 @@ func Recovery(res http.ResponseWriter, req *http.Request) {
 
 	// set user with new password
 	password := fmt.Sprintf("%s", reqJSON["password"])
+	if err := db.CheckPassword(password); err != nil {
+		RenderJSON(res, req, RetUser{RetCode: -23, Msg: err.Error(), Data: ""})
+		return
+	}
 	usr := &user.User{}
 	u, err := db.User(email)
 	if err != nil {
@@ -1044,7 +1073,14 @@ func RenewHandler(res http.ResponseWriter, req *http.Request) {
 			if err == nil {
 
 				// validate it and allow or redirect request
-				token := cookie.Value
+				token, err := db.RenewSession(cookie.Value, week)
+				if err != nil {
+					RenderJSON(res, req, RetUser{
+						RetCode: -9,
+						Msg:     "not valid user",
+					})
+					return
+				}
 				http.SetCookie(res, &http.Cookie{
 					Name:    user.Lqcmstoken,
 					Value:   token,
@@ -6,13 +6,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strings"
-	"time"
 
 	"github.com/agreyfox/eshop/system/admin/user"
 	"github.com/agreyfox/eshop/system/db"
@@ -36,6 +34,9 @@ type RetUser struct {
 	Buttons        []string       `json:"buttons,omitempty"`
 	SocialType     string         `json:"social_type,omitempty"`
 	SocialLink     string         `json:"social_link,omitempty"`
+	Recovery       []string       `json:"recovery,omitempty"` // two-factor recovery codes
+	Device         string         `json:"device,omitempty"`   // remembered two-factor device
+	Verified       *bool          `json:"verified,omitempty"` // email verified, on login
 }
 
 type MetaData struct {
@@ -327,18 +328,10 @@ func ClientPublicIP(r *http.Request) string {
 	return ""
 }
 
-// Use sofescate way to get IP address.
+// GetIP returns the client address of r, forwarded addresses are taken from
+// trusted proxies only
 func GetIP(r *http.Request) string {
-	/* forwarded := r.Header.Get("X-FORWARDED-FOR")
-	if forwarded != "" {
-		return forwarded
-	}
-	return r.RemoteAddr */
-	ip := ClientPublicIP(r)
-	if ip == "" {
-		ip = ClientIP(r)
-	}
-	return ip
+	return db.ClientIP(r)
 }
 
 /*
@@ -364,6 +357,31 @@ func CustomerAuth(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
+// KeyAuth is CustomerAuth which also lets through the API keys granted
+// action on the content type of ?type=
+func KeyAuth(action string, next http.HandlerFunc) http.HandlerFunc {
+	auth := CustomerAuth(next)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if len(user.APIKeyToken(req)) == 0 {
+			auth(res, req)
+			return
+		}
+		usr, err := db.APIKeyUser(req)
+		if err != nil {
+			res.WriteHeader(http.StatusUnauthorized)
+			logger.Errorf("Action %s with API key error: %s", req.RequestURI, err)
+			return
+		}
+		t := strings.Split(req.URL.Query().Get("type"), "__")[0]
+		if !usr.Can(t, action) {
+			res.WriteHeader(http.StatusForbidden)
+			logger.Errorf("Action %s by %s without grant", req.RequestURI, usr.Email)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
 // get all currency list in systrem
 func getContentList(name string) []string {
 	ret := []string{}
@@ -403,7 +421,7 @@ func getEmailFromCookie(req *http.Request) (string, error) {
 	}
 	// validate it and allow or redirect request
 	token := cookie.Value
-	if jwt.Passes(token) {
+	if user.ValidToken(token) {
 		clienInfo := jwt.GetClaims(token)
 		userEmail = clienInfo["user"].(string)
 	} else {
@@ -426,18 +444,3 @@ func DecodeJwt(token string) map[string]interface{} {
 	fmt.Println(target)
 	return target
 } */
-
-// to create new password, 8 letter
-func GeneratePassword() string {
-	rand.Seed(time.Now().UnixNano())
-	chars := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
-		"abcdefghijklmnopqrstuvwxyz" +
-		"0123456789-+#$%")
-	length := 8
-	var b strings.Builder
-	for i := 0; i < length; i++ {
-		b.WriteRune(chars[rand.Intn(len(chars))])
-	}
-	str := b.String() // E.g. "ExcbsVQs"
-	return str
-}
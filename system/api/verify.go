@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/prometheus"
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/email"
+)
+
+// verifyEmailTemplate is the Email content of the verification email, its
+// body is formatted with the email and the link. Email:4 is the gift card
+// email of the payment.
+const verifyEmailTemplate = "Email:6"
+
+// verifyEmailBody is the verification email when the verifyEmailTemplate is
+// not set, with the email, the link and the hours it works
+const verifyEmailBody = `
+Please verify the email %s of your account by opening the link:
+
+%s
+
+The link works for %.0f hours. If you did not register, ignore this message.
+`
+
+// VerifyEmail verifies the email of the ?token= of a verification link
+func VerifyEmail(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "邮箱验证").Add(1)
+
+	addr, err := db.VerifyEmail(req.URL.Query().Get("token"))
+	if err != nil {
+		logger.Warnf("Email verification from %s failed: %s", ipAddr, err)
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     err.Error()})
+		return
+	}
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Done",
+		Data:    addr,
+	})
+}
+
+// ResendVerify sends a new verification link to the login customer
+func ResendVerify(res http.ResponseWriter, req *http.Request) {
+	ipAddr := GetIP(req)
+	go prometheus.ApiCounter.WithLabelValues(ipAddr, "重发验证邮件").Add(1)
+
+	buf, err := db.CurrentUser(req)
+	if err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -2,
+			Msg:     "You should login first"})
+		return
+	}
+	usr := user.User{}
+	json.Unmarshal(buf, &usr)
+
+	if usr.Verified {
+		RenderJSON(res, req, RetUser{
+			RetCode: -1,
+			Msg:     db.ErrVerified.Error()})
+		return
+	}
+	if err := db.ThrottleVerify(usr.Email, ipAddr); err != nil {
+		RenderJSON(res, req, RetUser{
+			RetCode: -8,
+			Msg:     err.Error()})
+		return
+	}
+	go sendVerifyEmail(usr.Email)
+
+	RenderJSON(res, req, RetUser{
+		RetCode: 0,
+		Msg:     "Verification email sent, Please check!",
+	})
+}
+
+// mailSite returns the https url of the domain setting, the site of the links
+// mailed to users. The request host is not used, a forged Host would have the
+// link lead to another site.
+func mailSite() (string, error) {
+	domain, _ := db.ConfigCache("domain").(string)
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), "/")
+	if len(domain) == 0 {
+		return "", fmt.Errorf("domain setting is not set")
+	}
+	return "https://" + domain, nil
+}
+
+// sendVerifyEmail mails the verification link of addr, by the
+// verifyEmailTemplate when it is set
+func sendVerifyEmail(addr string) {
+	site, err := mailSite()
+	if err != nil {
+		logger.Errorf("Verification email to %s not sent: %s", addr, err)
+		return
+	}
+	token, expires := db.VerifyToken(addr)
+	link := site + "/api/v1/user/verify?token=" + url.QueryEscape(token)
+
+	subject := "Please verify your email"
+	body := fmt.Sprintf(verifyEmailBody, addr, link, time.Until(expires).Hours())
+	tomail := []string{addr}
+	if buf, err := db.Content(verifyEmailTemplate); err == nil {
+		tmpl := userEmailInfo{}
+		if err := json.Unmarshal(buf, &tmpl); err == nil && tmpl.Enable && len(tmpl.EmailBody) > 0 {
+			subject = tmpl.Subject
+			body = fmt.Sprintf(tmpl.EmailBody, addr, link)
+		}
+	}
+
+	ret, err := email.Send(&email.Email{
+		To:       tomail,
+		Subject:  subject,
+		TextBody: body,
+		HtmlBody: strings.Replace(body, "\n", "<br>", -1),
+	})
+	if err != nil {
+		logger.Warnf("Send verification email to %s error: %s", addr, err)
+	} else if ret.Data.Succeeded == 1 {
+		logger.Infof("Verification email sent to %s", addr)
+	} else {
+		logger.Warnf("Verification email to %s sent with error: %v", addr, ret)
+	}
+}
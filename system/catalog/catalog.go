@@ -0,0 +1,335 @@
+// Package catalog builds the catalog tree of a game for the storefront: the
+// game with its categories, servers, products and direct top up games in one
+// document. Trees are cached with an ETag until a member content changes.
+package catalog
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/i18n"
+	"github.com/agreyfox/eshop/system/logs"
+	"go.uber.org/zap"
+)
+
+// ErrGameNotFound is returned when no online game has the slug
+var ErrGameNotFound = errors.New("game not found")
+
+var (
+	logger *zap.SugaredLogger = logs.Log.Sugar()
+
+	// Members are the content types a tree is built from, a change to any of
+	// them drops the cached trees
+	Members = []string{"Game", "Server", "Category", "Product", "DirectGame", "Discount"}
+
+	// contentAll reads all content of a type, tests replace it
+	contentAll = db.ContentAll
+
+	cache   = map[string]*Tree{}
+	cacheMu sync.Mutex
+)
+
+type (
+	// Node is one content item of the tree as its json fields, with the
+	// children added under their own keys
+	Node map[string]interface{}
+
+	// Draft is a pending content item a preview tree shows in place of the
+	// public item with its name, or as a new item
+	Draft struct {
+		Type string // content type, without __pending
+		ID   int    // pending id
+		Data []byte
+	}
+
+	// Tree is the built catalog of one game
+	Tree struct {
+		Game       Node   `json:"game"`
+		Categories []Node `json:"categories"` // top level categories, sub categories under "children"
+		Servers    []Node `json:"servers"`    // servers without a category
+		Products   []Node `json:"products"`
+		Direct     []Node `json:"direct"`
+
+		ETag string `json:"-"`
+		Body []byte `json:"-"`
+	}
+)
+
+func init() {
+	for _, ns := range Members {
+		db.AddContentWatcher(ns, func(target string, before, after []byte) {
+			Reset()
+		})
+	}
+}
+
+// Reset drops the cached trees
+func Reset() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	cache = map[string]*Tree{}
+}
+
+// Get returns the tree of the game with slug in locale, from the cache when
+// no member changed since it was built
+func Get(slug, locale string) (*Tree, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	key := slug + "|" + locale
+	if t, ok := cache[key]; ok {
+		return t, nil
+	}
+	t, err := Build(slug, locale)
+	if err != nil {
+		return nil, err
+	}
+	cache[key] = t
+	return t, nil
+}
+
+// Build reads the members of the game with slug into a tree with the texts
+// of locale. Only online content and product variants are kept, the active
+// discount of a product replaces its discount key and children are ordered
+// by their order field, then by name.
+func Build(slug, locale string) (*Tree, error) {
+	return build(slug, locale, nil)
+}
+
+// Preview builds the tree of the game with slug showing the pending draft as
+// if it was published. Preview trees are not cached.
+func Preview(slug, locale string, draft *Draft) (*Tree, error) {
+	return build(slug, locale, draft)
+}
+
+func build(slug, locale string, draft *Draft) (*Tree, error) {
+	loader := func(ns string) []Node {
+		return withDraft(load(ns, locale), ns, locale, draft)
+	}
+	game := findGame(slug, loader)
+	if game == nil {
+		return nil, ErrGameNotFound
+	}
+	of := func(ns string) []Node {
+		return ofGame(loader(ns), game)
+	}
+
+	discounts := map[int]Node{}
+	for _, d := range online(loader("Discount")) {
+		if list, ok := d["list"].(string); ok {
+			var v interface{}
+			if json.Unmarshal([]byte(list), &v) == nil {
+				d["list"] = v
+			}
+		}
+		discounts[d.ID()] = d
+	}
+
+	t := &Tree{
+		Game:       game,
+		Categories: []Node{},
+		Servers:    []Node{},
+		Products:   online(of("Product")),
+		Direct:     of("DirectGame"),
+	}
+	products := map[int]bool{}
+	for _, p := range t.Products {
+		products[p.ID()] = true
+		if list, ok := p["variants"].([]interface{}); ok {
+			variants := []interface{}{}
+			for _, v := range list {
+				if m, _ := v.(map[string]interface{}); m != nil && m["online"] == true {
+					variants = append(variants, v)
+				}
+			}
+			p["variants"] = variants
+		}
+		if d, ok := discounts[refID(p["discount"])]; ok {
+			p["discount"] = d
+		} else {
+			delete(p, "discount")
+		}
+	}
+
+	categories := map[int]Node{}
+	for _, c := range online(of("Category")) {
+		c["children"] = []Node{}
+		c["servers"] = []Node{}
+		categories[c.ID()] = c
+	}
+	for _, c := range sorted(values(categories)) {
+		if parent, ok := categories[refID(c["belongto"])]; ok && parent.ID() != c.ID() {
+			parent["children"] = append(parent["children"].([]Node), c)
+			continue
+		}
+		t.Categories = append(t.Categories, c)
+	}
+
+	for _, s := range sorted(online(of("Server"))) {
+		for _, field := range []string{"coins", "items"} {
+			ids := []int{}
+			list, _ := s[field].(string)
+			keys := []string{}
+			json.Unmarshal([]byte(list), &keys)
+			for _, k := range keys {
+				if id := refID(k); products[id] {
+					ids = append(ids, id)
+				}
+			}
+			s[field] = ids
+		}
+		key, _ := s["category"].(string)
+		if len(strings.TrimSpace(key)) == 0 {
+			t.Servers = append(t.Servers, s)
+			continue
+		}
+		// a server of an offline category is offline too
+		if c, ok := categories[refID(key)]; ok {
+			c["servers"] = append(c["servers"].([]Node), s)
+		}
+	}
+	t.Products = sorted(t.Products)
+	t.Direct = sorted(t.Direct)
+
+	body, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(body)
+	t.Body = body
+	t.ETag = `"` + hex.EncodeToString(sum[:10]) + `"`
+	logger.Debugf("Catalog of %s in %s built, etag %s", slug, locale, t.ETag)
+	return t, nil
+}
+
+// ID returns the content id of the node
+func (n Node) ID() int {
+	id, _ := n["id"].(float64)
+	return int(id)
+}
+
+// order returns the order field of the node, 0 when it has none
+func (n Node) order() int {
+	o, _ := n["order"].(float64)
+	return int(o)
+}
+
+// load reads all content of ns with the texts of locale
+func load(ns, locale string) []Node {
+	fields := i18n.Fields(ns)
+	list := []Node{}
+	for _, buf := range contentAll(ns) {
+		n := Node{}
+		if json.Unmarshal(buf, &n) == nil {
+			i18n.Apply(n, fields, locale)
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// withDraft puts the draft into the nodes of ns, in place of the node with
+// the same name or else with the negative pending id so it does not clash
+// with a public id. The draft is shown online and marked with "preview".
+func withDraft(list []Node, ns, locale string, draft *Draft) []Node {
+	if draft == nil || draft.Type != ns {
+		return list
+	}
+	n := Node{}
+	if json.Unmarshal(draft.Data, &n) != nil {
+		return list
+	}
+	i18n.Apply(n, i18n.Fields(ns), locale)
+	n["online"] = true
+	n["preview"] = true
+	delete(n, "unpublished")
+
+	for i, old := range list {
+		if old["name"] != n["name"] {
+			continue
+		}
+		if ns != "Game" && refID(old["game"]) != refID(n["game"]) {
+			continue
+		}
+		n["id"] = old["id"]
+		list[i] = n
+		return list
+	}
+	n["id"] = float64(-draft.ID)
+	return append(list, n)
+}
+
+// findGame returns the online game with slug as item slug or slugname
+func findGame(slug string, load func(ns string) []Node) Node {
+	for _, g := range online(load("Game")) {
+		if g["slug"] == slug || g["slugname"] == slug {
+			return g
+		}
+	}
+	return nil
+}
+
+// refID returns the id of a reference key "id,name", 0 when v has none
+func refID(v interface{}) int {
+	s, _ := v.(string)
+	id, _ := db.ReferenceKey(s)
+	return id
+}
+
+// ofGame keeps the nodes whose game field points to game
+func ofGame(list []Node, game Node) []Node {
+	name, _ := game["name"].(string)
+	result := []Node{}
+	for _, n := range list {
+		key, _ := n["game"].(string)
+		id, ref := db.ReferenceKey(key)
+		if id == game.ID() || (id == 0 && ref == name) {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// online keeps the nodes with online set which are not unpublished
+func online(list []Node) []Node {
+	result := []Node{}
+	for _, n := range list {
+		on, _ := n["online"].(bool)
+		if hidden, _ := n["unpublished"].(bool); on && !hidden {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+func values(m map[int]Node) []Node {
+	list := make([]Node, 0, len(m))
+	for _, n := range m {
+		list = append(list, n)
+	}
+	return list
+}
+
+// sorted orders the nodes by order, name and id
+func sorted(list []Node) []Node {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.order() != b.order() {
+			return a.order() < b.order()
+		}
+		an, _ := a["name"].(string)
+		bn, _ := b["name"].(string)
+		if an != bn {
+			return an < bn
+		}
+		return a.ID() < b.ID()
+	})
+	return list
+}
@@ -0,0 +1,176 @@
+package catalog
+
+import (
+	"testing"
+)
+
+var testContent = map[string][]string{
+	"Game": {
+		`{"id":1,"slug":"game-wow","slugname":"wow","name":"WOW","online":true}`,
+		`{"id":2,"slug":"game-poe","name":"POE","online":false}`,
+	},
+	"Category": {
+		`{"id":1,"name":"US","game":"1,WOW","online":true}`,
+		`{"id":2,"name":"EU","game":"1,WOW","online":true}`,
+		`{"id":3,"name":"PvP","game":"1,WOW","online":true,"belongto":"1,US"}`,
+		`{"id":4,"name":"Old","game":"1,WOW","online":false}`,
+		`{"id":5,"name":"PC","game":"2,POE","online":true}`,
+	},
+	"Server": {
+		`{"id":1,"name":"Stormrage","game":"1,WOW","online":true,"category":"3,PvP","coins":"[\"1,Gold\",\"3,Old Gold\"]","order":2}`,
+		`{"id":2,"name":"Area 52","game":"1,WOW","online":true,"category":"3,PvP","order":1}`,
+		`{"id":3,"name":"Legacy","game":"1,WOW","online":true,"category":"4,Old"}`,
+		`{"id":4,"name":"Classic","game":"1,WOW","online":true}`,
+		`{"id":5,"name":"Down","game":"1,WOW","online":false}`,
+	},
+	"Product": {
+		`{"id":1,"name":"Gold","game":"1,WOW","online":true,"order":2,"discount":"1,Summer"}`,
+		`{"id":2,"name":"Mount","game":"1,WOW","online":true,"order":1,"discount":"2,Winter","variants":[{"sku":"M-EU","price":5,"online":true},{"sku":"M-US","price":5,"online":false}]}`,
+		`{"id":3,"name":"Old Gold","game":"1,WOW","online":false}`,
+	},
+	"DirectGame": {
+		`{"id":1,"name":"Top up","game":"1,WOW"}`,
+	},
+	"Discount": {
+		`{"id":1,"name":"Summer","online":true,"list":"[{\"level\":1,\"qty\":\"20\",\"discount\":\"1\"}]"}`,
+		`{"id":2,"name":"Winter","online":false}`,
+	},
+}
+
+func stubContent(t *testing.T) func() {
+	old := contentAll
+	contentAll = func(ns string) [][]byte {
+		list := [][]byte{}
+		for _, s := range testContent[ns] {
+			list = append(list, []byte(s))
+		}
+		return list
+	}
+	Reset()
+	return func() {
+		contentAll = old
+		Reset()
+	}
+}
+
+func names(list []Node) []string {
+	n := []string{}
+	for _, c := range list {
+		n = append(n, c["name"].(string))
+	}
+	return n
+}
+
+func TestBuild(t *testing.T) {
+	defer stubContent(t)()
+
+	if _, err := Build("game-poe", "en"); err != ErrGameNotFound {
+		t.Errorf("expected offline game not found, got %v", err)
+	}
+	tree, err := Build("wow", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := names(tree.Categories); len(got) != 2 || got[0] != "EU" || got[1] != "US" {
+		t.Errorf("expected categories EU, US, got %v", got)
+	}
+	us := tree.Categories[1]
+	children := us["children"].([]Node)
+	if len(children) != 1 || children[0]["name"] != "PvP" {
+		t.Fatalf("expected PvP under US, got %v", names(children))
+	}
+	servers := children[0]["servers"].([]Node)
+	if got := names(servers); len(got) != 2 || got[0] != "Area 52" || got[1] != "Stormrage" {
+		t.Errorf("expected servers by order, got %v", got)
+	}
+	if coins := servers[1]["coins"].([]int); len(coins) != 1 || coins[0] != 1 {
+		t.Errorf("expected only the online coin, got %v", coins)
+	}
+	if got := names(tree.Servers); len(got) != 1 || got[0] != "Classic" {
+		t.Errorf("expected Classic without category, got %v", got)
+	}
+
+	if got := names(tree.Products); len(got) != 2 || got[0] != "Mount" || got[1] != "Gold" {
+		t.Errorf("expected online products by order, got %v", got)
+	}
+	if d, ok := tree.Products[1]["discount"].(Node); !ok || d["name"] != "Summer" {
+		t.Errorf("expected active discount on Gold, got %v", tree.Products[1]["discount"])
+	}
+	if _, ok := tree.Products[0]["discount"]; ok {
+		t.Errorf("expected inactive discount dropped, got %v", tree.Products[0]["discount"])
+	}
+	if v := tree.Products[0]["variants"].([]interface{}); len(v) != 1 {
+		t.Errorf("expected only the online variant, got %v", v)
+	}
+	if len(tree.Direct) != 1 {
+		t.Errorf("expected 1 direct game, got %v", names(tree.Direct))
+	}
+}
+
+func TestCacheETag(t *testing.T) {
+	defer stubContent(t)()
+
+	a, err := Get("wow", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := Get("wow", "en"); b != a {
+		t.Error("expected cached tree")
+	}
+
+	old := testContent["Product"][1]
+	defer func() { testContent["Product"][1] = old }()
+	testContent["Product"][1] = `{"id":2,"name":"Mount","game":"1,WOW","online":true,"order":1,"price":9}`
+	if b, _ := Get("wow", "en"); b.ETag != a.ETag {
+		t.Error("expected cached etag before reset")
+	}
+	Reset()
+	b, err := Get("wow", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ETag == a.ETag {
+		t.Error("expected new etag after a member changed")
+	}
+}
+
+func TestPreview(t *testing.T) {
+	defer stubContent(t)()
+
+	// an edit of a product replaces the public one
+	tree, err := Preview("wow", "en", &Draft{Type: "Product", ID: 3,
+		Data: []byte(`{"id":3,"name":"Gold","game":"1,WOW","online":false,"order":2,"description":"new text"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(tree.Products); len(got) != 2 || got[1] != "Gold" {
+		t.Fatalf("expected Mount, Gold, got %v", got)
+	}
+	gold := tree.Products[1]
+	if gold.ID() != 1 || gold["description"] != "new text" || gold["preview"] != true {
+		t.Errorf("unexpected draft %v", gold)
+	}
+
+	// a new category is added with the negative pending id
+	tree, err = Preview("wow", "en", &Draft{Type: "Category", ID: 1,
+		Data: []byte(`{"id":1,"name":"Asia","game":"1,WOW"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(tree.Categories); len(got) != 3 || got[0] != "Asia" || tree.Categories[0].ID() != -1 {
+		t.Errorf("expected draft Asia first, got %v", got)
+	}
+
+	// a draft game is found by its slug while offline
+	if _, err = Preview("game-poe", "en", &Draft{Type: "Game", ID: 9,
+		Data: []byte(`{"id":9,"slug":"game-poe","name":"POE","online":false}`)}); err != nil {
+		t.Errorf("expected draft game, got %v", err)
+	}
+
+	// previews are not cached
+	tree, _ = Get("wow", "en")
+	if got := names(tree.Categories); len(got) != 2 {
+		t.Errorf("expected public categories, got %v", got)
+	}
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrAPIKey is returned for a key which is unknown, revoked, expired or
+	// whose secret does not match
+	ErrAPIKey = errors.New("Invalid API key")
+	// ErrAPIKeyIP is returned for a key used from an address not in its list
+	ErrAPIKeyIP = errors.New("API key is not allowed from this address")
+	// ErrAPIKeySpec is returned for creating a key without a name or grants,
+	// or with an address which is neither an IP nor a CIDR range
+	ErrAPIKeySpec = errors.New("API key needs a name, grants and valid addresses")
+)
+
+// APIKeyTouchInterval is how often the last used time of a key is saved
+var APIKeyTouchInterval = time.Minute
+
+// apiKeyNow is the clock of the API keys, replaced in tests
+var apiKeyNow = time.Now
+
+// APIKey lets an integration call the api and admin routes its grants allow
+// with an Authorization: ApiKey <id>.<secret> header. Only the hash of the
+// secret is kept.
+type APIKey struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Hash     string       `json:"hash,omitempty"` // sha256 of the secret
+	Grants   []user.Grant `json:"grants"`
+	IPs      []string     `json:"ips,omitempty"` // addresses or CIDR ranges allowed, any when empty
+	Creator  string       `json:"creator"`
+	Created  int64        `json:"created"`           // unix seconds
+	Expires  int64        `json:"expires,omitempty"` // 0 for a key which does not expire
+	LastUsed int64        `json:"last_used,omitempty"`
+	LastIP   string       `json:"last_ip,omitempty"`
+}
+
+// User returns the user the requests of the key act as
+func (k *APIKey) User() *user.User {
+	return &user.User{
+		Email:   "apikey:" + k.ID,
+		IsAdmin: true,
+		Perm:    user.AdminPermmission,
+		Grants:  k.Grants,
+	}
+}
+
+// allows tells if the key may be used from ip
+func (k *APIKey) allows(ip string) bool {
+	return len(k.IPs) == 0 || addrIn(ip, k.IPs)
+}
+
+// CreateAPIKey saves the new key k with its id, hash and created time set
+// and returns the token of the key, which is not kept and shown only once
+func CreateAPIKey(k *APIKey) (string, error) {
+	k.Name = strings.TrimSpace(k.Name)
+	if len(k.Name) == 0 || len(k.Grants) == 0 {
+		return "", ErrAPIKeySpec
+	}
+	for _, g := range k.Grants {
+		if err := g.Validate(); err != nil {
+			return "", err
+		}
+	}
+	for _, a := range k.IPs {
+		if _, _, err := net.ParseCIDR(a); err != nil && net.ParseIP(a) == nil {
+			return "", ErrAPIKeySpec
+		}
+	}
+
+	id, secret := make([]byte, 6), make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	k.ID = hex.EncodeToString(id)
+	token := k.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = apiKeyHash(token)
+	k.Created = apiKeyNow().Unix()
+	k.LastUsed, k.LastIP = 0, ""
+
+	j, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	err = store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(DB__apikeys))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(k.ID), j)
+	})
+	if err != nil {
+		return "", err
+	}
+	k.Hash = ""
+	return token, nil
+}
+
+// apiKeyHash returns the hash of token kept in the store
+func apiKeyHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeys returns the keys without their hash, the latest created first
+func APIKeys() ([]APIKey, error) {
+	list := []APIKey{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__apikeys))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			k := APIKey{}
+			if err := json.Unmarshal(v, &k); err != nil {
+				return err
+			}
+			k.Hash = ""
+			list = append(list, k)
+			return nil
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Created > list[j].Created })
+	return list, err
+}
+
+// RevokeAPIKey deletes key id, its token no longer passes
+func RevokeAPIKey(id string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__apikeys))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrAPIKey
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// CheckAPIKey returns the key of token used from ip, and saves the time and
+// address it was last used
+func CheckAPIKey(token, ip string) (*APIKey, error) {
+	id := strings.SplitN(token, ".", 2)[0]
+	if store == nil || len(id) == 0 {
+		return nil, ErrAPIKey
+	}
+	now := apiKeyNow().Unix()
+
+	k := &APIKey{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__apikeys))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrAPIKey
+		}
+		return json.Unmarshal(b.Get([]byte(id)), k)
+	})
+	if err != nil {
+		return nil, ErrAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKeyHash(token)), []byte(k.Hash)) != 1 ||
+		(k.Expires > 0 && k.Expires <= now) {
+		logger.Warnf("API key %s refused from %s", id, ip)
+		return nil, ErrAPIKey
+	}
+	if !k.allows(ip) {
+		logger.Warnf("API key %s (%s) used from %s out of its addresses", k.ID, k.Name, ip)
+		return nil, ErrAPIKeyIP
+	}
+
+	if now-k.LastUsed >= int64(APIKeyTouchInterval/time.Second) || k.LastIP != ip {
+		k.LastUsed, k.LastIP = now, ip
+		err = store.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(DB__apikeys))
+			if b == nil || b.Get([]byte(id)) == nil {
+				return nil
+			}
+			j, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(id), j)
+		})
+		if err != nil {
+			logger.Warnf("Touch API key %s error: %s", id, err)
+		}
+	}
+	k.Hash = ""
+	return k, nil
+}
+
+// APIKeyUser returns the user of the API key of the request, with the grants
+// of the key
+func APIKeyUser(req *http.Request) (*user.User, error) {
+	k, err := CheckAPIKey(user.APIKeyToken(req), ClientIP(req))
+	if err != nil {
+		return nil, err
+	}
+	return k.User(), nil
+}
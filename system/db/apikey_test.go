@@ -0,0 +1,80 @@
+package db
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+)
+
+func TestAPIKey(t *testing.T) {
+	defer openTestStore(t, DB__apikeys)()
+	now := time.Unix(1600000000, 0)
+	clock := apiKeyNow
+	apiKeyNow = func() time.Time { return now }
+	defer func() { apiKeyNow = clock }()
+
+	grants := []user.Grant{{Resources: []string{"Order"}, Actions: []string{user.ActionRead, user.ActionModify}}}
+	if _, err := CreateAPIKey(&APIKey{Name: "bot"}); err != ErrAPIKeySpec {
+		t.Errorf("key without grants: %v", err)
+	}
+	if _, err := CreateAPIKey(&APIKey{Name: "bot", Grants: grants, IPs: []string{"intranet"}}); err != ErrAPIKeySpec {
+		t.Errorf("key with a wrong address: %v", err)
+	}
+	bad := []user.Grant{{Resources: []string{"Order"}, Actions: []string{"drop"}}}
+	if _, err := CreateAPIKey(&APIKey{Name: "bot", Grants: bad}); err != user.ErrGrant {
+		t.Errorf("key with an unknown action: %v", err)
+	}
+
+	k := &APIKey{Name: "bot", Grants: grants, IPs: []string{"10.0.0.0/8", "192.168.1.5"},
+		Creator: "owner@eshop.com", Expires: now.Add(time.Hour).Unix()}
+	token, err := CreateAPIKey(k)
+	if err != nil || !strings.HasPrefix(token, k.ID+".") || k.Hash != "" {
+		t.Fatalf("create key: %+v %s %v", k, token, err)
+	}
+
+	// the token passes from the listed addresses only
+	for ip, want := range map[string]error{"10.1.2.3": nil, "192.168.1.5": nil, "192.168.1.6": ErrAPIKeyIP} {
+		if _, err := CheckAPIKey(token, ip); err != want {
+			t.Errorf("key from %s: %v, want %v", ip, err, want)
+		}
+	}
+	if _, err := CheckAPIKey(token[:len(token)-1]+"x", "10.1.2.3"); err != ErrAPIKey {
+		t.Errorf("changed secret: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/v1/contents?type=Order", nil)
+	req.RemoteAddr = "10.9.9.9:4000"
+	req.Header.Set("Authorization", "ApiKey "+token)
+	usr, err := APIKeyUser(req)
+	if err != nil || usr.StaffRole() != user.RoleAPIKey || !usr.Can("Order", user.ActionModify) || usr.Can("Order", user.ActionDelete) {
+		t.Fatalf("key user: %+v %v", usr, err)
+	}
+	if j, err := CurrentUser(req); err != nil || !strings.Contains(string(j), "apikey:"+k.ID) {
+		t.Errorf("current user of the key: %s %v", j, err)
+	}
+
+	// the last use is listed without the hash
+	list, err := APIKeys()
+	if err != nil || len(list) != 1 || list[0].Hash != "" || list[0].LastIP != "10.9.9.9" || list[0].LastUsed != now.Unix() {
+		t.Errorf("unexpected keys %+v %v", list, err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := CheckAPIKey(token, "10.1.2.3"); err != ErrAPIKey {
+		t.Errorf("expired key: %v", err)
+	}
+	now = now.Add(-2 * time.Hour)
+
+	if err := RevokeAPIKey(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckAPIKey(token, "10.1.2.3"); err != ErrAPIKey {
+		t.Errorf("revoked key: %v", err)
+	}
+	if err := RevokeAPIKey(k.ID); err != ErrAPIKey {
+		t.Errorf("revoke twice: %v", err)
+	}
+}
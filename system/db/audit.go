@@ -0,0 +1,210 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Actions of the audit log
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditApprove = "approve"
+	AuditReject  = "reject"
+	AuditRestore = "restore"
+)
+
+// Audit types besides the content types
+const (
+	AuditConfig = "config"
+	AuditUser   = "user"
+	AuditAPIKey = "apikey"
+)
+
+// Actors of the changes not made by a login user
+const (
+	ActorSystem = "system"
+	ActorCLI    = "cli"
+)
+
+// auditSecrets are the fields whose values are not written to the audit log,
+// only that they changed
+var auditSecrets = map[string]bool{
+	"hash": true, "Salt": true, "password": true, "two_factor": true,
+	"client_secret": true, "email_password": true, "backup_basic_auth_password": true,
+}
+
+// auditPersonal are the fields of customer data, which are not written to the
+// audit log either. The email fields, true here, are written as the AnonID of
+// the email, the id the records of the account keep once it is erased. Other
+// values are hidden like secrets.
+var auditPersonal = map[string]bool{
+	"email": true, "user": true, "payer": true, "buyer_email": true, "recipient_email": true,
+	"ip": false, "payer_link": false, "notify_info": false, "pending_info": false,
+	"name": false, "phone": false, "address": false, "message": false,
+}
+
+// auditCustomer are the types of customer records, the auditPersonal fields
+// are hidden only in them. The catalog keeps its names in clear.
+var auditCustomer = map[string]bool{
+	AuditUser: true, "Order": true, "Carts": true, ReviewType: true, "GiftCard": true,
+}
+
+// auditNow is the clock of the audit log, replaced in tests
+var auditNow = time.Now
+
+// AuditEntry is one change in the audit log. The log is only appended to.
+type AuditEntry struct {
+	ID      int           `json:"id"`
+	Time    int64         `json:"time"`  // unix seconds
+	Actor   string        `json:"actor"` // email, apikey:<id>, cli or system
+	IP      string        `json:"ip,omitempty"`
+	Action  string        `json:"action"`
+	Type    string        `json:"type"`             // content type, config, user or apikey
+	Target  string        `json:"target,omitempty"` // id of the changed item
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// AuditFilter selects audit entries, the empty fields match any
+type AuditFilter struct {
+	Actor  string
+	IP     string
+	Action string
+	Type   string
+	Target string
+	Since  int64 // unix seconds
+	Until  int64
+	Offset int
+	Limit  int // 0 for all
+}
+
+// matches tells if e is selected by f, an email target selects the entries
+// of its AnonID
+func (f *AuditFilter) matches(e *AuditEntry) bool {
+	return (f.Actor == "" || strings.EqualFold(f.Actor, e.Actor)) &&
+		(f.IP == "" || f.IP == e.IP) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.Type == "" || strings.Split(f.Type, "__")[0] == strings.Split(e.Type, "__")[0]) &&
+		(f.Target == "" || f.Target == e.Target || f.Target == anonEmail(e.Target) || anonEmail(f.Target) == e.Target) &&
+		(f.Since == 0 || e.Time >= f.Since) &&
+		(f.Until == 0 || e.Time < f.Until)
+}
+
+// Audit appends e to the audit log with the changes from before to after, the
+// json of the item before and after the change, nil when it did not exist. A
+// user target is written as its AnonID and the personal fields of customer
+// records are hidden.
+func Audit(e AuditEntry, before, after []byte) error {
+	e.Time = auditNow().Unix()
+	if len(e.Actor) == 0 {
+		e.Actor = ActorSystem
+	}
+	if e.Type == AuditUser {
+		e.Target = anonEmail(e.Target)
+	}
+	customer := auditCustomer[strings.Split(e.Type, "__")[0]]
+	e.Changes = []FieldChange{}
+	for _, c := range diffFields(before, after) {
+		if c.Field == "etag" {
+			continue
+		}
+		if auditSecrets[c.Field] {
+			c.From, c.To = redact(c.From), redact(c.To)
+		} else if email, ok := auditPersonal[c.Field]; ok && customer {
+			c.From, c.To = pseudonym(c.From, email), pseudonym(c.To, email)
+		}
+		e.Changes = append(e.Changes, c)
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(DB__audit))
+		if err != nil {
+			return err
+		}
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = int(id)
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(e.ID), j)
+	})
+}
+
+// redact hides a secret value, keeping if it was set
+func redact(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return "***"
+}
+
+// anonEmail returns the AnonID of an email, other values as they are
+func anonEmail(v string) string {
+	if !strings.Contains(v, "@") || strings.HasPrefix(v, "erased-") {
+		return v
+	}
+	return AnonID(v)
+}
+
+// pseudonym hides a personal value, the value of an email field is replaced
+// by its AnonID
+func pseudonym(v interface{}, email bool) interface{} {
+	if s, ok := v.(string); ok && email && strings.Contains(s, "@") {
+		return anonEmail(s)
+	}
+	return redact(v)
+}
+
+// AuditContent appends the change of content target by actor from ip to the
+// audit log, before is the item before the change and the item after is read
+// from the store
+func AuditContent(actor, ip, action, target string, before []byte) {
+	t := strings.SplitN(target, ":", 2)
+	if len(t) != 2 {
+		return
+	}
+	after, _ := Content(target)
+	if len(after) == 0 {
+		after = nil
+	}
+	if err := Audit(AuditEntry{Actor: actor, IP: ip, Action: action, Type: t[0], Target: t[1]}, before, after); err != nil {
+		logger.Errorf("Audit %s of %s by %s error: %s", action, target, actor, err)
+	}
+}
+
+// AuditLog returns the entries selected by f, the latest first, and how many
+// entries are selected in all
+func AuditLog(f AuditFilter) ([]AuditEntry, int, error) {
+	list := []AuditEntry{}
+	total := 0
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__audit))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			e := AuditEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if !f.matches(&e) {
+				continue
+			}
+			total++
+			if total > f.Offset && (f.Limit == 0 || len(list) < f.Limit) {
+				list = append(list, e)
+			}
+		}
+		return nil
+	})
+	return list, total, err
+}
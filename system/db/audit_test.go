@@ -0,0 +1,119 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	defer openTestStore(t, DB__audit)()
+	now := time.Unix(1600000000, 0)
+	clock := auditNow
+	auditNow = func() time.Time { return now }
+	defer func() { auditNow = clock }()
+
+	before := []byte(`{"name":"a","hash":"x","etag":"1","updated":"1"}`)
+	after := []byte(`{"name":"b","hash":"y","etag":"2","updated":"2"}`)
+	if err := Audit(AuditEntry{Actor: "owner@eshop.com", IP: "10.0.0.1", Action: AuditUpdate, Type: AuditUser, Target: "a@eshop.com"}, before, after); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	if err := Audit(AuditEntry{Action: AuditUpdate, Type: "Order", Target: "3"},
+		[]byte(`{"status":"paid","user":"a@eshop.com","ip":"1.1.1.1"}`), []byte(`{"status":"sent","user":"b@eshop.com","ip":"2.2.2.2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	if err := Audit(AuditEntry{Actor: ActorCLI, Action: AuditDelete, Type: "Order__pending", Target: "4"}, []byte(`{"status":"paid"}`), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	list, total, err := AuditLog(AuditFilter{})
+	if err != nil || total != 3 || len(list) != 3 || list[0].ID != 3 || list[2].ID != 1 {
+		t.Fatalf("unexpected log %+v %d %v", list, total, err)
+	}
+	// secrets are hidden and the etag and updated fields left out
+	first := list[2]
+	if first.Time != 1600000000 || len(first.Changes) != 2 {
+		t.Fatalf("unexpected entry %+v", first)
+	}
+	for _, c := range first.Changes {
+		if c.Field == "hash" && (c.From != "***" || c.To != "***") {
+			t.Errorf("secret written to the log: %+v", c)
+		}
+	}
+	if list[1].Actor != ActorSystem || list[0].Changes[0].To != nil {
+		t.Errorf("unexpected entries %+v", list[:2])
+	}
+	// customer data is left out, an email is kept as its anonymous id
+	if first.Target != AnonID("a@eshop.com") {
+		t.Errorf("user target %s", first.Target)
+	}
+	for _, c := range list[1].Changes {
+		switch c.Field {
+		case "user":
+			if c.From != AnonID("a@eshop.com") || c.To != AnonID("b@eshop.com") {
+				t.Errorf("email written to the log: %+v", c)
+			}
+		case "ip":
+			if c.From != "***" || c.To != "***" {
+				t.Errorf("address written to the log: %+v", c)
+			}
+		}
+	}
+
+	cases := []struct {
+		f    AuditFilter
+		ids  []int
+		want int
+	}{
+		{AuditFilter{Actor: "OWNER@eshop.com"}, []int{1}, 1},
+		{AuditFilter{Type: "Order"}, []int{3, 2}, 2},
+		{AuditFilter{Action: AuditDelete}, []int{3}, 1},
+		{AuditFilter{Target: "3"}, []int{2}, 1},
+		{AuditFilter{Target: "a@eshop.com"}, []int{1}, 1},
+		{AuditFilter{Since: 1600003600, Until: 1600007200}, []int{2}, 1},
+		{AuditFilter{Offset: 1, Limit: 1}, []int{2}, 3},
+	}
+	for _, c := range cases {
+		list, total, err := AuditLog(c.f)
+		if err != nil || total != c.want || len(list) != len(c.ids) {
+			t.Errorf("filter %+v: %+v %d %v", c.f, list, total, err)
+			continue
+		}
+		for i, id := range c.ids {
+			if list[i].ID != id {
+				t.Errorf("filter %+v: entry %d is %d, want %d", c.f, i, list[i].ID, id)
+			}
+		}
+	}
+}
+
+func TestAuditCatalog(t *testing.T) {
+	defer openTestStore(t, DB__audit)()
+
+	// a catalog rename is logged in clear, the same fields of a review are not
+	if err := Audit(AuditEntry{Action: AuditUpdate, Type: "Product", Target: "1"},
+		[]byte(`{"name":"Gold 100","message":"old"}`), []byte(`{"name":"Gold 200","message":"new"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Audit(AuditEntry{Action: AuditUpdate, Type: ReviewType + "__pending", Target: "2"},
+		[]byte(`{"name":"Ann"}`), []byte(`{"name":"Bob"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	list, _, err := AuditLog(AuditFilter{})
+	if err != nil || len(list) != 2 {
+		t.Fatalf("unexpected log %+v %v", list, err)
+	}
+	for _, c := range list[1].Changes {
+		if c.Field == "name" && (c.From != "Gold 100" || c.To != "Gold 200") {
+			t.Errorf("product rename logged as %+v", c)
+		}
+		if c.Field == "message" && (c.From != "old" || c.To != "new") {
+			t.Errorf("product message logged as %+v", c)
+		}
+	}
+	if c := list[0].Changes; len(c) != 1 || c[0].From != "***" || c[0].To != "***" {
+		t.Errorf("review name logged as %+v", c)
+	}
+}
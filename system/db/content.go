@@ -41,9 +41,18 @@ func IsValidID(id string) bool {
 // SetContent inserts/replaces values in the database.
 // The `target` argument is a string made up of namespace:id (string:int)
 func SetContent(target string, data url.Values) (int, error) {
+	return SetContentBy(target, data, "")
+}
+
+// SetContentBy is SetContent recording author on the revision of the save
+func SetContentBy(target string, data url.Values, author string) (int, error) {
 	t := strings.Split(target, ":")
 	ns, id := t[0], t[1]
 
+	if err := CheckReferences(ns, data); err != nil {
+		return 0, err
+	}
+
 	// check if content id == -1 (indicating new post).
 	// if so, run an insert which will assign the next auto incremented int.
 	// this is done because boltdb begins its bucket auto increment value at 0,
@@ -51,10 +60,10 @@ func SetContent(target string, data url.Values) (int, error) {
 	// this is a problem when the original first post (with auto ID = 0) gets
 	// overwritten by any new post, originally having no ID, defauting to 0.
 	if id == "-1" {
-		return insert(ns, data)
+		return insert(ns, data, author)
 	}
 
-	return update(ns, id, data, nil)
+	return update(ns, id, data, nil, author)
 }
 
 // SetSubContent to set the sub bucket data
@@ -108,6 +117,12 @@ func SetSubContent(target, field string, data []map[string]interface{}) (int, er
 // UpdateContent updates/merges values in the database.
 // The `target` argument is a string made up of namespace:id (string:int)
 func UpdateContent(target string, data url.Values) (int, error) {
+	return UpdateContentBy(target, data, "")
+}
+
+// UpdateContentBy is UpdateContent recording author on the revision of the
+// save
+func UpdateContentBy(target string, data url.Values, author string) (int, error) {
 	t := strings.Split(target, ":")
 	ns, id := t[0], t[1]
 
@@ -115,19 +130,23 @@ func UpdateContent(target string, data url.Values) (int, error) {
 		return 0, fmt.Errorf("Invalid ID in target for UpdateContent: %s", target)
 	}
 
+	if err := CheckReferences(ns, data); err != nil {
+		return 0, err
+	}
+
 	// retrieve existing content from the database
 	existingContent, err := Content(target)
 	if err != nil {
 		return 0, err
 	}
-	return update(ns, id, data, &existingContent)
+	return update(ns, id, data, &existingContent, author)
 }
 
 // update can support merge or replace behavior depending on existingContent.
 // if existingContent is non-nil, we merge field values. empty/missing fields are ignored.
 // if existingContent is nil, we replace field values. empty/missing fields are reset.
 // if data has --remove-- 字串，表示该内容删除，
-func update(ns, id string, data url.Values, existingContent *[]byte) (int, error) {
+func update(ns, id string, data url.Values, existingContent *[]byte, author string) (int, error) {
 	var specifier string // i.e. __pending, __sorted, etc.
 	if strings.Contains(ns, "__") {
 		spec := strings.Split(ns, "__")
@@ -154,17 +173,38 @@ func update(ns, id string, data url.Values, existingContent *[]byte) (int, error
 	}
 	//logger.Debug(fmt.Sprintf("%+v", existingContent))
 
-	err = store.Update(func(tx *bolt.Tx) error {
+	return save(ns, specifier, cid, j, author, 0)
+}
+
+// save puts the json j of content cid and records its revision. restored is
+// the revision j was restored from, 0 for an edit.
+func save(ns, specifier string, cid int, j []byte, author string, restored int) (int, error) {
+	target := fmt.Sprintf("%s:%d", ns, cid)
+	limit := 0
+	if specifier == "" {
+		limit = revisionLimit(ns)
+	}
+
+	var before []byte
+	err := store.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
 		if err != nil {
 			return err
 		}
 
+		// keep a copy of the previous value for content watchers
+		if v := b.Get([]byte(fmt.Sprintf("%d", cid))); v != nil {
+			before = append([]byte{}, v...)
+		}
+
 		err = b.Put([]byte(fmt.Sprintf("%d", cid)), j)
 		if err != nil {
 			return err
 		}
 
+		if specifier == "" {
+			return addRevision(tx, target, before, j, author, restored, limit)
+		}
 		return nil
 	})
 	if err != nil {
@@ -173,6 +213,10 @@ func update(ns, id string, data url.Values, existingContent *[]byte) (int, error
 
 	if specifier == "" {
 		go SortContent(ns)
+		notifyWatchers(ns, target, before, j)
+		if before != nil {
+			go followRename(ns, before, j)
+		}
 	}
 
 	// update changes data, so invalidate client caching
@@ -183,8 +227,7 @@ func update(ns, id string, data url.Values, existingContent *[]byte) (int, error
 
 	go func() {
 		// update data in search index
-		target := fmt.Sprintf("%s:%s", ns, id)
-		err = search.UpdateIndex(target, j)
+		err := search.UpdateIndex(target, j)
 		if err != nil {
 			logger.Error("[search] UpdateIndex Error:", err)
 		}
@@ -221,6 +264,9 @@ func mergeData(ns string, data url.Values, existingContent []byte) ([]byte, erro
 	if err != nil {
 		return j, err
 	}
+	if err = validate(s); err != nil {
+		return j, err
+	}
 
 	j, err = json.Marshal(s)
 	if err != nil {
@@ -233,7 +279,7 @@ func mergeData(ns string, data url.Values, existingContent []byte) ([]byte, erro
 	return j, nil
 }
 
-func insert(ns string, data url.Values) (int, error) {
+func insert(ns string, data url.Values, author string) (int, error) {
 	var effectedID int
 	var specifier string // i.e. __pending, __sorted, etc.
 
@@ -243,6 +289,11 @@ func insert(ns string, data url.Values) (int, error) {
 		specifier = "__" + spec[1]
 	}
 
+	limit := 0
+	if specifier == "" {
+		limit = revisionLimit(ns)
+	}
+
 	var j []byte
 	var cid string
 	err := store.Update(func(tx *bolt.Tx) error {
@@ -302,6 +353,8 @@ func insert(ns string, data url.Values) (int, error) {
 			if err != nil {
 				return err
 			}
+
+			return addRevision(tx, string(v), nil, j, author, 0, limit)
 		}
 
 		return nil
@@ -312,6 +365,7 @@ func insert(ns string, data url.Values) (int, error) {
 
 	if specifier == "" {
 		go SortContent(ns)
+		notifyWatchers(ns, fmt.Sprintf("%s:%s", ns, cid), nil, j)
 	}
 
 	// insert changes data, so invalidate client caching
@@ -353,6 +407,13 @@ func DeleteContent(target string) error {
 		return err
 	}
 
+	if !strings.Contains(ns, "__") {
+		err = deleteReferences(ns, id, b)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = store.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(ns))
 		if b == nil {
@@ -377,12 +438,19 @@ func DeleteContent(target string) error {
 			}
 		}
 
+		if !strings.Contains(ns, "__") {
+			return deleteRevisions(tx, target)
+		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
 
+	if !strings.Contains(ns, "__") {
+		notifyWatchers(ns, target, b, nil)
+	}
+
 	// delete changes data, so invalidate client caching
 	err = InvalidateCache()
 	if err != nil {
@@ -1200,6 +1268,9 @@ func postToJSON(ns string, data url.Values) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = validate(post); err != nil {
+		return nil, err
+	}
 
 	// if the content has no slug, and has no specifier, create a slug, check it
 	// for duplicates, and add it to our values
@@ -1227,6 +1298,21 @@ func postToJSON(ns string, data url.Values) ([]byte, error) {
 	return j, nil
 }
 
+// ErrInvalidContent is returned when a content fails its Validate check
+var ErrInvalidContent = errors.New("Error. Invalid content.")
+
+// validate runs the Validate check of a content implementing item.Validatable
+func validate(post interface{}) error {
+	v, ok := post.(item.Validatable)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("%w %s", ErrInvalidContent, err)
+	}
+	return nil
+}
+
 func checkSlugForDuplicate(slug string) (string, error) {
 	// check for existing slug in __contentIndex
 	err := store.View(func(tx *bolt.Tx) error {
@@ -19,11 +19,27 @@ var (
 	DB__uploads      = "eshop__uploads"
 	DB__contentIndex = "eshop__contentIndex"
 	DB__addons       = "eshop__addons"
+	DB__wallets      = "eshop__wallets"
+	DB__points       = "eshop__points"
+	DB__referrals    = "eshop__referrals"
+	DB__revisions    = "eshop__revisions"
+	DB__previews     = "eshop__previews"
+	DB__sessions     = "eshop__sessions"
+	DB__logins       = "eshop__logins"
+	DB__oidc         = "eshop__oidc"
+	DB__erasures     = "eshop__erasures"
+	DB__apikeys      = "eshop__apikeys"
+	DB__audit        = "eshop__audit"
 
 	buckets = []string{
 		"eshop__config", "eshop__users",
 		"eshop__addons", "eshop__uploads",
-		"eshop__contentIndex",
+		"eshop__contentIndex", "eshop__wallets",
+		"eshop__points", "eshop__referrals",
+		"eshop__revisions", "eshop__previews",
+		"eshop__sessions", "eshop__logins",
+		"eshop__oidc", "eshop__erasures",
+		"eshop__apikeys", "eshop__audit",
 	}
 
 	bucketsToAdd []string
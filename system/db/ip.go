@@ -0,0 +1,78 @@
+package db
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the address of the client of req. The X-Forwarded-For and
+// X-Real-Ip headers are taken only from a proxy of the trusted_proxies
+// setting or of the loopback, anyone else could set them to any address. The
+// forwarded addresses are read from the last, the first one which is not a
+// trusted proxy is the client.
+func ClientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+	if err != nil {
+		ip = strings.TrimSpace(req.RemoteAddr)
+	}
+	proxies := trustedProxies()
+	if !trustedProxy(ip, proxies) {
+		return ip
+	}
+
+	forwarded := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if len(addr) == 0 {
+			continue
+		}
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		ip = addr
+		if !trustedProxy(addr, proxies) {
+			return ip
+		}
+	}
+	if addr := strings.TrimSpace(req.Header.Get("X-Real-Ip")); net.ParseIP(addr) != nil && trustedProxy(ip, proxies) {
+		return addr
+	}
+	return ip
+}
+
+// trustedProxies returns the addresses and CIDR ranges of the
+// trusted_proxies setting, a comma list
+func trustedProxies() []string {
+	v, _ := ConfigCache("trusted_proxies").(string)
+	list := []string{}
+	for _, a := range strings.Split(v, ",") {
+		if a = strings.TrimSpace(a); len(a) > 0 {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+func trustedProxy(ip string, proxies []string) bool {
+	if addr := net.ParseIP(ip); addr != nil && addr.IsLoopback() {
+		return true
+	}
+	return addrIn(ip, proxies)
+}
+
+// addrIn tells if ip is one of the addresses or in one of the CIDR ranges of
+// list
+func addrIn(ip string, list []string) bool {
+	addr := net.ParseIP(ip)
+	for _, a := range list {
+		if _, n, err := net.ParseCIDR(a); err == nil {
+			if addr != nil && n.Contains(addr) {
+				return true
+			}
+		} else if a == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{"trusted_proxies": "10.0.0.0/8, 192.168.1.1"})()
+
+	cases := []struct {
+		remote, forwarded, real, want string
+	}{
+		{"203.0.113.7:4000", "1.2.3.4", "5.6.7.8", "203.0.113.7"},
+		{"10.0.0.2:4000", "", "", "10.0.0.2"},
+		{"10.0.0.2:4000", "1.2.3.4, 203.0.113.7", "", "203.0.113.7"},
+		{"10.0.0.2:4000", "1.2.3.4, 203.0.113.7, 192.168.1.1", "", "203.0.113.7"},
+		{"127.0.0.1:4000", "", "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.2:4000", "10.1.1.1", "203.0.113.7", "203.0.113.7"},
+		{"10.0.0.2:4000", "bogus, 10.1.1.1", "", "10.1.1.1"},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = c.remote
+		if len(c.forwarded) > 0 {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if len(c.real) > 0 {
+			req.Header.Set("X-Real-Ip", c.real)
+		}
+		if ip := ClientIP(req); ip != c.want {
+			t.Errorf("%+v: got %s", c, ip)
+		}
+	}
+}
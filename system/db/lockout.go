@@ -0,0 +1,272 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrLoginLocked is returned for a login or recovery while its account or
+	// address waits after failures
+	ErrLoginLocked = errors.New("Too many failed attempts, try again later")
+	// ErrResetLimit is returned for too many password reset requests
+	ErrResetLimit = errors.New("Too many password reset requests, try again later")
+	// ErrVerifyLimit is returned for too many verification email requests
+	ErrVerifyLimit = errors.New("Too many verification emails, try again later")
+	// ErrNoLoginLock is returned for unlocking an account or address without
+	// failures
+	ErrNoLoginLock = errors.New("No failures recorded")
+)
+
+var (
+	// LoginFreeFailures is how many failures pass before a login waits
+	LoginFreeFailures = 3
+	// LoginBackoff is the wait after the first failure over the free ones,
+	// doubled with each failure after it up to LoginMaxBackoff
+	LoginBackoff    = time.Second
+	LoginMaxBackoff = 15 * time.Minute
+	// LoginLockFailures is how many failures lock an account, and
+	// LoginIPLockFailures an address, for LoginLockout
+	LoginLockFailures   = 10
+	LoginIPLockFailures = 50
+	LoginLockout        = 30 * time.Minute
+	// LoginFailureWindow is how long a failure counts
+	LoginFailureWindow = 24 * time.Hour
+
+	// ResetsPerHour is how many password resets, or verification emails, an
+	// account, and ResetsPerIPHour an address, can ask in an hour
+	ResetsPerHour   = 3
+	ResetsPerIPHour = 10
+
+	// loginNow is the clock of the login counters, replaced in tests
+	loginNow = time.Now
+)
+
+// LoginLock is the failure counter of an account or an address
+type LoginLock struct {
+	Key         string `json:"key"`      // email:x or ip:x
+	Failures    int    `json:"failures"` // failures or reset requests
+	Last        int64  `json:"last"`     // unix seconds of the last one
+	LockedUntil int64  `json:"locked_until,omitempty"`
+}
+
+// wait returns how long the counter has to wait at now
+func (l *LoginLock) wait(now time.Time) time.Duration {
+	if l.LockedUntil > now.Unix() {
+		return time.Unix(l.LockedUntil, 0).Sub(now)
+	}
+	over := l.Failures - LoginFreeFailures
+	if over <= 0 || l.LockedUntil > 0 {
+		return 0
+	}
+	backoff := LoginMaxBackoff
+	if over < 32 && LoginBackoff<<uint(over-1) < LoginMaxBackoff {
+		backoff = LoginBackoff << uint(over-1)
+	}
+	if d := time.Unix(l.Last, 0).Add(backoff).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func loginKeys(email, ip string) []string {
+	keys := []string{}
+	if email = strings.ToLower(strings.TrimSpace(email)); len(email) > 0 {
+		keys = append(keys, "email:"+email)
+	}
+	if len(ip) > 0 {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// getLoginLock reads counter key, an expired one is empty
+func getLoginLock(b *bolt.Bucket, key string, window time.Duration, now time.Time) *LoginLock {
+	l := &LoginLock{Key: key}
+	if v := b.Get([]byte(key)); v != nil {
+		json.Unmarshal(v, l)
+	}
+	if l.LockedUntil <= now.Unix() && now.Sub(time.Unix(l.Last, 0)) > window {
+		return &LoginLock{Key: key}
+	}
+	return l
+}
+
+func putLoginLock(b *bolt.Bucket, l *LoginLock) error {
+	j, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(l.Key), j)
+}
+
+// CheckLogin tells if a login of email from ip may be tried now, it returns
+// ErrLoginLocked and the wait when it may not
+func CheckLogin(email, ip string) (time.Duration, error) {
+	now := loginNow()
+	var wait time.Duration
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__logins))
+		if b == nil {
+			return nil
+		}
+		for _, key := range loginKeys(email, ip) {
+			if d := getLoginLock(b, key, LoginFailureWindow, now).wait(now); d > wait {
+				wait = d
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if wait > 0 {
+		return wait, ErrLoginLocked
+	}
+	return 0, nil
+}
+
+// LoginFailed counts a failed login or recovery of email from ip, the account
+// or the address is locked after too many
+func LoginFailed(email, ip string) error {
+	now := loginNow()
+	return store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(DB__logins))
+		if err != nil {
+			return err
+		}
+		for _, key := range loginKeys(email, ip) {
+			l := getLoginLock(b, key, LoginFailureWindow, now)
+			if l.LockedUntil > 0 && l.LockedUntil <= now.Unix() {
+				// a lock is over, count again
+				l = &LoginLock{Key: key}
+			}
+			l.Failures++
+			l.Last = now.Unix()
+
+			limit := LoginLockFailures
+			if strings.HasPrefix(key, "ip:") {
+				limit = LoginIPLockFailures
+			}
+			if l.Failures >= limit && l.LockedUntil == 0 {
+				l.LockedUntil = now.Add(LoginLockout).Unix()
+				logger.Warnf("Login %s locked until %s after %d failures, last from %s (%s)",
+					key, time.Unix(l.LockedUntil, 0).Format(time.RFC3339), l.Failures, ip, email)
+			}
+			if err := putLoginLock(b, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoginSucceeded clears the failures of email after a login
+func LoginSucceeded(email string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__logins))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte("email:" + strings.ToLower(email)))
+	})
+}
+
+// ThrottleReset counts a password reset request of email from ip, it returns
+// ErrResetLimit when there were too many in the last hour
+func ThrottleReset(email, ip string) error {
+	return throttleRequest("reset:", email, ip, ErrResetLimit)
+}
+
+// ThrottleVerify counts a request of a new verification email of email from
+// ip, it returns ErrVerifyLimit when there were too many in the last hour
+func ThrottleVerify(email, ip string) error {
+	return throttleRequest("verify:", email, ip, ErrVerifyLimit)
+}
+
+// throttleRequest counts a request of kind, returning limit when email or ip
+// asked ResetsPerHour or ResetsPerIPHour times in the last hour
+func throttleRequest(kind, email, ip string, limit error) error {
+	now := loginNow()
+	return store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(DB__logins))
+		if err != nil {
+			return err
+		}
+		locks := []*LoginLock{}
+		for _, key := range loginKeys(email, ip) {
+			l := getLoginLock(b, kind+key, time.Hour, now)
+			max := ResetsPerHour
+			if strings.HasPrefix(key, "ip:") {
+				max = ResetsPerIPHour
+			}
+			if l.Failures >= max {
+				logger.Warnf("Request %s%s from %s refused, %d requests in an hour", kind, email, ip, l.Failures)
+				return limit
+			}
+			locks = append(locks, l)
+		}
+		for _, l := range locks {
+			l.Failures++
+			l.Last = now.Unix()
+			if err := putLoginLock(b, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoginLocks returns the accounts and addresses which wait now, the latest
+// failure first
+func LoginLocks() ([]LoginLock, error) {
+	now := loginNow()
+	list := []LoginLock{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__logins))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			// skip the reset: and verify: request counters
+			if !strings.HasPrefix(string(k), "email:") && !strings.HasPrefix(string(k), "ip:") {
+				return nil
+			}
+			l := getLoginLock(b, string(k), LoginFailureWindow, now)
+			if l.wait(now) > 0 {
+				list = append(list, *l)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Last > list[j].Last })
+	return list, err
+}
+
+// UnlockLogin clears the failures of an account, email:x, or an address,
+// ip:x, by admin
+func UnlockLogin(key, admin, ip string) error {
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__logins))
+		if b == nil || b.Get([]byte(key)) == nil {
+			return ErrNoLoginLock
+		}
+		if err := b.Delete([]byte(key)); err != nil {
+			return err
+		}
+		if err := b.Delete([]byte("reset:" + key)); err != nil {
+			return err
+		}
+		return b.Delete([]byte("verify:" + key))
+	})
+	if err != nil {
+		return err
+	}
+	logger.Warnf("Login %s unlocked by %s from %s", key, admin, ip)
+	return nil
+}
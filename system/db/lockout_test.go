@@ -0,0 +1,122 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoffAndLockout(t *testing.T) {
+	defer openTestStore(t, DB__logins)()
+	now := time.Unix(1600000000, 0)
+	clock := loginNow
+	loginNow = func() time.Time { return now }
+	defer func() { loginNow = clock }()
+
+	email, ip := "buyer@example.com", "10.0.0.1"
+	for i := 0; i < LoginFreeFailures; i++ {
+		if _, err := CheckLogin(email, ip); err != nil {
+			t.Fatalf("failure %d: %v", i, err)
+		}
+		LoginFailed(email, ip)
+	}
+
+	// each failure after the free ones doubles the wait
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		LoginFailed(email, ip)
+		wait, err := CheckLogin(email, ip)
+		if err != ErrLoginLocked || wait != want {
+			t.Fatalf("backoff %d: %s %v, want %s", i, wait, err, want)
+		}
+		// the address is counted too
+		if wait, _ := CheckLogin("other@example.com", ip); wait != want {
+			t.Errorf("address backoff %d: %s, want %s", i, wait, want)
+		}
+		now = now.Add(want)
+		if _, err := CheckLogin(email, ip); err != nil {
+			t.Errorf("expected the login to pass after the wait, %v", err)
+		}
+	}
+
+	// the account locks after LoginLockFailures
+	for i := LoginFreeFailures + 3; i < LoginLockFailures; i++ {
+		LoginFailed(email, "10.0.0.2")
+	}
+	wait, err := CheckLogin(email, "10.0.0.3")
+	if err != ErrLoginLocked || wait != LoginLockout {
+		t.Fatalf("lockout: %s %v", wait, err)
+	}
+	locks, _ := LoginLocks()
+	if len(locks) != 2 || locks[0].Key != "email:"+email {
+		t.Errorf("locks %+v", locks)
+	}
+
+	now = now.Add(LoginLockout)
+	if _, err := CheckLogin(email, "10.0.0.3"); err != nil {
+		t.Errorf("expected the lockout to end, %v", err)
+	}
+	LoginFailed(email, "10.0.0.3")
+	if _, err := CheckLogin(email, "10.0.0.3"); err != nil {
+		t.Errorf("expected the count to start again after a lockout, %v", err)
+	}
+
+	// a login clears the account but not the address, unlock clears both
+	LoginSucceeded(email)
+	if _, err := CheckLogin(email, ""); err != nil {
+		t.Errorf("expected a login to clear the account, %v", err)
+	}
+	for i := 0; i < LoginFreeFailures+1; i++ {
+		LoginFailed(email, "")
+	}
+	if err := UnlockLogin("email:"+email, "owner@eshop.com", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckLogin(email, ""); err != nil {
+		t.Errorf("expected unlock to clear the account, %v", err)
+	}
+	if err := UnlockLogin("email:"+email, "owner@eshop.com", "127.0.0.1"); err != ErrNoLoginLock {
+		t.Errorf("unlock twice: %v", err)
+	}
+
+	// old failures are forgotten
+	LoginFailed(email, "")
+	LoginFailed(email, "")
+	LoginFailed(email, "")
+	LoginFailed(email, "")
+	now = now.Add(LoginFailureWindow + time.Second)
+	if _, err := CheckLogin(email, ""); err != nil {
+		t.Errorf("expected old failures to be forgotten, %v", err)
+	}
+}
+
+func TestThrottleReset(t *testing.T) {
+	defer openTestStore(t, DB__logins)()
+	now := time.Unix(1600000000, 0)
+	clock := loginNow
+	loginNow = func() time.Time { return now }
+	defer func() { loginNow = clock }()
+
+	email := "buyer@example.com"
+	for i := 0; i < ResetsPerHour; i++ {
+		if err := ThrottleReset(email, "10.0.0.1"); err != nil {
+			t.Fatalf("reset %d: %v", i, err)
+		}
+	}
+	if err := ThrottleReset(email, "10.0.0.2"); err != ErrResetLimit {
+		t.Errorf("reset over the account limit: %v", err)
+	}
+
+	// the address limit covers many accounts
+	for i := ResetsPerHour; i < ResetsPerIPHour; i++ {
+		if err := ThrottleReset(string(rune('a'+i))+"@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("reset %d: %v", i, err)
+		}
+	}
+	if err := ThrottleReset("new@example.com", "10.0.0.1"); err != ErrResetLimit {
+		t.Errorf("reset over the address limit: %v", err)
+	}
+
+	now = now.Add(time.Hour + time.Second)
+	if err := ThrottleReset(email, "10.0.0.1"); err != nil {
+		t.Errorf("expected the limit to end after an hour, %v", err)
+	}
+}
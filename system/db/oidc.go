@@ -0,0 +1,230 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/oidc"
+	"github.com/boltdb/bolt"
+)
+
+// LoginProviderType is the content type of the OpenID Connect providers
+const LoginProviderType = "LoginProvider"
+
+var (
+	// ErrNoLoginProvider is returned for a provider which is not set up or
+	// not enabled
+	ErrNoLoginProvider = errors.New("No such login provider")
+	// ErrLoginRequest is returned for a social login callback without a
+	// pending login, or an expired one
+	ErrLoginRequest = errors.New("Login request not found or expired")
+	// ErrSocialEmail is returned when the provider did not verify the email
+	ErrSocialEmail = errors.New("Email is not verified by the login provider")
+	// ErrSocialStaff is returned for a social login to an admin account
+	ErrSocialStaff = errors.New("Admin accounts can not use social login")
+	// ErrSocialTwoFactor is returned for a social login to an account with a
+	// second factor, which logs in with its password
+	ErrSocialTwoFactor = errors.New("Account has two-factor login, login with password")
+)
+
+// LoginRequestTTL is how long a customer has to finish a social login
+var LoginRequestTTL = 10 * time.Minute
+
+// LoginRequest is a social login in progress
+type LoginRequest struct {
+	oidc.Request
+	Provider string `json:"provider"`
+	Redirect string `json:"redirect,omitempty"` // where the customer goes after
+	Expires  int64  `json:"expires"`
+}
+
+// LoginProviders returns the enabled OpenID Connect providers
+func LoginProviders() []oidc.Provider {
+	list := []oidc.Provider{}
+	for _, v := range ContentAll(LoginProviderType) {
+		p := oidc.Provider{}
+		if err := json.Unmarshal(v, &p); err != nil {
+			continue
+		}
+		if p.Enabled {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// LoginProvider returns the enabled provider name
+func LoginProvider(name string) (*oidc.Provider, error) {
+	for _, p := range LoginProviders() {
+		if p.Name == name {
+			if len(strings.TrimSpace(p.Scopes)) == 0 {
+				p.Scopes = "openid email profile"
+			}
+			return &p, nil
+		}
+	}
+	return nil, ErrNoLoginProvider
+}
+
+// oidcBucket returns the sub bucket name of the social login bucket
+func oidcBucket(tx *bolt.Tx, name string) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(DB__oidc))
+	if err != nil {
+		return nil, err
+	}
+	return b.CreateBucketIfNotExists([]byte(name))
+}
+
+// SaveLoginRequest keeps r until the provider redirects back with its state
+func SaveLoginRequest(r *LoginRequest) error {
+	now := time.Now()
+	r.Expires = now.Add(LoginRequestTTL).Unix()
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return store.Update(func(tx *bolt.Tx) error {
+		b, err := oidcBucket(tx, "requests")
+		if err != nil {
+			return err
+		}
+		// drop the expired requests on the way
+		expired := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			old := LoginRequest{}
+			if json.Unmarshal(v, &old) == nil && old.Expires <= now.Unix() {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte(r.State), j)
+	})
+}
+
+// TakeLoginRequest returns and removes the login request of state, a state
+// can be used once
+func TakeLoginRequest(state string) (*LoginRequest, error) {
+	r := &LoginRequest{}
+	err := store.Update(func(tx *bolt.Tx) error {
+		b, err := oidcBucket(tx, "requests")
+		if err != nil {
+			return err
+		}
+		v := b.Get([]byte(state))
+		if v == nil {
+			return ErrLoginRequest
+		}
+		if err := json.Unmarshal(v, r); err != nil {
+			return err
+		}
+		return b.Delete([]byte(state))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r.Expires <= time.Now().Unix() {
+		return nil, ErrLoginRequest
+	}
+	return r, nil
+}
+
+// SocialUser returns the customer of the verified claims of provider. An
+// account linked to the provider subject is used first, then an account of
+// the verified email which gets linked. A new customer is made for a new
+// email.
+func SocialUser(provider string, c *oidc.Claims) (*user.User, error) {
+	link := provider + ":" + c.Subject
+	var linked string
+	err := store.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DB__oidc)); b != nil {
+			if l := b.Bucket([]byte("links")); l != nil {
+				linked = string(l.Get([]byte(link)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the linked account may be removed or have a new email since
+	if len(linked) > 0 {
+		if _, err := User(linked); err == ErrNoUserExists {
+			linked = ""
+		}
+	}
+
+	email := linked
+	if len(email) == 0 {
+		if !c.Verified() || len(c.Email) == 0 {
+			return nil, ErrSocialEmail
+		}
+		email = strings.ToLower(c.Email)
+	}
+
+	usr := &user.User{}
+	j, err := User(email)
+	switch err {
+	case nil:
+		if err := json.Unmarshal(j, usr); err != nil {
+			return nil, err
+		}
+	case ErrNoUserExists:
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		usr, err = user.NewCustomer(email, base64.RawURLEncoding.EncodeToString(b))
+		if err != nil {
+			return nil, err
+		}
+		usr.Verified = true // by the provider
+		if _, err := SetUser(usr); err != nil {
+			return nil, err
+		}
+		logger.Infof("Customer %s registered by %s login", email, provider)
+	default:
+		return nil, err
+	}
+
+	if len(usr.StaffRole()) > 0 {
+		return nil, ErrSocialStaff
+	}
+	if TwoFactorRequired(usr) {
+		return nil, ErrSocialTwoFactor
+	}
+
+	if !usr.Verified && len(linked) == 0 {
+		err = changeUser(email, func(u *user.User) error {
+			u.Verified = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		usr.Verified = true
+	}
+	if len(linked) == 0 {
+		err = store.Update(func(tx *bolt.Tx) error {
+			b, err := oidcBucket(tx, "links")
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(link), []byte(email))
+		})
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("Customer %s linked to %s", email, link)
+	}
+	return usr, nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/oidc"
+)
+
+func TestLoginRequestOnce(t *testing.T) {
+	defer openTestStore(t, DB__oidc)()
+
+	r := &LoginRequest{Request: oidc.Request{State: "s1", Nonce: "n1", Verifier: "v1"}, Provider: "mock", Redirect: "/account"}
+	if err := SaveLoginRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := TakeLoginRequest("s1")
+	if err != nil || got.Nonce != "n1" || got.Verifier != "v1" || got.Redirect != "/account" {
+		t.Fatalf("take request: %+v %v", got, err)
+	}
+	if _, err := TakeLoginRequest("s1"); err != ErrLoginRequest {
+		t.Errorf("take request twice: %v", err)
+	}
+
+	ttl := LoginRequestTTL
+	LoginRequestTTL = -time.Second
+	defer func() { LoginRequestTTL = ttl }()
+	if err := SaveLoginRequest(&LoginRequest{Request: oidc.Request{State: "s2"}, Provider: "mock"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TakeLoginRequest("s2"); err != ErrLoginRequest {
+		t.Errorf("take expired request: %v", err)
+	}
+}
+
+func TestSocialUser(t *testing.T) {
+	defer openTestStore(t, DB__users, DB__oidc)()
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{})()
+
+	// a new verified email registers a customer
+	usr, err := SocialUser("mock", &oidc.Claims{Subject: "1", Email: "New@Example.com", EmailVerified: true})
+	if err != nil || usr.Email != "new@example.com" || usr.ID == 0 || !getTestUser(t, usr.Email).Verified {
+		t.Fatalf("new customer: %+v %v", usr, err)
+	}
+	if len(usr.StaffRole()) > 0 {
+		t.Error("expected a customer account")
+	}
+
+	// the linked subject is used even when the email changed at the provider
+	again, err := SocialUser("mock", &oidc.Claims{Subject: "1", Email: "other@example.com"})
+	if err != nil || again.Email != "new@example.com" {
+		t.Errorf("linked subject: %+v %v", again, err)
+	}
+
+	// an existing account is linked by its verified email only
+	putTestUser(t, &user.User{ID: 7, Email: "buyer@example.com", Perm: user.CustomerPermission})
+	if _, err := SocialUser("other", &oidc.Claims{Subject: "2", Email: "buyer@example.com", EmailVerified: "false"}); err != ErrSocialEmail {
+		t.Errorf("unverified email: %v", err)
+	}
+	usr, err = SocialUser("other", &oidc.Claims{Subject: "2", Email: "buyer@example.com", EmailVerified: "true"})
+	if err != nil || usr.ID != 7 || !getTestUser(t, usr.Email).Verified {
+		t.Errorf("link by email: %+v %v", usr, err)
+	}
+
+	// admins and accounts with a second factor log in with their password
+	putTestUser(t, &user.User{ID: 8, Email: "editor@eshop.com", IsAdmin: true, Perm: user.AdminPermmission, Role: user.RoleEditor})
+	if _, err := SocialUser("mock", &oidc.Claims{Subject: "3", Email: "editor@eshop.com", EmailVerified: true}); err != ErrSocialStaff {
+		t.Errorf("admin social login: %v", err)
+	}
+	putTestUser(t, &user.User{ID: 9, Email: "safe@example.com", TwoFactor: &user.TwoFactor{Enabled: true}})
+	if _, err := SocialUser("mock", &oidc.Claims{Subject: "4", Email: "safe@example.com", EmailVerified: true}); err != ErrSocialTwoFactor {
+		t.Errorf("two-factor social login: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package db
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+)
+
+var (
+	// ErrPasswordShort is returned for a new password shorter than the policy
+	ErrPasswordShort = errors.New("Password is too short")
+	// ErrPasswordBreached is returned for a new password in the breached list
+	ErrPasswordBreached = errors.New("Password is known from a data breach, please choose another")
+	// ErrResetLink is returned for a reset link which is wrong, expired or
+	// used already
+	ErrResetLink = errors.New("Reset link is invalid or expired")
+)
+
+var (
+	// PasswordMinLength is the shortest new password when the
+	// password_min_length setting is not set
+	PasswordMinLength = 8
+	// ResetLinkTTL is how long a password reset link works
+	ResetLinkTTL = time.Hour
+)
+
+var (
+	// resetNow is the clock of the reset links, replaced in tests
+	resetNow = time.Now
+
+	// resetKey returns the key the reset links are signed with
+	resetKey = func() []byte {
+		secret, _ := ConfigCache("client_secret").(string)
+		key := sha256.Sum256([]byte("reset|" + secret))
+		return key[:]
+	}
+
+	breached breachedList
+)
+
+// breachedList is the breached passwords of the password_breached_list file,
+// kept as SHA-1 hashes and read again when the file changes
+type breachedList struct {
+	sync.Mutex
+	path   string
+	mod    time.Time
+	hashes map[string]bool
+}
+
+// has tells if the file at path lists password. A line of the file is a
+// password, or its SHA-1 hash in hex with an optional :count like the lists of
+// breached password services.
+func (l *breachedList) has(path, password string) (bool, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if l.path != path || !l.mod.Equal(info.ModTime()) {
+		f, err := os.Open(path)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+
+		hashes := map[string]bool{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 {
+				continue
+			}
+			h := strings.SplitN(line, ":", 2)[0]
+			if _, err := hex.DecodeString(h); err != nil || len(h) != 2*sha1.Size {
+				h = passwordSHA1(line)
+			}
+			hashes[strings.ToLower(h)] = true
+		}
+		if err := scanner.Err(); err != nil {
+			return false, err
+		}
+		l.path, l.mod, l.hashes = path, info.ModTime(), hashes
+		logger.Infof("Breached password list %s loaded, %d entries", path, len(hashes))
+	}
+	return l.hashes[passwordSHA1(password)], nil
+}
+
+func passwordSHA1(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckPassword returns an error when password can not be a new password by
+// the policy: shorter than the password_min_length setting, or listed in the
+// password_breached_list file. A list which can not be read is skipped.
+func CheckPassword(password string) error {
+	min := PasswordMinLength
+	if n, _ := ConfigCache("password_min_length").(float64); n > 0 {
+		min = int(n)
+	}
+	if utf8.RuneCountInString(password) < min {
+		return ErrPasswordShort
+	}
+
+	if path, _ := ConfigCache("password_breached_list").(string); len(path) > 0 {
+		found, err := breached.has(path, password)
+		if err != nil {
+			logger.Warnf("Breached password list %s skipped: %s", path, err)
+		} else if found {
+			return ErrPasswordBreached
+		}
+	}
+	return nil
+}
+
+// UpgradePassword replaces an older hash of usr by the Argon2id hash of
+// password, which has just been checked. The sessions of the user stay open.
+func UpgradePassword(usr *user.User, password string) {
+	if !usr.NeedsRehash() {
+		return
+	}
+	up := *usr
+	if err := user.SetPassword(&up, password); err != nil {
+		logger.Errorf("Rehash password of %s error: %s", usr.Email, err)
+		return
+	}
+	err := changeUser(usr.Email, func(u *user.User) error {
+		// the password changed meanwhile
+		if u.Hash != usr.Hash {
+			return nil
+		}
+		u.Hash, u.Salt, u.HashVersion = up.Hash, up.Salt, up.HashVersion
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("Rehash password of %s error: %s", usr.Email, err)
+		return
+	}
+	usr.Hash, usr.Salt, usr.HashVersion = up.Hash, up.Salt, up.HashVersion
+	logger.Infof("Password of %s rehashed to version %d", usr.Email, usr.HashVersion)
+}
+
+// ResetToken returns the signed token of the password reset link of email
+// and when it expires. The link works once, until the password changes.
+func ResetToken(email string) (string, time.Time, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	usr, err := resetUser(email)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := resetNow().Add(ResetLinkTTL)
+	payload := email + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + resetSign(payload, usr.Hash), expires, nil
+}
+
+// resetSign signs the payload of a reset link with the password hash the
+// user has, so the link stops working once the password is changed
+func resetSign(payload, hash string) string {
+	mac := hmac.New(sha256.New, resetKey())
+	mac.Write([]byte(payload + "|" + hash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func resetUser(email string) (*user.User, error) {
+	j, err := User(email)
+	if err != nil {
+		return nil, err
+	}
+	if j == nil {
+		return nil, ErrNoUserExists
+	}
+	usr := &user.User{}
+	if err := json.Unmarshal(j, usr); err != nil {
+		return nil, err
+	}
+	return usr, nil
+}
+
+// parseReset returns the payload, email and signature of a reset token which
+// has not expired
+func parseReset(token string) (string, string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", "", ErrResetLink
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", "", ErrResetLink
+	}
+	payload := string(b)
+	i := strings.LastIndex(payload, "|")
+	if i < 0 {
+		return "", "", "", ErrResetLink
+	}
+	expires, err := strconv.ParseInt(payload[i+1:], 10, 64)
+	if err != nil || expires <= resetNow().Unix() {
+		return "", "", "", ErrResetLink
+	}
+	return payload, payload[:i], parts[1], nil
+}
+
+// ResetEmail returns the email of a reset token which still works
+func ResetEmail(token string) (string, error) {
+	payload, email, sig, err := parseReset(token)
+	if err != nil {
+		return "", err
+	}
+	usr, err := resetUser(email)
+	if err != nil || !hmac.Equal([]byte(resetSign(payload, usr.Hash)), []byte(sig)) {
+		return "", ErrResetLink
+	}
+	return email, nil
+}
+
+// ResetPassword sets password, which has to pass CheckPassword, for the user
+// of the reset token, ends the sessions of the user and returns the email
+func ResetPassword(token, password string) (string, error) {
+	payload, email, sig, err := parseReset(token)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckPassword(password); err != nil {
+		return "", err
+	}
+
+	err = changeUser(email, func(u *user.User) error {
+		if !hmac.Equal([]byte(resetSign(payload, u.Hash)), []byte(sig)) {
+			return ErrResetLink
+		}
+		return user.SetPassword(u, password)
+	})
+	if err == ErrNoUserExists {
+		return "", ErrResetLink
+	}
+	if err != nil {
+		return "", err
+	}
+	n, err := RevokeSessions(email, "")
+	if err != nil {
+		return "", err
+	}
+	logger.Infof("Password of %s reset, %d sessions revoked", email, n)
+	return email, nil
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/nilslice/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastArgon2 lowers the Argon2id cost for the tests
+func fastArgon2() func() {
+	memory, time := user.Argon2Memory, user.Argon2Time
+	user.Argon2Memory, user.Argon2Time = 1024, 1
+	return func() { user.Argon2Memory, user.Argon2Time = memory, time }
+}
+
+func TestCheckPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eshop-breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	list := filepath.Join(dir, "breached.txt")
+	// passwords, and SHA-1 hashes with a count
+	content := "password123\n\nB3E9CEE3E9B1A2B5C8E3E5F1D2A7C6E4B4D1A0F3:12\n" + strings.ToUpper(passwordSHA1("letmein123")) + ":7\n"
+	if err := ioutil.WriteFile(list, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{"password_min_length": float64(10), "password_breached_list": list})()
+
+	cases := map[string]error{
+		"short":              ErrPasswordShort,
+		"password123":        ErrPasswordBreached,
+		"letmein123":         ErrPasswordBreached,
+		"correct horse":      nil,
+		"密码密码密码密码密码":         nil, // length in characters
+		"B3E9CEE3E9B1A2B5C8": nil,
+	}
+	for password, want := range cases {
+		if err := CheckPassword(password); err != want {
+			t.Errorf("password %q: %v, want %v", password, err, want)
+		}
+	}
+
+	// a changed list is read again, a missing one skipped
+	later := time.Now().Add(time.Minute)
+	if err := ioutil.WriteFile(list, []byte("correct horse\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(list, later, later)
+	if err := CheckPassword("correct horse"); err != ErrPasswordBreached {
+		t.Errorf("changed list: %v", err)
+	}
+	os.Remove(list)
+	if err := CheckPassword("correct horse"); err != nil {
+		t.Errorf("missing list: %v", err)
+	}
+}
+
+func TestUpgradePassword(t *testing.T) {
+	defer openTestStore(t, DB__users)()
+	defer fastArgon2()()
+
+	// a user saved before the Argon2id hashes
+	salt := []byte("0123456789abcdef")
+	hash, err := bcrypt.GenerateFromPassword(append(append([]byte{}, salt...), "old secret"...), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTestUser(t, &user.User{ID: 1, Email: "old@example.com", Hash: string(hash),
+		Salt: base64.StdEncoding.EncodeToString(salt), Role: user.RoleEditor})
+
+	usr := getTestUser(t, "old@example.com")
+	if !usr.NeedsRehash() || user.IsUser(usr, "wrong") || !user.IsUser(usr, "old secret") {
+		t.Fatalf("legacy user %+v", usr)
+	}
+	UpgradePassword(usr, "old secret")
+
+	saved := getTestUser(t, "old@example.com")
+	if saved.HashVersion != user.HashArgon2id || !strings.HasPrefix(saved.Hash, "$argon2id$") ||
+		saved.Hash != usr.Hash || saved.Role != user.RoleEditor {
+		t.Fatalf("rehashed user %+v", saved)
+	}
+	if saved.NeedsRehash() || !user.IsUser(saved, "old secret") || user.IsUser(saved, "old secreT") {
+		t.Error("expected the password to pass the new hash only")
+	}
+	UpgradePassword(saved, "old secret")
+	if getTestUser(t, "old@example.com").Hash != saved.Hash {
+		t.Error("expected a current hash to be kept")
+	}
+
+	// the hash keeps the parameters it was made with
+	defer fastArgon2()()
+	user.Argon2Time = 2
+	if !user.IsUser(saved, "old secret") {
+		t.Error("expected a hash of other parameters to pass")
+	}
+	saved.Hash = strings.Replace(saved.Hash, "$argon2id$", "$argon2i$", 1)
+	if user.IsUser(saved, "old secret") {
+		t.Error("expected a broken hash to fail")
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	defer openTestStore(t, DB__users, DB__sessions)()
+	defer fastArgon2()()
+	jwt.Secret([]byte("test"))
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{"client_secret": "s"})()
+	clock := resetNow
+	resetNow = func() time.Time { return now }
+	defer func() { resetNow = clock }()
+
+	usr, err := user.NewCustomer("buyer@example.com", "first password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr.Verified = true
+	putTestUser(t, usr)
+	if _, err := OpenSession(usr.Email, "phone", "10.0.0.1", time.Now().Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ResetToken("nobody@example.com"); err != ErrNoUserExists {
+		t.Errorf("link of no account: %v", err)
+	}
+	token, expires, err := ResetToken("Buyer@example.com")
+	if err != nil || !expires.Equal(now.Add(ResetLinkTTL)) {
+		t.Fatalf("reset link: %v %v", expires, err)
+	}
+	if addr, err := ResetEmail(token); err != nil || addr != "buyer@example.com" {
+		t.Errorf("link email: %s %v", addr, err)
+	}
+
+	parts := strings.Split(token, ".")
+	if _, err := ResetPassword(parts[0]+"."+parts[1][1:], "second password"); err != ErrResetLink {
+		t.Errorf("changed link: %v", err)
+	}
+	if _, err := ResetPassword(token, "short"); err != ErrPasswordShort {
+		t.Errorf("short password: %v", err)
+	}
+	now = now.Add(ResetLinkTTL)
+	if _, err := ResetPassword(token, "second password"); err != ErrResetLink {
+		t.Errorf("expired link: %v", err)
+	}
+	now = now.Add(-time.Minute)
+
+	if addr, err := ResetPassword(token, "second password"); err != nil || addr != "buyer@example.com" {
+		t.Fatalf("reset: %s %v", addr, err)
+	}
+	saved := getTestUser(t, "buyer@example.com")
+	if !user.IsUser(saved, "second password") || user.IsUser(saved, "first password") || !saved.Verified {
+		t.Errorf("user after reset %+v", saved)
+	}
+	if list, err := Sessions(saved.Email, ""); err != nil || len(list) != 0 {
+		t.Errorf("expected the sessions revoked, got %+v %v", list, err)
+	}
+
+	// the link works once
+	if _, err := ResetPassword(token, "third password"); err != ErrResetLink {
+		t.Errorf("used link: %v", err)
+	}
+	if _, err := ResetEmail(token); err != ErrResetLink {
+		t.Errorf("used link email: %v", err)
+	}
+}
@@ -0,0 +1,558 @@
+package db
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/boltdb/bolt"
+)
+
+var (
+	// ErrEraseStaff is returned for erasing an admin account, which has to
+	// lose its role first
+	ErrEraseStaff = errors.New("Admin accounts can not be erased")
+	// ErrEraseBalance is returned for erasing an account with a wallet or
+	// commission balance, which has to be paid out or used first
+	ErrEraseBalance = errors.New("Account has a balance, pay it out or use it first")
+)
+
+// PersonalKeys are what the data of a customer is found by
+type PersonalKeys struct {
+	Email  string
+	Anon   string   // the id erased records keep instead of the email
+	IPs    []string // addresses of the orders, reviews and sessions
+	Orders []string // order ids
+}
+
+// PersonalData is a store of customer data outside the system db, like the
+// payment records. Export returns the data of the keys, Erase removes or
+// anonymizes it and returns how many records it changed.
+type PersonalData struct {
+	Export func(k PersonalKeys) (interface{}, error)
+	Erase  func(k PersonalKeys) (int, error)
+}
+
+// Erasure is the record kept of an erased account, without its email
+type Erasure struct {
+	ID       string         `json:"id"` // the anonymous id of the account
+	Operator string         `json:"operator"`
+	IP       string         `json:"ip"`
+	Time     int64          `json:"time"`
+	Records  map[string]int `json:"records"` // changed records by store
+}
+
+var (
+	personalData   = map[string]PersonalData{}
+	personalDataMu sync.Mutex
+)
+
+// AddPersonalData registers store name for the data export and erasure of
+// customers
+func AddPersonalData(name string, p PersonalData) {
+	personalDataMu.Lock()
+	defer personalDataMu.Unlock()
+
+	personalData[name] = p
+}
+
+// anonKey returns the key the anonymous ids are made with, so an id can not
+// be found again from a guessed email without the server secret
+var anonKey = func() []byte {
+	secret, _ := ConfigCache("client_secret").(string)
+	key := sha256.Sum256([]byte("anon|" + secret))
+	return key[:]
+}
+
+// AnonID returns the id the erased records of email keep
+func AnonID(email string) string {
+	mac := hmac.New(sha256.New, anonKey())
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "erased-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// personalKeys finds the orders and addresses of email
+func personalKeys(email string) PersonalKeys {
+	email = strings.ToLower(strings.TrimSpace(email))
+	k := PersonalKeys{Email: email, Anon: AnonID(email)}
+	ips := map[string]bool{}
+	for _, o := range contentOf("Order", "user", email) {
+		if id, _ := o["order_id"].(string); len(id) > 0 {
+			k.Orders = append(k.Orders, id)
+		}
+		if ip, _ := o["ip"].(string); len(ip) > 0 {
+			ips[ip] = true
+		}
+	}
+	for _, ns := range []string{ReviewType, ReviewType + "__pending"} {
+		for _, r := range contentOf(ns, "email", email) {
+			if ip, _ := r["ip"].(string); len(ip) > 0 {
+				ips[ip] = true
+			}
+		}
+	}
+	store.View(func(tx *bolt.Tx) error {
+		if b := sessionBucket(tx, email); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				s := Session{}
+				if json.Unmarshal(v, &s) == nil && len(s.IP) > 0 {
+					ips[s.IP] = true
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	for ip := range ips {
+		k.IPs = append(k.IPs, ip)
+	}
+	sort.Strings(k.IPs)
+	return k
+}
+
+// contentOf returns the items of namespace ns whose field is email
+func contentOf(ns, field, email string) []map[string]interface{} {
+	list := []map[string]interface{}{}
+	for _, v := range ContentAll(ns) {
+		m := fieldsOf(v)
+		if s, _ := m[field].(string); strings.EqualFold(s, email) {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// ExportPersonalData returns everything kept of the customer email by section:
+// the account, orders, carts, reviews, wallet, points, referral, sessions,
+// social login links and the registered stores
+func ExportPersonalData(email string) (map[string]interface{}, error) {
+	k := personalKeys(email)
+	j, err := User(k.Email)
+	if err != nil {
+		return nil, err
+	}
+	usr := &user.User{}
+	if err := json.Unmarshal(j, usr); err != nil {
+		return nil, err
+	}
+	usr.Hash, usr.Salt = "", ""
+	if usr.TwoFactor != nil {
+		usr.TwoFactor = &user.TwoFactor{Enabled: usr.TwoFactor.Enabled}
+	}
+
+	data := map[string]interface{}{
+		"user":    usr,
+		"orders":  contentOf("Order", "user", k.Email),
+		"carts":   contentOf("Carts", "email", k.Email),
+		"reviews": append(contentOf(ReviewType, "email", k.Email), contentOf(ReviewType+"__pending", "email", k.Email)...),
+	}
+	err = store.View(func(tx *bolt.Tx) error {
+		data["wallet"] = exportAccount(tx, DB__wallets, k.Email, walletBalanceKey, walletLedgerKey)
+		data["points"] = exportAccount(tx, DB__points, k.Email, pointsBalanceKey, pointsLedgerKey)
+		data["referral"] = exportAccount(tx, DB__referrals, k.Email, walletBalanceKey, walletLedgerKey)
+
+		sessions := []Session{}
+		if b := sessionBucket(tx, k.Email); b != nil {
+			b.ForEach(func(_, v []byte) error {
+				s := Session{}
+				if json.Unmarshal(v, &s) == nil {
+					sessions = append(sessions, s)
+				}
+				return nil
+			})
+		}
+		data["sessions"] = sessions
+
+		links := []string{}
+		if b := tx.Bucket([]byte(DB__oidc)); b != nil {
+			if l := b.Bucket([]byte("links")); l != nil {
+				l.ForEach(func(link, v []byte) error {
+					if string(v) == k.Email {
+						links = append(links, string(link))
+					}
+					return nil
+				})
+			}
+		}
+		data["social_links"] = links
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	personalDataMu.Lock()
+	defer personalDataMu.Unlock()
+	for name, p := range personalData {
+		v, err := p.Export(k)
+		if err != nil {
+			return nil, fmt.Errorf("Export %s: %s", name, err)
+		}
+		data[name] = v
+	}
+	return data, nil
+}
+
+// WritePersonalZip writes the export data as a zip archive with a json file
+// per section
+func WritePersonalZip(w io.Writer, data map[string]interface{}) error {
+	names := []string{}
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	z := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := z.Create(name + ".json")
+		if err != nil {
+			return err
+		}
+		j, err := json.MarshalIndent(data[name], "", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(j); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// exportAccount returns the balance and ledger of email in a wallet like
+// bucket, kept under balanceKey and ledgerKey, nil when there is none
+func exportAccount(tx *bolt.Tx, name, email, balanceKey, ledgerKey string) interface{} {
+	b := tx.Bucket([]byte(name))
+	if b == nil || b.Bucket([]byte(email)) == nil {
+		return nil
+	}
+	ab := b.Bucket([]byte(email))
+	ledger := []json.RawMessage{}
+	if lb := ab.Bucket([]byte(ledgerKey)); lb != nil {
+		lb.ForEach(func(_, v []byte) error {
+			ledger = append(ledger, json.RawMessage(append([]byte{}, v...)))
+			return nil
+		})
+	}
+	var account json.RawMessage
+	if v := ab.Get([]byte(balanceKey)); v != nil {
+		account = json.RawMessage(append([]byte{}, v...))
+	}
+	return map[string]interface{}{
+		"account": account,
+		"ledger":  ledger,
+	}
+}
+
+// ErasePersonalData erases the customer email for operator from ip. Orders and
+// the wallet, points and referral ledgers are kept as financial records with
+// the email, names, addresses and notes replaced by AnonID; carts, sessions,
+// social links, login counters and the account are removed. The audit log has
+// the AnonID of the email already and is left as it is.
+func ErasePersonalData(email, operator, ip string) (*Erasure, error) {
+	k := personalKeys(email)
+	j, err := User(k.Email)
+	if err != nil {
+		return nil, err
+	}
+	usr := &user.User{}
+	if err := json.Unmarshal(j, usr); err != nil {
+		return nil, err
+	}
+	if len(usr.StaffRole()) > 0 {
+		return nil, ErrEraseStaff
+	}
+	if w, err := WalletOf(k.Email); err == nil && w.Balance > 0 {
+		return nil, ErrEraseBalance
+	}
+	err = store.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(DB__referrals)); b != nil && b.Bucket([]byte(k.Email)) != nil {
+			aff := Affiliate{}
+			if v := b.Bucket([]byte(k.Email)).Get([]byte(walletBalanceKey)); v != nil && json.Unmarshal(v, &aff) == nil && aff.Earned-aff.Paid > 0.005 {
+				return ErrEraseBalance
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Erasure{ID: k.Anon, Operator: operator, IP: ip, Time: time.Now().Unix(), Records: map[string]int{}}
+	for _, o := range contentOf("Order", "user", k.Email) {
+		if err := scrubContent("Order", o, map[string]interface{}{
+			"user": k.Anon, "payer": "", "payer_link": "", "ip": "", "comments": "",
+			"notify_info": "", "pending_info": "", "delivery": "",
+		}); err != nil {
+			return nil, err
+		}
+		e.Records["orders"]++
+	}
+	for _, ns := range []string{ReviewType, ReviewType + "__pending"} {
+		for _, r := range contentOf(ns, "email", k.Email) {
+			if err := scrubContent(ns, r, map[string]interface{}{"email": k.Anon, "name": "", "ip": ""}); err != nil {
+				return nil, err
+			}
+			e.Records["reviews"]++
+		}
+	}
+	for _, c := range contentOf("Carts", "email", k.Email) {
+		id, _ := c["id"].(float64)
+		if err := DeleteContent(fmt.Sprintf("Carts:%d", int(id))); err != nil {
+			return nil, err
+		}
+		e.Records["carts"]++
+	}
+
+	personalDataMu.Lock()
+	for name, p := range personalData {
+		n, err := p.Erase(k)
+		if err != nil {
+			personalDataMu.Unlock()
+			return nil, fmt.Errorf("Erase %s: %s", name, err)
+		}
+		e.Records[name] = n
+	}
+	personalDataMu.Unlock()
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{DB__wallets, DB__points, DB__referrals} {
+			n, err := moveAccount(tx, name, k.Email, k.Anon)
+			if err != nil {
+				return err
+			}
+			e.Records[strings.TrimPrefix(name, "eshop__")] = n
+		}
+		if b := tx.Bucket([]byte(DB__oidc)); b != nil {
+			if l := b.Bucket([]byte("links")); l != nil {
+				links := [][]byte{}
+				l.ForEach(func(link, v []byte) error {
+					if string(v) == k.Email {
+						links = append(links, link)
+					}
+					return nil
+				})
+				for _, link := range links {
+					if err := l.Delete(link); err != nil {
+						return err
+					}
+				}
+				e.Records["social_links"] = len(links)
+			}
+		}
+		if b := tx.Bucket([]byte(DB__logins)); b != nil {
+			for _, kind := range []string{"", "reset:", "verify:"} {
+				if err := b.Delete([]byte(kind + "email:" + k.Email)); err != nil {
+					return err
+				}
+			}
+		}
+		if b := tx.Bucket([]byte(DB__sessions)); b != nil && b.Bucket([]byte(k.Email)) != nil {
+			if err := b.DeleteBucket([]byte(k.Email)); err != nil {
+				return err
+			}
+		}
+
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(DB__erasures))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(e.ID), j)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := DeleteUser(k.Email); err != nil {
+		return nil, err
+	}
+
+	logger.Warnf("Account %s erased as %s by %s from %s: %v", k.Email, e.ID, operator, ip, e.Records)
+	return e, nil
+}
+
+// scrubContent saves item m of namespace ns with the fields set, and drops its
+// revisions which still hold the old values
+func scrubContent(ns string, m map[string]interface{}, fields map[string]interface{}) error {
+	id, _ := m["id"].(float64)
+	for f, v := range fields {
+		if _, ok := m[f]; ok {
+			m[f] = v
+		}
+	}
+	j, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	specifier := ""
+	if i := strings.Index(ns, "__"); i > 0 {
+		ns, specifier = ns[:i], ns[i:]
+	}
+	if _, err := save(ns, specifier, int(id), j, "erasure", 0); err != nil {
+		return err
+	}
+	if specifier != "" {
+		return nil
+	}
+	return store.Update(func(tx *bolt.Tx) error {
+		return deleteRevisions(tx, fmt.Sprintf("%s:%d", ns, int(id)))
+	})
+}
+
+// moveAccount moves the wallet like account of email in bucket name to anon,
+// with the email in its records replaced. The referral codes and the
+// commissions other affiliates earned on orders of email are changed too. It
+// returns how many records were changed.
+func moveAccount(tx *bolt.Tx, name, email, anon string) (int, error) {
+	b := tx.Bucket([]byte(name))
+	if b == nil {
+		return 0, nil
+	}
+	n := 0
+	if src := b.Bucket([]byte(email)); src != nil {
+		dst, err := b.CreateBucketIfNotExists([]byte(anon))
+		if err != nil {
+			return 0, err
+		}
+		if n, err = copyAnonymized(src, dst, email, anon); err != nil {
+			return 0, err
+		}
+		if err := b.DeleteBucket([]byte(email)); err != nil {
+			return 0, err
+		}
+	}
+	if name != DB__referrals {
+		return n, nil
+	}
+
+	// the referral code index, and buyer of the commissions of others
+	keys := [][]byte{}
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	for _, k := range keys {
+		ab := b.Bucket(k)
+		if string(k) == referralCodesKey || string(k) == referralOrdersKey {
+			m, err := replaceValues(ab, email, anon)
+			if err != nil {
+				return 0, err
+			}
+			n += m
+			continue
+		}
+		if lb := ab.Bucket([]byte(walletLedgerKey)); lb != nil {
+			m, err := replaceValues(lb, email, anon)
+			if err != nil {
+				return 0, err
+			}
+			n += m
+		}
+	}
+	return n, nil
+}
+
+// copyAnonymized copies bucket src to dst with email replaced by anon
+func copyAnonymized(src, dst *bolt.Bucket, email, anon string) (int, error) {
+	n := 0
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return 0, err
+	}
+	err := src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			sub, err := dst.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			m, err := copyAnonymized(src.Bucket(k), sub, email, anon)
+			n += m
+			return err
+		}
+		n++
+		return dst.Put(k, anonymize(v, email, anon))
+	})
+	return n, err
+}
+
+// replaceValues replaces email by anon in the values of bucket b
+func replaceValues(b *bolt.Bucket, email, anon string) (int, error) {
+	changed := map[string][]byte{}
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		if a := anonymize(v, email, anon); string(a) != string(v) {
+			changed[string(k)] = a
+		}
+		return nil
+	})
+	for k, v := range changed {
+		if err := b.Put([]byte(k), v); err != nil {
+			return 0, err
+		}
+	}
+	return len(changed), nil
+}
+
+// anonymize returns v, a plain value or a json object, with the values equal
+// to email replaced by anon
+func anonymize(v []byte, email, anon string) []byte {
+	if strings.EqualFold(string(v), email) {
+		return []byte(anon)
+	}
+	m := map[string]interface{}{}
+	if json.Unmarshal(v, &m) != nil {
+		return v
+	}
+	found := false
+	for f, val := range m {
+		if s, ok := val.(string); ok && strings.EqualFold(s, email) {
+			m[f] = anon
+			found = true
+		}
+	}
+	if !found {
+		return v
+	}
+	j, err := json.Marshal(m)
+	if err != nil {
+		return v
+	}
+	return j
+}
+
+// Erasures returns the records of the erased accounts, the latest first
+func Erasures() ([]Erasure, error) {
+	list := []Erasure{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__erasures))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			e := Erasure{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			list = append(list, e)
+			return nil
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time > list[j].Time })
+	return list, err
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/agreyfox/eshop/system/oidc"
+	"github.com/boltdb/bolt"
+	"github.com/nilslice/jwt"
+)
+
+// fakePersonalData registers a store recording the keys it is called with
+func fakePersonalData(keys *[]PersonalKeys) func() {
+	AddPersonalData("fake", PersonalData{
+		Export: func(k PersonalKeys) (interface{}, error) {
+			*keys = append(*keys, k)
+			return []string{k.Email}, nil
+		},
+		Erase: func(k PersonalKeys) (int, error) {
+			*keys = append(*keys, k)
+			return 2, nil
+		},
+	})
+	return func() {
+		personalDataMu.Lock()
+		delete(personalData, "fake")
+		personalDataMu.Unlock()
+	}
+}
+
+func TestPersonalExport(t *testing.T) {
+	defer openTestStore(t, DB__users, DB__sessions, DB__wallets, DB__points, DB__referrals, DB__oidc,
+		"Order", "Carts", ReviewType, ReviewType+"__pending")()
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{})()
+	jwt.Secret([]byte("test"))
+	keys := []PersonalKeys{}
+	defer fakePersonalData(&keys)()
+
+	email := "buyer@example.com"
+	putTestUser(t, &user.User{ID: 1, Email: email, Hash: "h", Salt: "s", Perm: user.CustomerPermission,
+		TwoFactor: &user.TwoFactor{Secret: "x", Recovery: []string{"r"}}})
+	putRefContent(t, "Order", "1", `{"id":1,"order_id":"A1","user":"buyer@example.com","ip":"1.1.1.1","total":10}`)
+	putRefContent(t, "Order", "2", `{"id":2,"order_id":"A2","user":"other@example.com","ip":"9.9.9.9","total":20}`)
+	putRefContent(t, "Carts", "1", `{"id":1,"email":"Buyer@example.com"}`)
+	putRefContent(t, ReviewType+"__pending", "1", `{"id":1,"email":"buyer@example.com","ip":"2.2.2.2"}`)
+	if _, err := OpenSession(email, "phone", "3.3.3.3", now.Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SocialUser("mock", &oidc.Claims{Subject: "1", Email: email, EmailVerified: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreditWallet(email, 5, "", "admin@eshop.com", "grant"); err != nil {
+		t.Fatal(err)
+	}
+
+	k := personalKeys("Buyer@Example.com")
+	if k.Email != email || k.Anon != AnonID(email) || len(k.Orders) != 1 || k.Orders[0] != "A1" {
+		t.Errorf("unexpected keys %+v", k)
+	}
+	if len(k.IPs) != 3 || k.IPs[0] != "1.1.1.1" || k.IPs[1] != "2.2.2.2" || k.IPs[2] != "3.3.3.3" {
+		t.Errorf("unexpected addresses %v", k.IPs)
+	}
+
+	data, err := ExportPersonalData(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := data["user"].(*user.User)
+	if usr.Hash != "" || usr.Salt != "" || usr.TwoFactor.Secret != "" || len(usr.TwoFactor.Recovery) > 0 {
+		t.Errorf("expected no secrets in the export, got %+v", usr)
+	}
+	if len(data["orders"].([]map[string]interface{})) != 1 || len(data["carts"].([]map[string]interface{})) != 1 ||
+		len(data["reviews"].([]map[string]interface{})) != 1 || len(data["sessions"].([]Session)) != 1 {
+		t.Errorf("unexpected sections %v", data)
+	}
+	if links := data["social_links"].([]string); len(links) != 1 {
+		t.Errorf("unexpected social links %v", links)
+	}
+	if data["wallet"] == nil || data["points"] != nil || len(keys) != 1 || keys[0].Orders[0] != "A1" {
+		t.Errorf("unexpected wallet %v, points %v or store keys %v", data["wallet"], data["points"], keys)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WritePersonalZip(buf, data); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(z.File) != len(data) || z.File[0].Name != "carts.json" {
+		t.Errorf("unexpected archive of %d files, first %s", len(z.File), z.File[0].Name)
+	}
+}
+
+func TestPersonalErase(t *testing.T) {
+	defer openTestStore(t, DB__users, DB__sessions, DB__wallets, DB__points, DB__referrals, DB__oidc,
+		DB__logins, DB__erasures, "Order", "Carts", ReviewType, ReviewType+"__pending")()
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{})()
+	jwt.Secret([]byte("test"))
+	keys := []PersonalKeys{}
+	defer fakePersonalData(&keys)()
+
+	email := "buyer@example.com"
+	anon := AnonID(email)
+	putTestUser(t, &user.User{ID: 1, Email: email, Perm: user.CustomerPermission})
+	putTestUser(t, &user.User{ID: 2, Email: "editor@eshop.com", IsAdmin: true, Perm: user.AdminPermmission, Role: user.RoleEditor})
+	if _, err := ErasePersonalData("editor@eshop.com", "admin@eshop.com", "1.1.1.1"); err != ErrEraseStaff {
+		t.Errorf("erase admin: %v", err)
+	}
+
+	if _, err := OpenSession(email, "phone", "3.3.3.3", now.Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SocialUser("mock", &oidc.Claims{Subject: "1", Email: email, EmailVerified: true}); err != nil {
+		t.Fatal(err)
+	}
+	LoginFailed(email, "3.3.3.3")
+
+	// the buyer was referred by an affiliate and has a referral code too
+	aff, err := ReferralCodeOf("aff@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddCommission(aff.Code, "A1", email, 100, 5, "USD"); err != nil {
+		t.Fatal(err)
+	}
+	own, err := ReferralCodeOf(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the balance of the wallet has to be used first
+	if _, err := CreditWallet(email, 5, "", "admin@eshop.com", "grant"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ErasePersonalData(email, email, "3.3.3.3"); err != ErrEraseBalance {
+		t.Errorf("erase with a balance: %v", err)
+	}
+	if _, err := DebitWallet(email, 5, "A1", "wallet", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ErasePersonalData(email, email, "3.3.3.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != anon || e.Records["wallets"] != 3 || e.Records["social_links"] != 1 || e.Records["fake"] != 2 {
+		t.Errorf("unexpected erasure %+v", e)
+	}
+	if len(keys) != 1 || keys[0].IPs[0] != "3.3.3.3" {
+		t.Errorf("unexpected store keys %v", keys)
+	}
+
+	if _, err := User(email); err != ErrNoUserExists {
+		t.Errorf("expected the account to be removed, got %v", err)
+	}
+	if w, err := WalletOf(email); err == nil && w.Balance != 0 {
+		t.Errorf("expected no wallet of the email, got %+v", w)
+	}
+	if l, err := WalletLedger(anon); err != nil || len(l) != 2 {
+		t.Errorf("expected the ledger kept as %s, got %v %v", anon, l, err)
+	}
+	if a, err := AffiliateByCode(own.Code); err != nil || a.Email != anon {
+		t.Errorf("expected the referral code of %s, got %+v %v", anon, a, err)
+	}
+	if c, err := CommissionsOf("aff@example.com"); err != nil || len(c) != 1 || c[0].Buyer != anon || c[0].Amount != 5 {
+		t.Errorf("expected the commission kept for %s, got %+v %v", anon, c, err)
+	}
+	store.View(func(tx *bolt.Tx) error {
+		if sessionBucket(tx, email) != nil {
+			t.Error("expected the sessions removed")
+		}
+		if tx.Bucket([]byte(DB__logins)).Get([]byte("email:"+email)) != nil {
+			t.Error("expected the login counter removed")
+		}
+		return nil
+	})
+
+	list, err := Erasures()
+	if err != nil || len(list) != 1 || list[0].ID != anon || list[0].Operator != email {
+		t.Errorf("unexpected erasures %+v %v", list, err)
+	}
+}
+
+func TestAnonID(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{"client_secret": "one"})()
+	id := AnonID("Buyer@example.com ")
+	if id != AnonID("buyer@example.com") || !strings.HasPrefix(id, "erased-") || len(id) != len("erased-")+16 {
+		t.Fatalf("unexpected id %s", id)
+	}
+	sum := sha256.Sum256([]byte("buyer@example.com"))
+	if id == "erased-"+hex.EncodeToString(sum[:8]) {
+		t.Error("expected an id which needs the secret")
+	}
+	configCache["client_secret"] = "two"
+	if AnonID("buyer@example.com") == id {
+		t.Error("expected the id to change with the secret")
+	}
+}
@@ -0,0 +1,461 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// PointsEarn is added when an order is completed
+	PointsEarn = "earn"
+	// PointsRedeem is taken when points pay part of an order
+	PointsRedeem = "redeem"
+	// PointsReverse takes back the points of a refunded or charged back order
+	PointsReverse = "reverse"
+	// PointsRefund gives back the points redeemed by a refunded order
+	PointsRefund = "refund"
+	// PointsExpire removes points which were not used in time
+	PointsExpire = "expire"
+	// PointsAdjust is a manual change made by an admin
+	PointsAdjust = "adjust"
+
+	pointsBalanceKey = "__balance"
+	pointsLedgerKey  = "__ledger"
+)
+
+// ErrInsufficientPoints is returned when the points balance does not cover a redemption
+var ErrInsufficientPoints = errors.New("Error. Insufficient points.")
+
+// ErrInvalidPoints is returned when a points operation is called with a wrong value
+var ErrInvalidPoints = errors.New("Error. Invalid points.")
+
+// PointsValidDays is how long earned points can be used, 0 means never expire
+var PointsValidDays = 365
+
+// pointsNow is the clock used for points expiry, replaced in tests
+var pointsNow = time.Now
+
+type (
+	// PointsAccount is the loyalty points summary of a customer
+	PointsAccount struct {
+		Email    string `json:"email"`
+		Balance  int    `json:"balance"`
+		Earned   int    `json:"earned"`
+		Redeemed int    `json:"redeemed"`
+		Updated  int64  `json:"updated"`
+	}
+
+	// PointsEntry is one record of the points history. Entries adding points
+	// are lots, Remaining tracks how much of the lot is unused so points are
+	// spent and expired oldest first.
+	PointsEntry struct {
+		ID        int    `json:"id"`
+		Email     string `json:"email"`
+		Type      string `json:"type"`
+		Points    int    `json:"points"`  // positive adds, negative takes
+		Balance   int    `json:"balance"` // balance after the entry is applied
+		Remaining int    `json:"remaining,omitempty"`
+		ExpireAt  int64  `json:"expire_at,omitempty"`
+		OrderID   string `json:"order_id,omitempty"`
+		Operator  string `json:"operator,omitempty"`
+		Comment   string `json:"comment,omitempty"`
+		Timestamp int64  `json:"timestamp"`
+	}
+
+	// pointsTx holds the buckets of one customer inside a write transaction
+	pointsTx struct {
+		email   string
+		ledger  *bolt.Bucket
+		account PointsAccount
+		now     int64
+	}
+)
+
+// EarnPoints adds points for a completed order. An order earns only once, a
+// second call for the same order returns the first entry.
+func EarnPoints(email string, points int, orderID string) (*PointsEntry, error) {
+	if points <= 0 {
+		return nil, ErrInvalidPoints
+	}
+
+	var entry *PointsEntry
+	err := withPoints(email, func(p *pointsTx) error {
+		if e := p.find(PointsEarn, orderID); e != nil {
+			entry = e
+			return nil
+		}
+		var err error
+		entry, err = p.add(PointsEarn, points, orderID, "", "")
+		return err
+	})
+	return entry, err
+}
+
+// RedeemPoints takes points from the balance to pay part of an order
+func RedeemPoints(email string, points int, orderID string) (*PointsEntry, error) {
+	if points <= 0 {
+		return nil, ErrInvalidPoints
+	}
+
+	var entry *PointsEntry
+	err := withPoints(email, func(p *pointsTx) error {
+		if p.account.Balance < points {
+			return ErrInsufficientPoints
+		}
+		var err error
+		entry, err = p.take(PointsRedeem, points, orderID, "", "")
+		if err == nil {
+			p.account.Redeemed += points
+		}
+		return err
+	})
+	return entry, err
+}
+
+// ReverseOrderPoints undo the points of a refunded or charged back order: the
+// earned points are taken back as far as the balance allows and redeemed
+// points are given back. It runs only once per order.
+func ReverseOrderPoints(email, orderID string) ([]PointsEntry, error) {
+	entries := []PointsEntry{}
+	err := withPoints(email, func(p *pointsTx) error {
+		if p.find(PointsReverse, orderID) != nil || p.find(PointsRefund, orderID) != nil {
+			return nil
+		}
+
+		if earned := p.find(PointsEarn, orderID); earned != nil {
+			points := earned.Points
+			if points > p.account.Balance {
+				points = p.account.Balance
+			}
+			if points > 0 {
+				e, err := p.take(PointsReverse, points, orderID, "", "order refunded")
+				if err != nil {
+					return err
+				}
+				p.account.Earned -= points
+				entries = append(entries, *e)
+			}
+		}
+
+		if redeemed := p.find(PointsRedeem, orderID); redeemed != nil {
+			e, err := p.add(PointsRefund, -redeemed.Points, orderID, "", "order refunded")
+			if err != nil {
+				return err
+			}
+			p.account.Redeemed += redeemed.Points
+			entries = append(entries, *e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// AdjustPoints is a manual change of the balance by an admin, a negative
+// value can not take more than the balance
+func AdjustPoints(email string, points int, operator, comment string) (*PointsEntry, error) {
+	if points == 0 {
+		return nil, ErrInvalidPoints
+	}
+
+	var entry *PointsEntry
+	err := withPoints(email, func(p *pointsTx) error {
+		var err error
+		if points > 0 {
+			entry, err = p.add(PointsAdjust, points, "", operator, comment)
+			return err
+		}
+		if p.account.Balance < -points {
+			return ErrInsufficientPoints
+		}
+		entry, err = p.take(PointsAdjust, -points, "", operator, comment)
+		return err
+	})
+	return entry, err
+}
+
+// PointsOf returns the points account of email, expired lots are not counted
+func PointsOf(email string) (*PointsAccount, error) {
+	email = strings.ToLower(email)
+	account := &PointsAccount{Email: email}
+	now := pointsNow().Unix()
+
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__points))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		pb := b.Bucket([]byte(email))
+		if pb == nil {
+			return nil
+		}
+		if v := pb.Get([]byte(pointsBalanceKey)); v != nil {
+			if err := json.Unmarshal(v, account); err != nil {
+				return err
+			}
+		}
+
+		// expired lots are written to the ledger on the next change, leave
+		// them out of the balance already
+		ledger := pb.Bucket([]byte(pointsLedgerKey))
+		if ledger == nil {
+			return nil
+		}
+		return ledger.ForEach(func(k, v []byte) error {
+			e := PointsEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if e.Remaining > 0 && e.ExpireAt > 0 && e.ExpireAt <= now {
+				account.Balance -= e.Remaining
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// PointsLedger returns the points history of email, newest first
+func PointsLedger(email string) ([]PointsEntry, error) {
+	email = strings.ToLower(email)
+	entries := []PointsEntry{}
+
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__points))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		pb := b.Bucket([]byte(email))
+		if pb == nil {
+			return nil
+		}
+		ledger := pb.Bucket([]byte(pointsLedgerKey))
+		if ledger == nil {
+			return nil
+		}
+
+		c := ledger.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			e := PointsEntry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// withPoints runs fn in one write transaction on the points of email, lots
+// which expired are removed before fn sees the balance
+func withPoints(email string, fn func(p *pointsTx) error) error {
+	email = strings.ToLower(email)
+	if len(email) == 0 {
+		return ErrInvalidPoints
+	}
+
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__points))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		pb, err := b.CreateBucketIfNotExists([]byte(email))
+		if err != nil {
+			return err
+		}
+		ledger, err := pb.CreateBucketIfNotExists([]byte(pointsLedgerKey))
+		if err != nil {
+			return err
+		}
+
+		p := &pointsTx{
+			email:   email,
+			ledger:  ledger,
+			account: PointsAccount{Email: email},
+			now:     pointsNow().Unix(),
+		}
+		if v := pb.Get([]byte(pointsBalanceKey)); v != nil {
+			if err := json.Unmarshal(v, &p.account); err != nil {
+				return err
+			}
+		}
+
+		if err := p.expire(); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+
+		p.account.Updated = p.now
+		j, err := json.Marshal(p.account)
+		if err != nil {
+			return err
+		}
+		return pb.Put([]byte(pointsBalanceKey), j)
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// find returns the first entry of kind for the order
+func (p *pointsTx) find(kind, orderID string) *PointsEntry {
+	if len(orderID) == 0 {
+		return nil
+	}
+	var found *PointsEntry
+	p.ledger.ForEach(func(k, v []byte) error {
+		if found != nil {
+			return nil
+		}
+		e := PointsEntry{}
+		if json.Unmarshal(v, &e) == nil && e.Type == kind && e.OrderID == orderID {
+			found = &e
+		}
+		return nil
+	})
+	return found
+}
+
+// put writes a new entry with the next ledger id
+func (p *pointsTx) put(e *PointsEntry) error {
+	if e.ID == 0 {
+		id, err := p.ledger.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = int(id)
+	}
+	j, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return p.ledger.Put(itob(e.ID), j)
+}
+
+// add creates a new lot of points
+func (p *pointsTx) add(kind string, points int, orderID, operator, comment string) (*PointsEntry, error) {
+	p.account.Balance += points
+	if kind == PointsEarn {
+		p.account.Earned += points
+	}
+	e := &PointsEntry{
+		Email:     p.email,
+		Type:      kind,
+		Points:    points,
+		Balance:   p.account.Balance,
+		Remaining: points,
+		OrderID:   orderID,
+		Operator:  operator,
+		Comment:   comment,
+		Timestamp: p.now,
+	}
+	if PointsValidDays > 0 {
+		e.ExpireAt = time.Unix(p.now, 0).AddDate(0, 0, PointsValidDays).Unix()
+	}
+	logger.Infof("Points %s %s %d, order %s, balance %d", p.email, kind, points, orderID, p.account.Balance)
+	return e, p.put(e)
+}
+
+// take spends points from the oldest lots
+func (p *pointsTx) take(kind string, points int, orderID, operator, comment string) (*PointsEntry, error) {
+	lots := []PointsEntry{}
+	err := p.ledger.ForEach(func(k, v []byte) error {
+		lot := PointsEntry{}
+		if err := json.Unmarshal(v, &lot); err != nil {
+			return err
+		}
+		if lot.Remaining > 0 {
+			lots = append(lots, lot)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	left := points
+	for i := 0; i < len(lots) && left > 0; i++ {
+		used := lots[i].Remaining
+		if used > left {
+			used = left
+		}
+		lots[i].Remaining -= used
+		left -= used
+		if err := p.put(&lots[i]); err != nil {
+			return nil, err
+		}
+	}
+	if left > 0 {
+		return nil, ErrInsufficientPoints
+	}
+
+	p.account.Balance -= points
+	e := &PointsEntry{
+		Email:     p.email,
+		Type:      kind,
+		Points:    -points,
+		Balance:   p.account.Balance,
+		OrderID:   orderID,
+		Operator:  operator,
+		Comment:   comment,
+		Timestamp: p.now,
+	}
+	logger.Infof("Points %s %s %d, order %s, balance %d", p.email, kind, points, orderID, p.account.Balance)
+	return e, p.put(e)
+}
+
+// expire clears the lots which passed their expiry time
+func (p *pointsTx) expire() error {
+	expired := []PointsEntry{}
+	err := p.ledger.ForEach(func(k, v []byte) error {
+		lot := PointsEntry{}
+		if err := json.Unmarshal(v, &lot); err != nil {
+			return err
+		}
+		if lot.Remaining > 0 && lot.ExpireAt > 0 && lot.ExpireAt <= p.now {
+			expired = append(expired, lot)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, lot := range expired {
+		points := lot.Remaining
+		lot.Remaining = 0
+		if err := p.put(&lot); err != nil {
+			return err
+		}
+		p.account.Balance -= points
+		e := &PointsEntry{
+			Email:     p.email,
+			Type:      PointsExpire,
+			Points:    -points,
+			Balance:   p.account.Balance,
+			OrderID:   lot.OrderID,
+			Comment:   "points expired",
+			Timestamp: p.now,
+		}
+		if err := p.put(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
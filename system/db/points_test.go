@@ -0,0 +1,89 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPointsEarnRedeemReverse(t *testing.T) {
+	defer openTestStore(t, DB__points)()
+
+	email := "Buyer@example.com"
+	if _, err := EarnPoints(email, 100, "A1"); err != nil {
+		t.Fatal(err)
+	}
+	// the same order earns only once
+	if _, err := EarnPoints(email, 100, "A1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EarnPoints(email, 50, "A2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RedeemPoints(email, 200, "B1"); err != ErrInsufficientPoints {
+		t.Errorf("expected ErrInsufficientPoints, got %v", err)
+	}
+	if _, err := RedeemPoints(email, 120, "B1"); err != nil {
+		t.Fatal(err)
+	}
+
+	acc, _ := PointsOf(email)
+	if acc.Balance != 30 || acc.Earned != 150 || acc.Redeemed != 120 {
+		t.Errorf("unexpected account %+v", acc)
+	}
+
+	// refund of A2 can only take back what is left
+	if _, err := ReverseOrderPoints(email, "A2"); err != nil {
+		t.Fatal(err)
+	}
+	acc, _ = PointsOf(email)
+	if acc.Balance != 0 {
+		t.Errorf("expected balance 0 after reverse, got %d", acc.Balance)
+	}
+
+	// refund of B1 gives the redeemed points back, once
+	ReverseOrderPoints(email, "B1")
+	ReverseOrderPoints(email, "B1")
+	acc, _ = PointsOf(email)
+	if acc.Balance != 120 {
+		t.Errorf("expected balance 120 after refund, got %d", acc.Balance)
+	}
+
+	history, _ := PointsLedger(email)
+	if len(history) != 5 || history[0].Type != PointsRefund {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
+
+func TestPointsExpire(t *testing.T) {
+	defer openTestStore(t, DB__points)()
+
+	defer func() { pointsNow = time.Now }()
+
+	email := "buyer@example.com"
+	if _, err := EarnPoints(email, 80, "A1"); err != nil {
+		t.Fatal(err)
+	}
+	pointsNow = func() time.Time { return time.Now().AddDate(0, 0, PointsValidDays+1) }
+	if _, err := AdjustPoints(email, 20, "admin@example.com", "gift"); err != nil {
+		t.Fatal(err)
+	}
+
+	acc, _ := PointsOf(email)
+	if acc.Balance != 20 {
+		t.Errorf("expired points still counted, balance %d", acc.Balance)
+	}
+	if _, err := AdjustPoints(email, -30, "admin@example.com", ""); err != ErrInsufficientPoints {
+		t.Errorf("expected ErrInsufficientPoints, got %v", err)
+	}
+
+	history, _ := PointsLedger(email)
+	found := false
+	for _, e := range history {
+		if e.Type == PointsExpire && e.Points == -80 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expire entry not written: %+v", history)
+	}
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrPreviewInvalid is returned for a preview token which is not signed by
+// the shop, has expired or was revoked
+var ErrPreviewInvalid = errors.New("Error. Preview link is invalid or expired.")
+
+// PreviewMaxTTL is the longest time a preview link can be valid
+var PreviewMaxTTL = 30 * 24 * time.Hour
+
+var (
+	// previewNow is the clock of the preview expiry, replaced in tests
+	previewNow = time.Now
+
+	// previewSecret returns the key preview tokens are signed with. It is
+	// kept apart from the login tokens, so a preview link never passes as a
+	// login.
+	previewSecret = func() []byte {
+		secret, _ := ConfigCache("client_secret").(string)
+		return []byte("preview|" + secret)
+	}
+)
+
+// Preview is a link which shows one pending content item to people without
+// an admin account until it expires or is revoked
+type Preview struct {
+	ID      string `json:"id"`
+	Target  string `json:"target"` // type__pending:id
+	Author  string `json:"author,omitempty"`
+	Created int64  `json:"created"` // unix seconds
+	Expires int64  `json:"expires"`
+}
+
+// sign returns the signature of the preview, binding its id to the target
+// and expiry
+func (p Preview) sign() string {
+	mac := hmac.New(sha256.New, previewSecret())
+	fmt.Fprintf(mac, "%s|%s|%d", p.ID, p.Target, p.Expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CreatePreview makes a preview link of the pending content target valid for
+// ttl, it returns the preview and its token
+func CreatePreview(target string, ttl time.Duration, author string) (*Preview, string, error) {
+	t := strings.Split(target, ":")
+	if len(t) != 2 || !strings.HasSuffix(t[0], "__pending") || !IsValidID(t[1]) {
+		return nil, "", fmt.Errorf("Not a pending content: %s", target)
+	}
+	if ttl <= 0 || ttl > PreviewMaxTTL {
+		return nil, "", fmt.Errorf("Preview time must be up to %s", PreviewMaxTTL)
+	}
+	c, err := Content(target)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(c) == 0 {
+		return nil, "", ErrBucketNoSuchRecord
+	}
+
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	now := previewNow()
+	p := &Preview{
+		ID:      base64.RawURLEncoding.EncodeToString(b),
+		Target:  target,
+		Author:  author,
+		Created: now.Unix(),
+		Expires: now.Add(ttl).Unix(),
+	}
+	j, err := json.Marshal(p)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(DB__previews))
+		if err != nil {
+			return err
+		}
+		// drop the expired links on the way
+		expired := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			old := Preview{}
+			if json.Unmarshal(v, &old) == nil && old.Expires <= now.Unix() {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte(p.ID), j)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return p, p.ID + "." + p.sign(), nil
+}
+
+// PreviewTarget checks a preview token and returns the pending content it
+// shows
+func PreviewTarget(token string) (string, error) {
+	t := strings.Split(token, ".")
+	if len(t) != 2 {
+		return "", ErrPreviewInvalid
+	}
+
+	p := Preview{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__previews))
+		if b == nil {
+			return ErrPreviewInvalid
+		}
+		v := b.Get([]byte(t[0]))
+		if v == nil {
+			return ErrPreviewInvalid
+		}
+		return json.Unmarshal(v, &p)
+	})
+	if err != nil {
+		return "", ErrPreviewInvalid
+	}
+	if !hmac.Equal([]byte(p.sign()), []byte(t[1])) || p.Expires <= previewNow().Unix() {
+		return "", ErrPreviewInvalid
+	}
+	return p.Target, nil
+}
+
+// Previews returns the preview links of target, of every item when target is
+// empty, newest first
+func Previews(target string) ([]Preview, error) {
+	list := []Preview{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__previews))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			p := Preview{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if len(target) == 0 || p.Target == target {
+				list = append(list, p)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Created > list[j].Created })
+	return list, err
+}
+
+// RevokePreview ends the preview link id at once
+func RevokePreview(id string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__previews))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrPreviewInvalid
+		}
+		return b.Delete([]byte(id))
+	})
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPreviews(t *testing.T) {
+	defer openTestStore(t, "News__pending", DB__previews)()
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	clock, secret := previewNow, previewSecret
+	previewNow = func() time.Time { return now }
+	previewSecret = func() []byte { return []byte("test") }
+	defer func() { previewNow, previewSecret = clock, secret }()
+
+	putRefContent(t, "News__pending", "3", `{"id":3,"title":"Draft"}`)
+
+	if _, _, err := CreatePreview("News:3", time.Hour, ""); err == nil {
+		t.Error("expected public content refused")
+	}
+	if _, _, err := CreatePreview("News__pending:4", time.Hour, ""); err == nil {
+		t.Error("expected missing content refused")
+	}
+	if _, _, err := CreatePreview("News__pending:3", PreviewMaxTTL+time.Hour, ""); err == nil {
+		t.Error("expected too long preview refused")
+	}
+
+	p, token, err := CreatePreview("News__pending:3", 24*time.Hour, "admin@eshop.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, err := PreviewTarget(token); err != nil || target != "News__pending:3" {
+		t.Errorf("expected pending target, got %s %v", target, err)
+	}
+	for _, bad := range []string{"", p.ID, p.ID + ".x", "x." + token[len(p.ID)+1:], token + "x"} {
+		if _, err := PreviewTarget(bad); !errors.Is(err, ErrPreviewInvalid) {
+			t.Errorf("%q: expected invalid, got %v", bad, err)
+		}
+	}
+
+	// a token signed with another secret fails
+	previewSecret = func() []byte { return []byte("other") }
+	if _, err := PreviewTarget(token); !errors.Is(err, ErrPreviewInvalid) {
+		t.Errorf("expected other secret invalid, got %v", err)
+	}
+	previewSecret = func() []byte { return []byte("test") }
+
+	now = now.Add(25 * time.Hour)
+	if _, err := PreviewTarget(token); !errors.Is(err, ErrPreviewInvalid) {
+		t.Errorf("expected expired, got %v", err)
+	}
+
+	// creating a link drops the expired ones
+	p2, token2, err := CreatePreview("News__pending:3", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := Previews("News__pending:3"); len(list) != 1 || list[0].ID != p2.ID {
+		t.Errorf("expected only the new preview, got %+v", list)
+	}
+	if err = RevokePreview(p2.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PreviewTarget(token2); !errors.Is(err, ErrPreviewInvalid) {
+		t.Errorf("expected revoked, got %v", err)
+	}
+	if err = RevokePreview(p2.ID); !errors.Is(err, ErrPreviewInvalid) {
+		t.Errorf("expected revoke twice invalid, got %v", err)
+	}
+}
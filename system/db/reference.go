@@ -0,0 +1,333 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/item"
+)
+
+// ErrReferenceNotFound is returned when a reference field names an item which
+// does not exist
+var ErrReferenceNotFound = errors.New("Error. Referenced content not found.")
+
+// ErrReferenced is returned when deleting an item a restrict reference still
+// points to
+var ErrReferenced = errors.New("Error. Content is still referenced.")
+
+// referencesOf returns the references declared by content type ns
+func referencesOf(ns string) []item.Reference {
+	t, ok := item.Types[ns]
+	if !ok {
+		return nil
+	}
+	r, ok := t().(item.Referencer)
+	if !ok {
+		return nil
+	}
+	return r.References()
+}
+
+// referrersOf returns the references to content type ns, by referencing type
+func referrersOf(ns string) map[string][]item.Reference {
+	refs := map[string][]item.Reference{}
+	for t := range item.Types {
+		for _, r := range referencesOf(t) {
+			if r.Type == ns {
+				refs[t] = append(refs[t], r)
+			}
+		}
+	}
+	return refs
+}
+
+// splitKeys returns the keys held by a reference field value. The editor
+// saves a select as "id,name" and a multiselect as a json list of those.
+func splitKeys(v string, multi bool) []string {
+	v = strings.TrimSpace(v)
+	if len(v) == 0 || v == "[]" {
+		return nil
+	}
+	if !multi || !strings.HasPrefix(v, "[") {
+		return []string{v}
+	}
+	list := []string{}
+	if err := json.Unmarshal([]byte(v), &list); err != nil {
+		return []string{v}
+	}
+	keys := []string{}
+	for _, k := range list {
+		if k = strings.TrimSpace(k); len(k) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// joinKeys is the reverse of splitKeys
+func joinKeys(keys []string, multi bool) string {
+	if !multi {
+		return strings.Join(keys, "")
+	}
+	j, _ := json.Marshal(keys)
+	return string(j)
+}
+
+// ReferenceKey splits a reference key "id,name" in its id and name. A bare
+// id has no name and a bare name has id 0.
+func ReferenceKey(key string) (int, string) {
+	p := strings.SplitN(strings.TrimSpace(key), ",", 2)
+	if !IsValidID(p[0]) {
+		return 0, strings.Join(p, ",")
+	}
+	id, _ := strconv.Atoi(p[0])
+	if len(p) == 1 {
+		return id, ""
+	}
+	return id, p[1]
+}
+
+// matchKey tells if content c is the one key names. A key is "id,name", a
+// bare id or a bare key field value, the id wins when there is one.
+func matchKey(c map[string]interface{}, r item.Reference, key string) bool {
+	id, name := ReferenceKey(key)
+	if id > 0 {
+		cid, _ := c["id"].(float64)
+		return id == int(cid)
+	}
+	v, ok := c[r.KeyField()].(string)
+	return ok && v == name
+}
+
+// renameKey returns key with the key field value changed from oldKey to newKey
+func renameKey(key, oldKey, newKey string) (string, bool) {
+	if key == oldKey {
+		return newKey, true
+	}
+	p := strings.SplitN(key, ",", 2)
+	if len(p) == 2 && p[1] == oldKey {
+		return p[0] + "," + newKey, true
+	}
+	return key, false
+}
+
+// contentMaps reads all content of ns as generic maps
+func contentMaps(ns string) []map[string]interface{} {
+	list := []map[string]interface{}{}
+	for _, buf := range ContentAll(ns) {
+		c := map[string]interface{}{}
+		if json.Unmarshal(buf, &c) == nil {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// CheckReferences checks the reference fields set in data name existing
+// content, fields not in data are not checked
+func CheckReferences(ns string, data url.Values) error {
+	if strings.Contains(ns, "__") {
+		return nil
+	}
+	for _, r := range referencesOf(ns) {
+		v := data.Get(r.Field)
+		if v == RemoveString {
+			continue
+		}
+		keys := splitKeys(v, r.Multi)
+		if len(keys) == 0 {
+			continue
+		}
+		targets := contentMaps(r.Type)
+		for _, key := range keys {
+			found := false
+			for _, c := range targets {
+				if matchKey(c, r, key) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%w %s %q of field %s", ErrReferenceNotFound, r.Type, key, r.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// refers tells if the reference field of c points to target
+func refers(c map[string]interface{}, r item.Reference, target map[string]interface{}) bool {
+	v, _ := c[r.Field].(string)
+	for _, key := range splitKeys(v, r.Multi) {
+		if matchKey(target, r, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Referrers returns the content pointing to target "Type:id", by type
+func Referrers(target string) (map[string][]json.RawMessage, error) {
+	ns := strings.Split(target, ":")[0]
+	buf, err := Content(target)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("%w %s", ErrReferenceNotFound, target)
+	}
+	t := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &t); err != nil {
+		return nil, err
+	}
+
+	result := map[string][]json.RawMessage{}
+	for typ, refs := range referrersOf(ns) {
+		for _, raw := range ContentAll(typ) {
+			c := map[string]interface{}{}
+			if json.Unmarshal(raw, &c) != nil {
+				continue
+			}
+			for _, r := range refs {
+				if refers(c, r, t) {
+					result[typ] = append(result[typ], append(json.RawMessage{}, raw...))
+					break
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// refHit is a reference found to content being deleted, the referencing
+// content and the value of its reference field
+type refHit struct {
+	target string
+	ref    item.Reference
+	value  string
+}
+
+// cascadeHits returns the references to content ns:id, t, and walks down the
+// content the cascade references delete in turn. A restrict reference
+// anywhere in the tree is an error, so nothing is deleted when a part of it
+// can not be. seen keeps the content walked already.
+func cascadeHits(ns, id string, t map[string]interface{}, seen map[string]bool) ([]refHit, error) {
+	seen[ns+":"+id] = true
+	hits := []refHit{}
+	for typ, list := range referrersOf(ns) {
+		for _, c := range contentMaps(typ) {
+			cid, _ := c["id"].(float64)
+			target := fmt.Sprintf("%s:%d", typ, int(cid))
+			for _, r := range list {
+				if !refers(c, r, t) {
+					continue
+				}
+				if r.OnDelete != item.OnDeleteCascade {
+					return nil, fmt.Errorf("%w %s:%s is used by %s field %s", ErrReferenced, ns, id, target, r.Field)
+				}
+				v, _ := c[r.Field].(string)
+				hits = append(hits, refHit{target: target, ref: r, value: v})
+				if r.Multi || seen[target] {
+					continue
+				}
+				if _, err := cascadeHits(typ, strconv.Itoa(int(cid)), c, seen); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return hits, nil
+}
+
+// deleteReferences applies the delete rules of the references to target
+// before it is deleted. Restrict references stop the delete, cascade
+// references delete the referencing content or drop the key from a list.
+// The whole cascade is checked before the first change.
+func deleteReferences(ns, id string, buf []byte) error {
+	if len(referrersOf(ns)) == 0 {
+		return nil
+	}
+	t := map[string]interface{}{}
+	if err := json.Unmarshal(buf, &t); err != nil {
+		return err
+	}
+	hits, err := cascadeHits(ns, id, t, map[string]bool{})
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hits {
+		// the cascade of an earlier hit may have deleted it
+		if c, _ := Content(h.target); len(c) == 0 {
+			continue
+		}
+		if !h.ref.Multi {
+			logger.Infof("Delete %s which refers to %s:%s", h.target, ns, id)
+			if err := DeleteContent(h.target); err != nil {
+				return err
+			}
+			continue
+		}
+		keys := []string{}
+		for _, key := range splitKeys(h.value, true) {
+			if !matchKey(t, h.ref, key) {
+				keys = append(keys, key)
+			}
+		}
+		logger.Infof("Remove %s:%s from %s field %s", ns, id, h.target, h.ref.Field)
+		if _, err := UpdateContent(h.target, url.Values{h.ref.Field: {joinKeys(keys, true)}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// followRename updates the reference fields pointing to content of ns when
+// its key field changed
+func followRename(ns string, before, after []byte) {
+	refs := referrersOf(ns)
+	if len(refs) == 0 {
+		return
+	}
+	b, a := map[string]interface{}{}, map[string]interface{}{}
+	if json.Unmarshal(before, &b) != nil || json.Unmarshal(after, &a) != nil {
+		return
+	}
+
+	for typ, list := range refs {
+		for _, r := range list {
+			oldKey, _ := b[r.KeyField()].(string)
+			newKey, _ := a[r.KeyField()].(string)
+			if len(oldKey) == 0 || len(newKey) == 0 || oldKey == newKey {
+				continue
+			}
+			for _, c := range contentMaps(typ) {
+				v, _ := c[r.Field].(string)
+				keys := splitKeys(v, r.Multi)
+				changed := false
+				for i, key := range keys {
+					if !matchKey(b, r, key) {
+						continue
+					}
+					if k, ok := renameKey(key, oldKey, newKey); ok {
+						keys[i] = k
+						changed = true
+					}
+				}
+				if !changed {
+					continue
+				}
+				cid, _ := c["id"].(float64)
+				target := fmt.Sprintf("%s:%d", typ, int(cid))
+				logger.Infof("Rename %s field %s from %s to %s", target, r.Field, oldKey, newKey)
+				if _, err := UpdateContent(target, url.Values{r.Field: {joinKeys(keys, r.Multi)}}); err != nil {
+					logger.Errorf("Rename reference of %s error: %s", target, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/agreyfox/eshop/system/item"
+	"github.com/boltdb/bolt"
+)
+
+type refGame struct {
+	item.Item
+	Name string `json:"name"`
+}
+
+type refServer struct {
+	item.Item
+	Name  string `json:"name"`
+	Game  string `json:"game"`
+	Coins string `json:"coins"`
+}
+
+func (s *refServer) References() []item.Reference {
+	return []item.Reference{
+		{Field: "game", Type: "RefGame", OnDelete: item.OnDeleteRestrict},
+		{Field: "coins", Type: "RefCoin", Multi: true, OnDelete: item.OnDeleteCascade},
+	}
+}
+
+type refRegion struct {
+	item.Item
+	Name string `json:"name"`
+	Game string `json:"game"`
+}
+
+func (r *refRegion) References() []item.Reference {
+	return []item.Reference{{Field: "game", Type: "RefGame", OnDelete: item.OnDeleteCascade}}
+}
+
+type refShop struct {
+	item.Item
+	Name   string `json:"name"`
+	Region string `json:"region"`
+}
+
+func (s *refShop) References() []item.Reference {
+	return []item.Reference{{Field: "region", Type: "RefRegion", OnDelete: item.OnDeleteRestrict}}
+}
+
+func putRefContent(t *testing.T, ns, id, j string) {
+	err := store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(ns)).Put([]byte(id), []byte(j))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	defer openTestStore(t, "RefGame", "RefServer", "RefCoin")()
+	item.Types["RefGame"] = func() interface{} { return new(refGame) }
+	item.Types["RefCoin"] = func() interface{} { return new(refGame) }
+	item.Types["RefServer"] = func() interface{} { return new(refServer) }
+	defer func() {
+		delete(item.Types, "RefGame")
+		delete(item.Types, "RefCoin")
+		delete(item.Types, "RefServer")
+	}()
+
+	putRefContent(t, "RefGame", "1", `{"id":1,"name":"WOW"}`)
+	putRefContent(t, "RefGame", "2", `{"id":2,"name":"POE"}`)
+	putRefContent(t, "RefCoin", "1", `{"id":1,"name":"Gold"}`)
+	putRefContent(t, "RefServer", "1", `{"id":1,"name":"US","game":"1,WOW","coins":"[\"1,Gold\"]"}`)
+
+	cases := []struct {
+		data url.Values
+		ok   bool
+	}{
+		{url.Values{"game": {"1,WOW"}}, true},
+		{url.Values{"game": {"2"}}, true},
+		{url.Values{"game": {"POE"}}, true},
+		{url.Values{"game": {"9,Diablo"}}, false},
+		{url.Values{"game": {"Diablo"}}, false},
+		{url.Values{"coins": {`["1,Gold"]`}}, true},
+		{url.Values{"coins": {`["1,Gold","2,Silver"]`}}, false},
+		{url.Values{"coins": {"[]"}}, true},
+		{url.Values{"game": {RemoveString}}, true},
+		{url.Values{"name": {"EU"}}, true},
+	}
+	for _, c := range cases {
+		err := CheckReferences("RefServer", c.data)
+		if c.ok && err != nil {
+			t.Errorf("%v: unexpected error %s", c.data, err)
+		}
+		if !c.ok && !errors.Is(err, ErrReferenceNotFound) {
+			t.Errorf("%v: expected not found, got %v", c.data, err)
+		}
+	}
+
+	refs, err := Referrers("RefGame:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs["RefServer"]) != 1 {
+		t.Errorf("expected 1 server of WOW, got %v", refs)
+	}
+	if refs, _ = Referrers("RefGame:2"); len(refs) != 0 {
+		t.Errorf("expected no referrer of POE, got %v", refs)
+	}
+	if _, err = Referrers("RefGame:9"); !errors.Is(err, ErrReferenceNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	buf, _ := Content("RefGame:1")
+	if err = deleteReferences("RefGame", "1", buf); !errors.Is(err, ErrReferenced) {
+		t.Errorf("expected restrict error, got %v", err)
+	}
+	buf, _ = Content("RefGame:2")
+	if err = deleteReferences("RefGame", "2", buf); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestReferenceKeys(t *testing.T) {
+	if keys := splitKeys(`["1,Gold","2,Silver"]`, true); len(keys) != 2 || keys[1] != "2,Silver" {
+		t.Errorf("split list got %v", keys)
+	}
+	if keys := splitKeys("6,Star Trek Online", false); len(keys) != 1 {
+		t.Errorf("split select got %v", keys)
+	}
+	if v := joinKeys([]string{}, true); v != "[]" {
+		t.Errorf("join empty list got %s", v)
+	}
+	if k, ok := renameKey("6,STO", "STO", "Star Trek Online"); !ok || k != "6,Star Trek Online" {
+		t.Errorf("rename got %s", k)
+	}
+}
+
+// TestDeleteCascadeTree checks a restrict reference below a cascade stops the
+// delete before any content of the cascade is deleted
+func TestDeleteCascadeTree(t *testing.T) {
+	defer openTestStore(t, "RefGame", "RefRegion", "RefShop")()
+	item.Types["RefGame"] = func() interface{} { return new(refGame) }
+	item.Types["RefRegion"] = func() interface{} { return new(refRegion) }
+	item.Types["RefShop"] = func() interface{} { return new(refShop) }
+	defer func() {
+		delete(item.Types, "RefGame")
+		delete(item.Types, "RefRegion")
+		delete(item.Types, "RefShop")
+	}()
+
+	putRefContent(t, "RefGame", "1", `{"id":1,"name":"WOW"}`)
+	putRefContent(t, "RefRegion", "1", `{"id":1,"name":"EU","game":"1,WOW"}`)
+	putRefContent(t, "RefRegion", "2", `{"id":2,"name":"US","game":"1,WOW"}`)
+	putRefContent(t, "RefShop", "1", `{"id":1,"name":"Shop","region":"2,US"}`)
+
+	buf, _ := Content("RefGame:1")
+	if err := deleteReferences("RefGame", "1", buf); !errors.Is(err, ErrReferenced) {
+		t.Fatalf("expected restrict error, got %v", err)
+	}
+	for _, target := range []string{"RefRegion:1", "RefRegion:2"} {
+		if c, _ := Content(target); len(c) == 0 {
+			t.Errorf("%s deleted before the restrict reference was found", target)
+		}
+	}
+}
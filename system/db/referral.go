@@ -0,0 +1,566 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// CommissionPending is earned but not paid out yet
+	CommissionPending = "pending"
+	// CommissionPaid was sent to the affiliate in a payout batch
+	CommissionPaid = "paid"
+	// CommissionReversed belongs to a refunded order and will not be paid
+	CommissionReversed = "reversed"
+
+	// ReferralCookie keeps the referral code of the affiliate link the
+	// customer came from
+	ReferralCookie = "eshop_ref"
+
+	referralCodesKey  = "__codes"
+	referralOrdersKey = "__orders"
+	referralCodeChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	referralCodeLen   = 8
+)
+
+// ErrSelfReferral is returned when an affiliate buys with its own code
+var ErrSelfReferral = errors.New("Error. Affiliate can not refer itself.")
+
+// ErrReferralNotFound is returned for an unknown referral code
+var ErrReferralNotFound = errors.New("Error. Referral code not found.")
+
+// ReferralCookieDays is how long an affiliate link is attributed to the customer
+var ReferralCookieDays = 30
+
+type (
+	// Affiliate is the referral account of a customer, Earned is the total of
+	// the commissions not reversed, Paid the part already sent by payout
+	Affiliate struct {
+		Email       string  `json:"email"`
+		Code        string  `json:"code"`
+		PayPal      string  `json:"paypal,omitempty"` // payout receiver, email when empty
+		Clicks      int     `json:"clicks"`
+		Conversions int     `json:"conversions"`
+		Earned      float64 `json:"earned"`
+		Paid        float64 `json:"paid"`
+		Created     int64   `json:"created"`
+		Updated     int64   `json:"updated"`
+	}
+
+	// Commission is the affiliate share of one referred order. A refund after
+	// the payout writes a negative commission which is taken from the next payout.
+	Commission struct {
+		ID        int     `json:"id"`
+		Affiliate string  `json:"affiliate"`
+		OrderID   string  `json:"order_id"`
+		Buyer     string  `json:"buyer,omitempty"`
+		Total     float64 `json:"order_total"`
+		Amount    float64 `json:"amount"`
+		Currency  string  `json:"currency"`
+		Status    string  `json:"status"`
+		BatchID   string  `json:"batch_id,omitempty"`
+		Timestamp int64   `json:"timestamp"`
+		Updated   int64   `json:"updated,omitempty"`
+	}
+
+	// AffiliateBalance is the pending commission of an affiliate in one
+	// currency, the unit of a payout
+	AffiliateBalance struct {
+		Email    string  `json:"email"`
+		Receiver string  `json:"receiver"`
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+		IDs      []int   `json:"ids"`
+	}
+)
+
+// ReferralCodeOf returns the affiliate account of email, the account and its
+// referral code are created on the first call
+func ReferralCodeOf(email string) (*Affiliate, error) {
+	email = strings.ToLower(email)
+	if len(email) == 0 {
+		return nil, ErrNoUserExists
+	}
+
+	aff := &Affiliate{}
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		ab, err := b.CreateBucketIfNotExists([]byte(email))
+		if err != nil {
+			return err
+		}
+		if v := ab.Get([]byte(walletBalanceKey)); v != nil {
+			return json.Unmarshal(v, aff)
+		}
+
+		codes, err := b.CreateBucketIfNotExists([]byte(referralCodesKey))
+		if err != nil {
+			return err
+		}
+		code := newReferralCode()
+		for codes.Get([]byte(code)) != nil {
+			code = newReferralCode()
+		}
+		if err := codes.Put([]byte(code), []byte(email)); err != nil {
+			return err
+		}
+
+		now := time.Now().Unix()
+		*aff = Affiliate{Email: email, Code: code, Created: now, Updated: now}
+		return putAffiliate(ab, aff)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aff, nil
+}
+
+// AffiliateByCode returns the affiliate owning code
+func AffiliateByCode(code string) (*Affiliate, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	aff := &Affiliate{}
+
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		ab := affiliateBucket(b, code)
+		if ab == nil {
+			return ErrReferralNotFound
+		}
+		return json.Unmarshal(ab.Get([]byte(walletBalanceKey)), aff)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aff, nil
+}
+
+// SetAffiliatePayPal sets the PayPal account commissions of email are paid to
+func SetAffiliatePayPal(email, paypal string) (*Affiliate, error) {
+	aff, err := ReferralCodeOf(email)
+	if err != nil {
+		return nil, err
+	}
+	err = withAffiliate(aff.Code, func(ab *bolt.Bucket, a *Affiliate) error {
+		a.PayPal = strings.TrimSpace(paypal)
+		aff = a
+		return nil
+	})
+	return aff, err
+}
+
+// RecordReferralClick counts a visit through the affiliate link of code
+func RecordReferralClick(code string) error {
+	return withAffiliate(code, func(ab *bolt.Bucket, a *Affiliate) error {
+		a.Clicks++
+		return nil
+	})
+}
+
+// AddCommission records the commission of a referred order. An order gives
+// only one commission, a second call returns the first one.
+func AddCommission(code, orderID, buyer string, total, amount float64, currency string) (*Commission, error) {
+	amount = roundAmount(amount)
+	if amount <= 0 || len(orderID) == 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	var entry *Commission
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		orders, err := b.CreateBucketIfNotExists([]byte(referralOrdersKey))
+		if err != nil {
+			return err
+		}
+
+		ab := affiliateBucket(b, code)
+		if ab == nil {
+			return ErrReferralNotFound
+		}
+		aff := &Affiliate{}
+		if err := json.Unmarshal(ab.Get([]byte(walletBalanceKey)), aff); err != nil {
+			return err
+		}
+		if strings.EqualFold(aff.Email, buyer) {
+			return ErrSelfReferral
+		}
+		ledger, err := ab.CreateBucketIfNotExists([]byte(walletLedgerKey))
+		if err != nil {
+			return err
+		}
+
+		if v := orders.Get([]byte(orderID)); v != nil {
+			entry = findCommission(ledger, orderID)
+			return nil
+		}
+
+		entry = &Commission{
+			Affiliate: aff.Email,
+			OrderID:   orderID,
+			Buyer:     strings.ToLower(buyer),
+			Total:     roundAmount(total),
+			Amount:    amount,
+			Currency:  currency,
+			Status:    CommissionPending,
+			Timestamp: time.Now().Unix(),
+		}
+		if err := putCommission(ledger, entry); err != nil {
+			return err
+		}
+		if err := orders.Put([]byte(orderID), []byte(aff.Email)); err != nil {
+			return err
+		}
+
+		aff.Conversions++
+		aff.Earned = roundAmount(aff.Earned + amount)
+		return putAffiliate(ab, aff)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Affiliate %s commission %.2f %s for order %s", entry.Affiliate, entry.Amount, entry.Currency, orderID)
+	return entry, nil
+}
+
+// ReverseCommission cancels the commission of a refunded order. A pending
+// commission is reversed, a paid one is taken back from the next payout.
+func ReverseCommission(orderID string) (*Commission, error) {
+	var entry *Commission
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		orders := b.Bucket([]byte(referralOrdersKey))
+		if orders == nil {
+			return nil
+		}
+		email := orders.Get([]byte(orderID))
+		if email == nil {
+			return nil
+		}
+		ab := b.Bucket(email)
+		if ab == nil {
+			return nil
+		}
+		ledger := ab.Bucket([]byte(walletLedgerKey))
+		if ledger == nil {
+			return nil
+		}
+		aff := &Affiliate{}
+		if err := json.Unmarshal(ab.Get([]byte(walletBalanceKey)), aff); err != nil {
+			return err
+		}
+
+		c := findCommission(ledger, orderID)
+		if c == nil || c.Status == CommissionReversed || c.Amount < 0 {
+			return nil
+		}
+		for _, e := range commissionsOf(ledger) {
+			if e.OrderID == orderID && e.Amount < 0 {
+				return nil // taken back already
+			}
+		}
+
+		now := time.Now().Unix()
+		if c.Status == CommissionPending {
+			c.Status = CommissionReversed
+			c.Updated = now
+			j, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := ledger.Put(itob(c.ID), j); err != nil {
+				return err
+			}
+			entry = c
+		} else {
+			entry = &Commission{
+				Affiliate: c.Affiliate,
+				OrderID:   orderID,
+				Buyer:     c.Buyer,
+				Total:     c.Total,
+				Amount:    -c.Amount,
+				Currency:  c.Currency,
+				Status:    CommissionPending,
+				Timestamp: now,
+			}
+			if err := putCommission(ledger, entry); err != nil {
+				return err
+			}
+		}
+
+		aff.Conversions--
+		aff.Earned = roundAmount(aff.Earned - c.Amount)
+		return putAffiliate(ab, aff)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil {
+		logger.Infof("Affiliate %s commission of order %s reversed", entry.Affiliate, orderID)
+	}
+	return entry, nil
+}
+
+// CommissionsOf returns the commissions of email, newest first
+func CommissionsOf(email string) ([]Commission, error) {
+	email = strings.ToLower(email)
+	entries := []Commission{}
+
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		ab := b.Bucket([]byte(email))
+		if ab == nil {
+			return nil
+		}
+		ledger := ab.Bucket([]byte(walletLedgerKey))
+		if ledger == nil {
+			return nil
+		}
+		all := commissionsOf(ledger)
+		for i := len(all) - 1; i >= 0; i-- {
+			entries = append(entries, all[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// AffiliateBalances sums the pending commissions of every affiliate per
+// currency, balances below min are left for a later payout
+func AffiliateBalances(min float64) ([]AffiliateBalance, error) {
+	balances := []AffiliateBalance{}
+
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		return b.ForEach(func(k, v []byte) error {
+			ab := b.Bucket(k)
+			if v != nil || ab == nil || strings.HasPrefix(string(k), "__") {
+				return nil
+			}
+			ledger := ab.Bucket([]byte(walletLedgerKey))
+			if ledger == nil {
+				return nil
+			}
+			aff := &Affiliate{}
+			if err := json.Unmarshal(ab.Get([]byte(walletBalanceKey)), aff); err != nil {
+				return err
+			}
+			receiver := aff.PayPal
+			if len(receiver) == 0 {
+				receiver = aff.Email
+			}
+
+			sums := map[string]*AffiliateBalance{}
+			for _, c := range commissionsOf(ledger) {
+				if c.Status != CommissionPending {
+					continue
+				}
+				s, ok := sums[c.Currency]
+				if !ok {
+					s = &AffiliateBalance{Email: aff.Email, Receiver: receiver, Currency: c.Currency}
+					sums[c.Currency] = s
+				}
+				s.Amount = roundAmount(s.Amount + c.Amount)
+				s.IDs = append(s.IDs, c.ID)
+			}
+			for _, s := range sums {
+				if s.Amount > 0 && s.Amount >= min {
+					balances = append(balances, *s)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(balances, func(i, j int) bool {
+		if balances[i].Email == balances[j].Email {
+			return balances[i].Currency < balances[j].Currency
+		}
+		return balances[i].Email < balances[j].Email
+	})
+	return balances, nil
+}
+
+// MarkCommissionsPaid sets the commissions of a payout balance to paid
+func MarkCommissionsPaid(balance AffiliateBalance, batchID string) error {
+	return withAffiliateEmail(balance.Email, func(ab *bolt.Bucket, a *Affiliate) error {
+		ledger := ab.Bucket([]byte(walletLedgerKey))
+		if ledger == nil {
+			return nil
+		}
+		now := time.Now().Unix()
+		for _, id := range balance.IDs {
+			v := ledger.Get(itob(id))
+			if v == nil {
+				continue
+			}
+			c := Commission{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			if c.Status != CommissionPending {
+				continue
+			}
+			c.Status = CommissionPaid
+			c.BatchID = batchID
+			c.Updated = now
+			j, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := ledger.Put(itob(c.ID), j); err != nil {
+				return err
+			}
+		}
+		a.Paid = roundAmount(a.Paid + balance.Amount)
+		return nil
+	})
+}
+
+// withAffiliate runs fn with the account of code and saves the account after
+func withAffiliate(code string, fn func(ab *bolt.Bucket, a *Affiliate) error) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		ab := affiliateBucket(b, code)
+		if ab == nil {
+			return ErrReferralNotFound
+		}
+		return updateAffiliate(ab, fn)
+	})
+}
+
+// withAffiliateEmail is withAffiliate for the account of email
+func withAffiliateEmail(email string, fn func(ab *bolt.Bucket, a *Affiliate) error) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__referrals))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		ab := b.Bucket([]byte(strings.ToLower(email)))
+		if ab == nil {
+			return ErrReferralNotFound
+		}
+		return updateAffiliate(ab, fn)
+	})
+}
+
+func updateAffiliate(ab *bolt.Bucket, fn func(ab *bolt.Bucket, a *Affiliate) error) error {
+	aff := &Affiliate{}
+	if err := json.Unmarshal(ab.Get([]byte(walletBalanceKey)), aff); err != nil {
+		return err
+	}
+	if err := fn(ab, aff); err != nil {
+		return err
+	}
+	aff.Updated = time.Now().Unix()
+	return putAffiliate(ab, aff)
+}
+
+// affiliateBucket finds the bucket of the affiliate owning code
+func affiliateBucket(b *bolt.Bucket, code string) *bolt.Bucket {
+	codes := b.Bucket([]byte(referralCodesKey))
+	if codes == nil {
+		return nil
+	}
+	email := codes.Get([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	if email == nil {
+		return nil
+	}
+	return b.Bucket(email)
+}
+
+func putAffiliate(ab *bolt.Bucket, aff *Affiliate) error {
+	j, err := json.Marshal(aff)
+	if err != nil {
+		return err
+	}
+	return ab.Put([]byte(walletBalanceKey), j)
+}
+
+func putCommission(ledger *bolt.Bucket, c *Commission) error {
+	id, err := ledger.NextSequence()
+	if err != nil {
+		return err
+	}
+	c.ID = int(id)
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ledger.Put(itob(c.ID), j)
+}
+
+// findCommission returns the first commission of the order
+func findCommission(ledger *bolt.Bucket, orderID string) *Commission {
+	for _, c := range commissionsOf(ledger) {
+		if c.OrderID == orderID {
+			found := c
+			return &found
+		}
+	}
+	return nil
+}
+
+// commissionsOf returns the ledger oldest first
+func commissionsOf(ledger *bolt.Bucket) []Commission {
+	entries := []Commission{}
+	ledger.ForEach(func(k, v []byte) error {
+		c := Commission{}
+		if json.Unmarshal(v, &c) == nil {
+			entries = append(entries, c)
+		}
+		return nil
+	})
+	return entries
+}
+
+// newReferralCode makes a random code without the easily confused 0/O and 1/I
+func newReferralCode() string {
+	var b strings.Builder
+	max := big.NewInt(int64(len(referralCodeChars)))
+	for i := 0; i < referralCodeLen; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			n = big.NewInt(time.Now().UnixNano() % int64(len(referralCodeChars)))
+		}
+		b.WriteByte(referralCodeChars[n.Int64()])
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestReferralCommission(t *testing.T) {
+	defer openTestStore(t, DB__referrals)()
+
+	aff, err := ReferralCodeOf("Aff@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := ReferralCodeOf("aff@example.com")
+	if len(aff.Code) != referralCodeLen || again.Code != aff.Code {
+		t.Fatalf("referral code not stable: %s %s", aff.Code, again.Code)
+	}
+
+	if err := RecordReferralClick(aff.Code); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordReferralClick("NOPE"); err != ErrReferralNotFound {
+		t.Errorf("expected ErrReferralNotFound, got %v", err)
+	}
+	if _, err := AddCommission(aff.Code, "S1", "aff@example.com", 100, 5, "USD"); err != ErrSelfReferral {
+		t.Errorf("expected ErrSelfReferral, got %v", err)
+	}
+
+	AddCommission(aff.Code, "A1", "buyer@example.com", 100, 5, "USD")
+	AddCommission(aff.Code, "A1", "buyer@example.com", 100, 5, "USD")
+	AddCommission(aff.Code, "A2", "buyer@example.com", 200, 10, "USD")
+	AddCommission(aff.Code, "A3", "buyer@example.com", 60, 3, "EUR")
+
+	// refund before payout, A2 is never paid
+	if c, _ := ReverseCommission("A2"); c == nil || c.Status != CommissionReversed {
+		t.Errorf("expected reversed commission, got %+v", c)
+	}
+
+	balances, err := AffiliateBalances(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Amount != 5 || balances[0].Currency != "USD" {
+		t.Fatalf("unexpected balances %+v", balances)
+	}
+	if err := MarkCommissionsPaid(balances[0], "B1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// refund after payout is taken from the next payout
+	ReverseCommission("A1")
+	ReverseCommission("A1")
+	AddCommission(aff.Code, "A4", "buyer@example.com", 200, 10, "USD")
+	balances, _ = AffiliateBalances(0)
+	if len(balances) != 2 || balances[1].Currency != "USD" || balances[1].Amount != 5 {
+		t.Errorf("unexpected balances after refund %+v", balances)
+	}
+
+	aff, _ = AffiliateByCode(aff.Code)
+	if aff.Clicks != 1 || aff.Conversions != 2 || aff.Earned != 13 || aff.Paid != 5 {
+		t.Errorf("unexpected affiliate %+v", aff)
+	}
+	commissions, _ := CommissionsOf("aff@example.com")
+	if len(commissions) != 5 || commissions[0].OrderID != "A4" {
+		t.Errorf("unexpected commissions %+v", commissions)
+	}
+}
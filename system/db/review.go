@@ -0,0 +1,256 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/agreyfox/eshop/system/item"
+)
+
+// ReviewType is the content type of the product reviews
+const ReviewType = "Review"
+
+// orderCompleted is the status of a completed Order, kept in step with
+// data.OrderCompleted of the payment service
+const orderCompleted = "Completed-完成"
+
+var (
+	// ErrInvalidReview is returned for a review with a wrong rating or text
+	ErrInvalidReview = errors.New("Error. Invalid review.")
+	// ErrReviewNotPurchased is returned when the customer has no completed
+	// order with the product
+	ErrReviewNotPurchased = errors.New("Error. Only customers who bought the product can review it.")
+	// ErrReviewExists is returned for a second review of the same product
+	ErrReviewExists = errors.New("Error. You have reviewed this product already.")
+	// ErrReviewLimit is returned when too many reviews were sent in a day
+	ErrReviewLimit = errors.New("Error. Too many reviews, please try again later.")
+)
+
+var (
+	// ReviewsPerDay is how many reviews a customer can send in 24 hours
+	ReviewsPerDay = 5
+	// ReviewsPerIPDay is how many reviews can be sent from one address in 24
+	// hours
+	ReviewsPerIPDay = 10
+	// ReviewMaxText is the longest review text in characters
+	ReviewMaxText = 2000
+
+	// reviewNow is the clock of the review limits, replaced in tests
+	reviewNow = time.Now
+
+	ratings   map[string]Rating // "Type:id" of products and games
+	ratingsMu sync.Mutex
+)
+
+type (
+	// ReviewRequest is a review sent by a customer
+	ReviewRequest struct {
+		Product int    `json:"product"`
+		Rating  int    `json:"rating"`
+		Title   string `json:"title,omitempty"`
+		Text    string `json:"text,omitempty"`
+		Name    string `json:"name,omitempty"`
+	}
+
+	// Rating is the average rating and number of the public reviews of a
+	// product or game
+	Rating struct {
+		Average float64 `json:"average"`
+		Count   int     `json:"count"`
+	}
+)
+
+func init() {
+	AddContentWatcher(ReviewType, func(target string, before, after []byte) {
+		resetRatings()
+	})
+}
+
+// SubmitReview checks a review of the customer email sent from ip and saves it
+// to the pending reviews for an admin to approve. It returns the pending id.
+func SubmitReview(email, ip string, r ReviewRequest) (int, error) {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Text = strings.TrimSpace(r.Text)
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Rating < 1 || r.Rating > 5 || utf8.RuneCountInString(r.Text) > ReviewMaxText || utf8.RuneCountInString(r.Title) > 200 {
+		return 0, ErrInvalidReview
+	}
+
+	data, err := checkReview(email, ip, r)
+	if err != nil {
+		return 0, err
+	}
+	return SetContent(ReviewType+"__pending:-1", data)
+}
+
+// checkReview applies the purchase and spam rules to a review and returns the
+// form values it is saved with
+func checkReview(email, ip string, r ReviewRequest) (url.Values, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) == 0 || r.Product <= 0 {
+		return nil, ErrInvalidReview
+	}
+	// the purchase has to be claimed by a verified account
+	if err := CheckVerified(email, VerifyForClaim); err != nil {
+		return nil, err
+	}
+	buf, err := Content(fmt.Sprintf("Product:%d", r.Product))
+	if err != nil {
+		return nil, err
+	}
+	product := fieldsOf(buf)
+	name, _ := product["name"].(string)
+	if len(name) == 0 {
+		return nil, ErrBucketNoSuchRecord
+	}
+	game, _ := product["game"].(string)
+	_, gameName := ReferenceKey(game)
+
+	// one review per product, a few per day from a customer or an address
+	now := reviewNow()
+	since := now.Add(-24*time.Hour).UnixNano() / int64(time.Millisecond)
+	byEmail, byIP := 0, 0
+	for _, ns := range []string{ReviewType, ReviewType + "__pending"} {
+		for _, v := range ContentAll(ns) {
+			c := fieldsOf(v)
+			e, _ := c["email"].(string)
+			if p, _ := c["product"].(string); strings.EqualFold(e, email) && keyID(p) == r.Product {
+				return nil, ErrReviewExists
+			}
+			if ts, _ := c["timestamp"].(float64); int64(ts) <= since {
+				continue
+			}
+			if strings.EqualFold(e, email) {
+				byEmail++
+			}
+			if a, _ := c["ip"].(string); len(ip) > 0 && a == ip {
+				byIP++
+			}
+		}
+	}
+	if byEmail >= ReviewsPerDay || byIP >= ReviewsPerIPDay {
+		return nil, ErrReviewLimit
+	}
+
+	orderID := purchasedIn(email, name, gameName)
+	if len(orderID) == 0 {
+		return nil, ErrReviewNotPurchased
+	}
+
+	if len(r.Name) == 0 {
+		r.Name = strings.Split(email, "@")[0]
+	}
+	ts := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	return url.Values{
+		"product":   {fmt.Sprintf("%d,%s", r.Product, name)},
+		"game":      {game},
+		"email":     {email},
+		"name":      {r.Name},
+		"rating":    {strconv.Itoa(r.Rating)},
+		"title":     {r.Title},
+		"text":      {r.Text},
+		"order_id":  {orderID},
+		"verified":  {"true"},
+		"ip":        {ip},
+		"timestamp": {ts},
+		"updated":   {ts},
+	}, nil
+}
+
+// keyID returns the id of a reference key, "id,name"
+func keyID(key string) int {
+	id, _ := ReferenceKey(key)
+	return id
+}
+
+// purchasedIn returns the id of a completed order of email which contains the
+// product of game, empty when there is none
+func purchasedIn(email, product, game string) string {
+	for _, v := range ContentAll("Order") {
+		o := fieldsOf(v)
+		user, _ := o["user"].(string)
+		status, _ := o["status"].(string)
+		if !strings.EqualFold(user, email) || status != orderCompleted {
+			continue
+		}
+		detail, _ := o["order_detail"].(string)
+		items := []struct {
+			Game    string `json:"game"`
+			Product string `json:"product"`
+		}{}
+		if json.Unmarshal([]byte(detail), &items) != nil {
+			continue
+		}
+		for _, it := range items {
+			if it.Product == product && (len(it.Game) == 0 || len(game) == 0 || it.Game == game) {
+				id, _ := o["order_id"].(string)
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// RatingOf returns the rating of the public reviews of item id of the
+// Reviewable type ns
+func RatingOf(ns string, id int) Rating {
+	ratingsMu.Lock()
+	defer ratingsMu.Unlock()
+
+	if ratings == nil {
+		ratings = countRatings()
+	}
+	return ratings[fmt.Sprintf("%s:%d", ns, id)]
+}
+
+// resetRatings drops the counted ratings after a review has changed
+func resetRatings() {
+	ratingsMu.Lock()
+	ratings = nil
+	ratingsMu.Unlock()
+}
+
+// countRatings averages the public reviews per item of the Reviewable types
+func countRatings() map[string]Rating {
+	fields := map[string]string{}
+	for ns, t := range item.Types {
+		if r, ok := t().(item.Reviewable); ok {
+			fields[ns] = r.ReviewField()
+		}
+	}
+
+	sums := map[string]int{}
+	counts := map[string]int{}
+	for _, v := range ContentAll(ReviewType) {
+		c := fieldsOf(v)
+		rating, _ := c["rating"].(float64)
+		if hidden, _ := c["unpublished"].(bool); hidden || rating < 1 {
+			continue
+		}
+		for ns, field := range fields {
+			key, _ := c[field].(string)
+			if id := keyID(key); id > 0 {
+				k := fmt.Sprintf("%s:%d", ns, id)
+				sums[k] += int(rating)
+				counts[k]++
+			}
+		}
+	}
+
+	list := map[string]Rating{}
+	for k, n := range counts {
+		list[k] = Rating{
+			Average: math.Round(float64(sums[k])/float64(n)*10) / 10,
+			Count:   n,
+		}
+	}
+	return list
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/item"
+)
+
+type reviewProduct struct {
+	item.Item
+	Name string `json:"name"`
+}
+
+func (p *reviewProduct) ReviewField() string { return "product" }
+
+type reviewGame struct {
+	item.Item
+	Name string `json:"name"`
+}
+
+func (g *reviewGame) ReviewField() string { return "game" }
+
+func TestReviewChecks(t *testing.T) {
+	defer openTestStore(t, "Product", "Order", ReviewType, ReviewType+"__pending")()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	reviewNow = func() time.Time { return now }
+	defer func() { reviewNow = time.Now }()
+
+	putRefContent(t, "Product", "1", `{"id":1,"name":"Gold","game":"3,WOW"}`)
+	putRefContent(t, "Product", "2", `{"id":2,"name":"Mount","game":"3,WOW"}`)
+	putRefContent(t, "Order", "1", `{"id":1,"order_id":"A1","user":"buyer@example.com","status":"Completed-完成",`+
+		`"order_detail":"[{\"game\":\"WOW\",\"product\":\"Gold\",\"quantity\":1}]"}`)
+	putRefContent(t, "Order", "2", `{"id":2,"order_id":"A2","user":"buyer@example.com","status":"Paid-已付款",`+
+		`"order_detail":"[{\"game\":\"WOW\",\"product\":\"Mount\",\"quantity\":1}]"}`)
+
+	data, err := checkReview("Buyer@example.com", "1.2.3.4", ReviewRequest{Product: 1, Rating: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Get("verified") != "true" || data.Get("order_id") != "A1" || data.Get("product") != "1,Gold" ||
+		data.Get("game") != "3,WOW" || data.Get("name") != "buyer" {
+		t.Errorf("unexpected review %v", data)
+	}
+
+	// the order of the mount is not completed, nobody else bought anything
+	if _, err = checkReview("buyer@example.com", "1.2.3.4", ReviewRequest{Product: 2, Rating: 4}); err != ErrReviewNotPurchased {
+		t.Errorf("expected ErrReviewNotPurchased, got %v", err)
+	}
+	if _, err = checkReview("other@example.com", "1.2.3.4", ReviewRequest{Product: 1, Rating: 4}); err != ErrReviewNotPurchased {
+		t.Errorf("expected ErrReviewNotPurchased, got %v", err)
+	}
+
+	// a waiting review counts as the review of the product
+	ts := now.Add(-time.Hour).UnixNano() / int64(time.Millisecond)
+	putRefContent(t, ReviewType+"__pending", "1", fmt.Sprintf(`{"id":1,"product":"1,Gold","email":"buyer@example.com","ip":"1.2.3.4","rating":5,"timestamp":%d}`, ts))
+	if _, err = checkReview("buyer@example.com", "5.6.7.8", ReviewRequest{Product: 1, Rating: 3}); err != ErrReviewExists {
+		t.Errorf("expected ErrReviewExists, got %v", err)
+	}
+
+	// the address has sent too many reviews today
+	defer func(n int) { ReviewsPerIPDay = n }(ReviewsPerIPDay)
+	ReviewsPerIPDay = 1
+	putRefContent(t, "Order", "3", `{"id":3,"order_id":"A3","user":"buyer@example.com","status":"Completed-完成",`+
+		`"order_detail":"[{\"game\":\"WOW\",\"product\":\"Mount\",\"quantity\":1}]"}`)
+	if _, err = checkReview("buyer@example.com", "1.2.3.4", ReviewRequest{Product: 2, Rating: 4}); err != ErrReviewLimit {
+		t.Errorf("expected ErrReviewLimit, got %v", err)
+	}
+	// reviews older than a day do not count
+	now = now.Add(24 * time.Hour)
+	if _, err = checkReview("buyer@example.com", "1.2.3.4", ReviewRequest{Product: 2, Rating: 4}); err != nil {
+		t.Errorf("expected review to pass the next day, got %v", err)
+	}
+
+	if _, err = SubmitReview("buyer@example.com", "1.2.3.4", ReviewRequest{Product: 2, Rating: 6}); err != ErrInvalidReview {
+		t.Errorf("expected ErrInvalidReview, got %v", err)
+	}
+}
+
+func TestRatingOf(t *testing.T) {
+	defer openTestStore(t, ReviewType)()
+	item.Types["Product"] = func() interface{} { return new(reviewProduct) }
+	item.Types["Game"] = func() interface{} { return new(reviewGame) }
+	defer func() {
+		delete(item.Types, "Product")
+		delete(item.Types, "Game")
+		resetRatings()
+	}()
+
+	putRefContent(t, ReviewType, "1", `{"id":1,"product":"1,Gold","game":"3,WOW","rating":5}`)
+	putRefContent(t, ReviewType, "2", `{"id":2,"product":"1,Gold","game":"3,WOW","rating":4}`)
+	putRefContent(t, ReviewType, "3", `{"id":3,"product":"2,Mount","game":"3,WOW","rating":2}`)
+	putRefContent(t, ReviewType, "4", `{"id":4,"product":"2,Mount","game":"3,WOW","rating":1,"unpublished":true}`)
+	resetRatings()
+
+	cases := []struct {
+		ns   string
+		id   int
+		want Rating
+	}{
+		{"Product", 1, Rating{Average: 4.5, Count: 2}},
+		{"Product", 2, Rating{Average: 2, Count: 1}},
+		{"Game", 3, Rating{Average: 3.7, Count: 3}},
+		{"Product", 9, Rating{}},
+	}
+	for _, c := range cases {
+		if got := RatingOf(c.ns, c.id); got != c.want {
+			t.Errorf("RatingOf(%s, %d) = %+v, want %+v", c.ns, c.id, got, c.want)
+		}
+	}
+}
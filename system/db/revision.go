@@ -0,0 +1,274 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrRevisionNotFound is returned when a content item has no such revision
+var ErrRevisionNotFound = errors.New("Error. Revision not found.")
+
+// DefaultRevisionLimit is how many revisions of an item are kept when
+// PaymentSetting has no revision_limit
+var DefaultRevisionLimit = 20
+
+// revisionLimit returns how many revisions of type ns are kept, replaced in
+// tests
+var revisionLimit = func(ns string) int {
+	for _, name := range []string{"revision_limit_" + ns, "revision_limit"} {
+		v, err := GetParameterFromConfig("PaymentSetting", "name", name, "valueString")
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			return n
+		}
+	}
+	return DefaultRevisionLimit
+}
+
+type (
+	// Revision is one save of a content item. Data is the whole item as saved
+	// so any revision can be compared or restored on its own.
+	Revision struct {
+		ID        int             `json:"id"`
+		Target    string          `json:"target"`
+		Author    string          `json:"author,omitempty"`
+		Timestamp int64           `json:"timestamp"`
+		Changed   []string        `json:"changed"`
+		Restored  int             `json:"restored,omitempty"` // the revision this save restored
+		Data      json.RawMessage `json:"data,omitempty"`
+	}
+
+	// FieldChange is the difference of one field between two revisions
+	FieldChange struct {
+		Field string      `json:"field"`
+		From  interface{} `json:"from"`
+		To    interface{} `json:"to"`
+	}
+)
+
+// fieldsOf decodes the top-level fields of a content item
+func fieldsOf(j []byte) map[string]interface{} {
+	m := map[string]interface{}{}
+	if len(j) > 0 {
+		json.Unmarshal(j, &m)
+	}
+	return m
+}
+
+// diffFields returns the changed fields between two saves of an item ordered
+// by name, the updated time is left out
+func diffFields(before, after []byte) []FieldChange {
+	from, to := fieldsOf(before), fieldsOf(after)
+	names := map[string]bool{}
+	for k := range from {
+		names[k] = true
+	}
+	for k := range to {
+		names[k] = true
+	}
+	delete(names, "updated")
+
+	changes := []FieldChange{}
+	for k := range names {
+		if !reflect.DeepEqual(from[k], to[k]) {
+			changes = append(changes, FieldChange{Field: k, From: from[k], To: to[k]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// addRevision records the save of target from before to after in tx. Saves
+// which change nothing are not recorded and only the newest limit revisions
+// are kept.
+func addRevision(tx *bolt.Tx, target string, before, after []byte, author string, restored, limit int) error {
+	changes := diffFields(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+	rb, err := tx.CreateBucketIfNotExists([]byte(DB__revisions))
+	if err != nil {
+		return err
+	}
+	b, err := rb.CreateBucketIfNotExists([]byte(target))
+	if err != nil {
+		return err
+	}
+
+	id, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	rev := Revision{
+		ID:        int(id),
+		Target:    target,
+		Author:    author,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Changed:   []string{},
+		Restored:  restored,
+		Data:      json.RawMessage(after),
+	}
+	for _, c := range changes {
+		rev.Changed = append(rev.Changed, c.Field)
+	}
+	j, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	if err = b.Put(itob(rev.ID), j); err != nil {
+		return err
+	}
+
+	// drop the oldest revisions over the limit
+	c := b.Cursor()
+	for n := b.Stats().KeyN + 1 - limit; n > 0; n-- {
+		k, _ := c.First()
+		if k == nil {
+			break
+		}
+		if err = c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteRevisions drops the revisions of target in tx
+func deleteRevisions(tx *bolt.Tx, target string) error {
+	rb := tx.Bucket([]byte(DB__revisions))
+	if rb == nil || rb.Bucket([]byte(target)) == nil {
+		return nil
+	}
+	return rb.DeleteBucket([]byte(target))
+}
+
+// Revisions returns the revisions of target newest first, without their data
+func Revisions(target string) ([]Revision, error) {
+	list := []Revision{}
+	err := store.View(func(tx *bolt.Tx) error {
+		rb := tx.Bucket([]byte(DB__revisions))
+		if rb == nil {
+			return nil
+		}
+		b := rb.Bucket([]byte(target))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			rev := Revision{}
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return err
+			}
+			rev.Data = nil
+			list = append(list, rev)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// RevisionOf returns revision id of target with its data
+func RevisionOf(target string, id int) (*Revision, error) {
+	var rev *Revision
+	err := store.View(func(tx *bolt.Tx) error {
+		rb := tx.Bucket([]byte(DB__revisions))
+		if rb == nil {
+			return ErrRevisionNotFound
+		}
+		b := rb.Bucket([]byte(target))
+		if b == nil {
+			return ErrRevisionNotFound
+		}
+		v := b.Get(itob(id))
+		if v == nil {
+			return ErrRevisionNotFound
+		}
+		rev = &Revision{}
+		return json.Unmarshal(v, rev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// DiffRevisions returns the changed fields of target from revision from to
+// revision to, to 0 compares with the current content
+func DiffRevisions(target string, from, to int) ([]FieldChange, error) {
+	a, err := RevisionOf(target, from)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	if to == 0 {
+		b, err = Content(target)
+		if err == nil && len(b) == 0 {
+			err = ErrBucketNoSuchRecord
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rev, err := RevisionOf(target, to)
+		if err != nil {
+			return nil, err
+		}
+		b = rev.Data
+	}
+	return diffFields(a.Data, b), nil
+}
+
+// RestoreRevision saves the data of revision id back to target. The restore
+// is itself recorded as a new revision, so it can be undone the same way.
+func RestoreRevision(target string, id int, author string) error {
+	t := strings.Split(target, ":")
+	if len(t) != 2 || !IsValidID(t[1]) {
+		return fmt.Errorf("Invalid target for RestoreRevision: %s", target)
+	}
+	ns, cid := t[0], t[1]
+
+	rev, err := RevisionOf(target, id)
+	if err != nil {
+		return err
+	}
+	current, err := Content(target)
+	if err == nil && len(current) == 0 {
+		err = ErrBucketNoSuchRecord
+	}
+	if err != nil {
+		return err
+	}
+
+	// the referenced content may have gone since the revision was saved
+	m := fieldsOf(rev.Data)
+	refs := url.Values{}
+	for _, r := range referencesOf(ns) {
+		if v, ok := m[r.Field].(string); ok {
+			refs.Set(r.Field, v)
+		}
+	}
+	if err = CheckReferences(ns, refs); err != nil {
+		return err
+	}
+
+	m["updated"] = time.Now().UnixNano() / int64(time.Millisecond)
+	j, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	n, _ := strconv.Atoi(cid)
+	_, err = save(ns, "", n, j, author, id)
+	return err
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func addTestRevision(t *testing.T, before, after, author string, limit int) {
+	err := store.Update(func(tx *bolt.Tx) error {
+		var b []byte
+		if len(before) > 0 {
+			b = []byte(before)
+		}
+		return addRevision(tx, "RevGame:1", b, []byte(after), author, 0, limit)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	defer openTestStore(t, "RevGame", DB__revisions)()
+
+	saves := []string{
+		`{"id":1,"name":"WOW","price":1,"updated":1}`,
+		`{"id":1,"name":"WOW","price":2,"updated":2}`,
+		`{"id":1,"name":"WOW","price":2,"updated":3}`,
+		`{"id":1,"name":"World of Warcraft","price":2,"hint":"EU","updated":4}`,
+		`{"id":1,"name":"World of Warcraft","price":3,"updated":5}`,
+	}
+	before := ""
+	for _, j := range saves {
+		addTestRevision(t, before, j, "admin@eshop.com", 3)
+		before = j
+	}
+	putRefContent(t, "RevGame", "1", before)
+
+	list, err := Revisions("RevGame:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the save changing only the updated time is not recorded and the
+	// oldest revision is over the limit
+	if len(list) != 3 || list[0].ID != 4 || list[2].ID != 2 {
+		t.Fatalf("expected revisions 4,3,2, got %+v", list)
+	}
+	if list[0].Data != nil || list[0].Author != "admin@eshop.com" {
+		t.Errorf("unexpected list entry %+v", list[0])
+	}
+	if c := list[1].Changed; len(c) != 2 || c[0] != "hint" || c[1] != "name" {
+		t.Errorf("expected hint,name changed, got %v", c)
+	}
+
+	changes, err := DiffRevisions("RevGame:1", 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Field != "name" || changes[1].Field != "price" ||
+		changes[0].From != "WOW" || changes[1].To != float64(3) {
+		t.Errorf("unexpected diff %+v", changes)
+	}
+	if changes, _ = DiffRevisions("RevGame:1", 4, 0); len(changes) != 0 {
+		t.Errorf("expected no change against current content, got %+v", changes)
+	}
+	if _, err = DiffRevisions("RevGame:1", 1, 4); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("expected pruned revision not found, got %v", err)
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		return deleteRevisions(tx, "RevGame:1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = Revisions("RevGame:1"); len(list) != 0 {
+		t.Errorf("expected revisions deleted, got %+v", list)
+	}
+}
@@ -0,0 +1,271 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/boltdb/bolt"
+	"github.com/nilslice/jwt"
+)
+
+// ErrSessionNotFound is returned for a session which is not open
+var ErrSessionNotFound = errors.New("Error. Session not found.")
+
+// SessionTouchInterval is how often the last seen time of a session is saved
+var SessionTouchInterval = time.Minute
+
+// sessionNow is the clock of the sessions, replaced in tests
+var sessionNow = time.Now
+
+// Session is a login of a user. The token of the login carries the session
+// id as its jti claim and passes only while the session is open.
+type Session struct {
+	ID       string `json:"id"`
+	Email    string `json:"email"`
+	Device   string `json:"device,omitempty"` // user agent of the login
+	IP       string `json:"ip,omitempty"`
+	Created  int64  `json:"created"` // unix seconds
+	LastSeen int64  `json:"last_seen"`
+	Expires  int64  `json:"expires"`
+	Current  bool   `json:"current,omitempty"` // the session of the request
+}
+
+func init() {
+	user.SessionCheck = sessionOpen
+}
+
+// OpenSession records a login of email from device and ip until expires and
+// returns its signed token with claims added
+func OpenSession(email, device, ip string, expires time.Time, claims map[string]interface{}) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	now := sessionNow()
+	s := Session{
+		ID:       base64.RawURLEncoding.EncodeToString(b),
+		Email:    email,
+		Device:   device,
+		IP:       ip,
+		Created:  now.Unix(),
+		LastSeen: now.Unix(),
+		Expires:  expires.Unix(),
+	}
+	j, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		sb, err := tx.CreateBucketIfNotExists([]byte(DB__sessions))
+		if err != nil {
+			return err
+		}
+		b, err := sb.CreateBucketIfNotExists([]byte(email))
+		if err != nil {
+			return err
+		}
+		// drop the expired sessions on the way
+		expired := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			old := Session{}
+			if json.Unmarshal(v, &old) == nil && old.Expires <= now.Unix() {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte(s.ID), j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c := map[string]interface{}{}
+	for k, v := range claims {
+		c[k] = v
+	}
+	c["user"] = email
+	c["exp"] = expires
+	c["jti"] = s.ID
+	return jwt.New(c)
+}
+
+// RenewSession moves the expiry of the open session of token to expires and
+// returns the token of the session with the new expiry
+func RenewSession(token string, expires time.Time) (string, error) {
+	if !sessionOpen(token) {
+		return "", ErrSessionNotFound
+	}
+	email, id := tokenSession(token)
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := sessionBucket(tx, email)
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrSessionNotFound
+		}
+		s := Session{}
+		if err := json.Unmarshal(b.Get([]byte(id)), &s); err != nil {
+			return err
+		}
+		s.Expires = expires.Unix()
+		j, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), j)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.GetClaims(token)
+	claims["exp"] = expires
+	return jwt.New(claims)
+}
+
+// tokenSession returns the user and session id of a token
+func tokenSession(token string) (string, string) {
+	claims := jwt.GetClaims(token)
+	email, _ := claims["user"].(string)
+	id, _ := claims["jti"].(string)
+	return email, id
+}
+
+// sessionOpen tells if the session of a signed token is open, it is
+// user.SessionCheck
+func sessionOpen(token string) bool {
+	email, id := tokenSession(token)
+	if store == nil || len(email) == 0 || len(id) == 0 {
+		return false
+	}
+	now := sessionNow()
+
+	s := Session{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := sessionBucket(tx, email)
+		if b == nil {
+			return ErrSessionNotFound
+		}
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrSessionNotFound
+		}
+		return json.Unmarshal(v, &s)
+	})
+	if err != nil || s.Expires <= now.Unix() {
+		return false
+	}
+
+	if now.Unix()-s.LastSeen >= int64(SessionTouchInterval/time.Second) {
+		s.LastSeen = now.Unix()
+		err = store.Update(func(tx *bolt.Tx) error {
+			b := sessionBucket(tx, email)
+			if b == nil || b.Get([]byte(id)) == nil {
+				return nil
+			}
+			j, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(id), j)
+		})
+		if err != nil {
+			logger.Warnf("Touch session of %s error: %s", email, err)
+		}
+	}
+	return true
+}
+
+// sessionBucket returns the sessions of email in tx, nil when there are none
+func sessionBucket(tx *bolt.Tx, email string) *bolt.Bucket {
+	sb := tx.Bucket([]byte(DB__sessions))
+	if sb == nil {
+		return nil
+	}
+	return sb.Bucket([]byte(email))
+}
+
+// Sessions returns the open sessions of email, the last seen first. The
+// session of token is marked current.
+func Sessions(email, token string) ([]Session, error) {
+	_, current := tokenSession(token)
+	now := sessionNow().Unix()
+	list := []Session{}
+	err := store.View(func(tx *bolt.Tx) error {
+		b := sessionBucket(tx, email)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			s := Session{}
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Expires > now {
+				s.Current = s.ID == current
+				list = append(list, s)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(list, func(i, j int) bool { return list[i].LastSeen > list[j].LastSeen })
+	return list, err
+}
+
+// RevokeSession ends session id of email, its token no longer passes
+func RevokeSession(email, id string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := sessionBucket(tx, email)
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrSessionNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// RevokeSessions ends every session of email but the one with id except, it
+// returns how many were ended
+func RevokeSessions(email, except string) (int, error) {
+	n := 0
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := sessionBucket(tx, email)
+		if b == nil {
+			return nil
+		}
+		keys := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			if string(k) != except {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
+
+// EndSession ends the session of token, for a logout. The token is not
+// verified here, the caller checks it with user.ValidToken first.
+func EndSession(token string) error {
+	email, id := tokenSession(token)
+	if len(email) == 0 || len(id) == 0 {
+		return fmt.Errorf("Token has no session")
+	}
+	return RevokeSession(email, id)
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/boltdb/bolt"
+	"github.com/nilslice/jwt"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	defer openTestStore(t, DB__sessions)()
+	jwt.Secret([]byte("test"))
+	now := time.Unix(1600000000, 0)
+	clock := sessionNow
+	sessionNow = func() time.Time { return now }
+	defer func() { sessionNow = clock }()
+
+	email := "buyer@example.com"
+	phone, err := OpenSession(email, "phone", "10.0.0.1", now.Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := OpenSession(email, "laptop", "10.0.0.2", now.Add(time.Hour), map[string]interface{}{"country": "CN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.ValidToken(phone) || !user.ValidToken(laptop) {
+		t.Fatal("expected the new sessions to pass")
+	}
+	if jwt.GetClaims(laptop)["country"] != "CN" {
+		t.Error("expected the claims to be kept in the token")
+	}
+
+	// last seen is saved once the touch interval passed
+	now = now.Add(2 * SessionTouchInterval)
+	user.ValidToken(laptop)
+	list, err := Sessions(email, laptop)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("sessions %v, %v", list, err)
+	}
+	if list[0].Device != "laptop" || !list[0].Current || list[0].LastSeen != now.Unix() {
+		t.Errorf("expected the laptop session first and current, got %+v", list[0])
+	}
+	if list[1].Current {
+		t.Error("expected only one current session")
+	}
+
+	// revoking one session leaves the other
+	if err := RevokeSession(email, list[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if user.ValidToken(phone) || !user.ValidToken(laptop) {
+		t.Error("expected only the revoked session to fail")
+	}
+	if err := RevokeSession(email, list[1].ID); err != ErrSessionNotFound {
+		t.Errorf("revoke twice: %v", err)
+	}
+
+	// renew moves the expiry past the old one
+	renewed, err := RenewSession(laptop, now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Hour)
+	if !user.ValidToken(renewed) {
+		t.Error("expected the renewed session to pass")
+	}
+	now = now.Add(2 * time.Hour)
+	if user.ValidToken(renewed) {
+		t.Error("expected an expired session to fail")
+	}
+	if _, err := RenewSession(renewed, now.Add(time.Hour)); err != ErrSessionNotFound {
+		t.Errorf("renew an expired session: %v", err)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	defer openTestStore(t, DB__sessions, DB__users)()
+	jwt.Secret([]byte("test"))
+
+	email := "buyer@example.com"
+	expires := time.Now().Add(time.Hour)
+	tokens := []string{}
+	for _, device := range []string{"phone", "laptop", "tablet"} {
+		token, err := OpenSession(email, device, "", expires, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	other, err := OpenSession("other@example.com", "phone", "", expires, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// log out everywhere but here
+	_, keep := tokenSession(tokens[0])
+	if n, err := RevokeSessions(email, keep); err != nil || n != 2 {
+		t.Fatalf("revoked %d, %v", n, err)
+	}
+	if !user.ValidToken(tokens[0]) || user.ValidToken(tokens[1]) || user.ValidToken(tokens[2]) {
+		t.Error("expected only the kept session to pass")
+	}
+
+	// a password change ends every session of the user only
+	usr := &user.User{ID: 1, Email: email, Hash: "old"}
+	j, _ := json.Marshal(usr)
+	store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(DB__users)).Put([]byte(email), j)
+	})
+	changed := *usr
+	changed.Hash = "new"
+	if err := UpdateUser(usr, &changed); err != nil {
+		t.Fatal(err)
+	}
+	if user.ValidToken(tokens[0]) {
+		t.Error("expected a password change to end the session")
+	}
+	if !user.ValidToken(other) {
+		t.Error("expected the sessions of other users to pass")
+	}
+
+	if err := EndSession(other); err != nil || user.ValidToken(other) {
+		t.Errorf("expected a logout to end the session, %v", err)
+	}
+}
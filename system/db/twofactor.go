@@ -0,0 +1,335 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+)
+
+var (
+	// ErrTwoFactorRequired is returned for a login which needs a second factor
+	ErrTwoFactorRequired = errors.New("Two-factor code required")
+	// ErrTwoFactorEnroll is returned for a login of an admin who has to enroll
+	// a second factor first
+	ErrTwoFactorEnroll = errors.New("Two-factor enrollment required")
+	// ErrTwoFactorCode is returned for a wrong, used or expired code
+	ErrTwoFactorCode = errors.New("Wrong two-factor code")
+	// ErrTwoFactorEnabled is returned for enrolling twice
+	ErrTwoFactorEnabled = errors.New("Two-factor already enabled")
+	// ErrTwoFactorDisabled is returned for changing a second factor which is
+	// not enabled
+	ErrTwoFactorDisabled = errors.New("Two-factor not enabled")
+)
+
+// RecoveryCodes is how many single use recovery codes a user gets
+const RecoveryCodes = 10
+
+var (
+	// twoFactorNow is the clock of the second factor, replaced in tests
+	twoFactorNow = time.Now
+
+	// twoFactorKey returns the key the TOTP secrets are encrypted with
+	twoFactorKey = func() []byte {
+		secret, _ := ConfigCache("client_secret").(string)
+		key := sha256.Sum256([]byte("2fa|" + secret))
+		return key[:]
+	}
+)
+
+// TwoFactorRequired tells if a login of usr needs a second factor. It does
+// once enabled, and for admins when the admin_2fa setting is on.
+func TwoFactorRequired(usr *user.User) bool {
+	if usr.TwoFactor != nil && usr.TwoFactor.Enabled {
+		return true
+	}
+	enforced, _ := ConfigCache("admin_2fa").(bool)
+	return enforced && len(usr.StaffRole()) > 0
+}
+
+// PassTwoFactor checks the second factor of a login of usr whose password
+// passed. code is a TOTP or recovery code and device a remembered device
+// token. An admin who has to enroll confirms the enrollment with the first
+// code, the recovery codes are returned then.
+func PassTwoFactor(usr *user.User, code, device string) ([]string, error) {
+	if !TwoFactorRequired(usr) {
+		return nil, nil
+	}
+	code = strings.TrimSpace(code)
+	tf := usr.TwoFactor
+	if tf == nil || !tf.Enabled {
+		if len(code) == 0 {
+			return nil, ErrTwoFactorEnroll
+		}
+		return ConfirmTwoFactor(usr.Email, code)
+	}
+
+	if len(device) > 0 && deviceRemembered(tf, device) {
+		return nil, nil
+	}
+	if len(code) == 0 {
+		return nil, ErrTwoFactorRequired
+	}
+	return nil, changeUser(usr.Email, func(u *user.User) error {
+		return useCode(u.TwoFactor, code)
+	})
+}
+
+// BeginTwoFactor starts the enrollment of email and returns the TOTP secret
+// with its provisioning URI. It is enabled by ConfirmTwoFactor.
+func BeginTwoFactor(email string) (string, string, error) {
+	var secret string
+	err := changeUser(email, func(u *user.User) error {
+		if u.TwoFactor != nil && u.TwoFactor.Enabled {
+			return ErrTwoFactorEnabled
+		}
+		// the pending secret is kept, it may be scanned already
+		if u.TwoFactor != nil {
+			s, err := decryptSecret(u.TwoFactor.Secret)
+			if err == nil {
+				secret = s
+				return nil
+			}
+		}
+		s, err := user.NewTOTPSecret()
+		if err != nil {
+			return err
+		}
+		enc, err := encryptSecret(s)
+		if err != nil {
+			return err
+		}
+		secret = s
+		u.TwoFactor = &user.TwoFactor{Secret: enc}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	issuer, _ := ConfigCache("name").(string)
+	if len(issuer) == 0 {
+		issuer = "eshop"
+	}
+	return secret, user.ProvisioningURI(issuer, email, secret), nil
+}
+
+// ConfirmTwoFactor enables the enrolling second factor of email with a code
+// of the authenticator and returns the recovery codes
+func ConfirmTwoFactor(email, code string) ([]string, error) {
+	var codes []string
+	err := changeUser(email, func(u *user.User) error {
+		tf := u.TwoFactor
+		if tf == nil {
+			return ErrTwoFactorEnroll
+		}
+		if tf.Enabled {
+			return ErrTwoFactorEnabled
+		}
+		secret, err := decryptSecret(tf.Secret)
+		if err != nil {
+			return err
+		}
+		step := user.CheckTOTP(secret, code, twoFactorNow())
+		if step == 0 {
+			return ErrTwoFactorCode
+		}
+		codes, err = newRecoveryCodes(tf)
+		if err != nil {
+			return err
+		}
+		tf.Enabled = true
+		tf.LastStep = step
+		return nil
+	})
+	return codes, err
+}
+
+// DisableTwoFactor removes the second factor of email, it takes a code
+func DisableTwoFactor(email, code string) error {
+	return changeUser(email, func(u *user.User) error {
+		if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+			return ErrTwoFactorDisabled
+		}
+		if err := useCode(u.TwoFactor, code); err != nil {
+			return err
+		}
+		u.TwoFactor = nil
+		return nil
+	})
+}
+
+// ResetTwoFactor removes the second factor of email without a code, for an
+// admin helping a user who lost the authenticator
+func ResetTwoFactor(email string) error {
+	return changeUser(email, func(u *user.User) error {
+		if u.TwoFactor == nil {
+			return ErrTwoFactorDisabled
+		}
+		u.TwoFactor = nil
+		return nil
+	})
+}
+
+// RenewRecoveryCodes replaces the recovery codes of email, it takes a code
+func RenewRecoveryCodes(email, code string) ([]string, error) {
+	var codes []string
+	err := changeUser(email, func(u *user.User) error {
+		if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+			return ErrTwoFactorDisabled
+		}
+		if err := useCode(u.TwoFactor, code); err != nil {
+			return err
+		}
+		var err error
+		codes, err = newRecoveryCodes(u.TwoFactor)
+		return err
+	})
+	return codes, err
+}
+
+// RememberDevice returns a token which passes the second factor of email on
+// the device holding it, for remember_2fa_days days. It returns no token when
+// the setting is 0.
+func RememberDevice(email string) (string, time.Time, error) {
+	days, _ := ConfigCache("remember_2fa_days").(float64)
+	if days <= 0 {
+		return "", time.Time{}, nil
+	}
+	now := twoFactorNow()
+	expires := now.Add(time.Duration(days*24) * time.Hour)
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := changeUser(email, func(u *user.User) error {
+		if u.TwoFactor == nil || !u.TwoFactor.Enabled {
+			return ErrTwoFactorDisabled
+		}
+		devices := []user.Device{}
+		for _, d := range u.TwoFactor.Devices {
+			if d.Expires > now.Unix() {
+				devices = append(devices, d)
+			}
+		}
+		u.TwoFactor.Devices = append(devices, user.Device{
+			Hash:    hashCode(token),
+			Expires: expires.Unix(),
+		})
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// deviceRemembered tells if token is a remembered device of tf
+func deviceRemembered(tf *user.TwoFactor, token string) bool {
+	h := hashCode(token)
+	for _, d := range tf.Devices {
+		if d.Hash == h && d.Expires > twoFactorNow().Unix() {
+			return true
+		}
+	}
+	return false
+}
+
+// useCode checks code against tf. A TOTP code passes once and a recovery
+// code is used up.
+func useCode(tf *user.TwoFactor, code string) error {
+	secret, err := decryptSecret(tf.Secret)
+	if err != nil {
+		return err
+	}
+	if step := user.CheckTOTP(secret, code, twoFactorNow()); step > tf.LastStep {
+		tf.LastStep = step
+		return nil
+	}
+
+	h := hashCode(code)
+	for i, r := range tf.Recovery {
+		if r == h {
+			tf.Recovery = append(tf.Recovery[:i], tf.Recovery[i+1:]...)
+			return nil
+		}
+	}
+	return ErrTwoFactorCode
+}
+
+// newRecoveryCodes sets new recovery codes to tf and returns them
+func newRecoveryCodes(tf *user.TwoFactor) ([]string, error) {
+	codes := make([]string, RecoveryCodes)
+	tf.Recovery = make([]string, RecoveryCodes)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		tf.Recovery[i] = hashCode(codes[i])
+	}
+	return codes, nil
+}
+
+// hashCode returns the stored form of a recovery code or device token
+func hashCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// encryptSecret encrypts a TOTP secret with AES-GCM for the users bucket
+func encryptSecret(secret string) (string, error) {
+	block, err := aes.NewCipher(twoFactorKey())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret returns the TOTP secret encrypted by encryptSecret
+func decryptSecret(enc string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(twoFactorKey())
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("Two-factor secret is damaged")
+	}
+	n := gcm.NonceSize()
+	secret, err := gcm.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
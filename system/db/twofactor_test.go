@@ -0,0 +1,227 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+	"github.com/boltdb/bolt"
+)
+
+// fixTwoFactor sets a fixed clock, key and settings for the second factor
+// tests and returns the func restoring them
+func fixTwoFactor(now *time.Time, settings map[string]interface{}) func() {
+	clock, key := twoFactorNow, twoFactorKey
+	twoFactorNow = func() time.Time { return *now }
+	twoFactorKey = func() []byte { return []byte("0123456789abcdef0123456789abcdef") }
+
+	mu.Lock()
+	cache := configCache
+	configCache = settings
+	mu.Unlock()
+	return func() {
+		twoFactorNow, twoFactorKey = clock, key
+		mu.Lock()
+		configCache = cache
+		mu.Unlock()
+	}
+}
+
+func putTestUser(t *testing.T, usr *user.User) {
+	j, err := json.Marshal(usr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(DB__users)).Put([]byte(usr.Email), j)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getTestUser(t *testing.T, email string) *user.User {
+	j, err := User(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := &user.User{}
+	if err := json.Unmarshal(j, usr); err != nil {
+		t.Fatal(err)
+	}
+	return usr
+}
+
+func codeAt(t *testing.T, secret string, now time.Time) string {
+	code, err := user.TOTPCode(secret, user.TOTPStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, last 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for at, want := range cases {
+		if got := codeAt(t, secret, time.Unix(at, 0)); got != want {
+			t.Errorf("code at %d: %s, want %s", at, got, want)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+	if user.CheckTOTP(secret, "081804", now.Add(29*time.Second)) == 0 {
+		t.Error("expected the code of the step before to pass")
+	}
+	if user.CheckTOTP(secret, "081804", now.Add(2*time.Minute)) != 0 {
+		t.Error("expected an old code to fail")
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	defer openTestStore(t, DB__users)()
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{"name": "Shop"})()
+
+	email := "buyer@example.com"
+	putTestUser(t, &user.User{ID: 1, Email: email, Hash: "hash"})
+	if _, err := PassTwoFactor(getTestUser(t, email), "", ""); err != nil {
+		t.Fatalf("expected a login without a second factor to pass, %v", err)
+	}
+
+	secret, uri, err := BeginTwoFactor(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(uri, "otpauth://totp/Shop:buyer@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("provisioning uri %s", uri)
+	}
+	j, _ := User(email)
+	if strings.Contains(string(j), secret) {
+		t.Error("expected the secret to be stored encrypted")
+	}
+	if again, _, _ := BeginTwoFactor(email); again != secret {
+		t.Error("expected the pending secret to be kept")
+	}
+
+	// enrolling is not done before a code confirms it
+	if _, err := PassTwoFactor(getTestUser(t, email), "", ""); err != nil {
+		t.Errorf("expected a pending enrollment not to be required, %v", err)
+	}
+	if _, err := ConfirmTwoFactor(email, "000000"); err != ErrTwoFactorCode {
+		t.Errorf("confirm with a wrong code: %v", err)
+	}
+	codes, err := ConfirmTwoFactor(email, codeAt(t, secret, now))
+	if err != nil || len(codes) != RecoveryCodes {
+		t.Fatalf("confirm: %v, %v", codes, err)
+	}
+
+	usr := getTestUser(t, email)
+	if _, err := PassTwoFactor(usr, "", ""); err != ErrTwoFactorRequired {
+		t.Errorf("login without a code: %v", err)
+	}
+	// the code confirming the enrollment is used
+	if _, err := PassTwoFactor(usr, codeAt(t, secret, now), ""); err != ErrTwoFactorCode {
+		t.Errorf("login with a used code: %v", err)
+	}
+	now = now.Add(user.TOTPPeriod * time.Second)
+	if _, err := PassTwoFactor(usr, codeAt(t, secret, now), ""); err != nil {
+		t.Errorf("login with a code: %v", err)
+	}
+	if _, err := PassTwoFactor(usr, codeAt(t, secret, now), ""); err != ErrTwoFactorCode {
+		t.Errorf("login with the code again: %v", err)
+	}
+
+	// a recovery code passes once
+	if _, err := PassTwoFactor(usr, strings.ToUpper(codes[0]), ""); err != nil {
+		t.Errorf("login with a recovery code: %v", err)
+	}
+	if _, err := PassTwoFactor(usr, codes[0], ""); err != ErrTwoFactorCode {
+		t.Errorf("login with a used recovery code: %v", err)
+	}
+	if n := len(getTestUser(t, email).TwoFactor.Recovery); n != RecoveryCodes-1 {
+		t.Errorf("%d recovery codes left", n)
+	}
+
+	// a password change keeps the second factor
+	changed := *getTestUser(t, email)
+	changed.TwoFactor = nil
+	changed.Hash = "new"
+	if err := UpdateUser(getTestUser(t, email), &changed); err != nil {
+		t.Fatal(err)
+	}
+	if !TwoFactorRequired(getTestUser(t, email)) {
+		t.Error("expected a password change to keep the second factor")
+	}
+
+	if err := DisableTwoFactor(email, "000000"); err != ErrTwoFactorCode {
+		t.Errorf("disable with a wrong code: %v", err)
+	}
+	if err := DisableTwoFactor(email, codes[1]); err != nil {
+		t.Fatal(err)
+	}
+	if TwoFactorRequired(getTestUser(t, email)) {
+		t.Error("expected the second factor to be disabled")
+	}
+}
+
+func TestTwoFactorAdmin(t *testing.T) {
+	defer openTestStore(t, DB__users)()
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{
+		"admin_2fa":         true,
+		"remember_2fa_days": float64(30),
+	})()
+
+	email := "editor@eshop.com"
+	putTestUser(t, &user.User{ID: 1, Email: email, IsAdmin: true, Perm: user.AdminPermmission, Role: user.RoleEditor})
+	putTestUser(t, &user.User{ID: 2, Email: "buyer@example.com"})
+	if TwoFactorRequired(getTestUser(t, "buyer@example.com")) {
+		t.Error("expected the admin setting to leave customers alone")
+	}
+
+	// the admin enrolls on login, the first code confirms it
+	if _, err := PassTwoFactor(getTestUser(t, email), "", ""); err != ErrTwoFactorEnroll {
+		t.Fatalf("admin login without a second factor: %v", err)
+	}
+	secret, _, err := BeginTwoFactor(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := PassTwoFactor(getTestUser(t, email), codeAt(t, secret, now), "")
+	if err != nil || len(codes) != RecoveryCodes {
+		t.Fatalf("enroll on login: %v, %v", codes, err)
+	}
+
+	// a remembered device skips the code until it expires
+	device, expires, err := RememberDevice(email)
+	if err != nil || len(device) == 0 || !expires.Equal(now.Add(30*24*time.Hour)) {
+		t.Fatalf("remember device: %s %v %v", device, expires, err)
+	}
+	usr := getTestUser(t, email)
+	if _, err := PassTwoFactor(usr, "", device); err != nil {
+		t.Errorf("login from a remembered device: %v", err)
+	}
+	if _, err := PassTwoFactor(usr, "", "other"); err != ErrTwoFactorRequired {
+		t.Errorf("login from another device: %v", err)
+	}
+	now = now.Add(31 * 24 * time.Hour)
+	if _, err := PassTwoFactor(usr, "", device); err != ErrTwoFactorRequired {
+		t.Errorf("login from an expired device: %v", err)
+	}
+
+	if err := ResetTwoFactor(email); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PassTwoFactor(getTestUser(t, email), "", ""); err != ErrTwoFactorEnroll {
+		t.Errorf("admin login after a reset: %v", err)
+	}
+}
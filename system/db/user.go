@@ -68,6 +68,14 @@ func UpdateUser(usr, updatedUsr *user.User) error {
 	if updatedUsr.ID != usr.ID {
 		updatedUsr.ID = usr.ID
 	}
+	// the second factor is changed on its own, see twofactor.go
+	if updatedUsr.TwoFactor == nil {
+		updatedUsr.TwoFactor = usr.TwoFactor
+	}
+	// a changed email has to be verified again
+	if updatedUsr.Email == usr.Email && usr.Verified {
+		updatedUsr.Verified = true
+	}
 
 	err := store.Update(func(tx *bolt.Tx) error {
 		users := tx.Bucket([]byte(DB__users))
@@ -108,9 +116,45 @@ func UpdateUser(usr, updatedUsr *user.User) error {
 		return err
 	}
 
+	// a new password or email ends every login of the user
+	if usr.Hash != updatedUsr.Hash || usr.Email != updatedUsr.Email {
+		n, err := RevokeSessions(usr.Email, "")
+		if err != nil {
+			return err
+		}
+		logger.Infof("Password of %s changed, %d sessions revoked", usr.Email, n)
+	}
+
 	return nil
 }
 
+// changeUser runs fn on the user of email and saves the user in one
+// transaction, so a two-factor code can not be used twice
+func changeUser(email string, fn func(u *user.User) error) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__users))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+		v := b.Get([]byte(email))
+		if v == nil {
+			return ErrNoUserExists
+		}
+		u := &user.User{}
+		if err := json.Unmarshal(v, u); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+		j, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(email), j)
+	})
+}
+
 // DeleteUser deletes a user from the db by email
 func DeleteUser(email string) error {
 	err := store.Update(func(tx *bolt.Tx) error {
@@ -130,7 +174,8 @@ func DeleteUser(email string) error {
 		return err
 	}
 
-	return nil
+	_, err = RevokeSessions(email, "")
+	return err
 }
 
 // User gets the user by email from the db
@@ -242,24 +287,20 @@ func UserAll() ([][]byte, error) {
 	return users, nil
 }
 
-// CurrentUser extracts the user from the request data and returns the current user from the db
+// CurrentUser extracts the user from the request data and returns the current user from the db,
+// or the user of the API key of the request
 func CurrentUser(req *http.Request) ([]byte, error) {
-	if !user.IsValid(req) {
-		return nil, fmt.Errorf("Error. Invalid User.")
-	}
-	jwttoken := ""
-	tt := req.Header.Get(user.Lqcmstoken)
-
-	if len(tt) > 0 {
-
-		jwttoken = tt
-		//return jwt.Passes(token)
-	} else {
-		jwttokencookie, err := req.Cookie(user.Lqcmstoken)
+	if len(user.APIKeyToken(req)) > 0 {
+		usr, err := APIKeyUser(req)
 		if err != nil {
 			return nil, err
 		}
-		jwttoken = jwttokencookie.Value
+		return json.Marshal(usr)
+	}
+
+	jwttoken := user.Token(req)
+	if !user.ValidToken(jwttoken) {
+		return nil, fmt.Errorf("Error. Invalid User.")
 	}
 
 	claims := jwt.GetClaims(jwttoken)
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+)
+
+const (
+	// VerifyForCheckout is the setting which keeps unverified customers from
+	// submitting orders
+	VerifyForCheckout = "verify_checkout"
+	// VerifyForClaim is the setting which keeps unverified customers from
+	// using the orders placed under their email, like for verified purchase
+	// reviews
+	VerifyForClaim = "verify_claim"
+)
+
+var (
+	// ErrVerifyLink is returned for a verification link which is wrong or
+	// expired
+	ErrVerifyLink = errors.New("Verification link is invalid or expired")
+	// ErrVerified is returned for asking a link of a verified email
+	ErrVerified = errors.New("Email is verified already")
+	// ErrUnverified is returned when the setting needs a verified email
+	ErrUnverified = errors.New("Please verify your email first")
+)
+
+// VerifyLinkTTL is how long a verification link works when the
+// verify_link_hours setting is not set
+var VerifyLinkTTL = 48 * time.Hour
+
+var (
+	// verifyNow is the clock of the verification links, replaced in tests
+	verifyNow = time.Now
+
+	// verifyKey returns the key the verification links are signed with
+	verifyKey = func() []byte {
+		secret, _ := ConfigCache("client_secret").(string)
+		key := sha256.Sum256([]byte("verify|" + secret))
+		return key[:]
+	}
+)
+
+// VerifyToken returns the signed token of the verification link of email and
+// when it expires
+func VerifyToken(email string) (string, time.Time) {
+	ttl := VerifyLinkTTL
+	if hours, _ := ConfigCache("verify_link_hours").(float64); hours > 0 {
+		ttl = time.Duration(hours * float64(time.Hour))
+	}
+	expires := verifyNow().Add(ttl)
+	payload := strings.ToLower(email) + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + verifySign(payload), expires
+}
+
+func verifySign(payload string) string {
+	mac := hmac.New(sha256.New, verifyKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyEmail marks the user of the verification token verified and returns
+// the email. Opening a link again is no error.
+func VerifyEmail(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrVerifyLink
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrVerifyLink
+	}
+	payload := string(b)
+	if !hmac.Equal([]byte(verifySign(payload)), []byte(parts[1])) {
+		return "", ErrVerifyLink
+	}
+	i := strings.LastIndex(payload, "|")
+	if i < 0 {
+		return "", ErrVerifyLink
+	}
+	email := payload[:i]
+	expires, err := strconv.ParseInt(payload[i+1:], 10, 64)
+	if err != nil || expires <= verifyNow().Unix() {
+		return "", ErrVerifyLink
+	}
+
+	err = changeUser(email, func(u *user.User) error {
+		u.Verified = true
+		return nil
+	})
+	if err == ErrNoUserExists {
+		return "", ErrVerifyLink
+	}
+	if err != nil {
+		return "", err
+	}
+	logger.Infof("Email of %s verified", email)
+	return email, nil
+}
+
+// CheckVerified returns ErrUnverified when the setting, VerifyForCheckout or
+// VerifyForClaim, is on and email is a customer account which is not
+// verified. Guests without an account and admins pass.
+func CheckVerified(email, setting string) error {
+	if on, _ := ConfigCache(setting).(bool); !on {
+		return nil
+	}
+	j, err := User(strings.ToLower(strings.TrimSpace(email)))
+	if err == ErrNoUserExists {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	usr := &user.User{}
+	if err := json.Unmarshal(j, usr); err != nil {
+		return err
+	}
+	if usr.Verified || len(usr.StaffRole()) > 0 {
+		return nil
+	}
+	return ErrUnverified
+}
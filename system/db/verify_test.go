@@ -0,0 +1,93 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/admin/user"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	defer openTestStore(t, DB__users)()
+	now := time.Unix(1600000000, 0)
+	defer fixTwoFactor(&now, map[string]interface{}{"verify_link_hours": float64(24)})()
+	clock := verifyNow
+	verifyNow = func() time.Time { return now }
+	defer func() { verifyNow = clock }()
+
+	putTestUser(t, &user.User{ID: 1, Email: "buyer@example.com", Hash: "h1"})
+	token, expires := VerifyToken("Buyer@example.com")
+	if !expires.Equal(now.Add(24 * time.Hour)) {
+		t.Errorf("link expires %v", expires)
+	}
+
+	// a changed or expired link fails
+	parts := strings.Split(token, ".")
+	if _, err := VerifyEmail(parts[0] + "." + parts[1][1:]); err != ErrVerifyLink {
+		t.Errorf("changed link: %v", err)
+	}
+	other, _ := VerifyToken("other@example.com")
+	if _, err := VerifyEmail(other); err != ErrVerifyLink {
+		t.Errorf("link of no account: %v", err)
+	}
+	now = now.Add(25 * time.Hour)
+	if _, err := VerifyEmail(token); err != ErrVerifyLink {
+		t.Errorf("expired link: %v", err)
+	}
+	now = now.Add(-25 * time.Hour)
+
+	addr, err := VerifyEmail(token)
+	if err != nil || addr != "buyer@example.com" || !getTestUser(t, addr).Verified {
+		t.Fatalf("verify: %s %v", addr, err)
+	}
+	if _, err := VerifyEmail(token); err != nil {
+		t.Errorf("open the link again: %v", err)
+	}
+
+	// a new password keeps the verification, a new email does not
+	usr := getTestUser(t, addr)
+	if err := UpdateUser(usr, &user.User{Email: addr, Hash: "h2"}); err != nil {
+		t.Fatal(err)
+	}
+	if !getTestUser(t, addr).Verified {
+		t.Error("expected a password change to keep the verification")
+	}
+	usr = getTestUser(t, addr)
+	if err := UpdateUser(usr, &user.User{Email: "new@example.com", Hash: "h2"}); err != nil {
+		t.Fatal(err)
+	}
+	if getTestUser(t, "new@example.com").Verified {
+		t.Error("expected a new email to need a verification")
+	}
+}
+
+func TestCheckVerified(t *testing.T) {
+	defer openTestStore(t, DB__users)()
+	now := time.Unix(1600000000, 0)
+	settings := map[string]interface{}{}
+	defer fixTwoFactor(&now, settings)()
+
+	putTestUser(t, &user.User{ID: 1, Email: "new@example.com", Perm: user.CustomerPermission})
+	putTestUser(t, &user.User{ID: 2, Email: "done@example.com", Perm: user.CustomerPermission, Verified: true})
+	putTestUser(t, &user.User{ID: 3, Email: "editor@eshop.com", IsAdmin: true, Perm: user.AdminPermmission, Role: user.RoleEditor})
+
+	if err := CheckVerified("new@example.com", VerifyForCheckout); err != nil {
+		t.Errorf("setting off: %v", err)
+	}
+	settings[VerifyForCheckout] = true
+	cases := map[string]error{
+		"New@example.com":   ErrUnverified,
+		"done@example.com":  nil,
+		"editor@eshop.com":  nil,
+		"guest@example.com": nil,
+	}
+	for email, want := range cases {
+		if err := CheckVerified(email, VerifyForCheckout); err != want {
+			t.Errorf("checkout of %s: %v, want %v", email, err, want)
+		}
+	}
+	if err := CheckVerified("new@example.com", VerifyForClaim); err != nil {
+		t.Errorf("claim setting off: %v", err)
+	}
+}
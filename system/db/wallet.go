@@ -0,0 +1,235 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// WalletCredit marks a ledger entry which adds funds to a wallet
+	WalletCredit = "credit"
+	// WalletDebit marks a ledger entry which removes funds from a wallet
+	WalletDebit = "debit"
+
+	walletBalanceKey = "__balance"
+	walletLedgerKey  = "__ledger"
+)
+
+// ErrInsufficientBalance is returned when a debit is larger than the wallet balance
+var ErrInsufficientBalance = errors.New("Error. Insufficient wallet balance.")
+
+// ErrInvalidAmount is returned when a wallet operation is called with a non positive amount
+var ErrInvalidAmount = errors.New("Error. Invalid wallet amount.")
+
+type (
+	// Wallet is the store-credit account of a customer, keyed by email
+	Wallet struct {
+		Email    string  `json:"email"`
+		Balance  float64 `json:"balance"`
+		Currency string  `json:"currency"`
+		Updated  int64   `json:"updated"`
+	}
+
+	// WalletEntry is a single audit record in the wallet ledger, entries are
+	// never changed once written
+	WalletEntry struct {
+		ID        int     `json:"id"`
+		Email     string  `json:"email"`
+		Type      string  `json:"type"`
+		Amount    float64 `json:"amount"`
+		Balance   float64 `json:"balance"` // balance after the entry is applied
+		Reference string  `json:"reference,omitempty"`
+		Operator  string  `json:"operator,omitempty"`
+		Comment   string  `json:"comment,omitempty"`
+		Timestamp int64   `json:"timestamp"`
+	}
+)
+
+// WalletDefaultCurrency is the currency used for all store-credit balances
+var WalletDefaultCurrency = "USD"
+
+// CreditWallet adds amount to the wallet of email and records it in the ledger.
+// operator is the admin or service granting the credit, reference is normally
+// an order id.
+func CreditWallet(email string, amount float64, reference, operator, comment string) (*WalletEntry, error) {
+	return updateWallet(email, WalletCredit, amount, reference, operator, comment)
+}
+
+// DebitWallet removes amount from the wallet of email, it fails with
+// ErrInsufficientBalance when the balance does not cover the amount.
+func DebitWallet(email string, amount float64, reference, operator, comment string) (*WalletEntry, error) {
+	return updateWallet(email, WalletDebit, amount, reference, operator, comment)
+}
+
+// updateWallet applies a credit or debit inside one bolt write transaction so
+// that concurrent orders always see and update a consistent balance
+func updateWallet(email, kind string, amount float64, reference, operator, comment string) (*WalletEntry, error) {
+	email = strings.ToLower(email)
+	amount = roundAmount(amount)
+	if amount <= 0 || len(email) == 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	entry := &WalletEntry{
+		Email:     email,
+		Type:      kind,
+		Amount:    amount,
+		Reference: reference,
+		Operator:  operator,
+		Comment:   comment,
+		Timestamp: time.Now().Unix(),
+	}
+
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__wallets))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		wb, err := b.CreateBucketIfNotExists([]byte(email))
+		if err != nil {
+			return err
+		}
+
+		ledger, err := wb.CreateBucketIfNotExists([]byte(walletLedgerKey))
+		if err != nil {
+			return err
+		}
+
+		wallet := Wallet{Email: email, Currency: WalletDefaultCurrency}
+		if v := wb.Get([]byte(walletBalanceKey)); v != nil {
+			err = json.Unmarshal(v, &wallet)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch kind {
+		case WalletCredit:
+			wallet.Balance = roundAmount(wallet.Balance + amount)
+		case WalletDebit:
+			if wallet.Balance < amount {
+				return ErrInsufficientBalance
+			}
+			wallet.Balance = roundAmount(wallet.Balance - amount)
+		default:
+			return ErrInvalidAmount
+		}
+		wallet.Updated = entry.Timestamp
+
+		id, err := ledger.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = int(id)
+		entry.Balance = wallet.Balance
+
+		j, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		err = ledger.Put(itob(entry.ID), j)
+		if err != nil {
+			return err
+		}
+
+		w, err := json.Marshal(wallet)
+		if err != nil {
+			return err
+		}
+
+		return wb.Put([]byte(walletBalanceKey), w)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Wallet %s %s %.2f, balance %.2f, ref %s by %s", email, kind, amount, entry.Balance, reference, operator)
+	return entry, nil
+}
+
+// WalletOf returns the wallet of email, an empty wallet is returned when the
+// customer never received any credit
+func WalletOf(email string) (*Wallet, error) {
+	email = strings.ToLower(email)
+	wallet := &Wallet{Email: email, Currency: WalletDefaultCurrency}
+
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__wallets))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		wb := b.Bucket([]byte(email))
+		if wb == nil {
+			return nil
+		}
+
+		if v := wb.Get([]byte(walletBalanceKey)); v != nil {
+			return json.Unmarshal(v, wallet)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+// WalletLedger returns the ledger entries of email, newest first
+func WalletLedger(email string) ([]WalletEntry, error) {
+	email = strings.ToLower(email)
+	entries := []WalletEntry{}
+
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(DB__wallets))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		wb := b.Bucket([]byte(email))
+		if wb == nil {
+			return nil
+		}
+		ledger := wb.Bucket([]byte(walletLedgerKey))
+		if ledger == nil {
+			return nil
+		}
+
+		c := ledger.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			entry := WalletEntry{}
+			err := json.Unmarshal(v, &entry)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// itob returns an 8-byte big endian representation of v, so ledger keys sort
+// in insertion order
+func itob(v int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+// roundAmount keeps money values at cent precision
+func roundAmount(v float64) float64 {
+	return math.Round(v*100) / 100
+}
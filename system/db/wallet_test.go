@@ -0,0 +1,105 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// openTestStore points the package store at a temporary bolt file
+func openTestStore(t *testing.T, names ...string) func() {
+	dir, err := ioutil.TempDir("", "eshop-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := bolt.Open(filepath.Join(dir, "test.db"), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Update(func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := store
+	store = s
+	return func() {
+		store = old
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWalletConcurrentDebit(t *testing.T) {
+	defer openTestStore(t, DB__wallets)()
+
+	email := "buyer@example.com"
+	if _, err := CreditWallet(email, 100, "", "admin@example.com", "grant"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	paid, failed := 0, 0
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := DebitWallet(email, 7.5, "order", "wallet", "")
+			mu.Lock()
+			defer mu.Unlock()
+			if err == ErrInsufficientBalance {
+				failed++
+			} else if err != nil {
+				t.Error(err)
+			} else {
+				paid++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if paid != 13 || failed != 17 {
+		t.Errorf("expected 13 paid and 17 failed debits, got %d and %d", paid, failed)
+	}
+
+	w, err := WalletOf(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Balance != 2.5 {
+		t.Errorf("expected balance 2.5, got %v", w.Balance)
+	}
+
+	ledger, err := WalletLedger(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger) != 14 {
+		t.Errorf("expected 14 ledger entries, got %d", len(ledger))
+	}
+	if ledger[0].Balance != 2.5 || ledger[len(ledger)-1].Type != WalletCredit {
+		t.Errorf("ledger is not ordered newest first: %+v", ledger[0])
+	}
+}
+
+func TestWalletInvalidAmount(t *testing.T) {
+	defer openTestStore(t, DB__wallets)()
+
+	if _, err := CreditWallet("buyer@example.com", 0, "", "", ""); err != ErrInvalidAmount {
+		t.Errorf("expected ErrInvalidAmount, got %v", err)
+	}
+	if _, err := DebitWallet("buyer@example.com", 1, "", "", ""); err != ErrInsufficientBalance {
+		t.Errorf("expected ErrInsufficientBalance, got %v", err)
+	}
+}
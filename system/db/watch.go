@@ -0,0 +1,43 @@
+package db
+
+import (
+	"sync"
+)
+
+// ContentWatcher is called after content is saved to the public bucket of a
+// namespace. target is "Type:id", before is nil for new content.
+type ContentWatcher func(target string, before, after []byte)
+
+var (
+	watchers   = map[string][]ContentWatcher{}
+	watchersMu sync.RWMutex
+)
+
+// AddContentWatcher registers fn to be called whenever content of namespace
+// ns is inserted, updated or deleted, after is nil for a delete. Watchers run
+// in their own goroutine, so they must not expect the request which changed
+// the content to still be alive.
+func AddContentWatcher(ns string, fn ContentWatcher) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+
+	watchers[ns] = append(watchers[ns], fn)
+}
+
+// notifyWatchers runs the watchers registered for ns
+func notifyWatchers(ns, target string, before, after []byte) {
+	watchersMu.RLock()
+	list := watchers[ns]
+	watchersMu.RUnlock()
+
+	for _, fn := range list {
+		go func(fn ContentWatcher) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Errorf("Content watcher of %s panic: %v", target, r)
+				}
+			}()
+			fn(target, before, after)
+		}(fn)
+	}
+}
@@ -0,0 +1,188 @@
+// Package i18n picks the content locale of a request and applies the per
+// locale translations of localizable content, falling back to the default
+// locale text. It also reports the translations which are missing.
+package i18n
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/item"
+	"github.com/agreyfox/eshop/system/logs"
+	"go.uber.org/zap"
+	"golang.org/x/text/language"
+)
+
+var (
+	// DefaultLocale is the locale of the untranslated fields, PaymentSetting
+	// content_default_locale
+	DefaultLocale = "en"
+
+	// Locales are the locales the storefront serves, PaymentSetting
+	// content_locales as a comma separated list
+	Locales = []string{"en"}
+
+	logger *zap.SugaredLogger = logs.Log.Sugar()
+
+	// contentAll reads all content of a type, tests replace it
+	contentAll = db.ContentAll
+)
+
+// Missing lists the localized fields of one item without translation
+type Missing struct {
+	Type   string   `json:"type"`
+	ID     int      `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	Locale string   `json:"locale"`
+	Fields []string `json:"fields"`
+}
+
+// LoadSetting read the default locale and the locales from PaymentSetting
+func LoadSetting() {
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "content_default_locale", "valueString"); err == nil && len(v) > 0 {
+		DefaultLocale = strings.ToLower(strings.TrimSpace(v))
+	}
+	locales := []string{DefaultLocale}
+	if v, err := db.GetParameterFromConfig("PaymentSetting", "name", "content_locales", "valueString"); err == nil {
+		for _, l := range strings.Split(v, ",") {
+			l = strings.ToLower(strings.TrimSpace(l))
+			if len(l) > 0 && l != DefaultLocale {
+				locales = append(locales, l)
+			}
+		}
+	}
+	Locales = locales
+	logger.Infof("Content locales %v, default %s", Locales, DefaultLocale)
+}
+
+// match returns the served locale for tag, trying the full tag then its
+// base language
+func match(tag string) (string, bool) {
+	tag = strings.ToLower(strings.Replace(strings.TrimSpace(tag), "_", "-", -1))
+	if len(tag) == 0 {
+		return "", false
+	}
+	base := strings.Split(tag, "-")[0]
+	for _, try := range []string{tag, base} {
+		for _, l := range Locales {
+			if l == try {
+				return l, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Pick returns the locale of the request from ?lang= or else the
+// Accept-Language header, the default locale when none is served
+func Pick(req *http.Request) string {
+	if l, ok := match(req.URL.Query().Get("lang")); ok {
+		return l
+	}
+	tags, _, err := language.ParseAcceptLanguage(req.Header.Get("Accept-Language"))
+	if err == nil {
+		for _, t := range tags {
+			if l, ok := match(t.String()); ok {
+				return l
+			}
+		}
+	}
+	return DefaultLocale
+}
+
+// Fields returns the localized fields of content type ns
+func Fields(ns string) []string {
+	t, ok := item.Types[ns]
+	if !ok {
+		return nil
+	}
+	l, ok := t().(item.Localizable)
+	if !ok {
+		return nil
+	}
+	return l.Localized()
+}
+
+// translations reads the i18n translations of a content map
+func translations(m map[string]interface{}) item.Translations {
+	raw, ok := m["i18n"]
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	t := item.Translations{}
+	if json.Unmarshal(b, &t) != nil {
+		return nil
+	}
+	return t
+}
+
+// Apply sets the fields of content map m to their translation in locale and
+// drops the translations from m. Fields without translation keep the default
+// locale text.
+func Apply(m map[string]interface{}, fields []string, locale string) {
+	t := translations(m)
+	delete(m, "i18n")
+	if locale == DefaultLocale {
+		return
+	}
+	for _, f := range fields {
+		if v, ok := t.Text(locale, f); ok {
+			m[f] = v
+		}
+	}
+}
+
+// MissingOf returns the items of the localizable types which have a default
+// locale text without translation, for locale or else every served locale
+func MissingOf(locale string) []Missing {
+	locales := Locales
+	if len(locale) > 0 {
+		locales = []string{locale}
+	}
+	types := []string{}
+	for ns := range item.Types {
+		if len(Fields(ns)) > 0 {
+			types = append(types, ns)
+		}
+	}
+	sort.Strings(types)
+
+	result := []Missing{}
+	for _, ns := range types {
+		fields := Fields(ns)
+		for _, buf := range contentAll(ns) {
+			m := map[string]interface{}{}
+			if json.Unmarshal(buf, &m) != nil {
+				continue
+			}
+			t := translations(m)
+			id, _ := m["id"].(float64)
+			name, _ := m["name"].(string)
+			for _, l := range locales {
+				if l == DefaultLocale {
+					continue
+				}
+				miss := []string{}
+				for _, f := range fields {
+					if v, _ := m[f].(string); len(strings.TrimSpace(v)) == 0 {
+						continue
+					}
+					if _, ok := t.Text(l, f); !ok {
+						miss = append(miss, f)
+					}
+				}
+				if len(miss) > 0 {
+					result = append(result, Missing{Type: ns, ID: int(id), Name: name, Locale: l, Fields: miss})
+				}
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package i18n
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/agreyfox/eshop/system/item"
+	"github.com/blevesearch/bleve"
+)
+
+type i18nGame struct {
+	item.Item
+	Name string `json:"name"`
+	Desc string `json:"description"`
+	Hint string `json:"hint"`
+}
+
+func (g *i18nGame) Localized() []string {
+	return []string{"description", "hint"}
+}
+
+func setLocales() func() {
+	def, locales := DefaultLocale, Locales
+	DefaultLocale, Locales = "en", []string{"en", "fr", "pt-br"}
+	return func() { DefaultLocale, Locales = def, locales }
+}
+
+func TestPick(t *testing.T) {
+	defer setLocales()()
+	cases := []struct {
+		url, accept, locale string
+	}{
+		{"/?lang=fr", "", "fr"},
+		{"/?lang=FR_ca", "", "fr"},
+		{"/?lang=pt-BR", "fr", "pt-br"},
+		{"/?lang=de", "fr;q=0.5", "fr"},
+		{"/", "de-DE,fr-CH;q=0.8,en;q=0.5", "fr"},
+		{"/", "de, ja", "en"},
+		{"/", "", "en"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.url, nil)
+		if len(c.accept) > 0 {
+			req.Header.Set("Accept-Language", c.accept)
+		}
+		if l := Pick(req); l != c.locale {
+			t.Errorf("%s %s: expected %s, got %s", c.url, c.accept, c.locale, l)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	defer setLocales()()
+	j := `{"id":1,"name":"WOW","description":"Gold","hint":"Pick a server",
+		"i18n":[{"locale":"fr","fields":{"description":"Or","hint":" ","name":"Wow FR"}}]}`
+	for locale, want := range map[string][2]string{
+		"fr": {"Or", "Pick a server"},
+		"en": {"Gold", "Pick a server"},
+		"pt": {"Gold", "Pick a server"},
+	} {
+		m := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(j), &m); err != nil {
+			t.Fatal(err)
+		}
+		Apply(m, []string{"description", "hint"}, locale)
+		if m["description"] != want[0] || m["hint"] != want[1] || m["name"] != "WOW" {
+			t.Errorf("%s: got %v", locale, m)
+		}
+		if _, ok := m["i18n"]; ok {
+			t.Errorf("%s: translations not dropped", locale)
+		}
+	}
+}
+
+func TestMissingOf(t *testing.T) {
+	defer setLocales()()
+	item.Types["I18nGame"] = func() interface{} { return new(i18nGame) }
+	defer delete(item.Types, "I18nGame")
+	all := contentAll
+	defer func() { contentAll = all }()
+	contentAll = func(ns string) [][]byte {
+		if ns != "I18nGame" {
+			return nil
+		}
+		return [][]byte{
+			[]byte(`{"id":1,"name":"WOW","description":"Gold","hint":"",
+				"i18n":[{"locale":"fr","fields":{"description":"Or"}}]}`),
+			[]byte(`{"id":2,"name":"POE","description":"Orbs","hint":"Pick a league"}`),
+		}
+	}
+
+	if miss := MissingOf("fr"); len(miss) != 1 || miss[0].ID != 2 || len(miss[0].Fields) != 2 {
+		t.Errorf("fr: got %+v", miss)
+	}
+	if miss := MissingOf(""); len(miss) != 3 {
+		t.Errorf("all locales: got %+v", miss)
+	}
+}
+
+func TestSearchTranslations(t *testing.T) {
+	mapping, err := item.Item{}.SearchMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := bleve.NewMemOnly(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	g := &i18nGame{}
+	j := `{"id":1,"name":"WOW","description":"Gold",
+		"i18n":[{"locale":"fr","fields":{"description":"Pièces d'or"}},{"locale":"de","fields":{"description":"Goldmünzen"}}]}`
+	if err = json.Unmarshal([]byte(j), g); err != nil {
+		t.Fatal(err)
+	}
+	if err = idx.Index("I18nGame:1", g); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"pièces", "goldmünzen", "gold"} {
+		res, err := idx.Search(bleve.NewSearchRequest(bleve.NewQueryStringQuery(q)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Total != 1 {
+			t.Errorf("%s: expected 1 hit, got %d", q, res.Total)
+		}
+	}
+}
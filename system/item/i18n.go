@@ -0,0 +1,79 @@
+package item
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Translation holds the translated fields of an item for one locale, by json
+// field name
+type Translation struct {
+	Locale string            `json:"locale"`
+	Fields map[string]string `json:"fields"`
+}
+
+// Translations are the translations of an item, stored as a json array and
+// posted by the admin editor as json text
+type Translations []Translation
+
+// UnmarshalText parse and validate the translations from the admin form
+func (t *Translations) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if len(s) == 0 || s == "null" {
+		*t = nil
+		return nil
+	}
+	list := []Translation{}
+	if err := json.Unmarshal([]byte(s), &list); err != nil {
+		return fmt.Errorf("translations are not a json list: %s", err)
+	}
+	if err := Translations(list).Validate(); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// UnmarshalJSON reads the stored translations
+func (t *Translations) UnmarshalJSON(b []byte) error {
+	list := []Translation{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// Validate checks every translation has a locale used only once
+func (t Translations) Validate() error {
+	locales := map[string]bool{}
+	for i, tr := range t {
+		locale := strings.ToLower(strings.TrimSpace(tr.Locale))
+		if len(locale) == 0 {
+			return fmt.Errorf("translation %d has no locale", i+1)
+		}
+		if locales[locale] {
+			return fmt.Errorf("locale %s is translated twice", tr.Locale)
+		}
+		locales[locale] = true
+	}
+	return nil
+}
+
+// Text returns the translation of field in locale, false when there is none
+func (t Translations) Text(locale, field string) (string, bool) {
+	for _, tr := range t {
+		if strings.EqualFold(tr.Locale, locale) {
+			v, ok := tr.Fields[field]
+			return v, ok && len(strings.TrimSpace(v)) > 0
+		}
+	}
+	return "", false
+}
+
+// Localizable lets a content type declare the json fields which are
+// translated per locale in its I18n translations
+type Localizable interface {
+	Localized() []string
+}
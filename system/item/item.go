@@ -131,6 +131,19 @@ type Omittable interface {
 	Omit(http.ResponseWriter, *http.Request) ([]string, error)
 }
 
+// Validatable lets a content type check its fields together once they are
+// decoded, content which fails Validate is not saved
+type Validatable interface {
+	Validate() error
+}
+
+// Reviewable lets a content type be rated by the Review content, ReviewField
+// is the json name of the Review field which references it. The content API
+// adds the rating of its public reviews to the item.
+type Reviewable interface {
+	ReviewField() string
+}
+
 type ContentStructable interface {
 	ContentStruct() map[string]interface{}
 }
@@ -142,6 +155,12 @@ type Item struct {
 	Slug      string    `json:"slug"`
 	Timestamp int64     `json:"timestamp"`
 	Updated   int64     `json:"updated"`
+
+	I18n Translations `json:"i18n,omitempty"` // fields of Localizable types by locale
+
+	PublishAt   string `json:"publish_at,omitempty"`   // pending items are approved at this time
+	UnpublishAt string `json:"unpublish_at,omitempty"` // public items are hidden from this time
+	Unpublished bool   `json:"unpublished,omitempty"`  // set by the publish scheduler outside the publish window
 }
 
 // Time partially implements the Sortable interface
@@ -0,0 +1,34 @@
+package item
+
+const (
+	// OnDeleteRestrict refuses to delete an item which is still referenced
+	OnDeleteRestrict = "restrict"
+	// OnDeleteCascade deletes the referencing items with the referenced one,
+	// for a list field the key is only removed from the list
+	OnDeleteCascade = "cascade"
+)
+
+// Reference declares a field which holds the key of another content item,
+// e.g. the game of a product. The editor saves a key as "id,name".
+type Reference struct {
+	Field    string // json name of the referencing field
+	Type     string // referenced content type
+	Key      string // json name of the referenced field, "name" when empty
+	Multi    bool   // the field is a json list of keys
+	OnDelete string // OnDeleteRestrict or OnDeleteCascade
+}
+
+// KeyField returns the referenced field name
+func (r Reference) KeyField() string {
+	if len(r.Key) == 0 {
+		return "name"
+	}
+	return r.Key
+}
+
+// Referencer lets a content type declare its reference fields. References are
+// checked when the item is saved, followed when the referenced item is renamed
+// and enforced when it is deleted.
+type Referencer interface {
+	References() []Reference
+}
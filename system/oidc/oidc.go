@@ -0,0 +1,387 @@
+// Package oidc is a small OpenID Connect client for the customer social login,
+// the authorization code flow with PKCE and RS256 signed ID tokens
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned for an ID token which does not verify
+	ErrInvalidToken = errors.New("Invalid ID token")
+
+	// Client makes the requests to the providers
+	Client = &http.Client{Timeout: 10 * time.Second}
+
+	// Skew is the clock difference allowed on the token times
+	Skew = time.Minute
+
+	// now is the clock of the token checks, replaced in tests
+	now = time.Now
+
+	// CacheTTL is how long a discovery document and the keys are kept
+	CacheTTL = time.Hour
+
+	mu        sync.Mutex
+	documents = map[string]*document{}
+	keySets   = map[string]*keySet{}
+)
+
+// Provider is an OpenID Connect provider set up by a LoginProvider content
+type Provider struct {
+	Name         string `json:"name"` // used in the login urls
+	Title        string `json:"title"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Scopes       string `json:"scopes"` // space separated, openid is added
+	RedirectURL  string `json:"redirect_url"`
+	Enabled      bool   `json:"enabled"`
+}
+
+// Claims are the claims of an ID token used for the login
+type Claims struct {
+	Issuer        string      `json:"iss"`
+	Subject       string      `json:"sub"`
+	Audience      audience    `json:"aud"`
+	Expiry        int64       `json:"exp"`
+	IssuedAt      int64       `json:"iat"`
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // bool, or a string with some providers
+	Name          string      `json:"name"`
+}
+
+// Verified tells if the provider verified the email of the claims
+func (c *Claims) Verified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// audience is a single string or a list in the token
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// document is the discovery document of an issuer
+type document struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+	fetched  time.Time
+}
+
+type keySet struct {
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// Request is one login in progress, kept until the provider redirects back
+type Request struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code verifier
+}
+
+// NewRequest returns a login request with random state, nonce and verifier
+func NewRequest() (*Request, error) {
+	r := &Request{}
+	for _, s := range []*string{&r.State, &r.Nonce, &r.Verifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		*s = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return r, nil
+}
+
+// discover returns the discovery document of the provider
+func (p *Provider) discover() (*document, error) {
+	issuer := strings.TrimSuffix(p.Issuer, "/")
+	mu.Lock()
+	doc, ok := documents[issuer]
+	mu.Unlock()
+	if ok && now().Sub(doc.fetched) < CacheTTL {
+		return doc, nil
+	}
+
+	doc = &document{}
+	if err := getJSON(issuer+"/.well-known/openid-configuration", doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("Issuer %s does not match %s", doc.Issuer, p.Issuer)
+	}
+	if len(doc.AuthURL) == 0 || len(doc.TokenURL) == 0 || len(doc.JWKSURL) == 0 {
+		return nil, fmt.Errorf("Issuer %s has no endpoints", p.Issuer)
+	}
+	doc.fetched = now()
+	mu.Lock()
+	documents[issuer] = doc
+	mu.Unlock()
+	return doc, nil
+}
+
+// AuthURL returns the url of the provider the customer is sent to for r
+func (p *Provider) AuthURL(r *Request) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(r.Verifier))
+	scopes := "openid"
+	for _, s := range strings.Fields(p.Scopes) {
+		if s != "openid" {
+			scopes += " " + s
+		}
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", scopes)
+	v.Set("state", r.State)
+	v.Set("nonce", r.Nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(doc.AuthURL, "?") {
+		sep = "&"
+	}
+	return doc.AuthURL + sep + v.Encode(), nil
+}
+
+// Exchange trades the code the provider sent back for r for the verified
+// claims of the ID token
+func (p *Provider) Exchange(code string, r *Request) (*Claims, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("client_id", p.ClientID)
+	v.Set("code_verifier", r.Verifier)
+	req, err := http.NewRequest(http.MethodPost, doc.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(p.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Token request to %s failed: %s %s", p.Name, res.Status, body)
+	}
+	token := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if len(token.IDToken) == 0 {
+		return nil, fmt.Errorf("Token response of %s has no id_token", p.Name)
+	}
+	return p.Verify(token.IDToken, r.Nonce)
+}
+
+// Verify checks the signature, issuer, audience, times and nonce of an ID
+// token and returns its claims
+func (p *Provider) Verify(raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("ID token alg %s not supported", header.Alg)
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	c := &Claims{}
+	if err := decodeSegment(parts[1], c); err != nil {
+		return nil, ErrInvalidToken
+	}
+	t := now()
+	switch {
+	case strings.TrimSuffix(c.Issuer, "/") != strings.TrimSuffix(p.Issuer, "/"):
+		return nil, fmt.Errorf("ID token issuer %s is not %s", c.Issuer, p.Issuer)
+	case !c.Audience.has(p.ClientID):
+		return nil, fmt.Errorf("ID token is not for client %s", p.ClientID)
+	case time.Unix(c.Expiry, 0).Add(Skew).Before(t):
+		return nil, fmt.Errorf("ID token expired")
+	case c.IssuedAt > 0 && time.Unix(c.IssuedAt, 0).Add(-Skew).After(t):
+		return nil, fmt.Errorf("ID token issued in the future")
+	case c.Nonce != nonce:
+		return nil, fmt.Errorf("ID token nonce does not match")
+	case len(c.Subject) == 0:
+		return nil, ErrInvalidToken
+	}
+	return c, nil
+}
+
+func (a audience) has(id string) bool {
+	for _, s := range a {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the signing key kid of the provider, the keys are fetched
+// again for a kid not seen yet
+func (p *Provider) key(kid string) (*rsa.PublicKey, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	ks, ok := keySets[doc.JWKSURL]
+	mu.Unlock()
+	if ok && now().Sub(ks.fetched) < CacheTTL {
+		if k := ks.find(kid); k != nil {
+			return k, nil
+		}
+	}
+
+	ks, err = fetchKeys(doc.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	keySets[doc.JWKSURL] = ks
+	mu.Unlock()
+	if k := ks.find(kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("ID token key %s not found", kid)
+}
+
+// find returns key kid, or the only key for a token without kid
+func (ks *keySet) find(kid string) *rsa.PublicKey {
+	if len(kid) == 0 && len(ks.keys) == 1 {
+		for _, k := range ks.keys {
+			return k
+		}
+	}
+	return ks.keys[kid]
+}
+
+func fetchKeys(jwksURL string) (*keySet, error) {
+	set := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	if err := getJSON(jwksURL, &set); err != nil {
+		return nil, err
+	}
+	ks := &keySet{keys: map[string]*rsa.PublicKey{}, fetched: now()}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (len(k.Use) > 0 && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		ks.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return ks, nil
+}
+
+func getJSON(u string, v interface{}) error {
+	res, err := Client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Get %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
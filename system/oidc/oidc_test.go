@@ -0,0 +1,229 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a local OpenID Connect provider. It gives out codes for the
+// claims set by login and checks the PKCE verifier when they are exchanged.
+type mockIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+	codes  map[string]mockCode
+	claims map[string]interface{}
+}
+
+type mockCode struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, kid: "k1", codes: map[string]mockCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": m.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		id, secret, _ := r.BasicAuth()
+		c, ok := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || id != "shop" || secret != "s3cret" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != c.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{"nonce": c.nonce}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "at",
+			"id_token":     m.sign(t, claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+// login does what the provider login page does for the auth url, it returns
+// the code sent back to the redirect url
+func (m *mockIssuer) login(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		t.Fatalf("auth url %s", authURL)
+	}
+	code := "code-" + q.Get("state")
+	m.codes[code] = mockCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	return code
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	c := map[string]interface{}{
+		"iss": m.URL,
+		"aud": "shop",
+		"sub": "1001",
+		"iat": now().Unix(),
+		"exp": now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": m.kid, "typ": "JWT"})
+	p, _ := json.Marshal(c)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return &Provider{
+		Name:         "mock",
+		Issuer:       m.URL,
+		ClientID:     "shop",
+		ClientSecret: "s3cret",
+		Scopes:       "email profile",
+		RedirectURL:  "https://shop.example.com/api/v1/oidc/mock/callback",
+		Enabled:      true,
+	}
+}
+
+func TestCodeFlow(t *testing.T) {
+	m := newMockIssuer(t)
+	defer m.Close()
+	m.claims = map[string]interface{}{"email": "Buyer@Example.com", "email_verified": true}
+	p := m.provider()
+
+	r, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthURL(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, m.URL+"/authorize?") || !strings.Contains(authURL, "scope=openid+email+profile") {
+		t.Errorf("auth url %s", authURL)
+	}
+	if strings.Contains(authURL, r.Verifier) {
+		t.Error("expected the verifier to stay secret")
+	}
+
+	code := m.login(t, authURL)
+	claims, err := p.Exchange(code, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "1001" || claims.Email != "Buyer@Example.com" || !claims.Verified() {
+		t.Errorf("claims %+v", claims)
+	}
+
+	// a code is used once
+	if _, err := p.Exchange(code, r); err == nil {
+		t.Error("expected a used code to fail")
+	}
+
+	// the code needs the verifier of its request
+	other, _ := NewRequest()
+	code = m.login(t, authURL)
+	if _, err := p.Exchange(code, other); err == nil {
+		t.Error("expected a code with another verifier to fail")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := newMockIssuer(t)
+	defer m.Close()
+	p := m.provider()
+	clock := now
+	at := time.Unix(1600000000, 0)
+	now = func() time.Time { return at }
+	defer func() { now = clock }()
+
+	good := m.sign(t, map[string]interface{}{"nonce": "n1", "email_verified": "true"})
+	c, err := p.Verify(good, "n1")
+	if err != nil || !c.Verified() {
+		t.Fatalf("verify: %+v %v", c, err)
+	}
+
+	cases := map[string]string{
+		"nonce":     good,
+		"audience":  m.sign(t, map[string]interface{}{"nonce": "n2", "aud": []string{"other"}}),
+		"issuer":    m.sign(t, map[string]interface{}{"nonce": "n2", "iss": "https://evil.example.com"}),
+		"expired":   m.sign(t, map[string]interface{}{"nonce": "n2", "exp": at.Add(-time.Hour).Unix()}),
+		"future":    m.sign(t, map[string]interface{}{"nonce": "n2", "iat": at.Add(time.Hour).Unix()}),
+		"signature": good[:strings.LastIndex(good, ".")] + ".c2lnbmF0dXJl",
+	}
+	for name, token := range cases {
+		if _, err := p.Verify(token, "n2"); err == nil {
+			t.Errorf("expected a token with a bad %s to fail", name)
+		}
+	}
+
+	// a list audience with the client passes
+	list := m.sign(t, map[string]interface{}{"nonce": "n3", "aud": []string{"other", "shop"}})
+	if _, err := p.Verify(list, "n3"); err != nil {
+		t.Errorf("audience list: %v", err)
+	}
+
+	// a token signed by another key fails
+	other := newMockIssuer(t)
+	defer other.Close()
+	forged := other.sign(t, map[string]interface{}{"nonce": "n1", "iss": m.URL})
+	if _, err := p.Verify(forged, "n1"); err == nil {
+		t.Error("expected a token of another key to fail")
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	defer m.Close()
+	p := m.provider()
+	p.Issuer = strings.Replace(m.URL, "127.0.0.1", "localhost", 1)
+	if _, err := p.AuthURL(&Request{}); err == nil {
+		t.Error("expected an issuer not matching its document to fail")
+	}
+}
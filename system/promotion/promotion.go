@@ -0,0 +1,193 @@
+// Package promotion runs the time-boxed promotions of the shop. Discounts,
+// hot products and games and carousels with a start or end time are switched
+// on and off by a scheduler, and the promotions running now are listed with
+// a countdown for the content API.
+package promotion
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/logs"
+	"go.uber.org/zap"
+)
+
+var (
+	// Location is the timezone of start and end times without a zone,
+	// PaymentSetting promotion_timezone
+	Location = time.Local
+
+	logger *zap.SugaredLogger = logs.Log.Sugar()
+
+	// layouts accepted for start and end times, the editor sends the
+	// datetime-local form 2006-01-02T15:04
+	layouts = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	}
+)
+
+type (
+	// Window is the time a promotion runs, a zero Start or End leaves that
+	// side open
+	Window struct {
+		Start time.Time
+		End   time.Time
+	}
+
+	// Promotion is a scheduled content item with its window state
+	Promotion struct {
+		Type      string          `json:"type"`
+		ID        int             `json:"id"`
+		Name      string          `json:"name"`
+		Start     int64           `json:"start,omitempty"` // unix seconds
+		End       int64           `json:"end,omitempty"`
+		Active    bool            `json:"active"`
+		Countdown int64           `json:"countdown"` // seconds to the end when active, to the start otherwise
+		Data      json.RawMessage `json:"data"`
+	}
+
+	// target is a content type the scheduler manages, Field is the flag set
+	// while the window is active, or cleared when Invert is set
+	target struct {
+		Type     string
+		Field    string
+		Start    string
+		End      string
+		Timezone string
+		Invert   bool
+	}
+)
+
+// targets are the scheduled content types, by json field names
+var targets = []target{
+	{Type: "Discount", Field: "online", Start: "starttime", End: "endtime", Timezone: "timezone"},
+	{Type: "Product", Field: "hotItem", Start: "hotStart", End: "hotEnd"},
+	{Type: "Game", Field: "hot", Start: "hotStart", End: "hotEnd"},
+	{Type: "Carousel", Field: "offline", Start: "starttime", End: "endtime", Invert: true},
+}
+
+// LoadSetting read the promotion timezone from PaymentSetting
+func LoadSetting() {
+	tz, err := db.GetParameterFromConfig("PaymentSetting", "name", "promotion_timezone", "valueString")
+	if err != nil || len(tz) == 0 {
+		return
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		logger.Errorf("Promotion timezone %s error: %s", tz, err)
+		return
+	}
+	Location = loc
+}
+
+// ParseTime read a start or end time, times without a zone are in loc
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if loc == nil {
+		loc = Location
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", s)
+}
+
+// NewWindow makes the window of start and end, tz is an IANA zone name and
+// falls back to Location when empty
+func NewWindow(start, end, tz string) (Window, error) {
+	loc := Location
+	if tz = strings.TrimSpace(tz); len(tz) > 0 {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return Window{}, err
+		}
+		loc = l
+	}
+	s, err := ParseTime(start, loc)
+	if err != nil {
+		return Window{}, err
+	}
+	e, err := ParseTime(end, loc)
+	if err != nil {
+		return Window{}, err
+	}
+	if !s.IsZero() && !e.IsZero() && !e.After(s) {
+		return Window{}, fmt.Errorf("end %s is not after start %s", end, start)
+	}
+	return Window{Start: s, End: e}, nil
+}
+
+// Scheduled tells if the window has a start or end, items without one are
+// switched by hand
+func (w Window) Scheduled() bool {
+	return !w.Start.IsZero() || !w.End.IsZero()
+}
+
+// Active tells if now is inside the window
+func (w Window) Active(now time.Time) bool {
+	if !w.Start.IsZero() && now.Before(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && !now.Before(w.End) {
+		return false
+	}
+	return true
+}
+
+// Countdown returns the seconds until the window ends when active, or until
+// it starts when not started yet. 0 means there is no next switch.
+func (w Window) Countdown(now time.Time) int64 {
+	if !w.Start.IsZero() && now.Before(w.Start) {
+		return int64(w.Start.Sub(now).Seconds())
+	}
+	if !w.End.IsZero() && now.Before(w.End) {
+		return int64(w.End.Sub(now).Seconds())
+	}
+	return 0
+}
+
+// Current returns the scheduled promotions running at now and the ones
+// starting later, ended promotions are left out
+func Current(now time.Time) (active, upcoming []Promotion) {
+	active, upcoming = []Promotion{}, []Promotion{}
+	for _, t := range targets {
+		for _, p := range scan(t) {
+			if !p.window.Scheduled() {
+				continue
+			}
+			promo := Promotion{
+				Type:      t.Type,
+				ID:        p.id,
+				Name:      p.name,
+				Active:    p.window.Active(now),
+				Countdown: p.window.Countdown(now),
+				Data:      p.data,
+			}
+			if !p.window.Start.IsZero() {
+				promo.Start = p.window.Start.Unix()
+			}
+			if !p.window.End.IsZero() {
+				promo.End = p.window.End.Unix()
+			}
+			if promo.Active {
+				active = append(active, promo)
+			} else if promo.Countdown > 0 {
+				upcoming = append(upcoming, promo)
+			}
+		}
+	}
+	return active, upcoming
+}
@@ -0,0 +1,67 @@
+package promotion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowTimezone(t *testing.T) {
+	// 20:00 in Shanghai is 12:00 UTC
+	w, err := NewWindow("2021-06-18 20:00", "2021-06-19T02:00", "Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 6, 18, 12, 0, 0, 0, time.UTC)
+	if !w.Start.Equal(start) {
+		t.Errorf("expected start %s, got %s", start, w.Start.UTC())
+	}
+
+	cases := []struct {
+		now       time.Time
+		active    bool
+		countdown int64
+	}{
+		{start.Add(-time.Hour), false, 3600},
+		{start, true, 6 * 3600},
+		{start.Add(6*time.Hour - time.Second), true, 1},
+		{start.Add(6 * time.Hour), false, 0},
+	}
+	for _, c := range cases {
+		if w.Active(c.now) != c.active || w.Countdown(c.now) != c.countdown {
+			t.Errorf("at %s expected active %v countdown %d, got %v %d",
+				c.now, c.active, c.countdown, w.Active(c.now), w.Countdown(c.now))
+		}
+	}
+}
+
+func TestWindowOpenEnds(t *testing.T) {
+	loc := Location
+	defer func() { Location = loc }()
+	Location = time.UTC
+
+	w, err := NewWindow("", "2021-06-19", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.Scheduled() || !w.Active(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("window without start should be active until the end")
+	}
+	// an offset in the value wins over the timezone
+	w, _ = NewWindow("2021-06-18T20:00:00+08:00", "", "America/New_York")
+	if w.Start.UTC().Hour() != 12 || w.Countdown(w.Start) != 0 {
+		t.Errorf("unexpected window %+v", w)
+	}
+
+	if w, _ := NewWindow("", "", ""); w.Scheduled() {
+		t.Errorf("empty window should not be scheduled")
+	}
+	if _, err := NewWindow("2021-06-19", "2021-06-18", ""); err == nil {
+		t.Errorf("expected error for end before start")
+	}
+	if _, err := NewWindow("tomorrow", "", ""); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+	if _, err := NewWindow("2021-06-19", "", "Mars/Olympus"); err == nil {
+		t.Errorf("expected error for unknown timezone")
+	}
+}
@@ -0,0 +1,98 @@
+package promotion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/robfig/cron"
+)
+
+var runMu sync.Mutex
+
+// scheduled is one content item of a target with its window
+type scheduled struct {
+	id     int
+	name   string
+	flag   bool
+	window Window
+	data   json.RawMessage
+}
+
+// Start load the setting and run the scheduler every minute
+func Start() {
+	LoadSetting()
+	logger.Infof("Start promotion scheduler, timezone %s", Location)
+
+	Run(time.Now())
+	job := cron.New()
+	job.AddFunc("@every 1m", func() {
+		Run(time.Now())
+	})
+	job.Start()
+}
+
+// Run switch the flag of every scheduled item to the state of its window at
+// now. Items without start and end time are left to the admin.
+func Run(now time.Time) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	for _, t := range targets {
+		for _, s := range scan(t) {
+			if !s.window.Scheduled() {
+				continue
+			}
+			want := s.window.Active(now) != t.Invert
+			if s.flag == want {
+				continue
+			}
+			target := fmt.Sprintf("%s:%d", t.Type, s.id)
+			_, err := db.UpdateContent(target, url.Values{t.Field: []string{strconv.FormatBool(want)}})
+			if err != nil {
+				logger.Errorf("Promotion switch %s %s to %v error: %s", target, t.Field, want, err)
+				continue
+			}
+			logger.Infof("Promotion %s %s set %s to %v", target, s.name, t.Field, want)
+		}
+	}
+}
+
+// scan read the items of the target type, items with a wrong time are
+// logged and skipped
+func scan(t target) []scheduled {
+	items := []scheduled{}
+	for _, buf := range db.ContentAll(t.Type) {
+		c := map[string]interface{}{}
+		if err := json.Unmarshal(buf, &c); err != nil {
+			continue
+		}
+		id, _ := c["id"].(float64)
+		name, _ := c["name"].(string)
+		flag, _ := c[t.Field].(bool)
+		start, _ := c[t.Start].(string)
+		end, _ := c[t.End].(string)
+		tz := ""
+		if len(t.Timezone) > 0 {
+			tz, _ = c[t.Timezone].(string)
+		}
+
+		w, err := NewWindow(start, end, tz)
+		if err != nil {
+			logger.Warnf("Promotion %s:%d %s time error: %s", t.Type, int(id), name, err)
+			continue
+		}
+		items = append(items, scheduled{
+			id:     int(id),
+			name:   name,
+			flag:   flag,
+			window: w,
+			data:   append(json.RawMessage{}, buf...),
+		})
+	}
+	return items
+}
@@ -0,0 +1,294 @@
+// Package publish runs the scheduled publishing of content items. Pending
+// items with a publish_at time are approved when it comes, with the same
+// hooks as an approval by an admin, and public items are marked unpublished
+// outside their publish_at and unpublish_at window so the content API hides
+// them.
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agreyfox/eshop/management/editor"
+	"github.com/agreyfox/eshop/system/db"
+	"github.com/agreyfox/eshop/system/item"
+	"github.com/agreyfox/eshop/system/logs"
+	"github.com/agreyfox/eshop/system/promotion"
+	"github.com/robfig/cron"
+	"go.uber.org/zap"
+)
+
+// pendingSuffix is the bucket suffix of the content waiting for approval
+const pendingSuffix = "__pending"
+
+var (
+	logger *zap.SugaredLogger = logs.Log.Sugar()
+
+	runMu sync.Mutex
+
+	// database access, tests replace them
+	contentAll    = db.ContentAll
+	setContent    = db.SetContentBy
+	updateContent = db.UpdateContentBy
+	deleteContent = db.DeleteContent
+)
+
+// Schedule is a content item with a publish or unpublish time
+type Schedule struct {
+	Type        string `json:"type"`
+	ID          int    `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Pending     bool   `json:"pending"`
+	PublishAt   int64  `json:"publish_at,omitempty"` // unix seconds
+	UnpublishAt int64  `json:"unpublish_at,omitempty"`
+	Published   bool   `json:"published"`      // public and inside its window
+	Next        int64  `json:"next,omitempty"` // unix seconds of the next change
+}
+
+// scheduled is one content item with its publish window
+type scheduled struct {
+	ns     string
+	id     int
+	name   string
+	hidden bool
+	window promotion.Window
+	data   map[string]interface{}
+}
+
+// Start runs the scheduler every minute, after promotion.LoadSetting set the
+// timezone of the times
+func Start() {
+	logger.Infof("Start publish scheduler, timezone %s", promotion.Location)
+
+	Run(time.Now())
+	job := cron.New()
+	job.AddFunc("@every 1m", func() {
+		Run(time.Now())
+	})
+	job.Start()
+}
+
+// Run approves the pending items whose publish time has come and sets the
+// unpublished flag of the public items to the state of their window at now
+func Run(now time.Time) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	for _, ns := range types() {
+		for _, s := range scan(ns + pendingSuffix) {
+			if s.window.Start.IsZero() || now.Before(s.window.Start) {
+				continue
+			}
+			if err := approve(s); err != nil {
+				logger.Errorf("Publish %s:%d %s error: %s", ns, s.id, s.name, err)
+			}
+		}
+
+		for _, s := range scan(ns) {
+			hide := s.window.Scheduled() && !s.window.Active(now)
+			if s.hidden == hide {
+				continue
+			}
+			target := fmt.Sprintf("%s:%d", ns, s.id)
+			_, err := updateContent(target, url.Values{"unpublished": {strconv.FormatBool(hide)}}, "scheduler")
+			if err != nil {
+				logger.Errorf("Publish %s set unpublished %v error: %s", target, hide, err)
+				continue
+			}
+			logger.Infof("Publish %s %s set unpublished %v", target, s.name, hide)
+		}
+	}
+}
+
+// Scheduled lists the items with a publish or unpublish time which is still
+// to come at now, by time of their next change
+func Scheduled(now time.Time) []Schedule {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	list := []Schedule{}
+	for _, ns := range types() {
+		for _, pending := range []bool{true, false} {
+			bucket := ns
+			if pending {
+				bucket += pendingSuffix
+			}
+			for _, s := range scan(bucket) {
+				sc := Schedule{
+					Type:      ns,
+					ID:        s.id,
+					Name:      s.name,
+					Pending:   pending,
+					Published: !pending && s.window.Active(now),
+				}
+				if !s.window.Start.IsZero() {
+					sc.PublishAt = s.window.Start.Unix()
+					if now.Before(s.window.Start) {
+						sc.Next = sc.PublishAt
+					}
+				}
+				if !s.window.End.IsZero() {
+					sc.UnpublishAt = s.window.End.Unix()
+					if sc.Next == 0 && now.Before(s.window.End) {
+						sc.Next = sc.UnpublishAt
+					}
+				}
+				if sc.Next > 0 {
+					list = append(list, sc)
+				}
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Next < list[j].Next })
+	return list
+}
+
+// types returns the registered content types in name order
+func types() []string {
+	list := []string{}
+	for ns := range item.Types {
+		list = append(list, ns)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// scan reads the items of bucket which have a publish or unpublish time,
+// items with a wrong time are logged and skipped
+func scan(bucket string) []scheduled {
+	items := []scheduled{}
+	for _, buf := range contentAll(bucket) {
+		c := map[string]interface{}{}
+		if err := json.Unmarshal(buf, &c); err != nil {
+			continue
+		}
+		start, _ := c["publish_at"].(string)
+		end, _ := c["unpublish_at"].(string)
+		hidden, _ := c["unpublished"].(bool)
+		if len(start) == 0 && len(end) == 0 && !hidden {
+			continue
+		}
+		id, _ := c["id"].(float64)
+		name, _ := c["name"].(string)
+		if len(name) == 0 {
+			name, _ = c["title"].(string)
+		}
+
+		w, err := promotion.NewWindow(start, end, "")
+		if err != nil {
+			logger.Warnf("Publish %s:%d %s time error: %s", bucket, int(id), name, err)
+			continue
+		}
+		items = append(items, scheduled{
+			ns:     bucket,
+			id:     int(id),
+			name:   name,
+			hidden: hidden,
+			window: w,
+			data:   c,
+		})
+	}
+	return items
+}
+
+// approve moves a pending item to the public content the way an admin
+// approval does, running the approve and save hooks of its type
+func approve(s scheduled) error {
+	ns := s.ns[:len(s.ns)-len(pendingSuffix)]
+	t, ok := item.Types[ns]
+	if !ok {
+		return fmt.Errorf("type %s not registered", ns)
+	}
+	hook, ok := t().(item.Hookable)
+	if !ok {
+		return fmt.Errorf("type %s does not implement item.Hookable", ns)
+	}
+
+	pending := fmt.Sprintf("%s:%d", s.ns, s.id)
+	req, err := http.NewRequest(http.MethodPost, "/admin/v1/content/approve?"+url.Values{
+		"type": {s.ns},
+		"id":   {strconv.Itoa(s.id)},
+	}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res := httptest.NewRecorder()
+
+	if err = hook.BeforeApprove(res, req); err != nil {
+		return err
+	}
+	if m, ok := t().(editor.Mergeable); ok {
+		if err = m.Approve(res, req); err != nil {
+			return err
+		}
+	}
+	if err = hook.AfterApprove(res, req); err != nil {
+		return err
+	}
+	if err = hook.BeforeSave(res, req); err != nil {
+		return err
+	}
+
+	data := formValues(s.data)
+	for _, k := range []string{"id", "uuid", "__specifier", "unpublished"} {
+		data.Del(k)
+	}
+	id, err := setContent(ns+":-1", data, "scheduler")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%d", ns, id))
+	if err = hook.AfterSave(res, req.WithContext(ctx)); err != nil {
+		return err
+	}
+
+	if err = deleteContent(pending); err != nil {
+		logger.Warnf("Publish failed to remove %s after approval: %s", pending, err)
+	}
+	logger.Infof("Publish %s %s approved as %s:%d", pending, s.name, ns, id)
+	return nil
+}
+
+// formValues turns stored content back into the form values SetContent
+// takes, lists of objects are kept as json text like the admin editor sends
+// them
+func formValues(m map[string]interface{}) url.Values {
+	data := url.Values{}
+	for k, v := range m {
+		switch value := v.(type) {
+		case nil:
+		case string:
+			data.Set(k, value)
+		case []interface{}:
+			if len(value) > 0 {
+				if _, ok := value[0].(map[string]interface{}); ok {
+					if j, err := json.Marshal(value); err == nil {
+						data.Set(k, string(j))
+					}
+					continue
+				}
+			}
+			for _, e := range value {
+				data.Add(k, fmt.Sprint(e))
+			}
+		case map[string]interface{}:
+			if j, err := json.Marshal(value); err == nil {
+				data.Set(k, string(j))
+			}
+		case float64:
+			data.Set(k, strconv.FormatFloat(value, 'f', -1, 64))
+		default:
+			data.Set(k, fmt.Sprint(value))
+		}
+	}
+	return data
+}
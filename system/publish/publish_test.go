@@ -0,0 +1,119 @@
+package publish
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/agreyfox/eshop/system/item"
+	"github.com/agreyfox/eshop/system/promotion"
+)
+
+type pubNews struct {
+	item.Item
+	Title string `json:"title"`
+}
+
+var approved []string
+
+func (n *pubNews) BeforeApprove(res http.ResponseWriter, req *http.Request) error {
+	approved = append(approved, "before "+req.URL.Query().Get("id"))
+	return nil
+}
+
+func (n *pubNews) AfterApprove(res http.ResponseWriter, req *http.Request) error {
+	approved = append(approved, "after "+req.URL.Query().Get("id"))
+	return nil
+}
+
+type stubbed struct {
+	created map[string]url.Values
+	updated map[string]url.Values
+	deleted []string
+}
+
+// stubStore serves buckets as the content and records the writes, the
+// returned func puts the database back
+func stubStore(buckets map[string][]string) (*stubbed, func()) {
+	loc, all, set, update, del := promotion.Location, contentAll, setContent, updateContent, deleteContent
+	item.Types["PubNews"] = func() interface{} { return new(pubNews) }
+	approved = nil
+
+	st := &stubbed{created: map[string]url.Values{}, updated: map[string]url.Values{}}
+	promotion.Location = time.UTC
+	contentAll = func(ns string) [][]byte {
+		list := [][]byte{}
+		for _, j := range buckets[ns] {
+			list = append(list, []byte(j))
+		}
+		return list
+	}
+	setContent = func(target string, data url.Values, author string) (int, error) {
+		st.created[target] = data
+		return 7, nil
+	}
+	updateContent = func(target string, data url.Values, author string) (int, error) {
+		st.updated[target] = data
+		return 0, nil
+	}
+	deleteContent = func(target string) error {
+		st.deleted = append(st.deleted, target)
+		return nil
+	}
+
+	return st, func() {
+		promotion.Location, contentAll, setContent, updateContent, deleteContent = loc, all, set, update, del
+		delete(item.Types, "PubNews")
+	}
+}
+
+func TestRun(t *testing.T) {
+	st, restore := stubStore(map[string][]string{
+		"PubNews__pending": {
+			`{"id":1,"title":"Sale starts","publish_at":"2021-06-01 10:00","tags":["a","b"],"number":3}`,
+			`{"id":2,"title":"Next week","publish_at":"2021-06-08 10:00"}`,
+			`{"id":3,"title":"Draft"}`,
+		},
+		"PubNews": {
+			`{"id":4,"title":"Sale ends","unpublish_at":"2021-06-01 09:00"}`,
+			`{"id":5,"title":"Old","unpublish_at":"2021-06-01 09:00","unpublished":true}`,
+			`{"id":6,"title":"Extended","unpublish_at":"2021-07-01 09:00","unpublished":true}`,
+			`{"id":7,"title":"Cleared","unpublished":true}`,
+			`{"id":8,"title":"Always"}`,
+		},
+	})
+	defer restore()
+
+	now := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	Run(now)
+
+	data, ok := st.created["PubNews:-1"]
+	if len(st.created) != 1 || !ok {
+		t.Fatalf("expected pending 1 approved, got %v", st.created)
+	}
+	if data.Get("id") != "" || data.Get("title") != "Sale starts" || data.Get("number") != "3" || len(data["tags"]) != 2 {
+		t.Errorf("unexpected approved values %v", data)
+	}
+	if len(approved) != 2 || approved[0] != "before 1" || approved[1] != "after 1" {
+		t.Errorf("expected approve hooks, got %v", approved)
+	}
+	if len(st.deleted) != 1 || st.deleted[0] != "PubNews__pending:1" {
+		t.Errorf("expected pending 1 removed, got %v", st.deleted)
+	}
+
+	want := map[string]string{"PubNews:4": "true", "PubNews:6": "false", "PubNews:7": "false"}
+	if len(st.updated) != len(want) {
+		t.Errorf("expected %v, got %v", want, st.updated)
+	}
+	for target, v := range want {
+		if st.updated[target].Get("unpublished") != v {
+			t.Errorf("%s: expected unpublished %s, got %v", target, v, st.updated[target])
+		}
+	}
+
+	list := Scheduled(now)
+	if len(list) != 2 || list[0].ID != 2 || !list[0].Pending || list[1].ID != 6 || !list[1].Published {
+		t.Errorf("unexpected schedule %+v", list)
+	}
+}